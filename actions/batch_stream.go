@@ -0,0 +1,167 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+var (
+	maxBatchBodySize int64 = 200 << 20
+	maxBatchRows           = 1000000
+)
+
+// SetBatchLimits configures the maximum size of the body and the maximum
+// number of rows of batch imports. Zero values keep the defaults.
+func SetBatchLimits(bodySize int64, rows int) {
+	if bodySize > 0 {
+		maxBatchBodySize = bodySize
+	}
+	if rows > 0 {
+		maxBatchRows = rows
+	}
+}
+
+// errBatchTooLarge is returned when reading a batch body exceeding the
+// configured maximum size
+var errBatchTooLarge = errors.New("taille du corps de la requête supérieure au maximum")
+
+// limitedBody reads a request body restricted to max bytes, returning
+// errBatchTooLarge beyond.
+type limitedBody struct {
+	io.ReadCloser
+	read, max int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	l.read += int64(n)
+	if err != nil && err != io.EOF && l.read >= l.max {
+		return n, errBatchTooLarge
+	}
+	return n, err
+}
+
+// limitBody restricts the size of the request body to the configured maximum.
+func limitBody(ctx iris.Context) {
+	r := ctx.Request()
+	r.Body = &limitedBody{max: maxBatchBodySize,
+		ReadCloser: http.MaxBytesReader(ctx.ResponseWriter(), r.Body, maxBatchBodySize)}
+}
+
+// sendBatchError sends the response of a failed batch import. Like the import
+// jobs, a body exceeding the maximum size is sent as 413 and a malformed body
+// or one exceeding the maximum number of rows as 400, the other errors being
+// sent as 500.
+func sendBatchError(ctx iris.Context, errPrefix string, err error) {
+	var decodeErr *models.BatchDecodeError
+	switch {
+	case errors.Is(err, errBatchTooLarge):
+		ctx.StatusCode(http.StatusRequestEntityTooLarge)
+	case errors.As(err, &decodeErr):
+		ctx.StatusCode(http.StatusBadRequest)
+	default:
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{errPrefix + ", requête : " + err.Error()})
+		return
+	}
+	ctx.JSON(jsonError{errPrefix + ", décodage : " + err.Error()})
+}
+
+// batchStream returns a stream decoding the lines of the request body. NDJSON
+// is used if the request content type is application/x-ndjson, otherwise the
+// lines are read from the array named key of the JSON object.
+func batchStream(ctx iris.Context, key string) *models.BatchStream {
+	limitBody(ctx)
+	ndjson := strings.HasPrefix(ctx.GetHeader("Content-Type"), "application/x-ndjson")
+	return models.NewBatchStream(ctx.Request().Body, key, ndjson, maxBatchRows)
+}
+
+// spooledBatch is a batch import payload copied into a temporary file so that
+// it can be decoded line by line by an import job once the request is over.
+type spooledBatch struct {
+	file   *os.File
+	key    string
+	ndjson bool
+	Hash   string
+}
+
+// newSpooledBatch creates the temporary file of a batch whose lines are in the
+// array named key or sent as NDJSON.
+func newSpooledBatch(key string, ndjson bool) (*spooledBatch, error) {
+	f, err := ioutil.TempFile("", "propera_import_")
+	if err != nil {
+		return nil, err
+	}
+	return &spooledBatch{file: f, key: key, ndjson: ndjson}, nil
+}
+
+// ReadFrom copies the request body into the temporary file and decodes it
+// once, one line at a time using values returned by line, to check it and
+// compute the hash of its content.
+func (b *spooledBatch) ReadFrom(ctx iris.Context, line func() interface{}) error {
+	limitBody(ctx)
+	if _, err := io.Copy(b.file, ctx.Request().Body); err != nil {
+		return fmt.Errorf("lecture : %w", err)
+	}
+	s, err := b.Stream()
+	if err != nil {
+		return err
+	}
+	for {
+		ok, err := s.Next(line())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	b.Hash = s.ContentHash()
+	return nil
+}
+
+// Stream returns a stream decoding the batch from its beginning.
+func (b *spooledBatch) Stream() (*models.BatchStream, error) {
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return models.NewBatchStream(b.file, b.key, b.ndjson, maxBatchRows), nil
+}
+
+// Remove closes and deletes the temporary file.
+func (b *spooledBatch) Remove() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
+
+// spoolBatch copies the body of the request into a spooled batch. The response
+// is sent and nil returned if the body can't be stored or decoded.
+func spoolBatch(ctx iris.Context, key string, line func() interface{},
+	errPrefix string) *spooledBatch {
+	ndjson := strings.HasPrefix(ctx.GetHeader("Content-Type"), "application/x-ndjson")
+	b, err := newSpooledBatch(key, ndjson)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{errPrefix + ", fichier : " + err.Error()})
+		return nil
+	}
+	if err = b.ReadFrom(ctx, line); err != nil {
+		b.Remove()
+		if errors.Is(err, errBatchTooLarge) {
+			ctx.StatusCode(http.StatusRequestEntityTooLarge)
+		} else {
+			ctx.StatusCode(http.StatusBadRequest)
+		}
+		ctx.JSON(jsonError{errPrefix + ", décodage : " + err.Error()})
+		return nil
+	}
+	return b
+}
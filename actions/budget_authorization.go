@@ -0,0 +1,238 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+type budgetAuthorizationResp struct {
+	BudgetAuthorization models.BudgetAuthorization `json:"BudgetAuthorization"`
+}
+
+type authorizationAffectationResp struct {
+	AuthorizationAffectation models.AuthorizationAffectation `json:"AuthorizationAffectation"`
+}
+
+// GetBudgetAuthorizations handles the get request to fetch the
+// authorizations of programme of the year sent as Year or of all years.
+func GetBudgetAuthorizations(ctx iris.Context) {
+	year, err := ctx.URLParamInt64("Year")
+	if err != nil {
+		if ctx.URLParamExists("Year") {
+			ctx.StatusCode(http.StatusBadRequest)
+			ctx.JSON(jsonError{"Liste des autorisations de programme, paramètre : " + err.Error()})
+			return
+		}
+		year = 0
+	}
+	var resp models.BudgetAuthorizations
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(year, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Liste des autorisations de programme, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// CreateBudgetAuthorization handles the post request to create an
+// authorization of programme.
+func CreateBudgetAuthorization(ctx iris.Context) {
+	var req models.BudgetAuthorization
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création d'autorisation de programme, décodage : " + err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Création d'autorisation de programme, paramètre : " + err.Error()})
+		return
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := req.Create(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création d'autorisation de programme, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(budgetAuthorizationResp{req})
+}
+
+// ModifyBudgetAuthorization handles the put request to modify an
+// authorization of programme.
+func ModifyBudgetAuthorization(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Modification d'autorisation de programme, paramètre : " + err.Error()})
+		return
+	}
+	var req models.BudgetAuthorization
+	if err = ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Modification d'autorisation de programme, décodage : " + err.Error()})
+		return
+	}
+	if err = req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Modification d'autorisation de programme, paramètre : " + err.Error()})
+		return
+	}
+	req.ID = ID
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = req.Update(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Modification d'autorisation de programme, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(budgetAuthorizationResp{req})
+}
+
+// DeleteBudgetAuthorization handles the delete request of an authorization
+// of programme.
+func DeleteBudgetAuthorization(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Suppression d'autorisation de programme, paramètre : " + err.Error()})
+		return
+	}
+	b := models.BudgetAuthorization{ID: ID}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = b.Delete(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Suppression d'autorisation de programme, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{"Autorisation de programme supprimée"})
+}
+
+// GetAuthorizationAffectations handles the get request to fetch the
+// affectations of an authorization of programme.
+func GetAuthorizationAffectations(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Liste des affectations, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.AuthorizationAffectations
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(ID, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Liste des affectations, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// CreateAuthorizationAffectation handles the post request to affect a part
+// of an authorization of programme to a physical operation.
+func CreateAuthorizationAffectation(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Création d'affectation, paramètre : " + err.Error()})
+		return
+	}
+	var req models.AuthorizationAffectation
+	if err = ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création d'affectation, décodage : " + err.Error()})
+		return
+	}
+	if err = req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Création d'affectation, paramètre : " + err.Error()})
+		return
+	}
+	req.AuthorizationID = ID
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = req.Create(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création d'affectation, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(authorizationAffectationResp{req})
+}
+
+// ModifyAuthorizationAffectation handles the put request to modify the value
+// of an affectation.
+func ModifyAuthorizationAffectation(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Modification d'affectation, paramètre : " + err.Error()})
+		return
+	}
+	var req models.AuthorizationAffectation
+	if err = ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Modification d'affectation, décodage : " + err.Error()})
+		return
+	}
+	req.ID = ID
+	if req.Value <= 0 {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Modification d'affectation, paramètre : Value incorrect"})
+		return
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = req.Update(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Modification d'affectation, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(authorizationAffectationResp{req})
+}
+
+// DeleteAuthorizationAffectation handles the delete request of an
+// affectation.
+func DeleteAuthorizationAffectation(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Suppression d'affectation, paramètre : " + err.Error()})
+		return
+	}
+	a := models.AuthorizationAffectation{ID: ID}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = a.Delete(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Suppression d'affectation, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{"Affectation supprimée"})
+}
+
+// GetBudgetAuthorizationSchedule handles the get request to compute the
+// payment credits schedule of the authorizations of programme derived from
+// the payment forecasts of the affected operations.
+func GetBudgetAuthorizationSchedule(ctx iris.Context) {
+	p, err := forecastParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Echéancier des crédits de paiement, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.BudgetAuthorizationSchedule
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(*p, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Echéancier des crédits de paiement, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
@@ -0,0 +1,345 @@
+package actions
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testBudgetAuthorization(t *testing.T) {
+	t.Run("BudgetAuthorization", func(t *testing.T) {
+		ID := createBudgetAuthorizationTest(testCtx.E, t)
+		if ID == 0 {
+			t.Fatal("Impossible de créer l'autorisation de programme")
+		}
+		afID := createAuthorizationAffectationTest(testCtx.E, t, ID)
+		if afID == 0 {
+			t.Fatal("Impossible de créer l'affectation")
+		}
+		modifyAuthorizationAffectationTest(testCtx.E, t, afID)
+		modifyBudgetAuthorizationTest(testCtx.E, t, ID)
+		getBudgetAuthorizationsTest(testCtx.E, t)
+		getAuthorizationAffectationsTest(testCtx.E, t, ID)
+		getBudgetAuthorizationScheduleTest(testCtx.E, t, ID)
+		deleteAuthorizationAffectationTest(testCtx.E, t, afID)
+		deleteBudgetAuthorizationTest(testCtx.E, t, ID)
+	})
+}
+
+// createBudgetAuthorizationTest check route is protected and authorization
+// created.
+func createBudgetAuthorizationTest(e *httpexpect.Expect, t *testing.T) (ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"action_id":0,"year":2019,"value":100}`),
+			BodyContains: []string{"Création d'autorisation de programme, paramètre : ActionID incorrect"}},
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"action_id":1,"year":2019,"value":-100}`),
+			BodyContains: []string{"Création d'autorisation de programme, paramètre : Value incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusCreated,
+			IDName: `"id"`,
+			Sent:   []byte(`{"action_id":1,"year":2019,"value":100000000,"descript":"AP 2019"}`),
+			BodyContains: []string{`"BudgetAuthorization":{"id":`, `"action_id":1`,
+				`"year":2019`, `"value":100000000`, `"descript":"AP 2019"`}},
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusInternalServerError,
+			Sent:         []byte(`{"action_id":1,"year":2019,"value":100}`),
+			BodyContains: []string{"Création d'autorisation de programme, requête : insert "}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/budget_authorizations").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CreateBudgetAuthorization", &ID) {
+		t.Error(r)
+	}
+	return ID
+}
+
+// createAuthorizationAffectationTest check route is protected and
+// affectations limited to the value of the authorization.
+func createAuthorizationAffectationTest(e *httpexpect.Expect, t *testing.T, ID int) (afID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"physical_op_id":9,"value":0}`),
+			BodyContains: []string{"Création d'affectation, paramètre : Value incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusInternalServerError,
+			Sent:   []byte(`{"physical_op_id":9,"value":200000000}`),
+			BodyContains: []string{"Création d'affectation, requête : affectations " +
+				"(200000000) supérieures à l'autorisation (100000000)"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			Sent:         []byte(`{"physical_op_id":9,"value":100}`),
+			BodyContains: []string{"Création d'affectation, requête : insert "}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusCreated,
+			IDName: `"id"`,
+			Sent:   []byte(`{"physical_op_id":9,"value":60000000}`),
+			BodyContains: []string{`"AuthorizationAffectation":{"id":`,
+				`"authorization_id":` + strconv.Itoa(ID), `"physical_op_id":9`,
+				`"value":60000000`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/budget_authorizations/"+tc.ID+"/affectations").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CreateAuthorizationAffectation", &afID) {
+		t.Error(r)
+	}
+	return afID
+}
+
+// modifyAuthorizationAffectationTest check route is protected and
+// affectation modified.
+func modifyAuthorizationAffectationTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			Sent:         []byte(`{"value":100}`),
+			BodyContains: []string{"Modification d'affectation, requête : Affectation introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusInternalServerError,
+			Sent:   []byte(`{"value":150000000}`),
+			BodyContains: []string{"Modification d'affectation, requête : affectations " +
+				"(150000000) supérieures à l'autorisation (100000000)"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusOK,
+			Sent:   []byte(`{"value":80000000,"descript":"Travaux"}`),
+			BodyContains: []string{`"AuthorizationAffectation":{"id":` + strconv.Itoa(ID),
+				`"physical_op_id":9`, `"value":80000000`, `"descript":"Travaux"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.PUT("/api/authorization_affectations/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "ModifyAuthorizationAffectation") {
+		t.Error(r)
+	}
+}
+
+// modifyBudgetAuthorizationTest check route is protected and the value kept
+// greater than the affectations.
+func modifyBudgetAuthorizationTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusInternalServerError,
+			Sent:   []byte(`{"action_id":1,"year":2019,"value":50000000}`),
+			BodyContains: []string{"Modification d'autorisation de programme, requête : " +
+				"affectations (80000000) supérieures à l'autorisation (50000000)"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     "0",
+			Status: http.StatusInternalServerError,
+			Sent:   []byte(`{"action_id":1,"year":2019,"value":50000000}`),
+			BodyContains: []string{"Modification d'autorisation de programme, requête : " +
+				"Autorisation de programme introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusOK,
+			Sent:   []byte(`{"action_id":1,"year":2019,"value":120000000}`),
+			BodyContains: []string{`"BudgetAuthorization":{"id":` + strconv.Itoa(ID),
+				`"value":120000000`, `"descript":null`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.PUT("/api/budget_authorizations/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "ModifyBudgetAuthorization") {
+		t.Error(r)
+	}
+}
+
+// getBudgetAuthorizationsTest check route is protected and remaining amounts
+// sent back.
+func getBudgetAuthorizationsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Year=a",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Liste des autorisations de programme, paramètre : "}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Year=2018",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"BudgetAuthorization":[]`}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "Year=2019",
+			Status: http.StatusOK,
+			BodyContains: []string{`"year":2019`, `"value":120000000`,
+				`"affected":80000000`, `"not_affected":40000000`, `"consumed":`},
+			CountItemName: `"id"`,
+			ArraySize:     1},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/budget_authorizations").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetBudgetAuthorizations") {
+		t.Error(r)
+	}
+}
+
+// getAuthorizationAffectationsTest check route is protected and affectations
+// sent back.
+func getAuthorizationAffectationsTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			ID:           "0",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"AuthorizationAffectation":[]`}},
+		{
+			Token:  testCtx.User.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusOK,
+			BodyContains: []string{`"physical_op_id":9`, `"value":80000000`,
+				`"descript":"Travaux"`, `"op_number":`, `"consumed":`, `"not_consumed":`},
+			CountItemName: `"id"`,
+			ArraySize:     1},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/budget_authorizations/"+tc.ID+"/affectations").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetAuthorizationAffectations") {
+		t.Error(r)
+	}
+}
+
+// getBudgetAuthorizationScheduleTest check route is protected, schedule
+// computed over the horizon and commitments preceding the authorization left
+// out.
+func getBudgetAuthorizationScheduleTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "FirstYear=2019",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Echéancier des crédits de paiement, paramètre : "}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "FirstYear=2019&Horizon=4&DefaultPaymentTypeId=5",
+			Status: http.StatusOK,
+			BodyContains: []string{`"Years":[2019,2020,2021,2022]`,
+				`"BudgetAuthorizationSchedule":[{"id":`, `"affected":80000000`,
+				`"values":[`},
+			CountItemName: `"affected"`,
+			ArraySize:     1},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/budget_authorizations/schedule").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetBudgetAuthorizationSchedule") {
+		t.Error(r)
+	}
+	if _, err := testCtx.DB.Exec(`UPDATE budget_authorization SET year=2100 WHERE id=$1`,
+		ID); err != nil {
+		t.Fatalf("GetBudgetAuthorizationSchedule : modification de l'année %v", err)
+	}
+	defer func() {
+		if _, err := testCtx.DB.Exec(`UPDATE budget_authorization SET year=2019 WHERE id=$1`,
+			ID); err != nil {
+			t.Errorf("GetBudgetAuthorizationSchedule : restauration de l'année %v", err)
+		}
+	}()
+	testCases = []testCase{
+		{
+			Token:  testCtx.User.Token,
+			Param:  "FirstYear=2019&Horizon=4&DefaultPaymentTypeId=5",
+			Status: http.StatusOK,
+			BodyContains: []string{`"year":2100,"value":120000000,"affected":80000000,` +
+				`"values":[0,0,0,0]`}},
+	}
+	for _, r := range chkTestCases(testCases, f, "GetBudgetAuthorizationScheduleBefore") {
+		t.Error(r)
+	}
+}
+
+// deleteAuthorizationAffectationTest check route is protected and
+// affectation deleted.
+func deleteAuthorizationAffectationTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Suppression d'affectation, requête : Affectation introuvable"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{"Affectation supprimée"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.DELETE("/api/authorization_affectations/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "DeleteAuthorizationAffectation") {
+		t.Error(r)
+	}
+}
+
+// deleteBudgetAuthorizationTest check route is protected and authorization
+// deleted.
+func deleteBudgetAuthorizationTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     "0",
+			Status: http.StatusInternalServerError,
+			BodyContains: []string{"Suppression d'autorisation de programme, requête : " +
+				"Autorisation de programme introuvable"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{"Autorisation de programme supprimée"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.DELETE("/api/budget_authorizations/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "DeleteBudgetAuthorization") {
+		t.Error(r)
+	}
+}
@@ -146,13 +146,27 @@ func BatchBudgetCredits(ctx iris.Context) {
 		ctx.JSON(jsonError{"Erreur de lecture du batch crédits : " + err.Error()})
 		return
 	}
-	db := ctx.Values().Get("db").(*sql.DB)
-	if err := req.Save(db); err != nil {
+	db, rec := ctx.Values().Get("db").(*sql.DB), newImportRecord(ctx, "BudgetCredits")
+	var err error
+	if rec.ContentHash, err = models.BatchHash(req.Lines); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Batch crédits, hash : " + err.Error()})
+		return
+	}
+	if replayImport(ctx, &rec, "Batch crédits") {
+		return
+	}
+	recon, err := req.Save(recordGuard{&rec, reconResp("Credits importés")}, db)
+	if err == models.ErrAlreadyImported {
+		sendReplay(ctx, &rec)
+		return
+	}
+	if err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Batch crédits, requête : " + err.Error()})
 		return
 	}
-
+	resp := importResp{"Credits importés", *recon}
 	ctx.StatusCode(http.StatusOK)
-	ctx.JSON(jsonMessage{"Credits importés"})
+	ctx.JSON(resp)
 }
@@ -0,0 +1,58 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// budgetExecutionParams decodes the year, the payment type, the level and the
+// drill-down filters of the budget execution dashboard.
+func budgetExecutionParams(ctx iris.Context) (*models.BudgetExecutionParams, error) {
+	year, err := budgetYear(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p := models.BudgetExecutionParams{Year: year, Level: models.ExecutionChapter}
+	if p.PaymentTypeID, err = ctx.URLParamInt64("DefaultPaymentTypeId"); err != nil {
+		return nil, err
+	}
+	if ctx.URLParamExists("Level") {
+		p.Level = ctx.URLParam("Level")
+	}
+	if ctx.URLParamExists("Chapter") {
+		if p.Chapter.Int64, err = ctx.URLParamInt64("Chapter"); err != nil {
+			return nil, err
+		}
+		p.Chapter.Valid = true
+	}
+	for name, s := range map[string]*models.NullString{"Sector": &p.Sector,
+		"Program": &p.Program, "Action": &p.Action} {
+		if ctx.URLParamExists(name) {
+			*s = models.NullString{Valid: true, String: ctx.URLParam(name)}
+		}
+	}
+	return &p, p.Validate()
+}
+
+// GetBudgetExecution handles the get request of the budget execution
+// dashboard of a year.
+func GetBudgetExecution(ctx iris.Context) {
+	p, err := budgetExecutionParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Exécution budgétaire, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.BudgetExecution
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(p, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Exécution budgétaire, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
@@ -0,0 +1,105 @@
+package actions
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testBudgetExecution(t *testing.T) {
+	t.Run("BudgetExecution", func(t *testing.T) {
+		getBudgetExecutionTest(testCtx.E, t)
+		getLegacyBudgetExecutionTest(testCtx.E, t)
+	})
+}
+
+// getBudgetExecutionTest check route is protected and the levels of the
+// dashboard sent back.
+func getBudgetExecutionTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Year=2018",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Exécution budgétaire, paramètre : "}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Year=2018&DefaultPaymentTypeId=5&Level=region",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Exécution budgétaire, paramètre : Level incorrect"}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "Year=2018&DefaultPaymentTypeId=5",
+			Status: http.StatusOK,
+			BodyContains: []string{`"BudgetExecution":[{"chapter":`, `"sector":null`,
+				`"voted_commitment":`, `"committed":`, `"pending":`, `"paid":`,
+				`"remaining_commitment":`, `"commitment_rate":null`,
+				`"projected_commitment":`, `"projected_payment":`}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "Year=2018&DefaultPaymentTypeId=5&Level=action&Chapter=908",
+			Status: http.StatusOK,
+			BodyContains: []string{`"BudgetExecution":[{"chapter":908`, `"action":`,
+				`"action_name":`, `"op_id":null`}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "Year=2018&DefaultPaymentTypeId=5&Level=op&Chapter=908",
+			Status: http.StatusOK,
+			BodyContains: []string{`"BudgetExecution":[{"chapter":908`, `"op_number":`,
+				`"voted_commitment":null`, `"remaining_payment":null`}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Year=2018&DefaultPaymentTypeId=5&Chapter=1",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"BudgetExecution":[]`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/budget_execution").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetBudgetExecution") {
+		t.Error(r)
+	}
+}
+
+// getLegacyBudgetExecutionTest checks the voted credits of a year without
+// budget version come from the budget credits and the payment credits per
+// chapter.
+func getLegacyBudgetExecutionTest(e *httpexpect.Expect, t *testing.T) {
+	for _, qry := range []string{`INSERT INTO budget_credits (commission_date,
+		chapter_id,primary_commitment,frozen_commitment,reserved_commitment)
+		SELECT '2010-06-01',id,500000000,0,0 FROM budget_chapter WHERE code=908`,
+		`INSERT INTO payment_credit (year,chapter_id,function,primitive,reported,
+		added,modified,movement) SELECT 2010,id,811,200000000,0,50000000,0,0
+		FROM budget_chapter WHERE code=908`} {
+		if _, err := testCtx.DB.Exec(qry); err != nil {
+			t.Fatalf("GetLegacyBudgetExecution : insertion %v", err)
+		}
+	}
+	defer func() {
+		for _, qry := range []string{`DELETE FROM budget_credits
+			WHERE commission_date='2010-06-01'`,
+			`DELETE FROM payment_credit WHERE year=2010`} {
+			if _, err := testCtx.DB.Exec(qry); err != nil {
+				t.Errorf("GetLegacyBudgetExecution : suppression %v", err)
+			}
+		}
+	}()
+	testCases := []testCase{
+		{
+			Token:  testCtx.User.Token,
+			Param:  "Year=2010&DefaultPaymentTypeId=5&Chapter=908",
+			Status: http.StatusOK,
+			BodyContains: []string{`"BudgetExecution":[{"chapter":908`,
+				`"voted_commitment":500000000,"voted_payment":250000000`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/budget_execution").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetLegacyBudgetExecution") {
+		t.Error(r)
+	}
+}
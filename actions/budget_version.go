@@ -0,0 +1,235 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+type budgetVersionResp struct {
+	BudgetVersion models.BudgetVersion `json:"BudgetVersion"`
+}
+
+type budgetTransferResp struct {
+	BudgetTransfer models.BudgetTransfer `json:"BudgetTransfer"`
+}
+
+// budgetYear returns the year sent as Year or the current year.
+func budgetYear(ctx iris.Context) (int64, error) {
+	if !ctx.URLParamExists("Year") {
+		return int64(time.Now().Year()), nil
+	}
+	return ctx.URLParamInt64("Year")
+}
+
+// GetBudgetVersions handles the get request to fetch the budget versions of
+// a year with their credits.
+func GetBudgetVersions(ctx iris.Context) {
+	year, err := budgetYear(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Liste des versions budgétaires, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.BudgetVersions
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(year, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Liste des versions budgétaires, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// CreateBudgetVersion handles the post request to create a budget version
+// with its credits.
+func CreateBudgetVersion(ctx iris.Context) {
+	var req models.BudgetVersion
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création de version budgétaire, décodage : " + err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Création de version budgétaire, paramètre : " + err.Error()})
+		return
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := req.Create(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création de version budgétaire, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(budgetVersionResp{req})
+}
+
+// ModifyBudgetVersion handles the put request to modify a budget version and
+// replace its credits.
+func ModifyBudgetVersion(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Modification de version budgétaire, paramètre : " + err.Error()})
+		return
+	}
+	var req models.BudgetVersion
+	if err = ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Modification de version budgétaire, décodage : " + err.Error()})
+		return
+	}
+	if err = req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Modification de version budgétaire, paramètre : " + err.Error()})
+		return
+	}
+	req.ID = ID
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = req.Update(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Modification de version budgétaire, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(budgetVersionResp{req})
+}
+
+// DeleteBudgetVersion handles the delete request of a budget version.
+func DeleteBudgetVersion(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Suppression de version budgétaire, paramètre : " + err.Error()})
+		return
+	}
+	b := models.BudgetVersion{ID: ID}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = b.Delete(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Suppression de version budgétaire, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{"Version budgétaire supprimée"})
+}
+
+// GetBudgetTransfers handles the get request to fetch the transfers of a
+// year.
+func GetBudgetTransfers(ctx iris.Context) {
+	year, err := budgetYear(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Liste des virements, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.BudgetTransfers
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(year, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Liste des virements, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// CreateBudgetTransfer handles the post request to create a transfer between
+// two budget actions.
+func CreateBudgetTransfer(ctx iris.Context) {
+	var req models.BudgetTransfer
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création de virement, décodage : " + err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Création de virement, paramètre : " + err.Error()})
+		return
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := req.Create(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création de virement, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(budgetTransferResp{req})
+}
+
+// DeleteBudgetTransfer handles the delete request of a transfer.
+func DeleteBudgetTransfer(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Suppression de virement, paramètre : " + err.Error()})
+		return
+	}
+	b := models.BudgetTransfer{ID: ID}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = b.Delete(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Suppression de virement, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{"Virement supprimé"})
+}
+
+// GetVotedCredits handles the get request to compute the credits voted at
+// the date sent as Date, today by default, per chapter or per budget action
+// if ByAction is set.
+func GetVotedCredits(ctx iris.Context) {
+	date := time.Now()
+	var err error
+	if ctx.URLParamExists("Date") {
+		if date, err = time.Parse("2006-01-02", ctx.URLParam("Date")); err != nil {
+			ctx.StatusCode(http.StatusBadRequest)
+			ctx.JSON(jsonError{"Crédits votés, paramètre : Date incorrecte"})
+			return
+		}
+	}
+	byAction := false
+	if ctx.URLParamExists("ByAction") {
+		if byAction, err = ctx.URLParamBool("ByAction"); err != nil {
+			ctx.StatusCode(http.StatusBadRequest)
+			ctx.JSON(jsonError{"Crédits votés, paramètre : " + err.Error()})
+			return
+		}
+	}
+	var resp models.VotedCredits
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(date, byAction, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Crédits votés, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// GetVotedCreditHistory handles the get request to fetch the evolution of
+// the credits voted per chapter during a year.
+func GetVotedCreditHistory(ctx iris.Context) {
+	year, err := budgetYear(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Historique des crédits votés, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.VotedCreditHistory
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(year, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Historique des crédits votés, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
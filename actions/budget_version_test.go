@@ -0,0 +1,307 @@
+package actions
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testBudgetVersion(t *testing.T) {
+	t.Run("BudgetVersion", func(t *testing.T) {
+		bpID := createBudgetVersionTest(testCtx.E, t)
+		if bpID == 0 {
+			t.Fatal("Impossible de créer la version budgétaire")
+		}
+		modifyBudgetVersionTest(testCtx.E, t, bpID)
+		trID := createBudgetTransferTest(testCtx.E, t)
+		if trID == 0 {
+			t.Fatal("Impossible de créer le virement")
+		}
+		getBudgetVersionsTest(testCtx.E, t)
+		getBudgetTransfersTest(testCtx.E, t)
+		getVotedCreditsTest(testCtx.E, t)
+		getVotedCreditHistoryTest(testCtx.E, t)
+		deleteBudgetTransferTest(testCtx.E, t, trID)
+		deleteBudgetVersionTest(testCtx.E, t, bpID)
+	})
+}
+
+// createBudgetVersionTest check route is protected and versions created.
+func createBudgetVersionTest(e *httpexpect.Expect, t *testing.T) (ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"year":2019,"kind":"XX","vote_date":"2018-12-15T00:00:00Z"}`),
+			BodyContains: []string{"Création de version budgétaire, paramètre : Kind incorrect"}},
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"year":2019,"kind":"DM","vote_date":"2019-06-15T00:00:00Z"}`),
+			BodyContains: []string{"Création de version budgétaire, paramètre : Number incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusBadRequest,
+			Sent: []byte(`{"year":2019,"kind":"BP","vote_date":"2018-12-15T00:00:00Z",` +
+				`"credits":[{"action_id":1,"commitment":100},{"action_id":1,"commitment":100}]}`),
+			BodyContains: []string{"Création de version budgétaire, paramètre : " +
+				"Credits action incorrecte ou en double"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusCreated,
+			IDName: `"id"`,
+			Sent: []byte(`{"year":2019,"kind":"BP","vote_date":"2018-12-15T00:00:00Z",` +
+				`"credits":[{"action_id":1,"commitment":100000000,"payment":50000000}]}`),
+			BodyContains: []string{`"BudgetVersion":{"id":`, `"name":"BP 2019"`,
+				`"credits":[{"action_id":1,"commitment":100000000,"payment":50000000}]`}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusInternalServerError,
+			Sent: []byte(`{"year":2019,"kind":"BP","vote_date":"2018-12-15T00:00:00Z",` +
+				`"credits":[]}`),
+			BodyContains: []string{"Création de version budgétaire, requête : insert "}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/budget_versions").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CreateBudgetVersion", &ID) {
+		t.Error(r)
+	}
+	return ID
+}
+
+// modifyBudgetVersionTest check route is protected and credits replaced.
+func modifyBudgetVersionTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     "0",
+			Status: http.StatusInternalServerError,
+			Sent:   []byte(`{"year":2019,"kind":"BP","vote_date":"2018-12-15T00:00:00Z"}`),
+			BodyContains: []string{"Modification de version budgétaire, requête : " +
+				"Version budgétaire introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusOK,
+			Sent: []byte(`{"year":2019,"kind":"BP","vote_date":"2018-12-15T00:00:00Z",` +
+				`"credits":[{"action_id":1,"commitment":120000000,"payment":60000000},` +
+				`{"action_id":2,"commitment":10000000,"payment":0}]}`),
+			BodyContains: []string{`"BudgetVersion":{"id":` + strconv.Itoa(ID),
+				`{"action_id":1,"commitment":120000000,"payment":60000000}`,
+				`{"action_id":2,"commitment":10000000,"payment":0}`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.PUT("/api/budget_versions/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "ModifyBudgetVersion") {
+		t.Error(r)
+	}
+}
+
+// createBudgetTransferTest check route is protected and transfer created.
+func createBudgetTransferTest(e *httpexpect.Expect, t *testing.T) (ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusBadRequest,
+			Sent: []byte(`{"transfer_date":"2019-03-01T00:00:00Z","from_action_id":1,` +
+				`"to_action_id":1,"commitment":100}`),
+			BodyContains: []string{"Création de virement, paramètre : Actions incorrectes"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusBadRequest,
+			Sent: []byte(`{"transfer_date":"2019-03-01T00:00:00Z","from_action_id":1,` +
+				`"to_action_id":2}`),
+			BodyContains: []string{"Création de virement, paramètre : Montants incorrects"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusCreated,
+			IDName: `"id"`,
+			Sent: []byte(`{"transfer_date":"2019-03-01T00:00:00Z","from_action_id":1,` +
+				`"to_action_id":2,"commitment":20000000,"payment":5000000}`),
+			BodyContains: []string{`"BudgetTransfer":{"id":`, `"from_action_id":1`,
+				`"to_action_id":2`, `"commitment":20000000`, `"payment":5000000`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/budget_transfers").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CreateBudgetTransfer", &ID) {
+		t.Error(r)
+	}
+	return ID
+}
+
+// getBudgetVersionsTest check route is protected and versions sent back.
+func getBudgetVersionsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Year=a",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Liste des versions budgétaires, paramètre : "}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Year=2018",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"BudgetVersion":[]`}},
+		{
+			Token:         testCtx.User.Token,
+			Param:         "Year=2019",
+			Status:        http.StatusOK,
+			BodyContains:  []string{`"name":"BP 2019"`, `"kind":"BP"`, `"credits":[{`},
+			CountItemName: `"kind"`,
+			ArraySize:     1},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/budget_versions").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetBudgetVersions") {
+		t.Error(r)
+	}
+}
+
+// getBudgetTransfersTest check route is protected and transfers sent back.
+func getBudgetTransfersTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Year=2018",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"BudgetTransfer":[]`}},
+		{
+			Token:         testCtx.User.Token,
+			Param:         "Year=2019",
+			Status:        http.StatusOK,
+			BodyContains:  []string{`"from_action_id":1`, `"to_action_id":2`},
+			CountItemName: `"from_action_id"`,
+			ArraySize:     1},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/budget_transfers").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetBudgetTransfers") {
+		t.Error(r)
+	}
+}
+
+// getVotedCreditsTest check route is protected and credits computed at the
+// date.
+func getVotedCreditsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Date=2019-13-01",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Crédits votés, paramètre : Date incorrecte"}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "Date=2019-02-01&ByAction=true",
+			Status: http.StatusOK,
+			BodyContains: []string{`"action_id":1`, `"commitment":120000000`,
+				`"payment":60000000`, `"action_id":2`, `"commitment":10000000`},
+			CountItemName: `"action_id"`,
+			ArraySize:     2},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "Date=2019-04-01&ByAction=true",
+			Status: http.StatusOK,
+			BodyContains: []string{`"commitment":100000000,"payment":55000000`,
+				`"commitment":30000000,"payment":5000000`},
+			CountItemName: `"action_id"`,
+			ArraySize:     2},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Date=2018-04-01",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"VotedCredit":[]`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/voted_credits").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetVotedCredits") {
+		t.Error(r)
+	}
+}
+
+// getVotedCreditHistoryTest check route is protected and steps sent back.
+func getVotedCreditHistoryTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Year=2019",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"VotedCreditHistory":[{`, `"label":"BP"`, `"label":"Virement"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/voted_credits/history").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetVotedCreditHistory") {
+		t.Error(r)
+	}
+}
+
+// deleteBudgetTransferTest check route is protected and transfer deleted.
+func deleteBudgetTransferTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Suppression de virement, requête : Virement introuvable"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{"Virement supprimé"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.DELETE("/api/budget_transfers/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "DeleteBudgetTransfer") {
+		t.Error(r)
+	}
+}
+
+// deleteBudgetVersionTest check route is protected and version deleted.
+func deleteBudgetVersionTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     "0",
+			Status: http.StatusInternalServerError,
+			BodyContains: []string{"Suppression de version budgétaire, requête : " +
+				"Version budgétaire introuvable"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{"Version budgétaire supprimée"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.DELETE("/api/budget_versions/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "DeleteBudgetVersion") {
+		t.Error(r)
+	}
+}
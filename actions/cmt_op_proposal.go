@@ -0,0 +1,55 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// GetCmtOpProposalStats handles the get request to fetch the monthly
+// statistics of the proposals of links between commitments and operations.
+func GetCmtOpProposalStats(ctx iris.Context) {
+	var resp models.CmtOpProposalStats
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := resp.GetAll(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Statistiques des propositions, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// GetOpNameAliases handles the get request to fetch the aliases of IRIS op
+// names learnt from the validated proposals.
+func GetOpNameAliases(ctx iris.Context) {
+	var resp models.OpNameAliases
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := resp.GetAll(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Liste des alias, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// DeleteOpNameAlias handles the delete request of an alias of IRIS op name.
+func DeleteOpNameAlias(ctx iris.Context) {
+	aliasID, err := ctx.Params().GetInt64("aliasID")
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Suppression d'alias, paramètre : " + err.Error()})
+		return
+	}
+	alias, db := models.OpNameAlias{ID: aliasID}, ctx.Values().Get("db").(*sql.DB)
+	if err = alias.Delete(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Suppression d'alias, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{"Alias supprimé"})
+}
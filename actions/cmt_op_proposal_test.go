@@ -0,0 +1,61 @@
+package actions
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/iris-contrib/httpexpect"
+)
+
+// getCmtOpProposalStatsTest check route is protected and the decisions stored
+// by setCmtOpLinksTest are counted
+func getCmtOpProposalStatsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{`"CmtOpProposalStat":[{"month":`, `"precision":`, `"recall":`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/cmt_op_proposals/stats").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetCmtOpProposalStats") {
+		t.Error(r)
+	}
+}
+
+// getOpNameAliasesTest check route is protected and aliases are sent back
+func getOpNameAliasesTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{"OpNameAlias"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/op_name_aliases").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetOpNameAliases") {
+		t.Error(r)
+	}
+}
+
+// deleteOpNameAliasTest check route is protected and a bad ID is detected
+func deleteOpNameAliasTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Suppression d'alias, requête : Alias introuvable"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.DELETE("/api/op_name_aliases/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "DeleteOpNameAlias") {
+		t.Error(r)
+	}
+}
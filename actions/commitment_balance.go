@@ -0,0 +1,202 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// defaultLapseHorizon is the default number of days before the lapse date
+// for a commitment to be at risk
+const defaultLapseHorizon = 90
+
+// commitmentBalanceFilter decodes the at risk filter from the URL parameters.
+func commitmentBalanceFilter(ctx iris.Context) (*models.CommitmentBalanceFilter, error) {
+	f := models.CommitmentBalanceFilter{Horizon: defaultLapseHorizon}
+	var err error
+	if ctx.URLParamExists("horizon") {
+		if f.Horizon, err = ctx.URLParamInt64("horizon"); err != nil {
+			return nil, err
+		}
+	}
+	for name, v := range map[string]*models.NullInt64{"op_id": &f.OpID,
+		"beneficiary_code": &f.BeneficiaryCode, "action_id": &f.ActionID} {
+		if !ctx.URLParamExists(name) {
+			continue
+		}
+		if v.Int64, err = ctx.URLParamInt64(name); err != nil {
+			return nil, err
+		}
+		v.Valid = true
+	}
+	return &f, nil
+}
+
+// GetCommitmentBalance handles the get request to fetch the balance of a
+// financial commitment.
+func GetCommitmentBalance(ctx iris.Context) {
+	fcID, err := ctx.Params().GetInt64("fcID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Solde d'engagement, paramètre : " + err.Error()})
+		return
+	}
+	f, err := commitmentBalanceFilter(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Solde d'engagement, paramètre : " + err.Error()})
+		return
+	}
+	uID, err := getUserID(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Solde d'engagement, user : " + err.Error()})
+		return
+	}
+	resp, db := models.CommitmentBalance{ID: fcID}, ctx.Values().Get("db").(*sql.DB)
+	if err = resp.Get(f.Horizon, uID, db); err != nil {
+		ctx.StatusCode(http.StatusNotFound)
+		ctx.JSON(jsonError{"Solde d'engagement, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// GetAtRiskCommitments handles the get request to fetch the commitments that
+// will lapse within the horizon with an unpaid balance, optionally filtered by
+// operation, beneficiary or budget action.
+func GetAtRiskCommitments(ctx iris.Context) {
+	f, err := commitmentBalanceFilter(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Engagements à risque, paramètre : " + err.Error()})
+		return
+	}
+	uID, err := getUserID(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Engagements à risque, user : " + err.Error()})
+		return
+	}
+	var resp models.CommitmentBalances
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAtRisk(f, uID, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Engagements à risque, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// GetCommitmentRisks handles the get request to fetch the at risk commitments
+// grouped by operation, beneficiary or budget action.
+func GetCommitmentRisks(ctx iris.Context) {
+	f, err := commitmentBalanceFilter(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Synthèse des risques, paramètre : " + err.Error()})
+		return
+	}
+	group := ctx.URLParamDefault("group", "op")
+	if _, ok := models.CommitmentRiskGroups[group]; !ok {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Synthèse des risques, paramètre : regroupement " + group + " inconnu"})
+		return
+	}
+	uID, err := getUserID(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Synthèse des risques, user : " + err.Error()})
+		return
+	}
+	var resp models.CommitmentRisks
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetByGroup(group, f.Horizon, uID, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Synthèse des risques, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+type commitmentClosureReq struct {
+	CommitmentClosure models.CommitmentClosure `json:"CommitmentClosure"`
+}
+
+// CloseCommitment handles the post request to mark a commitment as closed
+// (soldé), the remaining to pay being released.
+func CloseCommitment(ctx iris.Context) {
+	fcID, err := ctx.Params().GetInt64("fcID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Solde d'engagement, paramètre : " + err.Error()})
+		return
+	}
+	var req commitmentClosureReq
+	if err = ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Solde d'engagement, décodage : " + err.Error()})
+		return
+	}
+	req.CommitmentClosure.CommitmentID = fcID
+	if err = req.CommitmentClosure.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Solde d'engagement : " + err.Error()})
+		return
+	}
+	if uID, ok := ctx.Values().Get("uID").(int); ok {
+		req.CommitmentClosure.UserID = models.NullInt64{Valid: true, Int64: int64(uID)}
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = req.CommitmentClosure.Create(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Solde d'engagement, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(req)
+}
+
+// ReopenCommitment handles the delete request to cancel the closure of a
+// commitment.
+func ReopenCommitment(ctx iris.Context) {
+	fcID, err := ctx.Params().GetInt64("fcID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Réouverture d'engagement, paramètre : " + err.Error()})
+		return
+	}
+	c, db := models.CommitmentClosure{CommitmentID: fcID}, ctx.Values().Get("db").(*sql.DB)
+	if err = c.Delete(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Réouverture d'engagement, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{"Engagement réouvert"})
+}
+
+// GetCommitmentClosures handles the get request to fetch the closed
+// commitments with the released amounts, restricted to the rights of the user.
+func GetCommitmentClosures(ctx iris.Context) {
+	uID, err := getUserID(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Engagements soldés, user : " + err.Error()})
+		return
+	}
+	var resp models.CommitmentClosures
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(uID, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Engagements soldés, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
@@ -0,0 +1,230 @@
+package actions
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testCommitmentBalance(t *testing.T) {
+	t.Run("CommitmentBalance", func(t *testing.T) {
+		getCommitmentBalanceTest(testCtx.E, t)
+		getAtRiskCommitmentsTest(testCtx.E, t)
+		getCommitmentRisksTest(testCtx.E, t)
+		closeCommitmentTest(testCtx.E, t)
+		getCommitmentClosuresTest(testCtx.E, t)
+		reopenCommitmentTest(testCtx.E, t)
+	})
+}
+
+// getCommitmentBalanceTest check route is protected and balance sent back
+func getCommitmentBalanceTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{Token: testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusNotFound,
+			BodyContains: []string{"Solde d'engagement, requête : Engagement introuvable"}},
+		{Token: testCtx.Admin.Token,
+			ID:           "219",
+			Param:        "abc",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Solde d'engagement, paramètre :"}},
+		{Token: testCtx.Admin.Token,
+			ID:           "219",
+			Param:        "90",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"id":219`, `"paid":`, `"remaining":`, `"at_risk":`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		r := e.GET("/api/financial_commitments/"+tc.ID+"/balance").
+			WithHeader("Authorization", "Bearer "+tc.Token)
+		if tc.Param != "" {
+			r = r.WithQuery("horizon", tc.Param)
+		}
+		return r.Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetCommitmentBalance") {
+		t.Error(r)
+	}
+}
+
+// getAtRiskCommitmentsTest check route is protected and list sent back
+func getAtRiskCommitmentsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{Token: testCtx.User.Token,
+			Param:        "abc",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Engagements à risque, paramètre :"}},
+		{Token: testCtx.User.Token,
+			Param:        "90",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"CommitmentBalance":[`}},
+		{Token: testCtx.Admin.Token,
+			Param:        "36500",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"CommitmentBalance":[{"id":`, `"days_to_lapse":`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/commitment_balances/at_risk").
+			WithQuery("horizon", tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetAtRiskCommitments") {
+		t.Error(r)
+	}
+}
+
+// getCommitmentRisksTest check route is protected and summary sent back
+func getCommitmentRisksTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{Token: testCtx.User.Token,
+			Param:        "unknown",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Synthèse des risques, paramètre : regroupement unknown inconnu"}},
+		{Token: testCtx.User.Token,
+			Param:        "beneficiary",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"CommitmentRisk":[`}},
+		{Token: testCtx.Admin.Token,
+			Param:        "action",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"CommitmentRisk":[`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/commitment_balances/risks").
+			WithQuery("group", tc.Param).WithQuery("horizon", 36500).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetCommitmentRisks") {
+		t.Error(r)
+	}
+}
+
+// closeCommitmentTest check route is protected and closure correctly created
+func closeCommitmentTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			ID:           "219",
+			Sent:         []byte(`{"CommitmentClosure":{"closed_at":"2019-06-01T00:00:00Z"`),
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Solde d'engagement, décodage :"}},
+		{Token: testCtx.Admin.Token,
+			ID:           "219",
+			Sent:         []byte(`{"CommitmentClosure":{"comment":"Opération terminée"}}`),
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Solde d'engagement : Champ closed_at incorrect"}},
+		{Token: testCtx.Admin.Token,
+			ID:           "0",
+			Sent:         []byte(`{"CommitmentClosure":{"closed_at":"2019-06-01T00:00:00Z"}}`),
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Solde d'engagement, requête : Engagement introuvable ou déjà soldé"}},
+		{Token: testCtx.Admin.Token,
+			ID: "219",
+			Sent: []byte(`{"CommitmentClosure":{"closed_at":"2019-06-01T00:00:00Z",
+			"comment":"Opération terminée"}}`),
+			Status: http.StatusCreated,
+			BodyContains: []string{`"commitment_id":219`, `"released_value":`,
+				`"comment":"Opération terminée"`}},
+		{Token: testCtx.Admin.Token,
+			ID:           "219",
+			Sent:         []byte(`{"CommitmentClosure":{"closed_at":"2019-06-01T00:00:00Z"}}`),
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Solde d'engagement, requête : Engagement introuvable ou déjà soldé"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/financial_commitments/"+tc.ID+"/closure").
+			WithBytes(tc.Sent).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CloseCommitment") {
+		t.Error(r)
+	}
+}
+
+// getCommitmentClosuresTest check route is protected and closures sent back
+// according to the rights of the user
+func getCommitmentClosuresTest(e *httpexpect.Expect, t *testing.T) {
+	var opID int64
+	if err := testCtx.DB.QueryRow(`SELECT physical_op_id FROM financial_commitment
+	WHERE id=219`).Scan(&opID); err != nil {
+		t.Fatalf("GetCommitmentClosures, opération : %v", err)
+	}
+	uID, granted := testCtx.User.User.ID, false
+	if err := testCtx.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM rights
+	WHERE users_id=$1 AND physical_op_id=$2)`, uID, opID).Scan(&granted); err != nil {
+		t.Fatalf("GetCommitmentClosures, droits : %v", err)
+	}
+	if _, err := testCtx.DB.Exec(`DELETE FROM rights WHERE users_id=$1
+	AND physical_op_id=$2`, uID, opID); err != nil {
+		t.Fatalf("GetCommitmentClosures, droits : %v", err)
+	}
+	defer func() {
+		testCtx.DB.Exec(`DELETE FROM rights WHERE users_id=$1 AND physical_op_id=$2`,
+			uID, opID)
+		if granted {
+			testCtx.DB.Exec(`INSERT INTO rights (users_id,physical_op_id)
+			VALUES($1,$2)`, uID, opID)
+		}
+	}()
+	testCases := []testCase{
+		notLoggedTestCase,
+		{Token: testCtx.User.Token,
+			Status:        http.StatusOK,
+			BodyContains:  []string{`"CommitmentClosure":[]`},
+			CountItemName: `"commitment_id"`,
+			ArraySize:     0},
+		{Token: testCtx.Admin.Token,
+			Status:        http.StatusOK,
+			BodyContains:  []string{`"CommitmentClosure":[`, `"commitment_id":219`},
+			CountItemName: `"commitment_id"`,
+			ArraySize:     1},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/commitment_closures").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetCommitmentClosures") {
+		t.Error(r)
+	}
+	if _, err := testCtx.DB.Exec(`INSERT INTO rights (users_id,physical_op_id)
+	VALUES($1,$2)`, uID, opID); err != nil {
+		t.Fatalf("GetCommitmentClosures, droits : %v", err)
+	}
+	testCases = []testCase{
+		{Token: testCtx.User.Token,
+			Status:        http.StatusOK,
+			BodyContains:  []string{`"commitment_id":219`},
+			CountItemName: `"commitment_id"`,
+			ArraySize:     1},
+	}
+	for _, r := range chkTestCases(testCases, f, "GetCommitmentClosures droits") {
+		t.Error(r)
+	}
+}
+
+// reopenCommitmentTest check route is protected and closure removed
+func reopenCommitmentTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			ID:           "219",
+			Status:       http.StatusOK,
+			BodyContains: []string{"Engagement réouvert"}},
+		{Token: testCtx.Admin.Token,
+			ID:           "219",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Réouverture d'engagement, requête : Engagement non soldé"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.DELETE("/api/financial_commitments/"+tc.ID+"/closure").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "ReopenCommitment") {
+		t.Error(r)
+	}
+}
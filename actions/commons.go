@@ -1,5 +1,7 @@
 package actions
 
+import "github.com/Iledant/iris-propera/models"
+
 type jsonError struct {
 	Error string `json:"error"`
 }
@@ -7,3 +9,10 @@ type jsonError struct {
 type jsonMessage struct {
 	Message string `json:"message"`
 }
+
+// importResp is sent back by batch imports with the reconciliation of the
+// imported amounts
+type importResp struct {
+	Message        string                `json:"message"`
+	Reconciliation models.Reconciliation `json:"Reconciliation"`
+}
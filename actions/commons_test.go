@@ -79,6 +79,8 @@ func TestAll(t *testing.T) {
 		testEvent(t)
 		testFinancialCommitment(t)
 		testImportLog(t)
+		testImportJob(t)
+		testDropScheduler(t)
 		testOpDptRatio(t)
 		testPaymentRatio(t)
 		testPaymentType(t)
@@ -100,6 +102,9 @@ func TestAll(t *testing.T) {
 		testUser(t, &testCtx.Config.Users.User)
 		testPaymentPrevisions(t)
 		testConsistency(t)
+		testPmtCmtProposal(t)
+		testCommitmentBalance(t)
+		testLapseAlert(t)
 		testAvgPmtTime(t)
 		testPaymentDemands(t)
 		testPaymentDelays(t)
@@ -107,6 +112,11 @@ func TestAll(t *testing.T) {
 		testPlanForecasts(t)
 		testFlowStockDelays(t)
 		testPaymentDemandsStocks(t)
+		testPrevisionSnapshot(t)
+		testBudgetAuthorization(t)
+		testBudgetVersion(t)
+		testBudgetExecution(t)
+		testTerritory(t)
 	})
 }
 
@@ -0,0 +1,236 @@
+package actions
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// Sub directories of the drop directory where imported files are moved
+const (
+	processedDir = "processed"
+	failedDir    = "failed"
+)
+
+// dropImportFunc imports the content of a dropped file. The record is used to
+// check if the same content was already imported.
+type dropImportFunc func(f *os.File, rec *models.ImportRecord, db *sql.DB) (interface{}, error)
+
+// dropImporters links the kinds of imports to the functions processing the
+// dropped files
+var dropImporters = map[string]dropImportFunc{
+	"FinancialCommitments": dropFcs,
+	"Payments":             dropPayments,
+	"Pendings":             dropPendings,
+	"BudgetCredits":        dropBudgetCredits,
+}
+
+// DefaultDropPatterns are the file patterns used for each kind of import when
+// no pattern is configured
+var DefaultDropPatterns = map[string]string{
+	"FinancialCommitments": "engagements_*.json",
+	"Payments":             "paiements_*.json",
+	"Pendings":             "en_cours_*.json",
+	"BudgetCredits":        "credits_*.json",
+}
+
+// DropScheduler watches a directory for files to import
+type DropScheduler struct {
+	Dir      string
+	Patterns map[string]string
+	// MinAge is the delay since the last modification of a file before it's
+	// imported, to avoid reading a file that is still being copied
+	MinAge time.Duration
+	db     *sql.DB
+	app    *iris.Application
+}
+
+// NewDropScheduler returns a scheduler for the directory using the default
+// patterns for kinds that are not configured. Unknown kinds are rejected.
+func NewDropScheduler(app *iris.Application, db *sql.DB, dir string,
+	patterns map[string]string) (*DropScheduler, error) {
+	s := DropScheduler{Dir: dir, Patterns: make(map[string]string),
+		MinAge: 10 * time.Second, db: db, app: app}
+	for k, p := range DefaultDropPatterns {
+		s.Patterns[k] = p
+	}
+	for k, p := range patterns {
+		if _, ok := dropImporters[k]; !ok {
+			return nil, fmt.Errorf("type d'import %s inconnu", k)
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("motif %s invalide : %v", p, err)
+		}
+		s.Patterns[k] = p
+	}
+	for _, d := range []string{processedDir, failedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// Start launches the goroutine scanning the directory at the given interval.
+func (s *DropScheduler) Start(interval time.Duration) {
+	go func() {
+		for {
+			s.Scan()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Scan imports all the files of the directory matching a pattern, moves them
+// to the processed or failed sub directory according to the outcome and
+// returns the import jobs that have been recorded in the import history.
+func (s *DropScheduler) Scan() []models.ImportJob {
+	kinds := make([]string, 0, len(s.Patterns))
+	for k := range s.Patterns {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	var jobs []models.ImportJob
+	done := make(map[string]bool)
+	for _, k := range kinds {
+		files, err := filepath.Glob(filepath.Join(s.Dir, s.Patterns[k]))
+		if err != nil {
+			s.app.Logger().Errorf("Import automatique %s : %v", k, err)
+			continue
+		}
+		for _, f := range files {
+			if done[f] || !s.ready(f) {
+				continue
+			}
+			done[f] = true
+			if job, ok := s.importFile(k, f); ok {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	return jobs
+}
+
+// ready checks if the file is a regular file old enough to be imported.
+func (s *DropScheduler) ready(name string) bool {
+	info, err := os.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return time.Since(info.ModTime()) >= s.MinAge
+}
+
+// importFile launches the import of the file recording it as an import job
+// and moves the file according to the outcome. If the job can't be recorded,
+// the file is left in place to be imported by a next scan and false returned.
+func (s *DropScheduler) importFile(kind, name string) (models.ImportJob, bool) {
+	job := models.ImportJob{Kind: kind,
+		Source: models.NullString{Valid: true, String: filepath.Base(name)}}
+	if err := job.Create(s.db); err != nil {
+		s.app.Logger().Errorf("Import automatique %s, création : %v", name, err)
+		return job, false
+	}
+	job.SetPhase(models.StagingPhase, s.db)
+	result, err := s.runImport(kind, name)
+	job.Finish(result, err, s.db)
+	dest := processedDir
+	if err != nil {
+		dest = failedDir
+		s.app.Logger().Warnf("Import automatique %s : %v", name, err)
+	} else {
+		s.app.Logger().Infof("Import automatique %s terminé", name)
+	}
+	target := filepath.Join(s.Dir, dest,
+		time.Now().Format("20060102-150405_")+filepath.Base(name))
+	if err = os.Rename(name, target); err != nil {
+		s.app.Logger().Errorf("Import automatique %s, déplacement : %v", name, err)
+	}
+	return job, true
+}
+
+// runImport opens the file and imports it. If the content was already
+// imported, the stored result is sent back.
+func (s *DropScheduler) runImport(kind, name string) (interface{}, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec := models.ImportRecord{Kind: kind}
+	result, err := dropImporters[kind](f, &rec, s.db)
+	if err == models.ErrAlreadyImported {
+		return rec.Result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// dropStream returns a stream decoding the file, using NDJSON if the file has
+// the ndjson extension.
+func dropStream(f *os.File, key string) *models.BatchStream {
+	ndjson := strings.EqualFold(filepath.Ext(f.Name()), ".ndjson")
+	return models.NewBatchStream(f, key, ndjson, maxBatchRows)
+}
+
+// dropDecode decodes the whole file into v.
+func dropDecode(f *os.File, v interface{}) error {
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("décodage : %v", err)
+	}
+	return nil
+}
+
+func dropFcs(f *os.File, rec *models.ImportRecord, db *sql.DB) (interface{}, error) {
+	var b models.FinancialCommitmentsBatch
+	resp, err := b.SaveStream(dropStream(f, "FinancialCommitment"),
+		recordGuard{rec: rec}, db)
+	if err != nil {
+		return nil, err
+	}
+	return *resp, nil
+}
+
+func dropPayments(f *os.File, rec *models.ImportRecord, db *sql.DB) (interface{}, error) {
+	var b models.PaymentBatch
+	recon, err := b.SaveStream(dropStream(f, "Payment"),
+		recordGuard{rec, reconResp("Paiements importés")}, db)
+	if err != nil {
+		return nil, err
+	}
+	return importResp{"Paiements importés", *recon}, nil
+}
+
+func dropPendings(f *os.File, rec *models.ImportRecord, db *sql.DB) (interface{}, error) {
+	var b models.PendingsBatch
+	if err := dropDecode(f, &b); err != nil {
+		return nil, err
+	}
+	recon, err := b.Save(recordGuard{rec,
+		reconResp("Engagements en cours importés")}, db)
+	if err != nil {
+		return nil, err
+	}
+	return importResp{"Engagements en cours importés", *recon}, nil
+}
+
+func dropBudgetCredits(f *os.File, rec *models.ImportRecord, db *sql.DB) (interface{}, error) {
+	var b models.BudgetCreditBatch
+	if err := dropDecode(f, &b); err != nil {
+		return nil, err
+	}
+	recon, err := b.Save(recordGuard{rec, reconResp("Credits importés")}, db)
+	if err != nil {
+		return nil, err
+	}
+	return importResp{"Credits importés", *recon}, nil
+}
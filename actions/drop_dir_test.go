@@ -0,0 +1,126 @@
+package actions
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testDropScheduler(t *testing.T) {
+	t.Run("DropScheduler", func(t *testing.T) {
+		dropSchedulerScanTest(t)
+		dropSchedulerNoJobTest(t)
+		getImportJobsTest(testCtx.E, t)
+	})
+}
+
+// dropSchedulerScanTest check dropped files are imported and moved according
+// to the outcome
+func dropSchedulerScanTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "propera_drop")
+	if err != nil {
+		t.Fatalf("Répertoire temporaire : %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err = NewDropScheduler(testCtx.App, testCtx.DB, dir,
+		map[string]string{"Unknown": "*.json"}); err == nil {
+		t.Error("NewDropScheduler : erreur attendue sur un type inconnu")
+	}
+	s, err := NewDropScheduler(testCtx.App, testCtx.DB, dir,
+		map[string]string{"Payments": "pmt_*.json"})
+	if err != nil {
+		t.Fatalf("NewDropScheduler : %v", err)
+	}
+	s.MinAge = 0
+	files := map[string]string{
+		//cSpell:disable
+		"pmt_ok.json":      `{"Payment":[{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43218,"number":"99010","value":42.5,"cancelled_value":0,"beneficiary_code":22844}]}`,
+		"pmt_ko.json":      `{"Payment":[{"coriolis_year":2000}]}`,
+		"paiements_x.json": `{"Payment":[]}`,
+		//cSpell:enable
+	}
+	for n, c := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, n), []byte(c), 0644); err != nil {
+			t.Fatalf("Écriture %s : %v", n, err)
+		}
+	}
+	jobs := s.Scan()
+	if len(jobs) != 2 {
+		t.Errorf("Scan : 2 imports attendus, %d reçus", len(jobs))
+	}
+	for _, j := range jobs {
+		switch j.Source.String {
+		case "pmt_ok.json":
+			if j.Status != "done" || !strings.Contains(string(j.Result), `"import_total":4250`) {
+				t.Errorf("Scan : pmt_ok.json statut %s résultat %s", j.Status, j.Result)
+			}
+		case "pmt_ko.json":
+			if j.Status != "failed" {
+				t.Errorf("Scan : pmt_ko.json statut %s", j.Status)
+			}
+		default:
+			t.Errorf("Scan : fichier %s inattendu", j.Source.String)
+		}
+	}
+	for d, n := range map[string]string{processedDir: "pmt_ok.json", failedDir: "pmt_ko.json"} {
+		moved, _ := filepath.Glob(filepath.Join(dir, d, "*_"+n))
+		if len(moved) != 1 {
+			t.Errorf("Scan : %s non déplacé dans %s", n, d)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, "paiements_x.json")); err != nil {
+		t.Errorf("Scan : paiements_x.json ne devrait pas être importé")
+	}
+}
+
+// dropSchedulerNoJobTest check a file is left in place when its import job
+// can't be recorded
+func dropSchedulerNoJobTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "propera_drop")
+	if err != nil {
+		t.Fatalf("Répertoire temporaire : %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatalf("Ouverture base : %v", err)
+	}
+	db.Close()
+	s, err := NewDropScheduler(testCtx.App, db, dir, nil)
+	if err != nil {
+		t.Fatalf("NewDropScheduler : %v", err)
+	}
+	s.MinAge = 0
+	name := filepath.Join(dir, "paiements_x.json")
+	if err = ioutil.WriteFile(name, []byte(`{"Payment":[]}`), 0644); err != nil {
+		t.Fatalf("Écriture : %v", err)
+	}
+	if jobs := s.Scan(); len(jobs) != 0 {
+		t.Errorf("Scan : aucun import attendu, %d reçus", len(jobs))
+	}
+	if _, err = os.Stat(name); err != nil {
+		t.Errorf("Scan : paiements_x.json devrait rester en place")
+	}
+}
+
+// getImportJobsTest check route is protected and the history is sent
+func getImportJobsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{"ImportJob", `"source":"pmt_ok.json"`, `"source":"pmt_ko.json"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/jobs").WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetImportJobs") {
+		t.Error(r)
+	}
+}
@@ -244,18 +244,36 @@ func UnlinkFcs(ctx iris.Context) {
 	}
 }
 
+// SetAutoLinkThreshold configures the score above which the financial
+// commitments imports link automatically a commitment to its best proposed
+// physical operation. A zero value keeps the default.
+func SetAutoLinkThreshold(threshold float64) {
+	if threshold > 0 {
+		models.CmtOpMatch.AutoLinkThreshold = threshold
+	}
+}
+
 // BatchFcs handles the post request with an array of financial commitments (IRIS import).
+// The lines are decoded and sent to the database as they arrive. The result of
+// a previous import with the same content or idempotency key is sent back
+// without importing again, a conflict being sent if the idempotency key was
+// used for another content.
 func BatchFcs(ctx iris.Context) {
 	db, req := ctx.Values().Get("db").(*sql.DB), models.FinancialCommitmentsBatch{}
-	if err := ctx.ReadJSON(&req); err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
-		ctx.JSON(jsonError{"Batch engagements, décodage : " + err.Error()})
+	rec := newImportRecord(ctx, "FinancialCommitments")
+	resp, err := req.SaveStream(batchStream(ctx, "FinancialCommitment"),
+		recordGuard{rec: &rec}, db)
+	if err == models.ErrAlreadyImported {
+		sendReplay(ctx, &rec)
+		return
+	}
+	if err == models.ErrIdempotencyConflict {
+		ctx.StatusCode(http.StatusConflict)
+		ctx.JSON(jsonError{"Batch engagements, idempotence : " + err.Error()})
 		return
 	}
-	resp, err := req.Save(db)
 	if err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
-		ctx.JSON(jsonError{"Batch engagements, requête : " + err.Error()})
+		sendBatchError(ctx, "Batch engagements", err)
 		return
 	}
 	ctx.StatusCode(http.StatusOK)
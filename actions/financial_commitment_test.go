@@ -1,6 +1,7 @@
 package actions
 
 import (
+	"fmt"
 	"net/http"
 	"testing"
 
@@ -21,6 +22,11 @@ func testFinancialCommitment(t *testing.T) {
 		batchFcsTest(testCtx.E, t)
 		batchOpFcsTest(testCtx.E, t)
 		setCmtOpLinksTest(testCtx.E, t)
+		cmtOpDecisionFeedbackTest(testCtx.E, t)
+		cmtOpAutoLinkRelinkTest(testCtx.E, t)
+		getCmtOpProposalStatsTest(testCtx.E, t)
+		getOpNameAliasesTest(testCtx.E, t)
+		deleteOpNameAliasTest(testCtx.E, t)
 	})
 }
 
@@ -407,7 +413,7 @@ func batchFcsTest(e *httpexpect.Expect, t *testing.T) {
 		{
 			Token:        testCtx.Admin.Token,
 			Status:       http.StatusOK,
-			BodyContains: []string{`CmtOpProposal":[`},
+			BodyContains: []string{`CmtOpProposal":[`, `"Reconciliation"`},
 			//cSpell:disable
 			Sent: []byte(`{"FinancialCommitment":[
 				{"chapter":"907","action":"17700301 - Intégration environnementale des ` +
@@ -496,6 +502,12 @@ func setCmtOpLinksTest(e *httpexpect.Expect, t *testing.T) {
 			Status:       http.StatusOK,
 			BodyContains: []string{"Liens engagements / opérations mis à jour"},
 			Sent:         []byte(`{"CmtOpLink":[{"op_id":501,"commitment_id":4319}]}`)},
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{"Liens engagements / opérations mis à jour"},
+			Sent: []byte(`{"CmtOpLink":[],` +
+				`"RejectedCmtOpLink":[{"op_id":501,"commitment_id":4319}]}`)},
 	}
 	f := func(tc testCase) *httpexpect.Response {
 		return e.POST("/api/cmt_op_link").
@@ -519,3 +531,141 @@ func setCmtOpLinksTest(e *httpexpect.Expect, t *testing.T) {
 		t.Error(r)
 	}
 }
+
+// cmtOpDecisionFeedbackTest checks that validating a link learns the alias of
+// the IRIS op name stored with the commitment, even without proposal, and
+// that a rejected pair is no longer proposed by the next import.
+func cmtOpDecisionFeedbackTest(e *httpexpect.Expect, t *testing.T) {
+	var cmtID int64
+	if err := testCtx.DB.QueryRow(`SELECT id FROM financial_commitment
+		WHERE iris_code='18002439'`).Scan(&cmtID); err != nil {
+		t.Fatalf("CmtOpDecisionFeedback, engagement : %v", err)
+	}
+	if _, err := testCtx.DB.Exec(`DELETE FROM cmt_op_proposal WHERE commitment_id=$1`,
+		cmtID); err != nil {
+		t.Fatalf("CmtOpDecisionFeedback, propositions : %v", err)
+	}
+	testCases := []testCase{
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{"Liens engagements / opérations mis à jour"},
+			Sent: []byte(fmt.Sprintf(`{"CmtOpLink":[{"op_id":501,"commitment_id":%d}]}`,
+				cmtID))},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/cmt_op_link").
+			WithHeader("Authorization", "Bearer "+tc.Token).
+			WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CmtOpDecisionFeedback accept") {
+		t.Error(r)
+	}
+	testCases = []testCase{
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusOK,
+			BodyContains: []string{`"iris_op_name":"ROUTE INNOVATION",` +
+				`"physical_op_id":501`}},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/op_name_aliases").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CmtOpDecisionFeedback alias") {
+		t.Error(r)
+	}
+	testCases = []testCase{
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{"Liens engagements / opérations mis à jour"},
+			Sent: []byte(fmt.Sprintf(`{"CmtOpLink":[],`+
+				`"RejectedCmtOpLink":[{"op_id":501,"commitment_id":%d}]}`, cmtID))},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/cmt_op_link").
+			WithHeader("Authorization", "Bearer "+tc.Token).
+			WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CmtOpDecisionFeedback reject") {
+		t.Error(r)
+	}
+	var count int64
+	if err := testCtx.DB.QueryRow(`SELECT count(1) FROM op_name_alias
+		WHERE iris_op_name='ROUTE INNOVATION'`).Scan(&count); err != nil || count != 0 {
+		t.Errorf("CmtOpDecisionFeedback : alias rejeté toujours présent %d %v", count, err)
+	}
+	// The alias would make the operation a candidate again without the rejection
+	if _, err := testCtx.DB.Exec(`INSERT INTO op_name_alias (iris_op_name,
+		physical_op_id,count,updated_at) VALUES('ROUTE INNOVATION',501,1,now())`); err != nil {
+		t.Fatalf("CmtOpDecisionFeedback, insertion alias : %v", err)
+	}
+	testCases = []testCase{
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{`CmtOpProposal":[`},
+			//cSpell:disable
+			Sent: []byte(`{"FinancialCommitment":[
+				{"chapter":"907","action":"17700301 - Intégration environnementale des ` +
+				`infrastructures de transport","iris_code":"18002439","coriolis_year":` +
+				`"2018","coriolis_egt_code":"IRIS","coriolis_egt_num":"553827",` +
+				`"coriolis_egt_line":"1","name":"ROUTE - INNOVATION INFRASTRUCTURE ` +
+				`ROUTIERE - VAL D'OISE","beneficiary":"DEPARTEMENT DU VAL D'OISE",` +
+				`"beneficiary_code":2306,"date":43175,"value":3000000,"lapse_date":44271,` +
+				`"app":false,"op_name":"Route - innovation"}]}`)},
+		//cSpell:enable
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/financial_commitments").
+			WithHeader("Authorization", "Bearer "+tc.Token).
+			WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CmtOpDecisionFeedback import") {
+		t.Error(r)
+	}
+	if err := testCtx.DB.QueryRow(`SELECT count(1) FROM cmt_op_proposal
+		WHERE commitment_id=$1 AND op_id=501 AND decision IS NULL`,
+		cmtID).Scan(&count); err != nil || count != 0 {
+		t.Errorf("CmtOpDecisionFeedback : paire rejetée proposée %d %v", count, err)
+	}
+	if _, err := testCtx.DB.Exec(`DELETE FROM op_name_alias
+		WHERE iris_op_name='ROUTE INNOVATION'`); err != nil {
+		t.Errorf("CmtOpDecisionFeedback, suppression alias : %v", err)
+	}
+}
+
+// cmtOpAutoLinkRelinkTest checks that an automatic link stored as accepted is
+// rejected when a user links the commitment to another operation
+func cmtOpAutoLinkRelinkTest(e *httpexpect.Expect, t *testing.T) {
+	var ID int64
+	if err := testCtx.DB.QueryRow(`INSERT INTO cmt_op_proposal (commitment_id,
+		op_id,score,auto_linked,decision,created_at,decided_at)
+		VALUES(4319,220,0.9,TRUE,'accepted',now(),now()) RETURNING id`).
+		Scan(&ID); err != nil {
+		t.Fatalf("CmtOpAutoLinkRelink, proposition : %v", err)
+	}
+	defer testCtx.DB.Exec(`DELETE FROM cmt_op_proposal WHERE id=$1`, ID)
+	if _, err := testCtx.DB.Exec(`UPDATE financial_commitment SET physical_op_id=220
+		WHERE id=4319`); err != nil {
+		t.Fatalf("CmtOpAutoLinkRelink, lien : %v", err)
+	}
+	defer testCtx.DB.Exec(`UPDATE financial_commitment SET physical_op_id=NULL
+		WHERE id=4319`)
+	e.POST("/api/cmt_op_link").WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+		WithBytes([]byte(`{"CmtOpLink":[{"op_id":501,"commitment_id":4319}]}`)).
+		Expect().Status(http.StatusOK)
+	var decision string
+	if err := testCtx.DB.QueryRow(`SELECT decision FROM cmt_op_proposal WHERE id=$1`,
+		ID).Scan(&decision); err != nil {
+		t.Fatalf("CmtOpAutoLinkRelink, décision : %v", err)
+	}
+	if decision != "rejected" {
+		t.Errorf("CmtOpAutoLinkRelink : attendu rejected, reçu %s", decision)
+	}
+	e.POST("/api/cmt_op_link").WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+		WithBytes([]byte(`{"CmtOpLink":[],` +
+			`"RejectedCmtOpLink":[{"op_id":501,"commitment_id":4319}]}`)).
+		Expect().Status(http.StatusOK)
+}
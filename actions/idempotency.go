@@ -0,0 +1,89 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// idempotencyDelay is the period during which an import with the same content
+// or the same idempotency key is not processed again
+const idempotencyDelay = 24 * time.Hour
+
+// newImportRecord returns the record of an import of the given kind using the
+// optional Idempotency-Key header of the request.
+func newImportRecord(ctx iris.Context, kind string) models.ImportRecord {
+	key := ctx.GetHeader("Idempotency-Key")
+	return models.ImportRecord{Kind: kind,
+		IdempotencyKey: models.NullString{Valid: key != "", String: key}}
+}
+
+// replayImport looks for a previous successful import with the same content
+// hash or idempotency key and, if found, sends back its stored result. A
+// conflict is sent if the idempotency key was used for another content. It
+// returns true if a response has been sent.
+func replayImport(ctx iris.Context, rec *models.ImportRecord, errPrefix string) bool {
+	db := ctx.Values().Get("db").(*sql.DB)
+	found, err := rec.Find(time.Now().Add(-idempotencyDelay), db)
+	if err == models.ErrIdempotencyConflict {
+		ctx.StatusCode(http.StatusConflict)
+		ctx.JSON(jsonError{errPrefix + ", idempotence : " + err.Error()})
+		return true
+	}
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{errPrefix + ", idempotence : " + err.Error()})
+		return true
+	}
+	if !found {
+		return false
+	}
+	sendReplay(ctx, rec)
+	return true
+}
+
+// sendReplay sends back the stored result of a previous import.
+func sendReplay(ctx iris.Context, rec *models.ImportRecord) {
+	ctx.Header("Idempotent-Replayed", "true")
+	ctx.ContentType("application/json")
+	ctx.StatusCode(http.StatusOK)
+	ctx.Write(rec.Result)
+}
+
+// recordGuard protects an import with its record: the content is locked and
+// checked within the import transaction and the response of the import is
+// recorded before the commit. resp builds the recorded response from the
+// result of the import, a nil resp recording the result as is.
+type recordGuard struct {
+	rec  *models.ImportRecord
+	resp func(result interface{}) interface{}
+}
+
+// Check locks the content and the idempotency key of the record and returns
+// true if they were already imported.
+func (g recordGuard) Check(contentHash string, tx *sql.Tx) (bool, error) {
+	g.rec.ContentHash = contentHash
+	if err := g.rec.Lock(tx); err != nil {
+		return false, err
+	}
+	return g.rec.Find(time.Now().Add(-idempotencyDelay), tx)
+}
+
+// Record stores the response of the import within the import transaction.
+func (g recordGuard) Record(result interface{}, tx *sql.Tx) error {
+	if g.resp != nil {
+		result = g.resp(result)
+	}
+	return g.rec.Save(result, tx)
+}
+
+// reconResp returns the builder of the response of an import sending back its
+// reconciliation with the message.
+func reconResp(message string) func(interface{}) interface{} {
+	return func(result interface{}) interface{} {
+		return importResp{message, *result.(*models.Reconciliation)}
+	}
+}
@@ -0,0 +1,296 @@
+package actions
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// importRunFunc launches a batch import reporting its progress and returns
+// the result to store in the job
+type importRunFunc func(progress models.ImportProgress, db *sql.DB) (interface{}, error)
+
+// importTask is an import job waiting to be processed by a worker
+type importTask struct {
+	Job models.ImportJob
+	Run importRunFunc
+}
+
+type importJobResp struct {
+	ImportJob models.ImportJob `json:"ImportJob"`
+}
+
+const (
+	importWorkersCount = 2
+	importQueueSize    = 32
+	jobEventsDelay     = time.Second
+	importHistorySize  = 200
+)
+
+var importTasks = make(chan importTask, importQueueSize)
+
+// SetInstanceID configures the identifier of the server instance owning the
+// import jobs it creates. An empty value keeps the host name.
+func SetInstanceID(ID string) {
+	if ID != "" {
+		models.JobOwner = ID
+	}
+}
+
+// startImportWorkers marks as failed the jobs left pending or running by a
+// previous execution of the instance, their content being lost, and launches
+// the pool of goroutines processing import jobs and the heartbeat of the jobs
+func startImportWorkers(db *sql.DB) {
+	models.FailOrphanJobs(db)
+	for i := 0; i < importWorkersCount; i++ {
+		go importWorker(db)
+	}
+	go jobsHeartbeat(db)
+}
+
+// jobsHeartbeat periodically refreshes the heartbeat of the jobs of the
+// instance and fails the jobs of the instances that stopped beating.
+func jobsHeartbeat(db *sql.DB) {
+	for range time.Tick(models.JobHeartbeat) {
+		models.BeatJobs(db)
+		models.FailStaleJobs(db)
+	}
+}
+
+// importWorker processes the queued import jobs, storing the phases and the
+// final result into database
+func importWorker(db *sql.DB) {
+	for t := range importTasks {
+		runImportTask(t, db)
+	}
+}
+
+// runImportTask runs the import of the task and stores its result. A panic
+// of the import marks the job as failed instead of stopping the worker.
+func runImportTask(t importTask, db *sql.DB) {
+	job := t.Job
+	defer func() {
+		if r := recover(); r != nil {
+			job.Finish(nil, fmt.Errorf("erreur interne : %v", r), db)
+		}
+	}()
+	progress := func(phase string) { job.SetPhase(phase, db) }
+	result, err := t.Run(progress, db)
+	job.Finish(result, err, db)
+}
+
+// queueImportJob creates the job in database and queues it for the workers.
+// The response is sent according to the outcome and false returned if the
+// job isn't queued.
+func queueImportJob(ctx iris.Context, kind string, run importRunFunc,
+	errPrefix string) bool {
+	return queueJob(ctx, models.ImportJob{Kind: kind}, run, errPrefix)
+}
+
+// queueRecordJob queues the job of the import record unless a job of the same
+// content is still in progress, in which case this job is sent back. A
+// conflict is sent if the idempotency key is used by a job of another content.
+func queueRecordJob(ctx iris.Context, rec *models.ImportRecord, run importRunFunc,
+	errPrefix string) bool {
+	db := ctx.Values().Get("db").(*sql.DB)
+	job := models.ImportJob{Kind: rec.Kind,
+		ContentHash:    models.NullString{Valid: true, String: rec.ContentHash},
+		IdempotencyKey: rec.IdempotencyKey}
+	found, err := job.FindInProgress(db)
+	if err == models.ErrIdempotencyConflict {
+		ctx.StatusCode(http.StatusConflict)
+		ctx.JSON(jsonError{errPrefix + ", idempotence : " + err.Error()})
+		return false
+	}
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{errPrefix + ", requête : " + err.Error()})
+		return false
+	}
+	if found {
+		ctx.Header("Idempotent-Replayed", "true")
+		ctx.StatusCode(http.StatusAccepted)
+		ctx.JSON(importJobResp{job})
+		return false
+	}
+	return queueJob(ctx, job, run, errPrefix)
+}
+
+// queueJob creates the job in database and queues it for the workers.
+func queueJob(ctx iris.Context, job models.ImportJob, run importRunFunc,
+	errPrefix string) bool {
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := job.Create(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{errPrefix + ", requête : " + err.Error()})
+		return false
+	}
+	select {
+	case importTasks <- importTask{Job: job, Run: run}:
+	default:
+		job.Finish(nil, fmt.Errorf("file d'attente pleine"), db)
+		ctx.StatusCode(http.StatusServiceUnavailable)
+		ctx.JSON(jsonError{errPrefix + " : file d'attente des imports pleine"})
+		return false
+	}
+	ctx.StatusCode(http.StatusAccepted)
+	ctx.JSON(importJobResp{job})
+	return true
+}
+
+// JobFcs handles the post request with an array of financial commitments
+// (IRIS import) and queues it as an import job unless the same content or
+// idempotency key was already imported or is being imported. The body is copied into a temporary
+// file that the job decodes line by line.
+func JobFcs(ctx iris.Context) {
+	b := spoolBatch(ctx, "FinancialCommitment",
+		func() interface{} { return &models.FinancialCommitmentLine{} },
+		"Import engagements")
+	if b == nil {
+		return
+	}
+	rec := newImportRecord(ctx, "FinancialCommitments")
+	rec.ContentHash = b.Hash
+	if replayImport(ctx, &rec, "Import engagements") {
+		b.Remove()
+		return
+	}
+	if !queueRecordJob(ctx, &rec,
+		func(progress models.ImportProgress, db *sql.DB) (interface{}, error) {
+			defer b.Remove()
+			s, err := b.Stream()
+			if err != nil {
+				return nil, err
+			}
+			var req models.FinancialCommitmentsBatch
+			resp, err := req.SaveStreamWithProgress(s, recordGuard{rec: &rec},
+				progress, db)
+			if err == models.ErrAlreadyImported {
+				return rec.Result, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return *resp, nil
+		}, "Import engagements") {
+		b.Remove()
+	}
+}
+
+// JobPayments handles the post request with an array of payments and queues
+// it as an import job unless the same content or idempotency key was already
+// imported or is being imported. The body is copied into a temporary file that the job decodes
+// line by line.
+func JobPayments(ctx iris.Context) {
+	b := spoolBatch(ctx, "Payment", func() interface{} { return &models.PaymentLine{} },
+		"Import paiements")
+	if b == nil {
+		return
+	}
+	rec := newImportRecord(ctx, "Payments")
+	rec.ContentHash = b.Hash
+	if replayImport(ctx, &rec, "Import paiements") {
+		b.Remove()
+		return
+	}
+	if !queueRecordJob(ctx, &rec,
+		func(progress models.ImportProgress, db *sql.DB) (interface{}, error) {
+			defer b.Remove()
+			s, err := b.Stream()
+			if err != nil {
+				return nil, err
+			}
+			var req models.PaymentBatch
+			recon, err := req.SaveStreamWithProgress(s,
+				recordGuard{&rec, reconResp("Paiements importés")}, progress, db)
+			if err == models.ErrAlreadyImported {
+				return rec.Result, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return importResp{"Paiements importés", *recon}, nil
+		}, "Import paiements") {
+		b.Remove()
+	}
+}
+
+// GetImportJobs handles the get request to fetch the history of the last
+// import jobs, including the ones launched from the drop directory.
+func GetImportJobs(ctx iris.Context) {
+	var resp models.ImportJobs
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := resp.GetAll(importHistorySize, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Historique des imports, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// GetImportJob handles the get request to fetch the status of an import job.
+func GetImportJob(ctx iris.Context) {
+	jobID, err := ctx.Params().GetInt64("jobID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Statut d'import, paramètre : " + err.Error()})
+		return
+	}
+	resp, db := importJobResp{models.ImportJob{ID: jobID}}, ctx.Values().Get("db").(*sql.DB)
+	if err = resp.ImportJob.Get(db); err != nil {
+		ctx.StatusCode(http.StatusNotFound)
+		ctx.JSON(jsonError{"Statut d'import, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// GetImportJobEvents handles the get request to subscribe to the progress of
+// an import job using server-sent events. An event is sent each time the job
+// changes and the stream is closed when the job is finished.
+func GetImportJobEvents(ctx iris.Context) {
+	jobID, err := ctx.Params().GetInt64("jobID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Suivi d'import, paramètre : " + err.Error()})
+		return
+	}
+	job, db := models.ImportJob{ID: jobID}, ctx.Values().Get("db").(*sql.DB)
+	if err = job.Get(db); err != nil {
+		ctx.StatusCode(http.StatusNotFound)
+		ctx.JSON(jsonError{"Suivi d'import, requête : " + err.Error()})
+		return
+	}
+	ctx.ContentType("text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.StatusCode(http.StatusOK)
+	var last string
+	ctx.StreamWriter(func(w io.Writer) bool {
+		if last != "" {
+			time.Sleep(jobEventsDelay)
+			if err := job.Get(db); err != nil {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+				return false
+			}
+		}
+		content, err := json.Marshal(job)
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+			return false
+		}
+		if string(content) != last {
+			last = string(content)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", job.Status, last)
+		}
+		return !job.Finished()
+	})
+}
@@ -0,0 +1,223 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testImportJob(t *testing.T) {
+	t.Run("ImportJob", func(t *testing.T) {
+		jobPaymentsNDJSONTest(testCtx.E, t)
+		ID := jobPaymentsTest(testCtx.E, t)
+		if ID == 0 {
+			t.Fatal("Impossible de créer l'import")
+		}
+		getImportJobTest(testCtx.E, t, ID)
+		getImportJobEventsTest(testCtx.E, t, ID)
+		importJobPanicTest(t)
+		failOrphanJobsTest(t)
+		jobPaymentsConflictTest(testCtx.E, t)
+	})
+}
+
+// jobPaymentsTest check route is protected and a small batch is queued
+func jobPaymentsTest(e *httpexpect.Expect, t *testing.T) (ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"Payment":[{"coriolis_year":2000}]}`),
+			BodyContains: []string{"Import paiements, décodage"}},
+		{Token: testCtx.Admin.Token,
+			Status: http.StatusAccepted,
+			IDName: `"id"`,
+			//cSpell:disable
+			Sent: []byte(`{"Payment":[{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43215,"number":"15390","value":5623.8,"cancelled_value":0,"beneficiary_code":22844,"receipt_date":43200}]}`),
+			//cSpell:enable
+			BodyContains: []string{"ImportJob", `"kind":"Payments"`, `"status":"pending"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/jobs/payments").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "JobPayments", &ID) {
+		t.Error(r)
+	}
+	return ID
+}
+
+// jobPaymentsNDJSONTest check that NDJSON lines are decoded before the job is
+// queued
+func jobPaymentsNDJSONTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte("{\"coriolis_year\":\"2005\"}\n{\"coriolis_year\":2000}\n"),
+			BodyContains: []string{"Import paiements, décodage : décodage ligne 2"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/jobs/payments").WithHeader("Content-Type", "application/x-ndjson").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "JobPaymentsNDJSON") {
+		t.Error(r)
+	}
+}
+
+// jobPaymentsConflictTest check that an idempotency key can't be used for
+// jobs of different contents
+func jobPaymentsConflictTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		{Token: testCtx.Admin.Token,
+			Status: http.StatusAccepted,
+			//cSpell:disable
+			Sent: []byte(`{"Payment":[{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43218,"number":"99020","value":1.5,"cancelled_value":0,"beneficiary_code":22844}]}`),
+			//cSpell:enable
+			BodyContains: []string{"ImportJob", `"kind":"Payments"`}},
+		{Token: testCtx.Admin.Token,
+			Status: http.StatusConflict,
+			//cSpell:disable
+			Sent: []byte(`{"Payment":[{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43218,"number":"99020","value":2.5,"cancelled_value":0,"beneficiary_code":22844}]}`),
+			//cSpell:enable
+			BodyContains: []string{"Import paiements, idempotence : "}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/jobs/payments").WithHeader("Idempotency-Key", "job-test-1").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "JobPaymentsConflict") {
+		t.Error(r)
+	}
+}
+
+// getImportJobTest check route is protected and the job is processed
+func getImportJobTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	// Wait for the worker to process the job
+	for i := 0; i < 20; i++ {
+		body := string(e.GET("/api/jobs/"+strconv.Itoa(ID)).
+			WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).Expect().Content)
+		if !strings.Contains(body, `"status":"pending"`) &&
+			!strings.Contains(body, `"status":"running"`) {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusNotFound,
+			BodyContains: []string{"Statut d'import, requête : Import introuvable"}},
+		{Token: testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusOK,
+			BodyContains: []string{`"status":"done"`, `"phase":"linking"`,
+				`"result":{"message":"Paiements importés","Reconciliation":{"lines_count":1,` +
+					`"import_total":562380,"source_total":null,"difference":null}}`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/jobs/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetImportJob") {
+		t.Error(r)
+	}
+}
+
+// getImportJobEventsTest check route is protected and the stream sends the
+// final event
+func getImportJobEventsTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusNotFound,
+			BodyContains: []string{"Suivi d'import, requête : Import introuvable"}},
+		{Token: testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{"event: done", `"status":"done"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/jobs/"+tc.ID+"/events").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetImportJobEvents") {
+		t.Error(r)
+	}
+}
+
+// importJobPanicTest checks that a panic during an import marks the job as
+// failed
+func importJobPanicTest(t *testing.T) {
+	job := models.ImportJob{Kind: "Payments"}
+	if err := job.Create(testCtx.DB); err != nil {
+		t.Fatalf("ImportJobPanic, création : %v", err)
+	}
+	runImportTask(importTask{Job: job,
+		Run: func(progress models.ImportProgress, db *sql.DB) (interface{}, error) {
+			progress(models.StagingPhase)
+			panic("test")
+		}}, testCtx.DB)
+	if err := job.Get(testCtx.DB); err != nil {
+		t.Fatalf("ImportJobPanic, lecture : %v", err)
+	}
+	if job.Status != models.JobFailed || job.Error.String != "erreur interne : test" {
+		t.Errorf("ImportJobPanic : attendu failed, reçu %s %s", job.Status, job.Error.String)
+	}
+}
+
+// failOrphanJobsTest checks that the jobs left pending by the instance or by
+// an instance having a stale heartbeat are marked as failed, the live jobs of
+// the other instances being kept
+func failOrphanJobsTest(t *testing.T) {
+	job, other := models.ImportJob{Kind: "Payments"}, models.ImportJob{Kind: "Payments"}
+	if err := job.Create(testCtx.DB); err != nil {
+		t.Fatalf("FailOrphanJobs, création : %v", err)
+	}
+	if err := other.Create(testCtx.DB); err != nil {
+		t.Fatalf("FailOrphanJobs, création : %v", err)
+	}
+	defer testCtx.DB.Exec(`UPDATE import_job SET status=$1 WHERE id=$2`,
+		models.JobFailed, other.ID)
+	if _, err := testCtx.DB.Exec(`UPDATE import_job SET owner='autre instance'
+	WHERE id=$1`, other.ID); err != nil {
+		t.Fatalf("FailOrphanJobs, propriétaire : %v", err)
+	}
+	if err := models.FailOrphanJobs(testCtx.DB); err != nil {
+		t.Fatalf("FailOrphanJobs : %v", err)
+	}
+	if err := job.Get(testCtx.DB); err != nil {
+		t.Fatalf("FailOrphanJobs, lecture : %v", err)
+	}
+	if job.Status != models.JobFailed || !job.Error.Valid {
+		t.Errorf("FailOrphanJobs : attendu failed, reçu %s", job.Status)
+	}
+	if err := other.Get(testCtx.DB); err != nil {
+		t.Fatalf("FailOrphanJobs, lecture : %v", err)
+	}
+	if other.Status != models.JobPending {
+		t.Errorf("FailOrphanJobs autre instance : attendu pending, reçu %s",
+			other.Status)
+	}
+	if _, err := testCtx.DB.Exec(`UPDATE import_job SET heartbeat_at=$1
+	WHERE id=$2`, time.Now().Add(-2*models.JobStaleDelay), other.ID); err != nil {
+		t.Fatalf("FailStaleJobs, signe de vie : %v", err)
+	}
+	if err := models.FailStaleJobs(testCtx.DB); err != nil {
+		t.Fatalf("FailStaleJobs : %v", err)
+	}
+	if err := other.Get(testCtx.DB); err != nil {
+		t.Fatalf("FailStaleJobs, lecture : %v", err)
+	}
+	if other.Status != models.JobFailed || !other.Error.Valid {
+		t.Errorf("FailStaleJobs : attendu failed, reçu %s", other.Status)
+	}
+}
@@ -0,0 +1,188 @@
+package actions
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// lapseAlertsKind is the kind of the import jobs computing the lapse alerts
+const lapseAlertsKind = "LapseAlerts"
+
+// SetLapseDemandDays configures the delay in days during which a payment
+// demand prevents the alert on a commitment. A zero value keeps the default.
+func SetLapseDemandDays(days int64) {
+	if days > 0 {
+		models.LapseAlertConfig.DemandDays = days
+	}
+}
+
+// StartLapseAlerts launches the goroutine queuing the computation of the
+// lapse alerts at the given interval. The jobs are recorded in the import
+// history.
+func StartLapseAlerts(db *sql.DB, interval time.Duration) {
+	go func() {
+		for {
+			job := models.ImportJob{Kind: lapseAlertsKind}
+			if err := job.Create(db); err == nil {
+				importTasks <- importTask{Job: job, Run: runLapseAlerts}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// runLapseAlerts refreshes the lapse alerts and sends the monthly digests if
+// a mailer is configured.
+func runLapseAlerts(progress models.ImportProgress, db *sql.DB) (interface{}, error) {
+	resp := models.LapseAlertRun{FailedDigests: []string{}}
+	if err := resp.Run(progress, db); err != nil {
+		return nil, err
+	}
+	if mailer == nil {
+		return resp, nil
+	}
+	digests, err := models.GetDueLapseDigests(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range digests {
+		claimed, err := d.Claim(db)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			continue
+		}
+		if err = mailer.Send(d.Email, "Propera : engagements bientôt caducs",
+			lapseDigestBody(&d)); err != nil {
+			resp.FailedDigests = append(resp.FailedDigests, d.Email)
+			if err = d.Release(db); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		resp.Digests++
+	}
+	return resp, nil
+}
+
+// lapseDigestBody returns the text of the digest email.
+func lapseDigestBody(d *models.LapseDigest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Bonjour %s,\n\n", d.Name)
+	fmt.Fprintf(&b, "%d engagement(s) de vos opérations deviendront caducs "+
+		"prochainement sans demande de paiement récente :\n\n", len(d.Alerts))
+	for _, a := range d.Alerts {
+		op := "sans opération"
+		if a.OpNumber.Valid {
+			op = a.OpNumber.String + " " + a.OpName.String
+		}
+		fmt.Fprintf(&b, "- %s %s (%s, %s) : %d,%02d € restant à payer, caducité "+
+			"le %s (J-%d)\n", a.IrisCode, a.Name, a.Beneficiary, op,
+			a.Remaining/100, a.Remaining%100, a.LapseDate.Format("02/01/2006"),
+			a.DaysToLapse)
+	}
+	b.WriteString("\nCes alertes sont également disponibles dans Propera.\n")
+	return b.String()
+}
+
+// JobLapseAlerts handles the post request to launch the computation of the
+// lapse alerts and the digests as an import job.
+func JobLapseAlerts(ctx iris.Context) {
+	queueImportJob(ctx, lapseAlertsKind, runLapseAlerts, "Alertes de caducité")
+}
+
+// GetLapseAlerts handles the get request to fetch the lapse alerts of the
+// connected user, only the unread ones if the unread parameter is true.
+func GetLapseAlerts(ctx iris.Context) {
+	var unread bool
+	var err error
+	if ctx.URLParamExists("unread") {
+		if unread, err = ctx.URLParamBool("unread"); err != nil {
+			ctx.StatusCode(http.StatusBadRequest)
+			ctx.JSON(jsonError{"Alertes de caducité, paramètre : " + err.Error()})
+			return
+		}
+	}
+	uID := int64(ctx.Values().Get("uID").(int))
+	var resp models.LapseAlerts
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetByUser(uID, unread, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Alertes de caducité, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+type lapseAlertsReadReq struct {
+	IDs []int64 `json:"LapseAlertID"`
+}
+
+// ReadLapseAlerts handles the post request to mark as read the alerts of the
+// connected user given by their IDs or all of them if no ID is sent.
+func ReadLapseAlerts(ctx iris.Context) {
+	var req lapseAlertsReadReq
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Lecture des alertes, décodage : " + err.Error()})
+		return
+	}
+	uID := int64(ctx.Values().Get("uID").(int))
+	var alerts models.LapseAlerts
+	db := ctx.Values().Get("db").(*sql.DB)
+	count, err := alerts.MarkRead(uID, req.IDs, db)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Lecture des alertes, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{fmt.Sprintf("%d alerte(s) lue(s)", count)})
+}
+
+type lapseDigestResp struct {
+	LapseDigest models.LapseDigestSubscription `json:"LapseDigest"`
+}
+
+// GetLapseDigest handles the get request to fetch the subscription of the
+// connected user to the monthly digest.
+func GetLapseDigest(ctx iris.Context) {
+	resp := lapseDigestResp{models.LapseDigestSubscription{
+		UserID: int64(ctx.Values().Get("uID").(int))}}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := resp.LapseDigest.Get(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Synthèse des alertes, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// SetLapseDigest handles the post request to subscribe or unsubscribe the
+// connected user to the monthly digest.
+func SetLapseDigest(ctx iris.Context) {
+	var req lapseDigestResp
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Synthèse des alertes, décodage : " + err.Error()})
+		return
+	}
+	req.LapseDigest.UserID = int64(ctx.Values().Get("uID").(int))
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := req.LapseDigest.Save(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Synthèse des alertes, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(req)
+}
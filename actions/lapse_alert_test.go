@@ -0,0 +1,199 @@
+package actions
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testLapseAlert(t *testing.T) {
+	t.Run("LapseAlert", func(t *testing.T) {
+		ID := jobLapseAlertsTest(testCtx.E, t)
+		if ID == 0 {
+			t.Fatal("Impossible de lancer le calcul des alertes")
+		}
+		waitImportJob(testCtx.E, t, ID, `"alerts":`)
+		getLapseAlertsTest(testCtx.E, t)
+		readLapseAlertsTest(testCtx.E, t)
+		lapseDigestTest(testCtx.E, t)
+		lapseDigestBodyTest(t)
+		lapseDigestClaimTest(t)
+	})
+}
+
+// jobLapseAlertsTest check route is protected and the job is queued
+func jobLapseAlertsTest(e *httpexpect.Expect, t *testing.T) (ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusAccepted,
+			IDName:       `"id"`,
+			BodyContains: []string{"ImportJob", `"kind":"LapseAlerts"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/jobs/lapse_alerts").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "JobLapseAlerts", &ID) {
+		t.Error(r)
+	}
+	return ID
+}
+
+// getLapseAlertsTest check route is protected and alerts sent back
+func getLapseAlertsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{Token: testCtx.User.Token,
+			Param:        "abc",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Alertes de caducité, paramètre :"}},
+		{Token: testCtx.User.Token,
+			Param:        "true",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"LapseAlert":[`}},
+		{Token: testCtx.Admin.Token,
+			Param:        "false",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"LapseAlert":[`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/lapse_alerts").WithQuery("unread", tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetLapseAlerts") {
+		t.Error(r)
+	}
+}
+
+// readLapseAlertsTest check route is protected and alerts marked as read
+func readLapseAlertsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{Token: testCtx.User.Token,
+			Sent:         []byte(`{"LapseAlertID":[`),
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Lecture des alertes, décodage :"}},
+		{Token: testCtx.User.Token,
+			Sent:         []byte(`{"LapseAlertID":[0]}`),
+			Status:       http.StatusOK,
+			BodyContains: []string{"0 alerte(s) lue(s)"}},
+		{Token: testCtx.Admin.Token,
+			Sent:         []byte(`{}`),
+			Status:       http.StatusOK,
+			BodyContains: []string{"alerte(s) lue(s)"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/lapse_alerts/read").WithBytes(tc.Sent).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "ReadLapseAlerts") {
+		t.Error(r)
+	}
+	body := string(e.GET("/api/lapse_alerts").WithQuery("unread", true).
+		WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).Expect().Content)
+	if !strings.Contains(body, `"LapseAlert":[]`) {
+		t.Errorf("ReadLapseAlerts : aucune alerte non lue attendue, reçu %s", body)
+	}
+}
+
+// lapseDigestTest check routes are protected and subscription saved
+func lapseDigestTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{Token: testCtx.User.Token,
+			Sent:         []byte(`{"LapseDigest":{"lapse_digest":true`),
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Synthèse des alertes, décodage :"}},
+		{Token: testCtx.User.Token,
+			Sent:         []byte(`{"LapseDigest":{"lapse_digest":true}}`),
+			Status:       http.StatusOK,
+			BodyContains: []string{`"lapse_digest":true`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/lapse_alerts/digest").WithBytes(tc.Sent).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "SetLapseDigest") {
+		t.Error(r)
+	}
+	testCases = []testCase{
+		notLoggedTestCase,
+		{Token: testCtx.User.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{`"LapseDigest":{"lapse_digest":true`}},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/lapse_alerts/digest").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetLapseDigest") {
+		t.Error(r)
+	}
+}
+
+// lapseDigestBodyTest checks the content of the digest email
+func lapseDigestBodyTest(t *testing.T) {
+	d := models.LapseDigest{Name: "Test", Alerts: []models.LapseAlert{
+		{IrisCode: "19003456", Name: "Engagement test", Beneficiary: "Bénéficiaire",
+			OpNumber:    models.NullString{Valid: true, String: "18VN044"},
+			OpName:      models.NullString{Valid: true, String: "Opération test"},
+			Remaining:   123456,
+			DaysToLapse: 12},
+	}}
+	body := lapseDigestBody(&d)
+	for _, s := range []string{"Bonjour Test", "1 engagement(s)",
+		"- 19003456 Engagement test (Bénéficiaire, 18VN044 Opération test) : 1234,56 €",
+		"(J-12)"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("LapseDigestBody : %s attendu dans %s", s, body)
+		}
+	}
+}
+
+// lapseDigestClaimTest checks that the digest of the month is claimed once and
+// that a released claim restores the previous date
+func lapseDigestClaimTest(t *testing.T) {
+	d := models.LapseDigest{UserID: int64(testCtx.User.User.ID)}
+	var sentAt models.NullTime
+	if err := testCtx.DB.QueryRow(`SELECT lapse_digest_at FROM users WHERE id=$1`,
+		d.UserID).Scan(&sentAt); err != nil {
+		t.Fatalf("LapseDigestClaim, lecture : %v", err)
+	}
+	defer testCtx.DB.Exec(`UPDATE users SET lapse_digest_at=$2 WHERE id=$1`,
+		d.UserID, sentAt)
+	if _, err := testCtx.DB.Exec(`UPDATE users SET lapse_digest_at=NULL
+	WHERE id=$1`, d.UserID); err != nil {
+		t.Fatalf("LapseDigestClaim, initialisation : %v", err)
+	}
+	for i, want := range []bool{true, false} {
+		claimed, err := d.Claim(testCtx.DB)
+		if err != nil {
+			t.Fatalf("LapseDigestClaim %d : %v", i, err)
+		}
+		if claimed != want {
+			t.Errorf("LapseDigestClaim %d : attendu %v, reçu %v", i, want, claimed)
+		}
+	}
+	first := models.LapseDigest{UserID: d.UserID}
+	if _, err := testCtx.DB.Exec(`UPDATE users SET lapse_digest_at=NULL
+	WHERE id=$1`, d.UserID); err != nil {
+		t.Fatalf("LapseDigestClaim, initialisation : %v", err)
+	}
+	if _, err := first.Claim(testCtx.DB); err != nil {
+		t.Fatalf("LapseDigestClaim : %v", err)
+	}
+	if err := first.Release(testCtx.DB); err != nil {
+		t.Fatalf("LapseDigestRelease : %v", err)
+	}
+	if err := testCtx.DB.QueryRow(`SELECT lapse_digest_at FROM users WHERE id=$1`,
+		d.UserID).Scan(&sentAt); err != nil {
+		t.Fatalf("LapseDigestRelease, lecture : %v", err)
+	}
+	if sentAt.Valid {
+		t.Errorf("LapseDigestRelease : date non restaurée %v", sentAt.Time)
+	}
+}
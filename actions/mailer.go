@@ -0,0 +1,49 @@
+package actions
+
+import (
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer sends the emails of the application
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends emails through a SMTP server, using plain authentication
+// if a user name is given
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	UserName string
+	Password string
+	From     string
+}
+
+// mailer is used for the digests, no email is sent if it's nil
+var mailer Mailer
+
+// SetMailer configures the mailer used to send the digests.
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+// Send sends a plain text email.
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.UserName != "" {
+		auth = smtp.PlainAuth("", m.UserName, m.Password, m.Host)
+	}
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("UTF-8", subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body}, "\r\n")
+	return smtp.SendMail(fmt.Sprintf("%s:%d", m.Host, m.Port), auth, m.From,
+		[]string{to}, []byte(msg))
+}
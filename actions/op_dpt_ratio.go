@@ -14,7 +14,15 @@ type opsWithDptRatiosResp struct {
 	models.ProgrammingsYears
 }
 
-// GetOpWithDptRatios handles get operation with department ratios request.
+// legacyOpsWithDptRatiosResp embeddes datas for response in the legacy format.
+type legacyOpsWithDptRatiosResp struct {
+	models.LegacyOpWithDptRatios
+	models.ProgrammingsYears
+}
+
+// GetOpWithDptRatios handles get operation with ratios on the territories of
+// the level sent as Level request or on the departments in the legacy format
+// if no level is sent.
 func GetOpWithDptRatios(ctx iris.Context) {
 	uID, err := getUserID(ctx)
 	if err != nil {
@@ -22,9 +30,31 @@ func GetOpWithDptRatios(ctx iris.Context) {
 		ctx.JSON(jsonError{"Liste des opérations avec ratio, user :" + err.Error()})
 		return
 	}
-	var resp opsWithDptRatiosResp
+	level, err := territoryLevel(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Liste des opérations avec ratio, paramètre : " + err.Error()})
+		return
+	}
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.OpWithDptRatios.GetAll(uID, db); err != nil {
+	if legacyDptFormat(ctx) {
+		var resp legacyOpsWithDptRatiosResp
+		if err = resp.LegacyOpWithDptRatios.GetAll(uID, db); err != nil {
+			ctx.StatusCode(http.StatusInternalServerError)
+			ctx.JSON(jsonError{"Liste des opérations avec ratio, requête ratios :" + err.Error()})
+			return
+		}
+		if err = resp.ProgrammingsYears.GetAll(db); err != nil {
+			ctx.StatusCode(http.StatusInternalServerError)
+			ctx.JSON(jsonError{"Liste des opérations avec ratio, requête years :" + err.Error()})
+			return
+		}
+		ctx.StatusCode(http.StatusOK)
+		ctx.JSON(resp)
+		return
+	}
+	var resp opsWithDptRatiosResp
+	if err = resp.OpWithDptRatios.GetAll(level, uID, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Liste des opérations avec ratio, requête ratios :" + err.Error()})
 		return
@@ -38,7 +68,9 @@ func GetOpWithDptRatios(ctx iris.Context) {
 	ctx.JSON(resp)
 }
 
-// BatchOpDptRatios handles the post request to set all ratios.
+// BatchOpDptRatios handles the post request to set all ratios on the
+// territories of the level sent as Level or on the departments in the legacy
+// format if no level is sent.
 func BatchOpDptRatios(ctx iris.Context) {
 	uID, err := getUserID(ctx)
 	if err != nil {
@@ -46,14 +78,33 @@ func BatchOpDptRatios(ctx iris.Context) {
 		ctx.JSON(jsonError{"Batch ratios départements, user : " + err.Error()})
 		return
 	}
+	level, err := territoryLevel(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Batch ratios départements, paramètre : " + err.Error()})
+		return
+	}
 	var req models.OpDptRatioBatch
-	if err := ctx.ReadJSON(&req); err != nil {
+	if legacyDptFormat(ctx) {
+		var legacy models.LegacyOpDptRatioBatch
+		if err = ctx.ReadJSON(&legacy); err != nil {
+			ctx.StatusCode(http.StatusInternalServerError)
+			ctx.JSON(jsonError{"Batch ratios départements, décodage : " + err.Error()})
+			return
+		}
+		req = legacy.Batch()
+	} else if err = ctx.ReadJSON(&req); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Batch ratios départements, décodage : " + err.Error()})
 		return
 	}
+	if err := req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Batch ratios départements, paramètre : " + err.Error()})
+		return
+	}
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = req.Save(uID, db); err != nil {
+	if err = req.Save(level, uID, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Batch ratios départements, requête :" + err.Error()})
 		return
@@ -61,7 +112,9 @@ func BatchOpDptRatios(ctx iris.Context) {
 	GetOpWithDptRatios(ctx)
 }
 
-// GetFCPerDpt handles the get request to calculate financial commitments per departments between two years.
+// GetFCPerDpt handles the get request to calculate financial commitments per territory of
+// the level sent as Level, or per department in the legacy format if no level
+// is sent, between two years.
 func GetFCPerDpt(ctx iris.Context) {
 	y0, err := ctx.URLParamInt("firstYear")
 	if err != nil {
@@ -80,18 +133,37 @@ func GetFCPerDpt(ctx iris.Context) {
 		ctx.JSON(jsonError{"Engagements par départements, dernière année plus petite que la première"})
 		return
 	}
-	var resp models.FCPerDepartments
+	level, err := territoryLevel(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Engagements par départements, paramètre : " + err.Error()})
+		return
+	}
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.GetAll(y0, y1, db); err != nil {
+	if legacyDptFormat(ctx) {
+		var resp models.LegacyFCPerDepartments
+		if err = resp.GetAll(y0, y1, db); err != nil {
+			ctx.StatusCode(http.StatusInternalServerError)
+			ctx.JSON(jsonError{"Engagements par départements, requête : " + err.Error()})
+			return
+		}
+		ctx.StatusCode(http.StatusOK)
+		ctx.JSON(resp)
+		return
+	}
+	var resp models.FCPerDepartments
+	if err = resp.GetAll(level, y0, y1, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Engagements par départements, requête : " + err.Error()})
+		return
 	}
 	ctx.StatusCode(http.StatusOK)
 	ctx.JSON(resp)
 }
 
-// GetDetailedFCPerDpt handles the get request to calculate financial commitments per departments
-// between two years and give repartition per operation
+// GetDetailedFCPerDpt handles the get request to calculate financial commitments per territory
+// of the level sent as Level, or per department in the legacy format if no level is
+// sent, between two years and give repartition per operation
 func GetDetailedFCPerDpt(ctx iris.Context) {
 	y0, err := ctx.URLParamInt("firstYear")
 	if err != nil {
@@ -110,9 +182,26 @@ func GetDetailedFCPerDpt(ctx iris.Context) {
 		ctx.JSON(jsonError{"Engagements détaillés par départements, dernière année plus petite que la première"})
 		return
 	}
-	var resp models.DetailedFCPerDepartments
+	level, err := territoryLevel(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Engagements détaillés par départements, paramètre : " + err.Error()})
+		return
+	}
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.GetAll(y0, y1, db); err != nil {
+	if legacyDptFormat(ctx) {
+		var resp models.LegacyDetailedFCPerDepartments
+		if err = resp.GetAll(y0, y1, db); err != nil {
+			ctx.StatusCode(http.StatusInternalServerError)
+			ctx.JSON(jsonError{"Engagements détaillés par départements, requête : " + err.Error()})
+			return
+		}
+		ctx.StatusCode(http.StatusOK)
+		ctx.JSON(resp)
+		return
+	}
+	var resp models.DetailedFCPerDepartments
+	if err = resp.GetAll(level, y0, y1, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Engagements détaillés par départements, requête : " + err.Error()})
 		return
@@ -121,7 +210,8 @@ func GetDetailedFCPerDpt(ctx iris.Context) {
 	ctx.JSON(resp)
 }
 
-// GetDetailedPrgPerDpt handles the get request to calculate programmings per departments
+// GetDetailedPrgPerDpt handles the get request to calculate programmings per territory of
+// the level sent as Level or per department in the legacy format if no level is sent
 func GetDetailedPrgPerDpt(ctx iris.Context) {
 	y, err := ctx.URLParamInt("year")
 	if err != nil {
@@ -129,9 +219,26 @@ func GetDetailedPrgPerDpt(ctx iris.Context) {
 		ctx.JSON(jsonError{"Programmation par départements, décodage year : " + err.Error()})
 		return
 	}
-	var resp models.DetailedPrgPerDepartments
+	level, err := territoryLevel(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Programmation par départements, paramètre : " + err.Error()})
+		return
+	}
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.GetAll(y, db); err != nil {
+	if legacyDptFormat(ctx) {
+		var resp models.LegacyDetailedPrgPerDepartments
+		if err = resp.GetAll(y, db); err != nil {
+			ctx.StatusCode(http.StatusBadRequest)
+			ctx.JSON(jsonError{"Programmation par départements, select : " + err.Error()})
+			return
+		}
+		ctx.StatusCode(http.StatusOK)
+		ctx.JSON(resp)
+		return
+	}
+	var resp models.DetailedPrgPerDepartments
+	if err = resp.GetAll(level, y, db); err != nil {
 		ctx.StatusCode(http.StatusBadRequest)
 		ctx.JSON(jsonError{"Programmation par départements, select : " + err.Error()})
 		return
@@ -22,14 +22,30 @@ func testOpDptRatio(t *testing.T) {
 func getOpWithDptRatiosTest(e *httpexpect.Expect, t *testing.T) {
 	testCases := []testCase{
 		notLoggedTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			Param:        "Level=region",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Liste des opérations avec ratio, paramètre : Level incorrect"}},
 		{
 			Token:  testCtx.Admin.Token,
 			Status: http.StatusOK,
 			BodyContains: []string{"OpsWithDptRatios", "name", "number", "r75", "r77",
 				"r78", "r91", "r92", "r93", "r94", "r95", "ProgrammingsYears"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Param:  "Level=department",
+			Status: http.StatusOK,
+			BodyContains: []string{"OpsWithDptRatios", "name", "number", `"ratios":[`,
+				`"Territory":[{"id":`, `"code":"75"`, "ProgrammingsYears"}},
+		{
+			Token:        testCtx.Admin.Token,
+			Param:        "Level=commune",
+			Status:       http.StatusOK,
+			BodyContains: []string{"OpsWithDptRatios", `"ratios":[]`, `"Territory":[]`}},
 	}
 	f := func(tc testCase) *httpexpect.Response {
-		return e.GET("/api/op_dpt_ratios/ops").
+		return e.GET("/api/op_dpt_ratios/ops").WithQueryString(tc.Param).
 			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
 	}
 	for _, r := range chkTestCases(testCases, f, "GetOpWithDptRatios") {
@@ -48,9 +64,51 @@ func batchOpDptRatiosTest(e *httpexpect.Expect, t *testing.T) {
 				`"r78":0.2,"r91":0.2,"r92":0.2,"r93":0,"r94":0,"r95":0}]}`),
 			BodyContains: []string{"OpsWithDptRatios", "9", `"r75":0.2`, "r77", "r78",
 				"r91", "r92", "r93", "r94", "r95", "ProgrammingsYears"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusBadRequest,
+			Sent: []byte(`{"OpDptRatios":[{"physical_op_id":9,"r75":0.6,"r77":0.5,` +
+				`"r78":0,"r91":0,"r92":0,"r93":0,"r94":0,"r95":0}]}`),
+			BodyContains: []string{"Batch ratios départements, paramètre : " +
+				"somme des ratios de l'opération 9 supérieure à 1"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Param:  "Level=department",
+			Status: http.StatusBadRequest,
+			Sent: []byte(`{"OpDptRatios":[{"physical_op_id":9,"code":"75","ratio":0.2},` +
+				`{"physical_op_id":9,"code":"75","ratio":0.3}]}`),
+			BodyContains: []string{"Batch ratios départements, paramètre : " +
+				"territoire 75 en double pour l'opération 9"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Param:  "Level=department",
+			Status: http.StatusBadRequest,
+			Sent: []byte(`{"OpDptRatios":[{"physical_op_id":9,"code":"75","ratio":0.6},` +
+				`{"physical_op_id":9,"code":"77","ratio":0.5}]}`),
+			BodyContains: []string{"Batch ratios départements, paramètre : " +
+				"somme des ratios de l'opération 9 supérieure à 1"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Param:  "Level=department",
+			Status: http.StatusInternalServerError,
+			Sent: []byte(`{"OpDptRatios":[{"physical_op_id":9,"code":"99",` +
+				`"ratio":0.2}]}`),
+			BodyContains: []string{"Batch ratios départements, requête :" +
+				"Territoire 99 introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Param:  "Level=department",
+			Status: http.StatusOK,
+			Sent: []byte(`{"OpDptRatios":[{"physical_op_id":9,"code":"75","ratio":0.2},` +
+				`{"physical_op_id":9,"code":"77","ratio":0.2},` +
+				`{"physical_op_id":9,"code":"78","ratio":0.2},` +
+				`{"physical_op_id":9,"code":"91","ratio":0.2},` +
+				`{"physical_op_id":9,"code":"92","ratio":0.2}]}`),
+			BodyContains: []string{"OpsWithDptRatios", `"id":9,`,
+				`"ratios":[0.2,0.2,0.2,0.2,0.2,null,null,null]`, "ProgrammingsYears"}},
 	}
 	f := func(tc testCase) *httpexpect.Response {
-		return e.POST("/api/op_dpt_ratios/upload").
+		return e.POST("/api/op_dpt_ratios/upload").WithQueryString(tc.Param).
 			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
 	}
 	for _, r := range chkTestCases(testCases, f, "BatchOpDptRatios") {
@@ -69,6 +127,12 @@ func getFCPerDptTest(e *httpexpect.Expect, t *testing.T) {
 			BodyContains: []string{`"FinancialCommitmentPerDpt":[{"total":137921605023,` +
 				`"fc75":null,"fc77":null,"fc78":null,"fc91":null,"fc92":null,"fc93":null,` +
 				`"fc94":null,"fc95":null}]`}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusOK,
+			Param:  "firstYear=2016&lastYear=2018&Level=department",
+			BodyContains: []string{`"FinancialCommitmentPerDpt":[{"total":137921605023,` +
+				`"values":[null,null,null,null,null,null,null,null]}]`, `"Territory":[{"id":`}},
 	}
 	f := func(tc testCase) *httpexpect.Response {
 		return e.GET("/api/op_dpt_ratios/financial_commitments").
@@ -94,6 +158,13 @@ func getDetailedFCPerDptTest(e *httpexpect.Expect, t *testing.T) {
 				`{"total":1053500000,"fc75":null,"fc77":null,"fc78":null,"fc91":null,` +
 					`"fc92":null,"fc93":null,"fc94":null,"fc95":null,"id":13,"number":` +
 					`"01BU003","name":"Bus - Tzen5 - Paris-Choisy (94)"}`}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusOK,
+			Param:  "firstYear=2016&lastYear=2018&Level=department",
+			BodyContains: []string{`"DetailedFinancialCommitmentPerDpt":[`,
+				`{"total":1053500000,"values":[null,null,null,null,null,null,null,null],` +
+					`"id":13,"number":"01BU003","name":"Bus - Tzen5 - Paris-Choisy (94)"}`}},
 		//cSpell:enable
 	}
 	f := func(tc testCase) *httpexpect.Response {
@@ -119,6 +190,14 @@ func getDetailedPrgPerDptTest(e *httpexpect.Expect, t *testing.T) {
 				`{"date":"2018-03-16T00:00:00Z","id":37,"number":"02VE001","name":` +
 					`"Vélo - Toutes opérations","total":1081455457,"pr75":null,"pr77":null,` +
 					`"pr78":null,"pr91":null,"pr92":null,"pr93":null,"pr94":null,"pr95":null}`}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusOK,
+			Param:  "year=2018&Level=department",
+			BodyContains: []string{`"DetailedProgrammingsPerDpt":[`,
+				`{"date":"2018-03-16T00:00:00Z","id":37,"number":"02VE001","name":` +
+					`"Vélo - Toutes opérations","total":1081455457,` +
+					`"values":[null,null,null,null,null,null,null,null]}`}},
 	}
 	f := func(tc testCase) *httpexpect.Response {
 		return e.GET("/api/op_dpt_ratios/detailed_programmings").
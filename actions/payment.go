@@ -45,22 +45,33 @@ func GetPaymentsPerMonth(ctx iris.Context) {
 	ctx.JSON(resp)
 }
 
-// BatchPayments handles the request sending an array of payments.
+// BatchPayments handles the request sending an array of payments. The lines
+// are decoded and sent to the database as they arrive. The result of a
+// previous import with the same content or idempotency key is sent back
+// without importing again, a conflict being sent if the idempotency key was
+// used for another content.
 func BatchPayments(ctx iris.Context) {
 	var req models.PaymentBatch
-	if err := ctx.ReadJSON(&req); err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
-		ctx.JSON(jsonError{"Batch de paiements, décodage : " + err.Error()})
+	db := ctx.Values().Get("db").(*sql.DB)
+	rec := newImportRecord(ctx, "Payments")
+	recon, err := req.SaveStream(batchStream(ctx, "Payment"),
+		recordGuard{&rec, reconResp("Paiements importés")}, db)
+	if err == models.ErrAlreadyImported {
+		sendReplay(ctx, &rec)
 		return
 	}
-	db := ctx.Values().Get("db").(*sql.DB)
-	if err := req.Save(db); err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
-		ctx.JSON(jsonError{"Batch de paiements, requête : " + err.Error()})
+	if err == models.ErrIdempotencyConflict {
+		ctx.StatusCode(http.StatusConflict)
+		ctx.JSON(jsonError{"Batch de paiements, idempotence : " + err.Error()})
 		return
 	}
+	if err != nil {
+		sendBatchError(ctx, "Batch de paiements", err)
+		return
+	}
+	resp := importResp{"Paiements importés", *recon}
 	ctx.StatusCode(http.StatusOK)
-	ctx.JSON(jsonMessage{"Paiements importés"})
+	ctx.JSON(resp)
 }
 
 // GetPrevisionRealized handles the request to the payment prevision and real payments for the given year and beneficiary.
@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/Iledant/iris-propera/forecast"
 	"github.com/Iledant/iris-propera/models"
 	"github.com/kataras/iris"
 )
@@ -114,3 +116,79 @@ func GetYearRatios(ctx iris.Context) {
 	ctx.StatusCode(http.StatusOK)
 	ctx.JSON(resp)
 }
+
+// calibrationParams decodes the period and the outliers exclusion from the URL
+// parameters, the default being the commitments of the last ten years with
+// the current year ignored as its payments aren't complete.
+func calibrationParams(ctx iris.Context) (*forecast.CalibrationParams, error) {
+	year := int64(time.Now().Year())
+	p := forecast.CalibrationParams{FirstYear: year - 10, LastYear: year}
+	var err error
+	for name, v := range map[string]*int64{"FirstYear": &p.FirstYear,
+		"LastYear": &p.LastYear} {
+		if !ctx.URLParamExists(name) {
+			continue
+		}
+		if *v, err = ctx.URLParamInt64(name); err != nil {
+			return nil, err
+		}
+	}
+	if ctx.URLParamExists("ExcludeOutliers") {
+		if p.ExcludeOutliers, err = ctx.URLParamBool("ExcludeOutliers"); err != nil {
+			return nil, err
+		}
+	}
+	return &p, p.Validate()
+}
+
+// GetPtRatiosCalibration handles the get request to compute the ratios of a
+// payment type from the payments history and compare them to its ratios.
+func GetPtRatiosCalibration(ctx iris.Context) {
+	ptID, err := ctx.Params().GetInt64("ptID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Calibrage des ratios d'une chronique, paramètre : " + err.Error()})
+		return
+	}
+	p, err := calibrationParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Calibrage des ratios d'une chronique, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.PaymentRatiosCalibration
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.Get(ptID, p, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Calibrage des ratios d'une chronique, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// AdoptPtRatiosCalibration handles the post request to replace the ratios of
+// a payment type by the ones computed from the payments history.
+func AdoptPtRatiosCalibration(ctx iris.Context) {
+	ptID, err := ctx.Params().GetInt64("ptID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Adoption du calibrage d'une chronique, paramètre : " + err.Error()})
+		return
+	}
+	p, err := calibrationParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Adoption du calibrage d'une chronique, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.PaymentRatiosCalibration
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.Adopt(ptID, p, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Adoption du calibrage d'une chronique, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
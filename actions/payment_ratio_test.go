@@ -13,6 +13,8 @@ func testPaymentRatio(t *testing.T) {
 		getPtRatiosTest(testCtx.E, t)
 		setPtRatiosTest(testCtx.E, t)
 		deletePtRatiosTest(testCtx.E, t)
+		getPtRatiosCalibrationTest(testCtx.E, t)
+		adoptPtRatiosCalibrationTest(testCtx.E, t)
 		getYearRatiosTest(testCtx.E, t)
 	})
 }
@@ -156,3 +158,64 @@ func getYearRatiosTest(e *httpexpect.Expect, t *testing.T) {
 		t.Error(r)
 	}
 }
+
+// getPtRatiosCalibrationTest check route is protected and calibrated ratios
+// correctly sent next to the current ones.
+func getPtRatiosCalibrationTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			ID:           "0",
+			Param:        "2010",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Calibrage des ratios d'une chronique, requête : Chronique introuvable"}},
+		{
+			Token:        testCtx.User.Token,
+			ID:           "5",
+			Param:        "2030",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Calibrage des ratios d'une chronique, paramètre : période incorrecte"}},
+		{
+			Token:  testCtx.User.Token,
+			ID:     "5",
+			Param:  "2010",
+			Status: http.StatusOK,
+			BodyContains: []string{`"Current":[]`, `"Calibrated":[`, `"Used":`,
+				`"Excluded":`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/payment_types/"+tc.ID+"/payment_ratios/calibration").
+			WithQuery("FirstYear", tc.Param).WithQuery("ExcludeOutliers", true).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetPtRatiosCalibration") {
+		t.Error(r)
+	}
+}
+
+// adoptPtRatiosCalibrationTest check route is protected and calibrated ratios
+// correctly saved.
+func adoptPtRatiosCalibrationTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Adoption du calibrage d'une chronique, requête : Chronique introuvable"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "5",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"Current":[{"index":0,`, `"Calibrated":[{"index":0,`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/payment_types/"+tc.ID+"/payment_ratios/calibration").
+			WithQuery("FirstYear", 2010).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "AdoptPtRatiosCalibration") {
+		t.Error(r)
+	}
+}
@@ -2,6 +2,7 @@ package actions
 
 import (
 	"net/http"
+	"sync"
 	"testing"
 
 	"github.com/iris-contrib/httpexpect"
@@ -15,6 +16,10 @@ func testPayment(t *testing.T) {
 		getPrevisionRealizedTest(testCtx.E, t)
 		getCumulatedMonthPaymentTest(testCtx.E, t)
 		batchPaymentsTest(testCtx.E, t)
+		batchPaymentsLimitsTest(testCtx.E, t)
+		batchPaymentsNDJSONTest(testCtx.E, t)
+		batchPaymentsReplayTest(testCtx.E, t)
+		batchPaymentsConcurrentTest(testCtx.E, t)
 	})
 }
 
@@ -157,6 +162,14 @@ func getCumulatedMonthPaymentTest(e *httpexpect.Expect, t *testing.T) {
 func batchPaymentsTest(e *httpexpect.Expect, t *testing.T) {
 	testCases := []testCase{
 		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"Payment":[{"coriolis_year":2000}]}`),
+			BodyContains: []string{"Batch de paiements, décodage : décodage ligne 1"}},
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"Paiements":[]}`),
+			BodyContains: []string{"Batch de paiements, décodage : décodage : tableau Payment absent"}},
 		{Token: testCtx.Admin.Token,
 			Status: http.StatusOK,
 			//cSpell:disable
@@ -171,6 +184,13 @@ func batchPaymentsTest(e *httpexpect.Expect, t *testing.T) {
 			{"coriolis_year":"2005","coriolis_egt_code":"P0534","coriolis_egt_num":"162726","coriolis_egt_line":"3","date":42867,"number":"47720","value":537107.87,"cancelled_value":0,"beneficiary_code":14154},
 			{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43215,"number":"15390","value":5623.8,"cancelled_value":0,"beneficiary_code":22844,"receipt_date":43200}]}`),
 			BodyContains: []string{"Paiements importés"}},
+		{Token: testCtx.Admin.Token,
+			Status: http.StatusOK,
+			Sent: []byte(`{"Payment":[{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43216,"number":"99001","value":"0.29","cancelled_value":0,"beneficiary_code":22844},
+			{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43216,"number":"99002","value":0.29,"cancelled_value":"0,005","beneficiary_code":22844}],
+			"SourceTotal":"0.60"}`),
+			BodyContains: []string{"Paiements importés", `"lines_count":2`, `"import_total":58`,
+				`"source_total":60`, `"difference":-2`}},
 		//cSpell:enable
 	}
 	f := func(tc testCase) *httpexpect.Response {
@@ -181,3 +201,119 @@ func batchPaymentsTest(e *httpexpect.Expect, t *testing.T) {
 		t.Error(r)
 	}
 }
+
+// batchPaymentsLimitsTest checks that a batch exceeding the maximum number of
+// rows or the maximum body size is rejected
+func batchPaymentsLimitsTest(e *httpexpect.Expect, t *testing.T) {
+	bodySize, rows := maxBatchBodySize, maxBatchRows
+	defer func() { maxBatchBodySize, maxBatchRows = bodySize, rows }()
+	//cSpell:disable
+	line := `{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43215,"number":"15390","value":5623.8,"cancelled_value":0,"beneficiary_code":22844}`
+	//cSpell:enable
+	sent := []byte(`{"Payment":[` + line + "," + line + `]}`)
+	maxBatchRows = 1
+	e.POST("/api/payments").WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+		WithBytes(sent).Expect().Status(http.StatusBadRequest).Body().
+		Contains("Batch de paiements, décodage : nombre de lignes supérieur au maximum de 1")
+	maxBatchRows, maxBatchBodySize = rows, int64(len(line))
+	e.POST("/api/payments").WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+		WithBytes(sent).Expect().Status(http.StatusRequestEntityTooLarge).Body().
+		Contains("Batch de paiements, décodage : ")
+}
+
+// batchPaymentsNDJSONTest check a batch sent as NDJSON is properly decoded
+func batchPaymentsNDJSONTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		{Token: testCtx.Admin.Token,
+			Status: http.StatusOK,
+			//cSpell:disable
+			Sent: []byte(`{"coriolis_year":"2003","coriolis_egt_code":"P0385","coriolis_egt_num":"132770","coriolis_egt_line":"501","date":43132,"number":"1667","value":94254.15,"cancelled_value":0,"beneficiary_code":14154}
+{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43215,"number":"15390","value":5623.8,"cancelled_value":0,"beneficiary_code":22844,"receipt_date":43200}
+`),
+			//cSpell:enable
+			BodyContains: []string{"Paiements importés"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/payments").WithHeader("Content-Type", "application/x-ndjson").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "BatchPaymentsNDJSON") {
+		t.Error(r)
+	}
+}
+
+// batchPaymentsReplayTest check a batch already imported or sent with the same
+// idempotency key is not imported again and that an idempotency key can't be
+// used for another content
+func batchPaymentsReplayTest(e *httpexpect.Expect, t *testing.T) {
+	//cSpell:disable
+	sent := []byte(`{"Payment":[{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43216,"number":"99001","value":"0.29","cancelled_value":0,"beneficiary_code":22844},
+	{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43216,"number":"99002","value":0.29,"cancelled_value":"0,005","beneficiary_code":22844}],
+	"SourceTotal":"0.60"}`)
+	keyed := []byte(`{"Payment":[{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43217,"number":"99003","value":12.5,"cancelled_value":0,"beneficiary_code":22844}]}`)
+	changed := []byte(`{"Payment":[{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43217,"number":"99003","value":13.5,"cancelled_value":0,"beneficiary_code":22844}]}`)
+	//cSpell:enable
+	resp := e.POST("/api/payments").WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+		WithBytes(sent).Expect()
+	resp.Status(http.StatusOK)
+	resp.Header("Idempotent-Replayed").Equal("true")
+	resp.Body().Contains(`"import_total":58`).Contains(`"difference":-2`)
+
+	resp = e.POST("/api/payments").WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+		WithHeader("Idempotency-Key", "paiements-test-1").WithBytes(keyed).Expect()
+	resp.Status(http.StatusOK)
+	resp.Header("Idempotent-Replayed").Empty()
+	resp.Body().Contains(`"import_total":1250`)
+
+	resp = e.POST("/api/payments").WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+		WithHeader("Idempotency-Key", "paiements-test-1").WithBytes(changed).Expect()
+	resp.Status(http.StatusConflict)
+	resp.Body().Contains("Batch de paiements, idempotence : ")
+}
+
+// batchPaymentsConcurrentTest checks that the same batch sent twice at the
+// same time is imported and recorded once, the second request waiting for
+// the first one and sending back its result
+func batchPaymentsConcurrentTest(e *httpexpect.Expect, t *testing.T) {
+	//cSpell:disable
+	sent := []byte(`{"Payment":[{"coriolis_year":"2005","coriolis_egt_code":"P0852","coriolis_egt_num":"170678","coriolis_egt_line":"1","date":43218,"number":"99004","value":7.5,"cancelled_value":0,"beneficiary_code":22844}]}`)
+	//cSpell:enable
+	var before, after int64
+	if err := testCtx.DB.QueryRow(`SELECT count(1) FROM import_record
+	WHERE kind='Payments'`).Scan(&before); err != nil {
+		t.Fatalf("BatchPaymentsConcurrent, requête : %v", err)
+	}
+	replayed := make(chan string, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := e.POST("/api/payments").
+				WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+				WithBytes(sent).Expect()
+			resp.Status(http.StatusOK)
+			resp.Body().Contains(`"import_total":750`)
+			replayed <- resp.Raw().Header.Get("Idempotent-Replayed")
+		}()
+	}
+	wg.Wait()
+	close(replayed)
+	var count int
+	for r := range replayed {
+		if r == "true" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("BatchPaymentsConcurrent : attendu 1 rejeu, reçu %d", count)
+	}
+	if err := testCtx.DB.QueryRow(`SELECT count(1) FROM import_record
+	WHERE kind='Payments'`).Scan(&after); err != nil {
+		t.Fatalf("BatchPaymentsConcurrent, requête : %v", err)
+	}
+	if after != before+1 {
+		t.Errorf("BatchPaymentsConcurrent : attendu 1 enregistrement, reçu %d",
+			after-before)
+	}
+}
@@ -132,12 +132,28 @@ func BatchPendings(ctx iris.Context) {
 		ctx.JSON(jsonError{"Batch d'engagements en cours, décodage : " + err.Error()})
 		return
 	}
-	db := ctx.Values().Get("db").(*sql.DB)
-	if err := req.Save(db); err != nil {
+	db, rec := ctx.Values().Get("db").(*sql.DB), newImportRecord(ctx, "Pendings")
+	var err error
+	if rec.ContentHash, err = models.BatchHash(req.PendingsBatch); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Batch d'engagements en cours, hash : " + err.Error()})
+		return
+	}
+	if replayImport(ctx, &rec, "Batch d'engagements en cours") {
+		return
+	}
+	recon, err := req.Save(recordGuard{&rec,
+		reconResp("Engagements en cours importés")}, db)
+	if err == models.ErrAlreadyImported {
+		sendReplay(ctx, &rec)
+		return
+	}
+	if err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Batch d'engagements en cours, requête : " + err.Error()})
 		return
 	}
+	resp := importResp{"Engagements en cours importés", *recon}
 	ctx.StatusCode(http.StatusOK)
-	ctx.JSON(jsonMessage{"Engagements en cours importés"})
+	ctx.JSON(resp)
 }
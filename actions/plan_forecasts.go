@@ -22,9 +22,32 @@ func GetPlanForecasts(ctx iris.Context) {
 		ctx.JSON(jsonError{"Prévisions de plan, lastYear : " + err.Error()})
 		return
 	}
+	sID, err := snapshotID(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Prévisions de plan, SnapshotID : " + err.Error()})
+		return
+	}
+	level, err := territoryLevel(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Prévisions de plan, Level : " + err.Error()})
+		return
+	}
 	db := ctx.Values().Get("db").(*sql.DB)
+	if legacyDptFormat(ctx) {
+		var resp models.LegacyPlanForecasts
+		if err := resp.GetAll(db, firstYear, lastYear, sID); err != nil {
+			ctx.StatusCode(http.StatusInternalServerError)
+			ctx.JSON(jsonError{"Prévisions de plan, requête : " + err.Error()})
+			return
+		}
+		ctx.StatusCode(http.StatusOK)
+		ctx.JSON(resp)
+		return
+	}
 	var resp models.PlanForecasts
-	if err := resp.GetAll(db, firstYear, lastYear); err != nil {
+	if err := resp.GetAll(db, firstYear, lastYear, sID, level); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Prévisions de plan, requête : " + err.Error()})
 		return
@@ -30,6 +30,12 @@ func getPlanForecasts(e *httpexpect.Expect, t *testing.T) {
 			Status:       http.StatusBadRequest,
 			BodyContains: []string{`Prévisions de plan, lastYear :`},
 		},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "firstYear=2021&lastYear=2026&Level=region",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{`Prévisions de plan, Level : Level incorrect`},
+		},
 		{
 			Token:        testCtx.User.Token,
 			Param:        "firstYear=2021&lastYear=2019",
@@ -44,6 +50,14 @@ func getPlanForecasts(e *httpexpect.Expect, t *testing.T) {
 			IDName:       `"Number":`,
 			ArraySize:    54,
 		},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "firstYear=2021&lastYear=2026&Level=department",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"PlanForecast":[`, `"ratios":[`, `"Territory":[`},
+			IDName:       `"Number":`,
+			ArraySize:    54,
+		},
 	}
 	f := func(tc testCase) *httpexpect.Response {
 		return e.GET("/api/plan_forecasts").WithQueryString(tc.Param).
@@ -0,0 +1,77 @@
+package actions
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// JobPaymentReconciliation handles the post request to launch the computation
+// of the proposals of links for all unlinked payments as an import job.
+func JobPaymentReconciliation(ctx iris.Context) {
+	queueImportJob(ctx, "PaymentReconciliation",
+		func(progress models.ImportProgress, db *sql.DB) (interface{}, error) {
+			var resp models.PmtCmtReconciliation
+			if err := resp.Run(progress, db); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}, "Rapprochement des paiements")
+}
+
+// GetPmtCmtProposals handles the get request to fetch the review queue of the
+// proposals of links between payments and commitments.
+func GetPmtCmtProposals(ctx iris.Context) {
+	var resp models.PmtCmtProposals
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := resp.GetPending(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Propositions de rapprochement, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// AcceptPmtCmtProposals handles the post request to accept in bulk the
+// proposals selected by their IDs or a minimum score.
+func AcceptPmtCmtProposals(ctx iris.Context) {
+	var req models.PmtCmtDecision
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Validation de rapprochements, décodage : " + err.Error()})
+		return
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	count, err := req.Accept(db)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Validation de rapprochements, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{fmt.Sprintf("%d paiement(s) rattaché(s)", count)})
+}
+
+// RejectPmtCmtProposals handles the post request to reject in bulk the
+// proposals selected by their IDs or a minimum score.
+func RejectPmtCmtProposals(ctx iris.Context) {
+	var req models.PmtCmtDecision
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Rejet de rapprochements, décodage : " + err.Error()})
+		return
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	count, err := req.Reject(db)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Rejet de rapprochements, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{fmt.Sprintf("%d proposition(s) rejetée(s)", count)})
+}
@@ -0,0 +1,125 @@
+package actions
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testPmtCmtProposal(t *testing.T) {
+	t.Run("PmtCmtProposal", func(t *testing.T) {
+		ID := jobPaymentReconciliationTest(testCtx.E, t)
+		if ID == 0 {
+			t.Fatal("Impossible de lancer le rapprochement")
+		}
+		waitImportJob(testCtx.E, t, ID, `"payments_count":`)
+		getPmtCmtProposalsTest(testCtx.E, t)
+		acceptPmtCmtProposalsTest(testCtx.E, t)
+		rejectPmtCmtProposalsTest(testCtx.E, t)
+	})
+}
+
+// jobPaymentReconciliationTest check route is protected and the job is queued
+func jobPaymentReconciliationTest(e *httpexpect.Expect, t *testing.T) (ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusAccepted,
+			IDName:       `"id"`,
+			BodyContains: []string{"ImportJob", `"kind":"PaymentReconciliation"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/jobs/payment_reconciliation").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "JobPaymentReconciliation", &ID) {
+		t.Error(r)
+	}
+	return ID
+}
+
+// waitImportJob waits for the job to be processed and checks its result
+func waitImportJob(e *httpexpect.Expect, t *testing.T, ID int, result string) {
+	var body string
+	for i := 0; i < 50; i++ {
+		body = string(e.GET("/api/jobs/"+strconv.Itoa(ID)).
+			WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).Expect().Content)
+		if !strings.Contains(body, `"status":"pending"`) &&
+			!strings.Contains(body, `"status":"running"`) {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if !strings.Contains(body, `"status":"done"`) || !strings.Contains(body, result) {
+		t.Errorf("Import %d : %s attendu, reçu %s", ID, result, body)
+	}
+}
+
+// getPmtCmtProposalsTest check route is protected and the queue is sent back
+func getPmtCmtProposalsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{"PmtCmtProposal"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/payment_reconciliation/proposals").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetPmtCmtProposals") {
+		t.Error(r)
+	}
+}
+
+// acceptPmtCmtProposalsTest check route is protected and selection is checked
+func acceptPmtCmtProposalsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"ProposalID":"a"}`),
+			BodyContains: []string{"Validation de rapprochements, décodage"}},
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusInternalServerError,
+			Sent:         []byte(`{}`),
+			BodyContains: []string{"Validation de rapprochements, requête : ProposalID ou MinScore requis"}},
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			Sent:         []byte(`{"MinScore":2}`),
+			BodyContains: []string{"0 paiement(s) rattaché(s)"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/payment_reconciliation/accept").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "AcceptPmtCmtProposals") {
+		t.Error(r)
+	}
+}
+
+// rejectPmtCmtProposalsTest check route is protected and selection is checked
+func rejectPmtCmtProposalsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusInternalServerError,
+			Sent:         []byte(`{"ProposalID":[]}`),
+			BodyContains: []string{"Rejet de rapprochements, requête : ProposalID ou MinScore requis"}},
+		{Token: testCtx.Admin.Token,
+			Status:       http.StatusOK,
+			Sent:         []byte(`{"ProposalID":[0]}`),
+			BodyContains: []string{"0 proposition(s) rejetée(s)"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/payment_reconciliation/reject").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "RejectPmtCmtProposals") {
+		t.Error(r)
+	}
+}
@@ -2,8 +2,11 @@ package actions
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/Iledant/iris-propera/forecast"
 	"github.com/Iledant/iris-propera/models"
 	"github.com/kataras/iris"
 )
@@ -77,3 +80,103 @@ func GetCurYearActionPmtPrevisions(ctx iris.Context) {
 	ctx.StatusCode(http.StatusOK)
 	ctx.JSON(resp)
 }
+
+// backtestParams decodes the parameters of the backtest from the URL
+// parameters, the default being the forecasts of the last five years over
+// three years using ten years of history.
+func backtestParams(ctx iris.Context) (*models.ForecastBacktestParams, error) {
+	year := int64(time.Now().Year())
+	p := models.ForecastBacktestParams{FirstOrigin: year - 5, LastOrigin: year - 1,
+		Horizon: 3, Window: 10, LastYear: year}
+	for name, v := range map[string]*int64{"FirstOrigin": &p.FirstOrigin,
+		"LastOrigin": &p.LastOrigin, "Horizon": &p.Horizon, "Window": &p.Window} {
+		if !ctx.URLParamExists(name) {
+			continue
+		}
+		var err error
+		if *v, err = ctx.URLParamInt64(name); err != nil {
+			return nil, err
+		}
+	}
+	return &p, p.Validate()
+}
+
+// GetPaymentPrevisionsBacktest handles the get request to replay the payment
+// forecasts as of past years and measure the accuracy of each method.
+func GetPaymentPrevisionsBacktest(ctx iris.Context) {
+	p, err := backtestParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Évaluation des prévisions, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.ForecastBacktest
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.Run(p, db); err != nil {
+		if err == forecast.ErrBacktestOrigins {
+			ctx.StatusCode(http.StatusBadRequest)
+			ctx.JSON(jsonError{"Évaluation des prévisions, paramètre : " + err.Error()})
+			return
+		}
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Évaluation des prévisions, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// forecasterParams decodes the forecast method, the confidence level and the
+// history window from the URL parameters, the default being the ratio method
+// with a 90% confidence band computed on ten years.
+func forecasterParams(ctx iris.Context) (*models.ForecasterParams, error) {
+	fp := models.ForecasterParams{Method: ctx.URLParamDefault("Method",
+		models.RatioForecastMethod), Confidence: 0.9, Window: 10}
+	var err error
+	if ctx.URLParamExists("Confidence") {
+		if fp.Confidence, err = ctx.URLParamFloat64("Confidence"); err != nil {
+			return nil, err
+		}
+	}
+	if ctx.URLParamExists("Window") {
+		if fp.Window, err = ctx.URLParamInt64("Window"); err != nil {
+			return nil, err
+		}
+	}
+	if fp.Window <= 0 {
+		return nil, errors.New("Window incorrect")
+	}
+	switch fp.Method {
+	case models.DeclaredForecastMethod, models.RatioForecastMethod,
+		models.VintageForecastMethod, models.SmoothingForecastMethod:
+	default:
+		return nil, errors.New("Method incorrect")
+	}
+	return &fp, nil
+}
+
+// GetPaymentPrevisionsForecast handles the get request to forecast the
+// payments with the selected method and a confidence band.
+func GetPaymentPrevisionsForecast(ctx iris.Context) {
+	p, err := forecastParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Prévisions de paiement par méthode, paramètre : " + err.Error()})
+		return
+	}
+	fp, err := forecasterParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Prévisions de paiement par méthode, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.PaymentBandForecast
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(fp, *p, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Prévisions de paiement par méthode, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
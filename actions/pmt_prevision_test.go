@@ -2,7 +2,9 @@ package actions
 
 import (
 	"net/http"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/iris-contrib/httpexpect"
 )
@@ -14,6 +16,8 @@ func testPaymentPrevisions(t *testing.T) {
 		getActionPaymentPrevisionsTest(testCtx.E, t)
 		getOpPaymentPrevisionsTest(testCtx.E, t)
 		getCurYearActionPmtPrevisionsTest(testCtx.E, t)
+		getPaymentPrevisionsBacktestTest(testCtx.E, t)
+		getPaymentPrevisionsForecastTest(testCtx.E, t)
 	})
 }
 
@@ -98,3 +102,112 @@ func getCurYearActionPmtPrevisionsTest(e *httpexpect.Expect, t *testing.T) {
 		t.Error(r)
 	}
 }
+
+// getPaymentPrevisionsBacktestTest check route is protected and the accuracy
+// of the methods sent back.
+func getPaymentPrevisionsBacktestTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "0",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Évaluation des prévisions, paramètre : Window incorrect"}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "10",
+			Status: http.StatusOK,
+			BodyContains: []string{`"ForecastAccuracy":[`,
+				`{"method":"ratio","payment_type_id":null,"payment_type":null,"points":`,
+				`{"method":"empirical","payment_type_id":null,"payment_type":null,"points":`,
+				`{"method":"differential","payment_type_id":null,"payment_type":null,"points":`,
+				`,"payment_type":"`,
+				`"BacktestYear":[`, `"BacktestAction":[`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/payment_previsions/backtest").
+			WithQuery("FirstOrigin", 2015).WithQuery("LastOrigin", 2017).
+			WithQuery("Window", tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetPaymentPrevisionsBacktest") {
+		t.Error(r)
+	}
+	testCases = []testCase{
+		{
+			Token:        testCtx.User.Token,
+			Param:        "FirstOrigin=2015&LastOrigin=2017&Horizon=11",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Évaluation des prévisions, paramètre : horizon incorrect"}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "FirstOrigin=-2000000000&LastOrigin=2000000000",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Évaluation des prévisions, paramètre : trop d'années de départ"}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "FirstOrigin=1900&LastOrigin=1901",
+			Status: http.StatusBadRequest,
+			BodyContains: []string{"Évaluation des prévisions, paramètre : " +
+				"années de départ hors de l'historique"}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "FirstOrigin=2015&LastOrigin=" + strconv.Itoa(time.Now().Year()),
+			Status: http.StatusBadRequest,
+			BodyContains: []string{"Évaluation des prévisions, paramètre : " +
+				"années de départ hors de l'historique"}},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/payment_previsions/backtest").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetPaymentPrevisionsBacktest bornes") {
+		t.Error(r)
+	}
+}
+
+// getPaymentPrevisionsForecastTest check route is protected and the previsions
+// and confidence bands sent back for each method.
+func getPaymentPrevisionsForecastTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "fake",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Prévisions de paiement par méthode, paramètre : Method incorrect"}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "declared",
+			Status: http.StatusOK,
+			BodyContains: []string{`"Method":"declared"`, `"Confidence":0.9`,
+				`"Years":[2019,2020,2021]`, `"PaymentBandForecast":[`}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "ratio",
+			Status: http.StatusOK,
+			BodyContains: []string{`"Method":"ratio"`, `"PaymentBandForecast":[{`,
+				`"low":[`, `"high":[`}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "vintage",
+			Status: http.StatusOK,
+			BodyContains: []string{`"Method":"vintage"`, `"PaymentBandForecast":[{`,
+				`"low":[`, `"high":[`}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "smoothing",
+			Status: http.StatusOK,
+			BodyContains: []string{`"Method":"smoothing"`, `"PaymentBandForecast":[{`,
+				`"action_id":null`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/payment_previsions/forecast").
+			WithQuery("Method", tc.Param).WithQuery("FirstYear", 2019).
+			WithQuery("DefaultPaymentTypeId", 5).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetPaymentPrevisionsForecast") {
+		t.Error(r)
+	}
+}
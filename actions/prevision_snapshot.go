@@ -0,0 +1,106 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// snapshotID returns the prevision snapshot ID sent as SnapshotID or 0 to use
+// the current previsions.
+func snapshotID(ctx iris.Context) (int64, error) {
+	if !ctx.URLParamExists("SnapshotID") {
+		return 0, nil
+	}
+	return ctx.URLParamInt64("SnapshotID")
+}
+
+// GetPrevisionSnapshots handles the get request to fetch all the prevision
+// snapshots.
+func GetPrevisionSnapshots(ctx iris.Context) {
+	var resp models.PrevisionSnapshots
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := resp.GetAll(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Liste des snapshots, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+type previsionSnapshotResp struct {
+	PrevisionSnapshot models.PrevisionSnapshot `json:"PrevisionSnapshot"`
+}
+
+// CreatePrevisionSnapshot handles the post request to capture the current
+// previsions, programmings and pre programmings in a named snapshot.
+func CreatePrevisionSnapshot(ctx iris.Context) {
+	var req models.PrevisionSnapshot
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création de snapshot, décodage : " + err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Création de snapshot, paramètre : " + err.Error()})
+		return
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := req.Create(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création de snapshot, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(previsionSnapshotResp{req})
+}
+
+// DeletePrevisionSnapshot handles the delete request of a prevision snapshot.
+func DeletePrevisionSnapshot(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Suppression de snapshot, paramètre : " + err.Error()})
+		return
+	}
+	s := models.PrevisionSnapshot{ID: ID}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = s.Delete(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Suppression de snapshot, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{"Snapshot supprimé"})
+}
+
+// GetPrevisionSnapshotDiff handles the get request to fetch the amounts per
+// operation and year that differ between two snapshots, the ID 0 standing for
+// the current previsions.
+func GetPrevisionSnapshotDiff(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Différences de snapshots, paramètre : " + err.Error()})
+		return
+	}
+	otherID, err := ctx.Params().GetInt64("otherID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Différences de snapshots, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.PrevisionSnapshotDiffs
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(ID, otherID, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Différences de snapshots, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
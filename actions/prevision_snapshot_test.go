@@ -0,0 +1,166 @@
+package actions
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testPrevisionSnapshot(t *testing.T) {
+	t.Run("PrevisionSnapshot", func(t *testing.T) {
+		ID := createPrevisionSnapshotTest(testCtx.E, t)
+		if ID == 0 {
+			t.Fatal("Impossible de créer le snapshot")
+		}
+		getPrevisionSnapshotsTest(testCtx.E, t)
+		getSnapshotSummariesTest(testCtx.E, t, ID)
+		getPrevisionSnapshotDiffTest(testCtx.E, t, ID)
+		deletePrevisionSnapshotTest(testCtx.E, t, ID)
+	})
+}
+
+// createPrevisionSnapshotTest check route is protected and snapshot created.
+func createPrevisionSnapshotTest(e *httpexpect.Expect, t *testing.T) (ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"name":""}`),
+			BodyContains: []string{"Création de snapshot, paramètre : Name incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusCreated,
+			IDName: `"id"`,
+			Sent:   []byte(`{"name":"BP 2019","descript":"Budget primitif"}`),
+			BodyContains: []string{`"PrevisionSnapshot":{"id":`, `"name":"BP 2019"`,
+				`"descript":"Budget primitif"`, `"created_at":`}},
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusInternalServerError,
+			Sent:         []byte(`{"name":"BP 2019"}`),
+			BodyContains: []string{"Création de snapshot, requête : insert "}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/prevision_snapshots").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CreatePrevisionSnapshot", &ID) {
+		t.Error(r)
+	}
+	return ID
+}
+
+// getPrevisionSnapshotsTest check route is protected and snapshots sent back.
+func getPrevisionSnapshotsTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:         testCtx.User.Token,
+			Status:        http.StatusOK,
+			BodyContains:  []string{`"PrevisionSnapshot":[`, `"name":"BP 2019"`},
+			CountItemName: `"id"`,
+			ArraySize:     1},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/prevision_snapshots").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetPrevisionSnapshots") {
+		t.Error(r)
+	}
+}
+
+// getSnapshotSummariesTest check the summaries computed from a snapshot.
+func getSnapshotSummariesTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		{
+			Token:        testCtx.User.Token,
+			Param:        "/summaries/multiannual_programmation?y1=2019&SnapshotID=a",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Programmation pluriannuelle, paramètre : "}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "/summaries/multiannual_programmation?y1=2019&SnapshotID=0",
+			Status:       http.StatusOK,
+			BodyContains: []string{`"MultiannualProgrammation":[`}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "/summaries/multiannual_programmation?y1=2019&SnapshotID=999",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Programmation pluriannuelle, requête : Snapshot introuvable"}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "/summaries/multiannual_programmation?y1=2019&SnapshotID=" + strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{`"MultiannualProgrammation":[{"number":`}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "/summaries/annual_programmation?year=2018&SnapshotID=" + strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{`"AnnualProgrammation":[`}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "/plan_forecasts?firstYear=2021&lastYear=2026&SnapshotID=" + strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{`"PlanForecast":[`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api"+tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetSnapshotSummaries") {
+		t.Error(r)
+	}
+}
+
+// getPrevisionSnapshotDiffTest check route is protected and no difference
+// between the snapshot and the current previsions.
+func getPrevisionSnapshotDiffTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			ID:           "999",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Différences de snapshots, requête : Snapshot introuvable"}},
+		{
+			Token:        testCtx.User.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{`"PrevisionSnapshotDiff":[]`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/prevision_snapshots/"+tc.ID+"/diff/0").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetPrevisionSnapshotDiff") {
+		t.Error(r)
+	}
+}
+
+// deletePrevisionSnapshotTest check route is protected and snapshot deleted.
+func deletePrevisionSnapshotTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Suppression de snapshot, requête : Snapshot introuvable"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{"Snapshot supprimé"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.DELETE("/api/prevision_snapshots/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "DeletePrevisionSnapshot") {
+		t.Error(r)
+	}
+}
@@ -9,6 +9,8 @@ import (
 // SetRoutes initialize all routes for the application
 func SetRoutes(app *iris.Application, db *sql.DB) {
 
+	startImportWorkers(db)
+
 	api := app.Party("/api", setDBMiddleware(db))
 	api.Post("/user/signup", SignUp)
 	api.Post("/user/signin", Login)
@@ -76,10 +78,17 @@ func SetRoutes(app *iris.Application, db *sql.DB) {
 	adminParty.Post("/financial_commitments", BatchFcs)
 	adminParty.Post("/financial_commitments/attachments", BatchOpFcs)
 
+	adminParty.Post("/financial_commitments/{fcID:int}/closure", CloseCommitment)
+	adminParty.Delete("/financial_commitments/{fcID:int}/closure", ReopenCommitment)
+
 	adminParty.Post("/cmt_op_link", SetCmtOpLinks)
+	adminParty.Get("/cmt_op_proposals/stats", GetCmtOpProposalStats)
+	adminParty.Get("/op_name_aliases", GetOpNameAliases)
+	adminParty.Delete("/op_name_aliases/{aliasID:int}", DeleteOpNameAlias)
 
 	adminParty.Post("/payment_types/{ptID:int}/payment_ratios", SetPtRatios)
 	adminParty.Delete("/payment_types/{ptID:int}/payment_ratios", DeleteRatios)
+	adminParty.Post("/payment_types/{ptID:int}/payment_ratios/calibration", AdoptPtRatiosCalibration)
 
 	adminParty.Post("/payment_types", CreatePaymentType)
 	adminParty.Put("/payment_types/{ptID:int}", ModifyPaymentType)
@@ -94,6 +103,12 @@ func SetRoutes(app *iris.Application, db *sql.DB) {
 
 	adminParty.Post("/payments", BatchPayments)
 
+	adminParty.Post("/jobs/financial_commitments", JobFcs)
+	adminParty.Post("/jobs/payments", JobPayments)
+	adminParty.Get("/jobs", GetImportJobs)
+	adminParty.Get("/jobs/{jobID:int}", GetImportJob)
+	adminParty.Get("/jobs/{jobID:int}/events", GetImportJobEvents)
+
 	adminParty.Post("/plans/{pID:int}/planlines", CreatePlanLine)
 	adminParty.Put("/plans/{pID:int}/planlines/{plID:int}", ModifyPlanLine)
 	adminParty.Delete("/plans/{pID:int}/planlines/{plID:int}", DeletePlanLine)
@@ -122,11 +137,21 @@ func SetRoutes(app *iris.Application, db *sql.DB) {
 	adminParty.Delete("/scenarios/{sID:int}", DeleteScenario)
 	adminParty.Get("/scenarios/{sID:int}", GetScenarioDatas)
 	adminParty.Post("/scenarios/{sID:int}/offsets", SetScenarioOffsets)
+	adminParty.Post("/scenarios/{sID:int}/status", SetScenarioStatus)
+	adminParty.Get("/scenarios/{sID:int}/new_ops", GetScenarioNewOps)
+	adminParty.Post("/scenarios/{sID:int}/new_ops", SetScenarioNewOps)
 	adminParty.Get("/scenarios/{sID:int}/payment_per_budget_action",
 		GetScenarioActionPayments)
 	adminParty.Get("/scenarios/{sID:int}/statistical_payment_per_budget_action",
 		GetScenarioStatActionPayments)
 	adminParty.Get("/scenarios/{sID:int}/budget", GetMultiAnnualScenario)
+	adminParty.Get("/scenarios/{sID:int}/payment_forecast", GetPaymentForecast)
+	adminParty.Get("/scenarios/{sID:int}/simulation", GetScenarioSimulation)
+	adminParty.Post("/scenarios/{sID:int}/clone", CloneScenario)
+	adminParty.Get("/scenarios/compare", CompareScenarios)
+	adminParty.Post("/scenarios/optimize", OptimizeScenario)
+	adminParty.Get("/scenarios/{sID:int}/offset_diff/{otherID:int}", GetScenarioOffsetDiff)
+	adminParty.Get("/payment_forecast", GetPaymentForecast)
 
 	adminParty.Post("/payment_credits", BatchPaymentCredits)
 
@@ -140,6 +165,11 @@ func SetRoutes(app *iris.Application, db *sql.DB) {
 
 	adminParty.Get("/payment/{pmtID:int64}/possible_linked_commitment", GetPossibleLinkedCmts)
 	adminParty.Post("/payment/{pmtID:int64}/link_commitment/{cmtID}", LinkPaymentToCmt)
+	adminParty.Post("/jobs/payment_reconciliation", JobPaymentReconciliation)
+	adminParty.Post("/jobs/lapse_alerts", JobLapseAlerts)
+	adminParty.Get("/payment_reconciliation/proposals", GetPmtCmtProposals)
+	adminParty.Post("/payment_reconciliation/accept", AcceptPmtCmtProposals)
+	adminParty.Post("/payment_reconciliation/reject", RejectPmtCmtProposals)
 
 	adminParty.Put("/payment_demands", UpdatePaymentDemand)
 	adminParty.Post("/payment_demands", BatchPaymentDemands)
@@ -169,6 +199,14 @@ func SetRoutes(app *iris.Application, db *sql.DB) {
 
 	userParty.Get("/physical_ops/{opID:int}/events", GetEvents)
 	userParty.Get("/physical_ops/{opID:int}/financial_commitments", GetOpFcs) // changed, before financialcommitments
+	userParty.Get("/financial_commitments/{fcID:int}/balance", GetCommitmentBalance)
+	userParty.Get("/commitment_balances/at_risk", GetAtRiskCommitments)
+	userParty.Get("/commitment_balances/risks", GetCommitmentRisks)
+	userParty.Get("/commitment_closures", GetCommitmentClosures)
+	userParty.Get("/lapse_alerts", GetLapseAlerts)
+	userParty.Post("/lapse_alerts/read", ReadLapseAlerts)
+	userParty.Get("/lapse_alerts/digest", GetLapseDigest)
+	userParty.Post("/lapse_alerts/digest", SetLapseDigest)
 	userParty.Get("/physical_ops/{opID:int}/financial_commitments/{fcID:int}/payments",
 		GetFcPayment) // changed, before financialcommitments
 	userParty.Get("/events", GetNextMonthEvent)
@@ -185,6 +223,7 @@ func SetRoutes(app *iris.Application, db *sql.DB) {
 
 	userParty.Get("/payment_ratios", GetRatios)
 	userParty.Get("/payment_types/{ptID:int}/payment_ratios", GetPtRatios)
+	userParty.Get("/payment_types/{ptID:int}/payment_ratios/calibration", GetPtRatiosCalibration)
 	userParty.Get("/payment_ratios/year", GetYearRatios)
 
 	userParty.Get("/payment_types", GetPaymentTypes)
@@ -251,6 +290,8 @@ func SetRoutes(app *iris.Application, db *sql.DB) {
 	userParty.Get("/payment_previsions/actions", GetActionPaymentPrevisions)
 	userParty.Get("/payment_previsions/ops", GetOpPaymentPrevisions)
 	userParty.Get("/payment_previsions/current_year", GetCurYearActionPmtPrevisions)
+	userParty.Get("/payment_previsions/backtest", GetPaymentPrevisionsBacktest)
+	userParty.Get("/payment_previsions/forecast", GetPaymentPrevisionsForecast)
 
 	userParty.Get("/average_payment_time", GetAvgPmtTimes)
 
@@ -264,6 +305,42 @@ func SetRoutes(app *iris.Application, db *sql.DB) {
 
 	userParty.Get("/plan_forecasts", GetPlanForecasts)
 
+	userParty.Get("/prevision_snapshots", GetPrevisionSnapshots)
+	adminParty.Post("/prevision_snapshots", CreatePrevisionSnapshot)
+	adminParty.Delete("/prevision_snapshots/{ID:int}", DeletePrevisionSnapshot)
+	userParty.Get("/prevision_snapshots/{ID:int}/diff/{otherID:int}",
+		GetPrevisionSnapshotDiff)
+
+	userParty.Get("/budget_authorizations", GetBudgetAuthorizations)
+	adminParty.Post("/budget_authorizations", CreateBudgetAuthorization)
+	adminParty.Put("/budget_authorizations/{ID:int}", ModifyBudgetAuthorization)
+	adminParty.Delete("/budget_authorizations/{ID:int}", DeleteBudgetAuthorization)
+	userParty.Get("/budget_authorizations/schedule", GetBudgetAuthorizationSchedule)
+	userParty.Get("/budget_authorizations/{ID:int}/affectations",
+		GetAuthorizationAffectations)
+	adminParty.Post("/budget_authorizations/{ID:int}/affectations",
+		CreateAuthorizationAffectation)
+	adminParty.Put("/authorization_affectations/{ID:int}",
+		ModifyAuthorizationAffectation)
+	adminParty.Delete("/authorization_affectations/{ID:int}",
+		DeleteAuthorizationAffectation)
+
+	userParty.Get("/budget_versions", GetBudgetVersions)
+	adminParty.Post("/budget_versions", CreateBudgetVersion)
+	adminParty.Put("/budget_versions/{ID:int}", ModifyBudgetVersion)
+	adminParty.Delete("/budget_versions/{ID:int}", DeleteBudgetVersion)
+	userParty.Get("/budget_transfers", GetBudgetTransfers)
+	adminParty.Post("/budget_transfers", CreateBudgetTransfer)
+	adminParty.Delete("/budget_transfers/{ID:int}", DeleteBudgetTransfer)
+	userParty.Get("/voted_credits", GetVotedCredits)
+	userParty.Get("/voted_credits/history", GetVotedCreditHistory)
+	userParty.Get("/budget_execution", GetBudgetExecution)
+
+	userParty.Get("/territories", GetTerritories)
+	adminParty.Post("/territories", CreateTerritory)
+	adminParty.Put("/territories/{ID:int}", ModifyTerritory)
+	adminParty.Delete("/territories/{ID:int}", DeleteTerritory)
+
 	userParty.Get("/flow_stock_delays", GetFlowStockDelays)
 }
 
@@ -2,9 +2,13 @@ package actions
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Iledant/iris-propera/forecast"
 	"github.com/Iledant/iris-propera/models"
 	"github.com/kataras/iris"
 )
@@ -39,6 +43,7 @@ func CreateScenario(ctx iris.Context) {
 		ctx.JSON(jsonError{"Création d'un scénario : mauvais format"})
 		return
 	}
+	req.OwnerID = models.NullInt64{Valid: true, Int64: int64(ctx.Values().Get("uID").(int))}
 	db := ctx.Values().Get("db").(*sql.DB)
 	if err := req.Create(db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
@@ -49,6 +54,19 @@ func CreateScenario(ctx iris.Context) {
 	ctx.JSON(scenarioResp{req})
 }
 
+// scenarioErrorStatus returns the status code of an error of the publication
+// workflow of a scenario, an internal error being sent for the other errors.
+func scenarioErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrScenarioNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrScenarioLocked),
+		errors.Is(err, models.ErrScenarioTransition):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 // ModifyScenario handles post request to modify an existing scenario.
 func ModifyScenario(ctx iris.Context) {
 	sID, err := ctx.Params().GetInt64("sID")
@@ -70,8 +88,8 @@ func ModifyScenario(ctx iris.Context) {
 		return
 	}
 	req.ID = sID
-	if err = req.Update(db); err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
+	if err = req.Update(int64(ctx.Values().Get("uID").(int)), db); err != nil {
+		ctx.StatusCode(scenarioErrorStatus(err))
 		ctx.JSON(jsonError{"Modification de scénario, requête : " + err.Error()})
 		return
 	}
@@ -89,8 +107,8 @@ func DeleteScenario(ctx iris.Context) {
 	}
 	s := models.Scenario{ID: sID}
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = s.Delete(db); err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
+	if err = s.Delete(int64(ctx.Values().Get("uID").(int)), db); err != nil {
+		ctx.StatusCode(scenarioErrorStatus(err))
 		ctx.JSON(jsonError{"Suppression de scénario, requête : " + err.Error()})
 		return
 	}
@@ -147,8 +165,13 @@ func SetScenarioOffsets(ctx iris.Context) {
 		ctx.JSON(jsonError{"Offsets de scénario, décodage : " + err.Error()})
 		return
 	}
-	if err = req.Save(sID, db); err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
+	if err = req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Offsets de scénario, paramètre : " + err.Error()})
+		return
+	}
+	if err = req.Save(sID, int64(ctx.Values().Get("uID").(int)), db); err != nil {
+		ctx.StatusCode(scenarioErrorStatus(err))
 		ctx.JSON(jsonError{"Offsets de scénario, requête : " + err.Error()})
 		return
 	}
@@ -165,9 +188,12 @@ func GetScenarioActionPayments(ctx iris.Context) {
 		ctx.JSON(jsonError{"Prévisions de payment de scénario, paramètre : " + err.Error()})
 		return
 	}
-	firstYear, err := ctx.URLParamInt64("FirstYear")
+	firstYear, years, err := summaryYears(ctx, "FirstYear",
+		int64(time.Now().Year()+1), 3)
 	if err != nil {
-		firstYear = int64(time.Now().Year() + 1)
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Prévisions de payment de scénario, paramètre : " + err.Error()})
+		return
 	}
 	ptID, err := ctx.URLParamInt64("DefaultPaymentTypeId")
 	if err != nil {
@@ -177,7 +203,7 @@ func GetScenarioActionPayments(ctx iris.Context) {
 	}
 	var resp models.ScenarioActionPayments
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.GetAll(firstYear, sID, ptID, db); err != nil {
+	if err = resp.GetAll(firstYear, years, sID, ptID, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Prévisions de payment de scénario, requête : " + err.Error()})
 		return
@@ -196,9 +222,13 @@ func GetScenarioStatActionPayments(ctx iris.Context) {
 			err.Error()})
 		return
 	}
-	firstYear, err := ctx.URLParamInt64("FirstYear")
+	firstYear, years, err := summaryYears(ctx, "FirstYear",
+		int64(time.Now().Year()+1), 3)
 	if err != nil {
-		firstYear = int64(time.Now().Year() + 1)
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Prévisions statistique de payment de scénario, paramètre : " +
+			err.Error()})
+		return
 	}
 	ptID, err := ctx.URLParamInt64("DefaultPaymentTypeId")
 	if err != nil {
@@ -209,7 +239,7 @@ func GetScenarioStatActionPayments(ctx iris.Context) {
 	}
 	var resp models.ScenarioStatActionPayments
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.GetAll(firstYear, sID, ptID, db); err != nil {
+	if err = resp.GetAll(firstYear, years, sID, ptID, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Prévisions statistique de payment de scénario, requête : " +
 			err.Error()})
@@ -229,13 +259,17 @@ func GetMultiAnnualScenario(ctx iris.Context) {
 			err.Error()})
 		return
 	}
-	firstYear, err := ctx.URLParamInt64("firstYear")
+	firstYear, years, err := summaryYears(ctx, "firstYear",
+		int64(time.Now().Year()+1), 5)
 	if err != nil {
-		firstYear = int64(time.Now().Year() + 1)
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Prévision budgétaire pluriannuelle de scénario, paramètre : " +
+			err.Error()})
+		return
 	}
 	var resp models.MultiAnnualBudgetScenario
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.GetAll(firstYear, sID, db); err != nil {
+	if err = resp.GetAll(firstYear, years, sID, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Prévision budgétaire pluriannuelle de scénario, requête : " +
 			err.Error()})
@@ -244,3 +278,332 @@ func GetMultiAnnualScenario(ctx iris.Context) {
 	ctx.StatusCode(http.StatusOK)
 	ctx.JSON(resp)
 }
+
+// defaultForecastHorizon is the number of years of the payment forecasts when
+// no horizon is given
+const defaultForecastHorizon = 3
+
+// forecastParams decodes the parameters of a payment forecast from the URL
+// parameters.
+func forecastParams(ctx iris.Context) (*forecast.Params, error) {
+	p := forecast.Params{FirstYear: int64(time.Now().Year() + 1),
+		Horizon: defaultForecastHorizon, Declared: true}
+	var err error
+	if ctx.URLParamExists("FirstYear") {
+		if p.FirstYear, err = ctx.URLParamInt64("FirstYear"); err != nil {
+			return nil, err
+		}
+	}
+	if ctx.URLParamExists("Horizon") {
+		if p.Horizon, err = ctx.URLParamInt64("Horizon"); err != nil {
+			return nil, err
+		}
+	}
+	if p.PaymentTypeID, err = ctx.URLParamInt64("DefaultPaymentTypeId"); err != nil {
+		return nil, err
+	}
+	if ctx.URLParamExists("Declared") {
+		if p.Declared, err = ctx.URLParamBool("Declared"); err != nil {
+			return nil, err
+		}
+	}
+	if p.Level, err = forecast.ParseLevel(ctx.URLParam("Level")); err != nil {
+		return nil, err
+	}
+	return &p, p.Validate()
+}
+
+// GetPaymentForecast handles the get request to project the payments over a
+// given horizon per budget action or operation, using the scenario offsets if
+// a scenario is given.
+func GetPaymentForecast(ctx iris.Context) {
+	sID := ctx.Params().GetInt64Default("sID", 0)
+	p, err := forecastParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Prévisions de paiement, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.PaymentForecast
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(*p, sID, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Prévisions de paiement, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// Default and maximal count of iterations of a simulation
+const (
+	defaultSimulationIterations = 500
+	maxSimulationIterations     = 10000
+)
+
+// simulationParams decodes the parameters of a simulation from the URL
+// parameters. The default seed is the current time so that each request draws
+// new values unless a seed is given.
+func simulationParams(ctx iris.Context) (*models.ScenarioSimulationParams, error) {
+	fp, err := forecastParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	p := models.ScenarioSimulationParams{Params: *fp,
+		Iterations: defaultSimulationIterations, Seed: now.UnixNano(), Window: 10,
+		CurrentYear: int64(now.Year())}
+	if ctx.URLParamExists("Iterations") {
+		if p.Iterations, err = ctx.URLParamInt("Iterations"); err != nil {
+			return nil, err
+		}
+	}
+	if p.Iterations <= 0 || p.Iterations > maxSimulationIterations {
+		return nil, errors.New("Iterations incorrect")
+	}
+	for name, v := range map[string]*int64{"Seed": &p.Seed, "Window": &p.Window} {
+		if !ctx.URLParamExists(name) {
+			continue
+		}
+		if *v, err = ctx.URLParamInt64(name); err != nil {
+			return nil, err
+		}
+	}
+	if p.Window <= 0 {
+		return nil, errors.New("Window incorrect")
+	}
+	return &p, nil
+}
+
+// GetScenarioSimulation handles the get request to simulate the payments of a
+// scenario drawing randomly the slippage of the operations from the past
+// forecast commitments and the payment delays from the payment curves of the
+// past commitment years, or from the payment type ratios without history. All
+// the forecast commitments are simulated if the scenario ID is 0.
+func GetScenarioSimulation(ctx iris.Context) {
+	sID, err := ctx.Params().GetInt64("sID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Simulation de scénario, paramètre : " + err.Error()})
+		return
+	}
+	p, err := simulationParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Simulation de scénario, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.ScenarioSimulation
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.Run(sID, p, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Simulation de scénario, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// CloneScenario handles the post request to create a scenario with the offsets
+// of an existing one.
+func CloneScenario(ctx iris.Context) {
+	sID, err := ctx.Params().GetInt64("sID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Copie de scénario, paramètre : " + err.Error()})
+		return
+	}
+	var req models.Scenario
+	if err = ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Copie de scénario, décodage : " + err.Error()})
+		return
+	}
+	if req.Invalid() {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Copie de scénario : mauvais format"})
+		return
+	}
+	req.OwnerID = models.NullInt64{Valid: true, Int64: int64(ctx.Values().Get("uID").(int))}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = req.Clone(sID, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Copie de scénario, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(scenarioResp{req})
+}
+
+// scenarioIDs decodes the comma separated list of scenario IDs.
+func scenarioIDs(s string) ([]int64, error) {
+	var IDs []int64
+	for _, v := range strings.Split(s, ",") {
+		ID, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return nil, errors.New("IDs incorrect")
+		}
+		IDs = append(IDs, ID)
+	}
+	return IDs, nil
+}
+
+// CompareScenarios handles the get request to compare the forecast
+// commitments per operation and the payments per budget action of several
+// scenarios, the differences being computed with the first scenario.
+func CompareScenarios(ctx iris.Context) {
+	IDs, err := scenarioIDs(ctx.URLParam("IDs"))
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Comparaison de scénarios, paramètre : " + err.Error()})
+		return
+	}
+	p, err := forecastParams(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Comparaison de scénarios, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.ScenarioComparison
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.Compare(IDs, *p, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Comparaison de scénarios, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// GetScenarioOffsetDiff handles the get request to list the operations whose
+// offset differs between two scenarios.
+func GetScenarioOffsetDiff(ctx iris.Context) {
+	sID, err := ctx.Params().GetInt64("sID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Différences d'offsets de scénarios, paramètre : " + err.Error()})
+		return
+	}
+	otherID, err := ctx.Params().GetInt64("otherID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Différences d'offsets de scénarios, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.ScenarioOffsetDiffs
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(sID, otherID, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Différences d'offsets de scénarios, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// GetScenarioNewOps handles the get request to fetch the hypothetical
+// operations of a scenario.
+func GetScenarioNewOps(ctx iris.Context) {
+	sID, err := ctx.Params().GetInt64("sID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Opérations hypothétiques de scénario, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.ScenarioNewOps
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = resp.GetAll(sID, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Opérations hypothétiques de scénario, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// SetScenarioNewOps handles the post request to replace the hypothetical
+// operations of a scenario.
+func SetScenarioNewOps(ctx iris.Context) {
+	sID, err := ctx.Params().GetInt64("sID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Opérations hypothétiques de scénario, paramètre : " + err.Error()})
+		return
+	}
+	var req models.ScenarioNewOps
+	if err = ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Opérations hypothétiques de scénario, décodage : " + err.Error()})
+		return
+	}
+	if err = req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Opérations hypothétiques de scénario, paramètre : " + err.Error()})
+		return
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = req.Save(sID, int64(ctx.Values().Get("uID").(int)), db); err != nil {
+		ctx.StatusCode(scenarioErrorStatus(err))
+		ctx.JSON(jsonError{"Opérations hypothétiques de scénario, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(req)
+}
+
+// OptimizeScenario handles the post request to search the offsets of the
+// operations that respect the payment and commitment ceilings per chapter
+// with the lowest weighted slippage and to save them as a new scenario.
+func OptimizeScenario(ctx iris.Context) {
+	var req models.ScenarioOptimizationParams
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Optimisation de scénario, décodage : " + err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Optimisation de scénario, paramètre : " + err.Error()})
+		return
+	}
+	var resp models.ScenarioOptimization
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := resp.Optimize(&req, int64(ctx.Values().Get("uID").(int)), db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Optimisation de scénario, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(resp)
+}
+
+// SetScenarioStatus handles the post request to change the status of a
+// scenario, the adoption freezing the inputs of its projections.
+func SetScenarioStatus(ctx iris.Context) {
+	sID, err := ctx.Params().GetInt64("sID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Statut de scénario, paramètre : " + err.Error()})
+		return
+	}
+	var req models.ScenarioStatus
+	if err = ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Statut de scénario, décodage : " + err.Error()})
+		return
+	}
+	if err = req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Statut de scénario, paramètre : " + err.Error()})
+		return
+	}
+	s := models.Scenario{ID: sID}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = s.SetStatus(req.Status, int64(ctx.Values().Get("uID").(int)), db); err != nil {
+		ctx.StatusCode(scenarioErrorStatus(err))
+		ctx.JSON(jsonError{"Statut de scénario, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(scenarioResp{s})
+}
@@ -1,8 +1,11 @@
 package actions
 
 import (
+	"database/sql"
+	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/iris-contrib/httpexpect"
@@ -21,6 +24,23 @@ func testScenario(t *testing.T) {
 		setScenarioOffsetsText(testCtx.E, t, ID)
 		getScenarioActionPaymentTest(testCtx.E, t, ID)
 		getScenarioStatActionPaymentTest(testCtx.E, t, ID)
+		getPaymentForecastTest(testCtx.E, t, ID)
+		getScenarioSimulationTest(testCtx.E, t, ID)
+		cloneID := cloneScenarioTest(testCtx.E, t, ID)
+		if cloneID == 0 {
+			t.Fatal("Impossible de copier le scénario")
+		}
+		compareScenariosTest(testCtx.E, t, ID, cloneID)
+		getScenarioOffsetDiffTest(testCtx.E, t, ID, cloneID)
+		setScenarioNewOpsTest(testCtx.E, t, cloneID)
+		getScenarioNewOpsTest(testCtx.E, t, cloneID)
+		getScenarioCrossTableTest(testCtx.E, t, cloneID)
+		optID := optimizeScenarioTest(testCtx.E, t, cloneID)
+		if optID != 0 {
+			deleteScenarioTest(testCtx.E, t, optID)
+		}
+		adoptedScenarioTest(testCtx.E, t, ID)
+		deleteScenarioTest(testCtx.E, t, cloneID)
 		deleteScenarioTest(testCtx.E, t, ID)
 	})
 }
@@ -76,7 +96,7 @@ func getScenarioActionPaymentTest(e *httpexpect.Expect, t *testing.T, ID int) {
 			BodyContains: []string{"ScenarioPaymentPerBudgetAction",
 				`"chapter":"908","sector":"TC","subfunction":"811","program":"281005",` +
 					`"action":"2810050101","action_name":"Liaisons tramways",` +
-					`"y1":2767866.8312180256,"y2":-327128.643554943,"y3":-766460.1789780867`},
+					`"values":[2767866.8312180256,-327128.643554943,-766460.1789780867]`},
 			CountItemName: `"chapter"`,
 			ArraySize:     53},
 	}
@@ -101,7 +121,7 @@ func getScenarioStatActionPaymentTest(e *httpexpect.Expect, t *testing.T, ID int
 			BodyContains: []string{"ScenarioStatisticalPaymentPerBudgetAction",
 				`"chapter":"908","sector":"TC","subfunction":"811","program":"281005",` +
 					`"action":"2810050101","action_name":"Liaisons tramways",` +
-					`"y1":2767866.8312180256,"y2":-327128.643554943,"y3":-766460.1789780867`},
+					`"values":[2767866.8312180256,-327128.643554943,-766460.1789780867]`},
 			CountItemName: `"chapter"`,
 			ArraySize:     53},
 	}
@@ -115,6 +135,332 @@ func getScenarioStatActionPaymentTest(e *httpexpect.Expect, t *testing.T, ID int
 	}
 }
 
+// getPaymentForecastTest check route is protected and the forecast over a
+// generic horizon matches the three years one.
+func getPaymentForecastTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Param:        "program",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Prévisions de paiement, paramètre : niveau d'agrégation program inconnu"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Param:  "action",
+			Status: http.StatusOK,
+			BodyContains: []string{`"Years":[2018,2019,2020,2021,2022]`,
+				`"action":"2810050101","action_name":"Liaisons tramways",` +
+					`"values":[2767866.83,-327128.64,-766460.18,`}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Param:  "op",
+			Status: http.StatusOK,
+			BodyContains: []string{`"PaymentForecast":[{`,
+				`"op_number":"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/scenarios/"+tc.ID+"/payment_forecast").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithQuery("FirstYear", 2018).
+			WithQuery("Horizon", 5).WithQuery("Level", tc.Param).
+			WithQuery("DefaultPaymentTypeId", 5).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetPaymentForecast") {
+		t.Error(r)
+	}
+	testCases = []testCase{
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Param:        "1000000000",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Prévisions de paiement, paramètre : horizon incorrect"}},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/scenarios/"+tc.ID+"/payment_forecast").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithQuery("FirstYear", 2018).
+			WithQuery("Horizon", tc.Param).WithQuery("DefaultPaymentTypeId", 5).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetPaymentForecast horizon") {
+		t.Error(r)
+	}
+}
+
+// getScenarioSimulationTest check route is protected and the percentiles of
+// the simulated payments sent back.
+func getScenarioSimulationTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Param:        "0",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Simulation de scénario, paramètre : Iterations incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Param:  "50",
+			Status: http.StatusOK,
+			BodyContains: []string{`"Years":[2018,2019,2020]`, `"Iterations":50`,
+				`"ScenarioSimulation":[{`, `"p10":[`, `"p90":[`,
+				`"ScenarioSimulationTotal":{`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/scenarios/"+tc.ID+"/simulation").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithQuery("FirstYear", 2018).
+			WithQuery("Iterations", tc.Param).WithQuery("Seed", 1).
+			WithQuery("DefaultPaymentTypeId", 5).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetScenarioSimulation") {
+		t.Error(r)
+	}
+	testCases = []testCase{
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Param:        "1000000000",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Simulation de scénario, paramètre : horizon incorrect"}},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/scenarios/"+tc.ID+"/simulation").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithQuery("FirstYear", 2018).
+			WithQuery("Horizon", tc.Param).WithQuery("DefaultPaymentTypeId", 5).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetScenarioSimulation horizon") {
+		t.Error(r)
+	}
+}
+
+// cloneScenarioTest check route is protected and the scenario copied with its
+// offsets.
+func cloneScenarioTest(e *httpexpect.Expect, t *testing.T, ID int) (cloneID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"name":""}`),
+			BodyContains: []string{"Copie de scénario : mauvais format"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			Sent:         []byte(`{"name":"Scénario copié"}`),
+			BodyContains: []string{"Copie de scénario, requête : Scenario introuvable"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusCreated,
+			IDName:       `"id"`,
+			Sent:         []byte(`{"name":"Scénario copié","descript":null}`),
+			BodyContains: []string{"Scenario", `"name":"Scénario copié"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/scenarios/"+tc.ID+"/clone").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CloneScenario", &cloneID) {
+		t.Error(r)
+	}
+	return cloneID
+}
+
+// compareScenariosTest check route is protected and a scenario and its copy
+// have null differences.
+func compareScenariosTest(e *httpexpect.Expect, t *testing.T, ID, cloneID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			Param:        "a,b",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Comparaison de scénarios, paramètre : IDs incorrect"}},
+		{
+			Token:        testCtx.Admin.Token,
+			Param:        strconv.Itoa(ID),
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Comparaison de scénarios, requête : au moins deux scénarios nécessaires"}},
+		{
+			Token:        testCtx.Admin.Token,
+			Param:        strconv.Itoa(ID) + ",0",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Comparaison de scénarios, requête : Scenario 0 introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Param:  strconv.Itoa(ID) + "," + strconv.Itoa(cloneID),
+			Status: http.StatusOK,
+			BodyContains: []string{`"Years":[2018,2019,2020]`,
+				`"ScenarioCommitmentComparison":[{`, `"ScenarioPaymentComparison":[{`,
+				`"action":"2810050101","action_name":"Liaisons tramways",` +
+					`"values":[[2767866.83,-327128.64,-766460.18],` +
+					`[2767866.83,-327128.64,-766460.18]],"deltas":[[0,0,0],[0,0,0]]`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/scenarios/compare").WithQuery("IDs", tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).WithQuery("FirstYear", 2018).
+			WithQuery("Declared", false).WithQuery("DefaultPaymentTypeId", 5).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CompareScenarios") {
+		t.Error(r)
+	}
+}
+
+// getScenarioOffsetDiffTest check route is protected and a scenario and its
+// copy have no offset difference.
+func getScenarioOffsetDiffTest(e *httpexpect.Expect, t *testing.T, ID, cloneID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			Param:        "0",
+			Status:       http.StatusInternalServerError,
+			BodyContains: []string{"Différences d'offsets de scénarios, requête : Scenario 0 introuvable"}},
+		{
+			Token:        testCtx.Admin.Token,
+			Param:        strconv.Itoa(cloneID),
+			Status:       http.StatusOK,
+			BodyContains: []string{`"ScenarioOffsetDiff":[]`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/scenarios/"+strconv.Itoa(ID)+"/offset_diff/"+tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetScenarioOffsetDiff") {
+		t.Error(r)
+	}
+}
+
+// setScenarioNewOpsTest check route is protected and hypothetical operations
+// correctly saved.
+func setScenarioNewOpsTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"ScenarioNewOp":[{"name":""}]}`),
+			BodyContains: []string{"Opérations hypothétiques de scénario, paramètre : Name incorrect"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			Sent:         []byte(`{"ScenarioNewOp":[{"name":"Nouvelle opération"}]}`),
+			BodyContains: []string{"Opérations hypothétiques de scénario, requête : Scenario introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusOK,
+			Sent: []byte(`{"ScenarioNewOp":[{"name":"Nouvelle opération",` +
+				`"budget_action_id":null,"commitments":[{"year":2019,"value":100000}]}]}`),
+			BodyContains: []string{`"ScenarioNewOp":[{"id":`,
+				`"name":"Nouvelle opération","budget_action_id":null,` +
+					`"commitments":[{"year":2019,"value":100000}]`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/scenarios/"+tc.ID+"/new_ops").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "SetScenarioNewOps") {
+		t.Error(r)
+	}
+}
+
+// getScenarioNewOpsTest check route is protected and hypothetical operations
+// sent back and used by the budget of the scenario.
+func getScenarioNewOpsTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusOK,
+			BodyContains: []string{`"name":"Nouvelle opération","budget_action_id":null,` +
+				`"commitments":[{"year":2019,"value":100000}]`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/scenarios/"+tc.ID+"/new_ops").
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetScenarioNewOps") {
+		t.Error(r)
+	}
+	testCases = []testCase{
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{`"name":"Nouvelle opération"`, `"values":[null,100000,`}},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/scenarios/"+tc.ID+"/budget").WithQuery("firstYear", 2018).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetScenarioNewOps") {
+		t.Error(r)
+	}
+}
+
+// getScenarioCrossTableTest checks the scenario cross table applies the levers
+// of the operations and labels the hypothetical operations.
+func getScenarioCrossTableTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	sID := strconv.Itoa(ID)
+	offsets := func(list string) {
+		e.POST("/api/scenarios/"+sID+"/offsets").
+			WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+			WithBytes([]byte(`{"offsetList":[` + list + `]}`)).Expect().
+			Status(http.StatusOK)
+	}
+	offsets(`{"physical_op_id":220,"offset":0,"scale":0.5},` +
+		`{"physical_op_id":546,"offset":1,"cancelled":true},` +
+		`{"physical_op_id":9,"offset":0},{"physical_op_id":543,"offset":2}`)
+	defer offsets(`{"physical_op_id":220,"offset":0},{"physical_op_id":546,"offset":1},` +
+		`{"physical_op_id":9,"offset":0},{"physical_op_id":543,"offset":2}`)
+	response := e.GET("/api/scenarios/"+sID).WithQuery("firstYear", 2018).
+		WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).Expect()
+	var resp struct {
+		OperationCrossTable []map[string]interface{}
+		ScenarioCrossTable  []map[string]interface{}
+	}
+	if err := json.Unmarshal(response.Content, &resp); err != nil {
+		t.Fatalf("GetScenarioCrossTable : réponse %s, %v", string(response.Content), err)
+	}
+	lines := make(map[interface{}]map[string]interface{})
+	for _, l := range resp.OperationCrossTable {
+		lines[l["id"]] = l
+	}
+	var newOp map[string]interface{}
+	for _, l := range resp.ScenarioCrossTable {
+		switch l["id"] {
+		case float64(220):
+			for k, v := range lines[float64(220)] {
+				if strings.HasPrefix(k, "y") && v != nil && l[k] != v.(float64)*0.5 {
+					t.Errorf("GetScenarioCrossTable : %s de l'opération 220 attendu %v, reçu %v",
+						k, v.(float64)*0.5, l[k])
+				}
+			}
+		case float64(546):
+			for k, v := range l {
+				if strings.HasPrefix(k, "y") && v != nil {
+					t.Errorf("GetScenarioCrossTable : %s de l'opération annulée 546 non nul : %v", k, v)
+				}
+			}
+		case nil:
+			newOp = l
+		}
+	}
+	if newOp == nil || newOp["new_op_id"] == nil || newOp["y1"] != float64(100000) ||
+		!strings.HasPrefix(newOp["number"].(string), "HYP-") {
+		t.Errorf("GetScenarioCrossTable : opération hypothétique incorrecte %v", newOp)
+	}
+}
+
 // modifyScenarioTest check route is protected and modify works properly.
 func modifyScenarioTest(e *httpexpect.Expect, t *testing.T, ID int) {
 	testCases := []testCase{
@@ -142,6 +488,282 @@ func modifyScenarioTest(e *httpexpect.Expect, t *testing.T, ID int) {
 	}
 }
 
+// optimizeScenarioTest check route is protected and the optimized scenario
+// created from the base scenario with the ceilings.
+func optimizeScenarioTest(e *httpexpect.Expect, t *testing.T, ID int) (optID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"name":"Scénario optimisé","horizon":3}`),
+			BodyContains: []string{"Optimisation de scénario, paramètre : Ceilings vide"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusBadRequest,
+			Sent: []byte(`{"name":"Scénario optimisé","horizon":3,` +
+				`"ceilings":[{"chapter":908,"year":2019,"payments":0}],` +
+				`"priorities":[{"kind":"budget","id":1,"weight":2}]}`),
+			BodyContains: []string{"Optimisation de scénario, paramètre : Priorities kind incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusBadRequest,
+			Sent: []byte(`{"name":"Scénario optimisé","horizon":3,` +
+				`"ceilings":[{"chapter":908,"year":2019,"payments":0}],` +
+				`"offset_ranges":[{"physical_op_id":220,"min_offset":2,"max_offset":1}]}`),
+			BodyContains: []string{"Optimisation de scénario, paramètre : OffsetRanges incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusInternalServerError,
+			Sent: []byte(`{"name":"Scénario optimisé","horizon":3,"base_scenario_id":0,` +
+				`"ceilings":[{"chapter":908,"year":2019,"payments":0}]}`),
+			BodyContains: []string{"Optimisation de scénario, requête : Scenario introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusCreated,
+			IDName: `"id"`,
+			Sent: []byte(`{"name":"Scénario optimisé","base_scenario_id":` +
+				strconv.Itoa(ID) + `,"first_year":2019,"horizon":3,` +
+				`"payment_type_id":5,"max_offset":2,` +
+				`"ceilings":[{"chapter":908,"year":2019,"payments":0,"commitments":0}],` +
+				`"priorities":[{"kind":"step","id":1,"weight":2}],` +
+				`"offset_ranges":[{"physical_op_id":220,"min_offset":-1,"max_offset":1}]}`),
+			BodyContains: []string{`"Scenario":{"id":`, `"name":"Scénario optimisé"`,
+				`"ScenarioShift":`, `"ScenarioCeiling":[{"chapter":908,"year":2019,` +
+					`"payments":0,"commitments":0,`, `"Excess":`, `"Cost":`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/scenarios/optimize").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "OptimizeScenario", &optID) {
+		t.Error(r)
+	}
+	return optID
+}
+
+// adoptedScenarioTest adopts a copy of the scenario, checks its figures stay
+// frozen when the forecast commitments change and removes it from database,
+// adopted scenarios being locked.
+func adoptedScenarioTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	response := e.POST("/api/scenarios/"+strconv.Itoa(ID)+"/clone").
+		WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+		WithBytes([]byte(`{"name":"Scénario adopté","descript":null}`)).Expect()
+	var clone struct {
+		Scenario struct {
+			ID int `json:"id"`
+		}
+	}
+	if err := json.Unmarshal(response.Content, &clone); err != nil || clone.Scenario.ID == 0 {
+		t.Fatalf("AdoptedScenario : copie impossible %s", string(response.Content))
+	}
+	defer func() {
+		for _, qry := range []string{`DELETE FROM scenario_offset WHERE scenario_id=$1`,
+			`DELETE FROM scenario WHERE id=$1`} {
+			if _, err := testCtx.DB.Exec(qry, clone.Scenario.ID); err != nil {
+				t.Errorf("AdoptedScenario : suppression %v", err)
+			}
+		}
+	}()
+	setScenarioStatusTest(e, t, clone.Scenario.ID)
+	fetch := func(sID int) []string {
+		id := strconv.Itoa(sID)
+		return []string{
+			string(e.GET("/api/scenarios/"+id).WithQuery("firstYear", 2018).
+				WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).Expect().Content),
+			string(e.GET("/api/scenarios/"+id+"/budget").WithQuery("firstYear", 2018).
+				WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).Expect().Content),
+			string(e.GET("/api/scenarios/"+id+"/payment_forecast").
+				WithHeader("Authorization", "Bearer "+testCtx.Admin.Token).
+				WithQuery("FirstYear", 2018).WithQuery("Horizon", 5).
+				WithQuery("Level", "action").WithQuery("DefaultPaymentTypeId", 5).
+				Expect().Content)}
+	}
+	adopted, draft := fetch(clone.Scenario.ID), fetch(ID)
+	if _, err := testCtx.DB.Exec(`UPDATE prev_commitment SET value=value+1000000`); err != nil {
+		t.Fatalf("AdoptedScenario : modification des prévisions %v", err)
+	}
+	defer func() {
+		if _, err := testCtx.DB.Exec(`UPDATE prev_commitment SET value=value-1000000`); err != nil {
+			t.Errorf("AdoptedScenario : restauration des prévisions %v", err)
+		}
+	}()
+	for i, body := range fetch(clone.Scenario.ID) {
+		if body != adopted[i] {
+			t.Errorf("AdoptedScenario[%d] : chiffres modifiés après adoption\n  ->attendu %s\n  ->reçu %s",
+				i, adopted[i], body)
+		}
+	}
+	if body := fetch(ID)[0]; body == draft[0] {
+		t.Errorf("AdoptedScenario : prévisions du brouillon inchangées %s", body)
+	}
+}
+
+// setScenarioStatusTest check route is protected, the transitions of the
+// workflow and the lock of the adopted scenario.
+func setScenarioStatusTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	sID := strconv.Itoa(ID)
+	var owner sql.NullInt64
+	if err := testCtx.DB.QueryRow(`SELECT owner_id FROM scenario WHERE id=$1`,
+		ID).Scan(&owner); err != nil {
+		t.Fatalf("SetScenarioStatus : propriétaire %v", err)
+	}
+	if _, err := testCtx.DB.Exec(`UPDATE scenario SET owner_id=$1 WHERE id=$2`,
+		testCtx.User.User.ID, ID); err != nil {
+		t.Fatalf("SetScenarioStatus : changement de propriétaire %v", err)
+	}
+	ownerCases := []testCase{
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Param:        "modify",
+			Status:       http.StatusForbidden,
+			BodyContains: []string{"Modification de scénario, requête : scénario d'un autre utilisateur"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Param:        "new_ops",
+			Status:       http.StatusForbidden,
+			BodyContains: []string{"Opérations hypothétiques de scénario, requête : scénario d'un autre utilisateur"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Status:       http.StatusForbidden,
+			BodyContains: []string{"Statut de scénario, requête : scénario d'un autre utilisateur"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		switch tc.Param {
+		case "modify":
+			return e.PUT("/api/scenarios/"+tc.ID).
+				WithHeader("Authorization", "Bearer "+tc.Token).
+				WithBytes([]byte(`{"name":"Scénario adopté","descript":null}`)).Expect()
+		case "new_ops":
+			return e.POST("/api/scenarios/"+tc.ID+"/new_ops").
+				WithHeader("Authorization", "Bearer "+tc.Token).
+				WithBytes([]byte(`{"ScenarioNewOp":[]}`)).Expect()
+		}
+		return e.POST("/api/scenarios/"+tc.ID+"/status").
+			WithHeader("Authorization", "Bearer "+tc.Token).
+			WithBytes([]byte(`{"status":"submitted"}`)).Expect()
+	}
+	for _, r := range chkTestCases(ownerCases, f, "ScenarioOwner") {
+		t.Error(r)
+	}
+	if _, err := testCtx.DB.Exec(`UPDATE scenario SET owner_id=$1 WHERE id=$2`,
+		owner, ID); err != nil {
+		t.Fatalf("SetScenarioStatus : restauration du propriétaire %v", err)
+	}
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"status":"published"}`),
+			BodyContains: []string{"Statut de scénario, paramètre : Status incorrect"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           "0",
+			Status:       http.StatusInternalServerError,
+			Sent:         []byte(`{"status":"submitted"}`),
+			BodyContains: []string{"Statut de scénario, requête : Scenario introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     sID,
+			Status: http.StatusConflict,
+			Sent:   []byte(`{"status":"adopted"}`),
+			BodyContains: []string{"Statut de scénario, requête : changement de statut " +
+				"impossible, passage du statut draft au statut adopted"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Status:       http.StatusOK,
+			Sent:         []byte(`{"status":"submitted"}`),
+			BodyContains: []string{`"status":"submitted","adopted_at":null`}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Status:       http.StatusOK,
+			Sent:         []byte(`{"status":"adopted"}`),
+			BodyContains: []string{`"status":"adopted"`}},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/scenarios/"+tc.ID+"/status").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "SetScenarioStatus") {
+		t.Error(r)
+	}
+	lockCases := []testCase{
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Param:        "offsets",
+			Status:       http.StatusConflict,
+			BodyContains: []string{"Offsets de scénario, requête : scénario verrouillé, statut adopted"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Param:        "delete",
+			Status:       http.StatusConflict,
+			BodyContains: []string{"Suppression de scénario, requête : scénario verrouillé, statut adopted"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Param:        "modify",
+			Status:       http.StatusConflict,
+			BodyContains: []string{"Modification de scénario, requête : scénario verrouillé, statut adopted"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Param:        "new_ops",
+			Status:       http.StatusConflict,
+			BodyContains: []string{"Opérations hypothétiques de scénario, requête : scénario verrouillé, statut adopted"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     sID,
+			Param:  "status",
+			Status: http.StatusConflict,
+			BodyContains: []string{"Statut de scénario, requête : changement de statut " +
+				"impossible, passage du statut adopted au statut draft"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           sID,
+			Status:       http.StatusOK,
+			BodyContains: []string{`"PaymentForecast":[`}},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		switch tc.Param {
+		case "offsets":
+			return e.POST("/api/scenarios/"+tc.ID+"/offsets").
+				WithHeader("Authorization", "Bearer "+tc.Token).
+				WithBytes([]byte(`{"offsetList":[]}`)).Expect()
+		case "delete":
+			return e.DELETE("/api/scenarios/"+tc.ID).
+				WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+		case "modify":
+			return e.PUT("/api/scenarios/"+tc.ID).
+				WithHeader("Authorization", "Bearer "+tc.Token).
+				WithBytes([]byte(`{"name":"Scénario adopté","descript":null}`)).Expect()
+		case "new_ops":
+			return e.POST("/api/scenarios/"+tc.ID+"/new_ops").
+				WithHeader("Authorization", "Bearer "+tc.Token).
+				WithBytes([]byte(`{"ScenarioNewOp":[]}`)).Expect()
+		case "status":
+			return e.POST("/api/scenarios/"+tc.ID+"/status").
+				WithHeader("Authorization", "Bearer "+tc.Token).
+				WithBytes([]byte(`{"status":"draft"}`)).Expect()
+		}
+		return e.GET("/api/scenarios/"+tc.ID+"/payment_forecast").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithQuery("FirstYear", 2018).
+			WithQuery("Horizon", 5).WithQuery("Level", "action").
+			WithQuery("DefaultPaymentTypeId", 5).Expect()
+	}
+	for _, r := range chkTestCases(lockCases, f, "AdoptedScenario") {
+		t.Error(r)
+	}
+}
+
 // deleteScenarioTest check route is protected and delete works properly.
 func deleteScenarioTest(e *httpexpect.Expect, t *testing.T, ID int) {
 	testCases := []testCase{
@@ -205,8 +827,8 @@ func getMultiannualBudgetScenarioTest(e *httpexpect.Expect, t *testing.T, ID int
 				// cSpell:disable
 				`"MultiannualBudgetScenario":[{"number":"01BU003","name":"Bus - Tzen5 -` +
 					` Paris-Choisy (94)","chapter":908,"sector":"MO","subfunction":"818",` +
-					`"program":"481015","action":"481015011","y0":0,"y1":1274000000,` +
-					`"y2":4047400000,"y3":0,"y4":0}]`}},
+					`"program":"481015","action":"481015011","values":[0,1274000000,` +
+					`4047400000,0,0]}]`}},
 		// cSpell:enable
 	}
 	f := func(tc testCase) *httpexpect.Response {
@@ -216,6 +838,28 @@ func getMultiannualBudgetScenarioTest(e *httpexpect.Expect, t *testing.T, ID int
 	for _, r := range chkTestCases(testCases, f, "GetMultiannualBudgetScenario") {
 		t.Error(r)
 	}
+	testCases = []testCase{
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			ID:           "1",
+			Param:        "first_year=2018&years=0",
+			BodyContains: []string{"Prévision budgétaire pluriannuelle de scénario, paramètre : years incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusOK,
+			ID:     "1",
+			Param:  "first_year=2019&years=7",
+			BodyContains: []string{`"Years":[2019,2020,2021,2022,2023,2024,2025]`,
+				`"action":"481015011","values":[1274000000,4047400000,`}},
+	}
+	f = func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/scenarios/"+tc.ID+"/budget").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetMultiannualBudgetScenarioYears") {
+		t.Error(r)
+	}
 }
 
 // setScenarioOffsetsText check route is protected and offset add return ok.
@@ -229,6 +873,12 @@ func setScenarioOffsetsText(e *httpexpect.Expect, t *testing.T, ID int) {
 			Sent: []byte(`{"offsetList":[{"physical_op_id":220,"offset":0},{"physical_op_id":546,"offset":1},
 			{"physical_op_id":9,"offset":0},{"physical_op_id":543,"offset":2}]}`),
 			BodyContains: []string{"Offsets de scénario, requête : "}},
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			ID:           strconv.Itoa(ID),
+			Sent:         []byte(`{"offsetList":[{"physical_op_id":220,"offset":0,"cap":0}]}`),
+			BodyContains: []string{"Offsets de scénario, paramètre : cap incorrect"}},
 		{
 			Token:  testCtx.Admin.Token,
 			Status: http.StatusOK,
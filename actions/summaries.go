@@ -2,6 +2,7 @@ package actions
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"time"
 
@@ -9,17 +10,51 @@ import (
 	"github.com/kataras/iris"
 )
 
+// maxSummaryYears is the greatest count of years of the multiannual summaries
+const maxSummaryYears = 20
+
+// summaryYears decodes the first year and the count of years of a multiannual
+// summary sent as first_year and years. The legacy parameter is used for the
+// first year if first_year isn't sent and the defaults if it isn't valid.
+func summaryYears(ctx iris.Context, legacy string, defFirst, defYears int64) (int64, int64, error) {
+	firstYear, years := defFirst, defYears
+	var err error
+	if ctx.URLParamExists("first_year") {
+		if firstYear, err = ctx.URLParamInt64("first_year"); err != nil {
+			return 0, 0, errors.New("first_year incorrect")
+		}
+	} else if y, err := ctx.URLParamInt64(legacy); err == nil {
+		firstYear = y
+	}
+	if ctx.URLParamExists("years") {
+		years, err = ctx.URLParamInt64("years")
+		if err != nil || years <= 0 || years > maxSummaryYears {
+			return 0, 0, errors.New("years incorrect")
+		}
+	}
+	return firstYear, years, nil
+}
+
 // GetMultiannualProg handles theget request to fetch multiannual programmation.
 func GetMultiannualProg(ctx iris.Context) {
 	var resp models.MultiannualProg
-	y1, err := ctx.URLParamInt64("y1")
+	y1, years, err := summaryYears(ctx, "y1", int64(time.Now().Year()), 0)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Programmation pluriannuelle, paramètre : " + err.Error()})
+		return
+	}
+	sID, err := snapshotID(ctx)
 	if err != nil {
-		y1 = int64(time.Now().Year())
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Programmation pluriannuelle, paramètre : " + err.Error()})
+		return
 	}
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.GetAll(y1, db); err != nil {
+	if err = resp.GetAll(y1, years, sID, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Programmation pluriannuelle, requête : " + err.Error()})
+		return
 	}
 	ctx.StatusCode(http.StatusOK)
 	ctx.JSON(resp)
@@ -38,9 +73,15 @@ func GetAnnualProgrammation(ctx iris.Context) {
 	if err != nil {
 		year = time.Now().Year()
 	}
+	sID, err := snapshotID(ctx)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Programmation annuelle, paramètre : " + err.Error()})
+		return
+	}
 	var resp annualProgResp
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.AnnualProgrammation.GetAll(year, db); err != nil {
+	if err = resp.AnnualProgrammation.GetAll(year, sID, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Programmation annuelle, requête : " + err.Error()})
 		return
@@ -73,7 +114,7 @@ func GetInitAnnualProgrammation(ctx iris.Context) {
 	}
 	var resp initAnnualProgResp
 	db := ctx.Values().Get("db").(*sql.DB)
-	if err = resp.AnnualProgrammation.GetAll(year, db); err != nil {
+	if err = resp.AnnualProgrammation.GetAll(year, 0, db); err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
 		ctx.JSON(jsonError{"Programmation annuelle, requête : " + err.Error()})
 		return
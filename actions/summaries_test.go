@@ -36,9 +36,26 @@ func multiannualProgrammationTest(e *httpexpect.Expect, t *testing.T) {
 			BodyContains:  []string{"MultiannualProg"},
 			CountItemName: `"number"`,
 			ArraySize:     318},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "years=21",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Programmation pluriannuelle, paramètre : years incorrect"}},
+		{
+			Token:        testCtx.User.Token,
+			Param:        "first_year=a",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Programmation pluriannuelle, paramètre : first_year incorrect"}},
+		{
+			Token:         testCtx.User.Token,
+			Param:         "first_year=2019&years=7",
+			Status:        http.StatusOK,
+			BodyContains:  []string{`"Years":[2019,2020,2021,2022,2023,2024,2025]`},
+			CountItemName: `"number"`,
+			ArraySize:     318},
 	}
 	f := func(tc testCase) *httpexpect.Response {
-		return e.GET("/api/summaries/multiannual_programmation").
+		return e.GET("/api/summaries/multiannual_programmation").WithQueryString(tc.Param).
 			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
 	}
 	for _, r := range chkTestCases(testCases, f, "MultiannuelProgrammation") {
@@ -0,0 +1,125 @@
+package actions
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/Iledant/iris-propera/models"
+	"github.com/kataras/iris"
+)
+
+// territoryResp embeddes response for a single territory.
+type territoryResp struct {
+	Territory models.Territory `json:"Territory"`
+}
+
+// territoryLevel returns the territory level sent as Level, the departments
+// by default.
+func territoryLevel(ctx iris.Context) (string, error) {
+	level := ctx.URLParamDefault("Level", models.TerritoryDepartment)
+	if err := models.CheckTerritoryLevel(level); err != nil {
+		return "", err
+	}
+	return level, nil
+}
+
+// legacyDptFormat returns true if no Level is sent, the ratios and the values
+// per territory being then sent and uploaded in the legacy format with a
+// field per department.
+func legacyDptFormat(ctx iris.Context) bool {
+	return !ctx.URLParamExists("Level")
+}
+
+// GetTerritories handles the get request to fetch the territories of the
+// level sent as Level or all territories.
+func GetTerritories(ctx iris.Context) {
+	level := ctx.URLParam("Level")
+	if level != "" {
+		if err := models.CheckTerritoryLevel(level); err != nil {
+			ctx.StatusCode(http.StatusBadRequest)
+			ctx.JSON(jsonError{"Liste des territoires, paramètre : " + err.Error()})
+			return
+		}
+	}
+	var resp models.Territories
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := resp.GetAll(level, db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Liste des territoires, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(resp)
+}
+
+// CreateTerritory handles the post request to create a territory.
+func CreateTerritory(ctx iris.Context) {
+	var req models.Territory
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création de territoire, décodage : " + err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Création de territoire, paramètre : " + err.Error()})
+		return
+	}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err := req.Create(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Création de territoire, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusCreated)
+	ctx.JSON(territoryResp{req})
+}
+
+// ModifyTerritory handles the put request to modify a territory.
+func ModifyTerritory(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Modification de territoire, paramètre : " + err.Error()})
+		return
+	}
+	var req models.Territory
+	if err = ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Modification de territoire, décodage : " + err.Error()})
+		return
+	}
+	if err = req.Validate(); err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Modification de territoire, paramètre : " + err.Error()})
+		return
+	}
+	req.ID = ID
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = req.Update(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Modification de territoire, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(territoryResp{req})
+}
+
+// DeleteTerritory handles the delete request of a territory.
+func DeleteTerritory(ctx iris.Context) {
+	ID, err := ctx.Params().GetInt64("ID")
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		ctx.JSON(jsonError{"Suppression de territoire, paramètre : " + err.Error()})
+		return
+	}
+	t := models.Territory{ID: ID}
+	db := ctx.Values().Get("db").(*sql.DB)
+	if err = t.Delete(db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"Suppression de territoire, requête : " + err.Error()})
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(jsonMessage{"Territoire supprimé"})
+}
@@ -0,0 +1,146 @@
+package actions
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/iris-contrib/httpexpect"
+)
+
+func testTerritory(t *testing.T) {
+	t.Run("Territory", func(t *testing.T) {
+		ID := createTerritoryTest(testCtx.E, t)
+		if ID == 0 {
+			t.Fatal("Impossible de créer le territoire")
+		}
+		modifyTerritoryTest(testCtx.E, t, ID)
+		getTerritoriesTest(testCtx.E, t)
+		deleteTerritoryTest(testCtx.E, t, ID)
+	})
+}
+
+// createTerritoryTest check route is protected and territory created.
+func createTerritoryTest(e *httpexpect.Expect, t *testing.T) (ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"code":"T1","name":"Territoire","level":"region"}`),
+			BodyContains: []string{"Création de territoire, paramètre : Level incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusInternalServerError,
+			Sent: []byte(`{"code":"T1","name":"Territoire","level":"ept",` +
+				`"parent_id":0}`),
+			BodyContains: []string{"Création de territoire, requête : " +
+				"Territoire parent introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			Status: http.StatusCreated,
+			IDName: `"id"`,
+			Sent:   []byte(`{"code":"T1","name":"Territoire","level":"ept"}`),
+			BodyContains: []string{`"Territory":{"id":`, `"code":"T1"`,
+				`"level":"ept"`, `"parent_id":null`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.POST("/api/territories").
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "CreateTerritory", &ID) {
+		t.Error(r)
+	}
+	return ID
+}
+
+// modifyTerritoryTest check route is protected and territory modified.
+func modifyTerritoryTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusBadRequest,
+			Sent:         []byte(`{"code":"","name":"Territoire modifié","level":"ept"}`),
+			BodyContains: []string{"Modification de territoire, paramètre : Code incorrect"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     "0",
+			Status: http.StatusInternalServerError,
+			Sent:   []byte(`{"code":"T1","name":"Territoire modifié","level":"ept"}`),
+			BodyContains: []string{"Modification de territoire, requête : " +
+				"Territoire introuvable"}},
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     strconv.Itoa(ID),
+			Status: http.StatusOK,
+			Sent:   []byte(`{"code":"T1","name":"Territoire modifié","level":"ept"}`),
+			BodyContains: []string{`"Territory":{"id":` + strconv.Itoa(ID),
+				`"name":"Territoire modifié"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.PUT("/api/territories/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).WithBytes(tc.Sent).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "ModifyTerritory") {
+		t.Error(r)
+	}
+}
+
+// getTerritoriesTest check route is protected and territories of the level
+// sent back.
+func getTerritoriesTest(e *httpexpect.Expect, t *testing.T) {
+	testCases := []testCase{
+		notLoggedTestCase,
+		{
+			Token:        testCtx.User.Token,
+			Param:        "Level=region",
+			Status:       http.StatusBadRequest,
+			BodyContains: []string{"Liste des territoires, paramètre : Level incorrect"}},
+		{
+			Token:  testCtx.User.Token,
+			Param:  "Level=department",
+			Status: http.StatusOK,
+			BodyContains: []string{`"Territory":[{"id":`, `"code":"75","name":"Paris"`,
+				`"code":"95","name":"Val-d'Oise"`},
+			CountItemName: `"level"`,
+			ArraySize:     8},
+		{
+			Token:        testCtx.User.Token,
+			Status:       http.StatusOK,
+			BodyContains: []string{`"code":"T1"`, `"code":"93"`}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.GET("/api/territories").WithQueryString(tc.Param).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "GetTerritories") {
+		t.Error(r)
+	}
+}
+
+// deleteTerritoryTest check route is protected and territory deleted.
+func deleteTerritoryTest(e *httpexpect.Expect, t *testing.T, ID int) {
+	testCases := []testCase{
+		notAdminTestCase,
+		{
+			Token:  testCtx.Admin.Token,
+			ID:     "0",
+			Status: http.StatusInternalServerError,
+			BodyContains: []string{"Suppression de territoire, requête : " +
+				"Territoire introuvable"}},
+		{
+			Token:        testCtx.Admin.Token,
+			ID:           strconv.Itoa(ID),
+			Status:       http.StatusOK,
+			BodyContains: []string{"Territoire supprimé"}},
+	}
+	f := func(tc testCase) *httpexpect.Response {
+		return e.DELETE("/api/territories/"+tc.ID).
+			WithHeader("Authorization", "Bearer "+tc.Token).Expect()
+	}
+	for _, r := range chkTestCases(testCases, f, "DeleteTerritory") {
+		t.Error(r)
+	}
+}
@@ -51,6 +51,7 @@ type homeResp struct {
 	models.NextMonthEvents
 	models.MonthCommitments
 	models.YearBudgetCredits
+	models.VotedCreditHistory
 	models.ProgrammingsPerMonthes
 	models.PaymentPerMonths
 	models.ImportLogs
@@ -95,6 +96,11 @@ func GetHomeDatas(ctx iris.Context) {
 		ctx.JSON(jsonError{"HomeDatas, YearBudgetCredits : " + err.Error()})
 		return
 	}
+	if err = resp.VotedCreditHistory.GetAll(int64(year), db); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		ctx.JSON(jsonError{"HomeDatas, VotedCreditHistory : " + err.Error()})
+		return
+	}
 	err = resp.ProgrammingsPerMonthes.GetAll(year, db)
 	if err != nil {
 		ctx.StatusCode(http.StatusInternalServerError)
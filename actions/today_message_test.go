@@ -62,7 +62,7 @@ func getHomeDatasTest(e *httpexpect.Expect, t *testing.T) {
 			BodyContains: []string{"TodayMessage", "Event", "BudgetCredits",
 				"FinancialCommitmentsPerMonth", "ProgrammingsPerMonth",
 				"PaymentsPerMonth", "CsfWeekTrend",
-				`"FlowStockDelays":`, `"PaymentRate":`}},
+				`"FlowStockDelays":`, `"PaymentRate":`, `"VotedCreditHistory":`}},
 	}
 	f := func(tc testCase) *httpexpect.Response {
 		return e.GET("/api/home").WithHeader("Authorization", "Bearer "+tc.Token).Expect()
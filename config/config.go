@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kataras/iris"
@@ -37,10 +39,24 @@ type Databases struct {
 
 // App defines global values for the application
 type App struct {
-	Prod          bool
-	LogFileName   string
-	LoggerLevel   string
-	TokenFileName string
+	Prod              bool
+	LogFileName       string
+	LoggerLevel       string
+	TokenFileName     string
+	MaxBatchBodySize  int64
+	MaxBatchRows      int
+	DropDir           string
+	DropPollSeconds   int
+	DropPatterns      map[string]string
+	AutoLinkThreshold float64
+	LapseAlertHours   int
+	LapseDemandDays   int64
+	SMTPHost          string
+	SMTPPort          int
+	SMTPUserName      string
+	SMTPPassword      string
+	MailFrom          string
+	InstanceID        string
 }
 
 // DBConf includes all informations for connecting to a database.
@@ -105,6 +121,20 @@ func (p *ProperaConf) Get(app *iris.Application) (logFile *os.File, err error) {
 		p.App.TokenFileName = os.Getenv("TOKEN_FILE_NAME")
 		p.App.Prod = true
 		p.App.LoggerLevel = "info"
+		p.App.MaxBatchBodySize, _ = strconv.ParseInt(os.Getenv("MAX_BATCH_BODY_SIZE"), 10, 64)
+		p.App.MaxBatchRows, _ = strconv.Atoi(os.Getenv("MAX_BATCH_ROWS"))
+		p.App.DropDir = os.Getenv("DROP_DIR")
+		p.App.DropPollSeconds, _ = strconv.Atoi(os.Getenv("DROP_POLL_SECONDS"))
+		p.App.DropPatterns = parseDropPatterns(os.Getenv("DROP_PATTERNS"))
+		p.App.AutoLinkThreshold, _ = strconv.ParseFloat(os.Getenv("AUTO_LINK_THRESHOLD"), 64)
+		p.App.LapseAlertHours, _ = strconv.Atoi(os.Getenv("LAPSE_ALERT_HOURS"))
+		p.App.LapseDemandDays, _ = strconv.ParseInt(os.Getenv("LAPSE_DEMAND_DAYS"), 10, 64)
+		p.App.SMTPHost = os.Getenv("SMTP_HOST")
+		p.App.SMTPPort, _ = strconv.Atoi(os.Getenv("SMTP_PORT"))
+		p.App.SMTPUserName = os.Getenv("SMTP_USERNAME")
+		p.App.SMTPPassword = os.Getenv("SMTP_PASSWORD")
+		p.App.MailFrom = os.Getenv("MAIL_FROM")
+		p.App.InstanceID = os.Getenv("INSTANCE_ID")
 		return logFile, nil
 	}
 	// Otherwise use database.yml
@@ -129,6 +159,19 @@ func (p *ProperaConf) Get(app *iris.Application) (logFile *os.File, err error) {
 	return logFile, nil
 }
 
+// parseDropPatterns decodes the patterns of files of the drop directory given
+// as kind=pattern pairs separated by semicolons.
+func parseDropPatterns(s string) map[string]string {
+	patterns := make(map[string]string)
+	for _, p := range strings.Split(s, ";") {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) != "" {
+			patterns[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return patterns
+}
+
 type mig struct {
 	Batch int64
 	Query string
@@ -221,6 +264,280 @@ var migrations = []mig{
 		Query: `ALTER TABLE payment_demands 
 			ALTER excluded SET DEFAULT FALSE,
 			ALTER excluded SET NOT NULL`},
+	{
+		Batch: 36,
+		Query: `CREATE TABLE IF NOT EXISTS import_job (
+			id SERIAL PRIMARY KEY,
+			kind varchar(50) NOT NULL,
+			status varchar(15) NOT NULL,
+			phase varchar(15),
+			result jsonb,
+			error text,
+			created_at timestamp NOT NULL,
+			updated_at timestamp NOT NULL
+		)`},
+	{
+		Batch: 37,
+		Query: `CREATE TABLE IF NOT EXISTS import_record (
+			id SERIAL PRIMARY KEY,
+			kind varchar(50) NOT NULL,
+			content_hash varchar(64) NOT NULL,
+			idempotency_key varchar(255),
+			result jsonb NOT NULL,
+			created_at timestamp NOT NULL
+		)`},
+	{
+		Batch: 38,
+		Query: `CREATE INDEX IF NOT EXISTS import_record_kind_idx 
+			ON import_record (kind, content_hash)`},
+	{
+		Batch: 39,
+		Query: `ALTER TABLE import_job ADD COLUMN source varchar(255)`},
+	{
+		Batch: 40,
+		Query: `CREATE TABLE IF NOT EXISTS cmt_op_proposal (
+			id SERIAL PRIMARY KEY,
+			commitment_id int NOT NULL REFERENCES financial_commitment(id) ON DELETE CASCADE,
+			op_id int NOT NULL REFERENCES physical_op(id) ON DELETE CASCADE,
+			iris_op_name varchar(250),
+			score double precision,
+			auto_linked boolean NOT NULL DEFAULT FALSE,
+			decision varchar(10),
+			created_at timestamp NOT NULL,
+			decided_at timestamp
+		)`},
+	{
+		Batch: 41,
+		Query: `CREATE TABLE IF NOT EXISTS op_name_alias (
+			id SERIAL PRIMARY KEY,
+			iris_op_name varchar(250) NOT NULL UNIQUE,
+			physical_op_id int NOT NULL REFERENCES physical_op(id) ON DELETE CASCADE,
+			count int NOT NULL DEFAULT 1,
+			updated_at timestamp NOT NULL
+		)`},
+	{
+		Batch: 42,
+		Query: `CREATE TABLE IF NOT EXISTS pmt_cmt_proposal (
+			id SERIAL PRIMARY KEY,
+			payment_id int NOT NULL REFERENCES payment(id) ON DELETE CASCADE,
+			commitment_id int NOT NULL REFERENCES financial_commitment(id) ON DELETE CASCADE,
+			score double precision NOT NULL,
+			explanation text[],
+			decision varchar(10),
+			created_at timestamp NOT NULL,
+			decided_at timestamp
+		)`},
+	{
+		Batch: 43,
+		Query: `CREATE TABLE IF NOT EXISTS commitment_closure (
+			id SERIAL PRIMARY KEY,
+			commitment_id int NOT NULL UNIQUE REFERENCES financial_commitment(id) ON DELETE CASCADE,
+			closed_at date NOT NULL,
+			released_value bigint NOT NULL,
+			comment text,
+			users_id int REFERENCES users(id),
+			created_at timestamp NOT NULL
+		)`},
+	{
+		Batch: 44,
+		Query: `CREATE TABLE IF NOT EXISTS lapse_alert (
+			id SERIAL PRIMARY KEY,
+			users_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			commitment_id int NOT NULL REFERENCES financial_commitment(id) ON DELETE CASCADE,
+			horizon int NOT NULL,
+			lapse_date date NOT NULL,
+			remaining bigint NOT NULL,
+			created_at timestamp NOT NULL,
+			updated_at timestamp NOT NULL,
+			read_at timestamp,
+			UNIQUE (users_id, commitment_id)
+		)`},
+	{
+		Batch: 45,
+		Query: `ALTER TABLE users ADD COLUMN lapse_digest boolean NOT NULL DEFAULT FALSE,
+			ADD COLUMN lapse_digest_at timestamp`},
+	{
+		Batch: 46,
+		Query: `ALTER TABLE scenario_offset ADD COLUMN scale double precision NOT NULL DEFAULT 1,
+			ADD COLUMN cancelled boolean NOT NULL DEFAULT FALSE,
+			ADD COLUMN cap bigint`},
+	{
+		Batch: 47,
+		Query: `CREATE TABLE IF NOT EXISTS scenario_new_op (
+			id SERIAL PRIMARY KEY,
+			scenario_id int NOT NULL REFERENCES scenario(id) ON DELETE CASCADE,
+			name varchar(255) NOT NULL,
+			budget_action_id int REFERENCES budget_action(id)
+		)`},
+	{
+		Batch: 48,
+		Query: `CREATE TABLE IF NOT EXISTS scenario_new_op_commitment (
+			id SERIAL PRIMARY KEY,
+			new_op_id int NOT NULL REFERENCES scenario_new_op(id) ON DELETE CASCADE,
+			year int NOT NULL,
+			value bigint NOT NULL
+		)`},
+	{
+		Batch: 49,
+		Query: `ALTER TABLE scenario_offset ADD COLUMN explanation text`},
+	{
+		Batch: 50,
+		Query: `ALTER TABLE scenario ADD COLUMN owner_id int REFERENCES users(id) ON DELETE SET NULL,
+			ADD COLUMN status varchar(10) NOT NULL DEFAULT 'draft'
+				CHECK (status IN ('draft','submitted','adopted','archived')),
+			ADD COLUMN adopted_at timestamp`},
+	{
+		Batch: 51,
+		Query: `CREATE TABLE IF NOT EXISTS scenario_snapshot (
+			scenario_id int PRIMARY KEY REFERENCES scenario(id) ON DELETE CASCADE,
+			created_at timestamp NOT NULL,
+			data jsonb NOT NULL
+		)`},
+	{
+		Batch: 52,
+		Query: `CREATE TABLE IF NOT EXISTS prevision_snapshot (
+			id SERIAL PRIMARY KEY,
+			name varchar(255) NOT NULL UNIQUE,
+			descript text,
+			created_at timestamp NOT NULL
+		)`},
+	{
+		Batch: 53,
+		Query: `CREATE TABLE IF NOT EXISTS prevision_snapshot_line (
+			snapshot_id int NOT NULL REFERENCES prevision_snapshot(id) ON DELETE CASCADE,
+			kind smallint NOT NULL,
+			physical_op_id int NOT NULL,
+			commission_id int,
+			year int,
+			value bigint,
+			total_value bigint,
+			state_ratio double precision,
+			descript text
+		)`},
+	{
+		Batch: 54,
+		Query: `CREATE INDEX IF NOT EXISTS prevision_snapshot_line_idx
+			ON prevision_snapshot_line(snapshot_id,kind)`},
+	{
+		Batch: 55,
+		Query: `CREATE TABLE IF NOT EXISTS budget_authorization (
+			id SERIAL PRIMARY KEY,
+			action_id int NOT NULL REFERENCES budget_action(id) ON DELETE CASCADE,
+			year int NOT NULL,
+			value bigint NOT NULL,
+			descript text,
+			UNIQUE (action_id, year)
+		)`},
+	{
+		Batch: 56,
+		Query: `CREATE TABLE IF NOT EXISTS authorization_affectation (
+			id SERIAL PRIMARY KEY,
+			authorization_id int NOT NULL REFERENCES budget_authorization(id) ON DELETE CASCADE,
+			physical_op_id int NOT NULL REFERENCES physical_op(id) ON DELETE CASCADE,
+			value bigint NOT NULL,
+			descript text,
+			UNIQUE (authorization_id, physical_op_id)
+		)`},
+	{
+		Batch: 57,
+		Query: `CREATE TABLE IF NOT EXISTS budget_version (
+			id SERIAL PRIMARY KEY,
+			year int NOT NULL,
+			kind varchar(2) NOT NULL CHECK (kind IN ('BP','BS','DM')),
+			number int NOT NULL DEFAULT 0,
+			vote_date date NOT NULL,
+			descript text,
+			UNIQUE (year, kind, number)
+		)`},
+	{
+		Batch: 58,
+		Query: `CREATE TABLE IF NOT EXISTS budget_version_credit (
+			id SERIAL PRIMARY KEY,
+			version_id int NOT NULL REFERENCES budget_version(id) ON DELETE CASCADE,
+			action_id int NOT NULL REFERENCES budget_action(id) ON DELETE CASCADE,
+			commitment bigint NOT NULL DEFAULT 0,
+			payment bigint NOT NULL DEFAULT 0,
+			UNIQUE (version_id, action_id)
+		)`},
+	{
+		Batch: 59,
+		Query: `CREATE TABLE IF NOT EXISTS budget_transfer (
+			id SERIAL PRIMARY KEY,
+			transfer_date date NOT NULL,
+			from_action_id int NOT NULL REFERENCES budget_action(id) ON DELETE CASCADE,
+			to_action_id int NOT NULL REFERENCES budget_action(id) ON DELETE CASCADE,
+			commitment bigint NOT NULL DEFAULT 0,
+			payment bigint NOT NULL DEFAULT 0,
+			descript text,
+			CHECK (from_action_id <> to_action_id)
+		)`},
+	{
+		Batch: 60,
+		Query: `CREATE TABLE IF NOT EXISTS territory (
+			id SERIAL PRIMARY KEY,
+			code varchar(20) NOT NULL,
+			name varchar(255) NOT NULL,
+			level varchar(20) NOT NULL CHECK (level IN ('department','ept','commune')),
+			parent_id int REFERENCES territory(id) ON DELETE SET NULL,
+			UNIQUE (level, code)
+		)`},
+	{
+		Batch: 61,
+		Query: `CREATE TABLE IF NOT EXISTS op_territory_ratio (
+			physical_op_id int NOT NULL REFERENCES physical_op(id) ON DELETE CASCADE,
+			territory_id int NOT NULL REFERENCES territory(id) ON DELETE CASCADE,
+			ratio double precision NOT NULL,
+			PRIMARY KEY (physical_op_id, territory_id)
+		)`},
+	{
+		Batch: 62,
+		Query: `INSERT INTO territory (code,name,level) VALUES
+			('75','Paris','department'),('77','Seine-et-Marne','department'),
+			('78','Yvelines','department'),('91','Essonne','department'),
+			('92','Hauts-de-Seine','department'),('93','Seine-Saint-Denis','department'),
+			('94','Val-de-Marne','department'),('95','Val-d''Oise','department')
+			ON CONFLICT DO NOTHING`},
+	{
+		Batch: 63,
+		Query: `DO $$ BEGIN
+			IF to_regclass('op_dpt_ratios') IS NOT NULL THEN
+				INSERT INTO op_territory_ratio (physical_op_id,territory_id,ratio)
+				SELECT r.physical_op_id,t.id,v.ratio
+				FROM op_dpt_ratios r
+				CROSS JOIN LATERAL (VALUES ('75',r.r75),('77',r.r77),('78',r.r78),
+					('91',r.r91),('92',r.r92),('93',r.r93),('94',r.r94),('95',r.r95))
+					v(code,ratio)
+				JOIN territory t ON t.level='department' AND t.code=v.code
+				WHERE v.ratio NOTNULL
+				ON CONFLICT DO NOTHING;
+				ALTER TABLE op_dpt_ratios RENAME TO op_dpt_ratios_legacy;
+			END IF;
+		END $$`},
+	{
+		Batch: 64,
+		Query: `ALTER TABLE import_job ADD COLUMN IF NOT EXISTS content_hash varchar(64),
+			ADD COLUMN IF NOT EXISTS idempotency_key varchar(255)`},
+	{
+		Batch: 65,
+		Query: `ALTER TABLE financial_commitment ADD COLUMN IF NOT EXISTS iris_op_name varchar(250)`},
+	{
+		Batch: 66,
+		Query: `UPDATE financial_commitment f SET iris_op_name=p.iris_op_name
+			FROM (SELECT DISTINCT ON (commitment_id) commitment_id,iris_op_name
+				FROM cmt_op_proposal WHERE iris_op_name IS NOT NULL
+				ORDER BY commitment_id,created_at DESC) p
+			WHERE f.id=p.commitment_id AND f.iris_op_name IS NULL`},
+	{
+		Batch: 67,
+		Query: `ALTER TABLE import_job ADD COLUMN IF NOT EXISTS owner varchar(255),
+			ADD COLUMN IF NOT EXISTS heartbeat_at timestamp`},
+	{
+		Batch: 68,
+		Query: `UPDATE cmt_op_proposal p SET decided_at=p.created_at,
+			decision=CASE WHEN f.physical_op_id=p.op_id THEN 'accepted'
+				ELSE 'rejected' END
+			FROM financial_commitment f
+			WHERE f.id=p.commitment_id AND p.auto_linked AND p.decision IS NULL`},
 }
 
 // handleMigrations checks against database if migrations queries must be executed
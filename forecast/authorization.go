@@ -0,0 +1,137 @@
+package forecast
+
+// Affectation is the part of an authorization of programme (AP) of a budget
+// action and a year given to an operation
+type Affectation struct {
+	AuthorizationID int64
+	ActionID        int64
+	Year            int64
+	OpID            int64
+	Value           float64
+}
+
+// consumer returns the authorization whose affectation is consumed by the
+// commitments of an operation of the budget action made in the year, the
+// latest authorization of the action voted that year or before, or 0 if the
+// commitments precede all the authorizations.
+func consumer(affectations []Affectation, actionID, year int64) int64 {
+	var ID, latest int64
+	for _, a := range affectations {
+		if a.ActionID != actionID || a.Year > year {
+			continue
+		}
+		if ID == 0 || a.Year > latest || (a.Year == latest && a.AuthorizationID < ID) {
+			ID, latest = a.AuthorizationID, a.Year
+		}
+	}
+	return ID
+}
+
+// cohort identifies the commitments of an operation made in a year
+type cohort struct {
+	opID int64
+	year int64
+}
+
+// Schedule computes the payment credits (CP) schedule of each authorization
+// of programme over the horizon from the projected payments of the
+// commitments that consume its affectations. The commitments and the forecast
+// commitments of an operation consume the affectation of the latest
+// authorization of the budget action of the operation voted the year of the
+// commitment or before, so the commitments made before any authorization are
+// ignored. The declared payments, if used, are shared between the commitments
+// of the operation in proportion to their projected payments of the year or
+// given to the latest commitment if none is projected.
+func Schedule(d *Data, p Params, affectations []Affectation) (map[int64][]float64, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	byOp := make(map[int64][]Affectation)
+	schedule := make(map[int64][]float64)
+	for _, a := range affectations {
+		byOp[a.OpID] = append(byOp[a.OpID], a)
+		if _, ok := schedule[a.AuthorizationID]; !ok {
+			schedule[a.AuthorizationID] = make([]float64, p.Horizon)
+		}
+	}
+	ratios := d.Ratios[p.PaymentTypeID]
+	cohorts := make(map[cohort][]float64)
+	opCohorts := make(map[int64][]cohort)
+	line := func(c cohort) []float64 {
+		values, ok := cohorts[c]
+		if !ok {
+			values = make([]float64, p.Horizon)
+			cohorts[c] = values
+			opCohorts[c.opID] = append(opCohorts[c.opID], c)
+		}
+		return values
+	}
+	spread := func(f Flow) {
+		if byOp[f.OpID] == nil {
+			return
+		}
+		values := line(cohort{f.OpID, f.Year})
+		for _, r := range ratios {
+			if i := p.inHorizon(f.Year + r.Index); i >= 0 {
+				values[i] += f.Value * r.Ratio
+			}
+		}
+	}
+	for _, f := range d.Commitments {
+		if f.Year < p.FirstYear-1 {
+			spread(f)
+		}
+	}
+	for _, f := range d.Programmings {
+		if f.Year == p.FirstYear-1 {
+			spread(f)
+		}
+	}
+	for _, f := range d.prevCommitments() {
+		spread(f)
+	}
+	if p.Declared {
+		for _, f := range d.PrevPayments {
+			i := p.inHorizon(f.Year)
+			if i < 0 || f.Value == 0 || byOp[f.OpID] == nil || !d.applyLever(&f) {
+				continue
+			}
+			shareDeclared(f, i, opCohorts[f.OpID], cohorts)
+		}
+	}
+	for c, values := range cohorts {
+		ID := consumer(byOp[c.opID], d.Ops[c.opID].ActionID, c.year)
+		if ID == 0 {
+			continue
+		}
+		s := schedule[ID]
+		for i, v := range values {
+			s[i] += v
+		}
+	}
+	return schedule, nil
+}
+
+// shareDeclared replaces the projected payments of the year of index i of the
+// commitments of an operation by its declared payment.
+func shareDeclared(f Flow, i int, opCohorts []cohort, cohorts map[cohort][]float64) {
+	var total float64
+	for _, c := range opCohorts {
+		total += cohorts[c][i]
+	}
+	if total != 0 {
+		for _, c := range opCohorts {
+			cohorts[c][i] *= f.Value / total
+		}
+		return
+	}
+	latest := -1
+	for j, c := range opCohorts {
+		if c.year <= f.Year && (latest < 0 || c.year > opCohorts[latest].year) {
+			latest = j
+		}
+	}
+	if latest >= 0 {
+		cohorts[opCohorts[latest]][i] = f.Value
+	}
+}
@@ -0,0 +1,68 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSchedule(t *testing.T) {
+	d := &Data{
+		Ops: map[int64]Op{1: {ID: 1, ActionID: 10}, 2: {ID: 2, ActionID: 10},
+			3: {ID: 3, ActionID: 20}},
+		Ratios: map[int64][]Ratio{5: {{Index: 0, Ratio: 0.5}, {Index: 1, Ratio: 0.3},
+			{Index: 2, Ratio: 0.2}}},
+		Commitments: []Flow{
+			{OpID: 1, Year: 2016, Value: 1000},
+			{OpID: 2, Year: 2016, Value: 400},
+			{ActionID: 10, Year: 2016, Value: 5000},
+		},
+		Programmings: []Flow{{OpID: 1, Year: 2017, Value: 500},
+			{OpID: 3, Year: 2017, Value: 100}},
+		PrevCommitments: []Flow{{OpID: 1, Year: 2020, Value: 600}},
+		PrevPayments: []Flow{{OpID: 1, Year: 2019, Value: 40},
+			{OpID: 1, Year: 2020, Value: 90}, {OpID: 2, Year: 2019, Value: 30}},
+	}
+	affectations := []Affectation{
+		{AuthorizationID: 7, ActionID: 10, Year: 2017, OpID: 1, Value: 750},
+		{AuthorizationID: 8, ActionID: 10, Year: 2020, OpID: 1, Value: 250},
+		{AuthorizationID: 8, ActionID: 10, Year: 2020, OpID: 2, Value: 100},
+		{AuthorizationID: 9, ActionID: 10, Year: 2017, OpID: 3, Value: 100},
+	}
+	p := Params{FirstYear: 2018, Horizon: 3, PaymentTypeID: 5}
+	cases := []struct {
+		name     string
+		declared bool
+		expected map[int64][]float64
+	}{
+		// The 2016 commitments of op 1 and op 2 precede their authorizations and
+		// op 3 belongs to another budget action than its authorization
+		{"statistique", false, map[int64][]float64{7: {150, 100, 0}, 8: {0, 0, 300},
+			9: {0, 0, 0}}},
+		// The declared payments of op 1 replace the projected ones and the one
+		// of op 2 goes to its 2016 commitment which consumes no authorization
+		{"déclaré", true, map[int64][]float64{7: {150, 40, 0}, 8: {0, 0, 90},
+			9: {0, 0, 0}}},
+	}
+	for _, c := range cases {
+		p.Declared = c.declared
+		s, err := Schedule(d, p, affectations)
+		if err != nil {
+			t.Fatalf("%s : %v", c.name, err)
+		}
+		if len(s) != len(c.expected) {
+			t.Fatalf("%s : %d autorisations attendues, reçu %d", c.name,
+				len(c.expected), len(s))
+		}
+		for ID, values := range c.expected {
+			for i, v := range values {
+				if math.Abs(s[ID][i]-v) > 1e-9 {
+					t.Errorf("%s autorisation %d année %d : %v attendu, reçu %v", c.name,
+						ID, i, v, s[ID][i])
+				}
+			}
+		}
+	}
+	if _, err := Schedule(d, Params{FirstYear: 2018}, affectations); err == nil {
+		t.Error("horizon nul : erreur attendue")
+	}
+}
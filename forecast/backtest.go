@@ -0,0 +1,388 @@
+package forecast
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Payment is an amount paid in a year on the commitments of a budget action
+// made in CommitmentYear
+type Payment struct {
+	ActionID       int64
+	CommitmentYear int64
+	Year           int64
+	Value          float64
+}
+
+// History gathers the commitments per budget action and year and the
+// payments on these commitments used to replay the forecasts
+type History struct {
+	Commitments []Flow
+	Payments    []Payment
+}
+
+// Before returns the history known at the beginning of the year.
+func (h *History) Before(year int64) *History {
+	var b History
+	for _, c := range h.Commitments {
+		if c.Year < year {
+			b.Commitments = append(b.Commitments, c)
+		}
+	}
+	for _, p := range h.Payments {
+		if p.Year < year {
+			b.Payments = append(b.Payments, p)
+		}
+	}
+	return &b
+}
+
+// StockMethod forecasts, per budget action, the payments of the horizon
+// starting at origin on the commitments made before origin. The history given
+// only contains the datas known at origin.
+type StockMethod func(h *History, origin, horizon int64) map[int64][]float64
+
+// cohortKey identifies the commitments of a budget action made in a year
+type cohortKey struct {
+	ActionID int64
+	Year     int64
+}
+
+// cohorts sums the commitments per budget action and year.
+func (h *History) cohorts() map[cohortKey]float64 {
+	c := make(map[cohortKey]float64)
+	for _, f := range h.Commitments {
+		c[cohortKey{f.ActionID, f.Year}] += f.Value
+	}
+	return c
+}
+
+// paid sums the payments per budget action, commitment year and index, i.e.
+// the count of years between the commitment and the payment.
+func (h *History) paid() map[cohortKey]map[int64]float64 {
+	p := make(map[cohortKey]map[int64]float64)
+	for _, f := range h.Payments {
+		k := cohortKey{f.ActionID, f.CommitmentYear}
+		if p[k] == nil {
+			p[k] = make(map[int64]float64)
+		}
+		p[k][f.Year-f.CommitmentYear] += f.Value
+	}
+	return p
+}
+
+// RatioMethod spreads the commitments using the given payment ratios,
+// regardless of the payments already made.
+func RatioMethod(ratios []Ratio) StockMethod {
+	return func(h *History, origin, horizon int64) map[int64][]float64 {
+		result := make(map[int64][]float64)
+		for k, v := range h.cohorts() {
+			for _, r := range ratios {
+				i := k.Year + r.Index - origin
+				if i < 0 || i >= horizon {
+					continue
+				}
+				if result[k.ActionID] == nil {
+					result[k.ActionID] = make([]float64, horizon)
+				}
+				result[k.ActionID][i] += v * r.Ratio
+			}
+		}
+		return result
+	}
+}
+
+// totalsByYear sums the commitments and the payments of all budget actions
+// per commitment year and index.
+func totalsByYear(h *History) (map[int64]float64, map[int64]map[int64]float64) {
+	commitments, payments := make(map[int64]float64), make(map[int64]map[int64]float64)
+	for k, v := range h.cohorts() {
+		commitments[k.Year] += v
+	}
+	for k, p := range h.paid() {
+		if payments[k.Year] == nil {
+			payments[k.Year] = make(map[int64]float64)
+		}
+		for idx, v := range p {
+			payments[k.Year][idx] += v
+		}
+	}
+	return commitments, payments
+}
+
+// EmpiricalRatios computes the share of the commitments paid idx years after
+// the commitment year using the commitments of the window years before
+// origin. The history must only contain the datas known at origin.
+func EmpiricalRatios(h *History, origin, window int64) []Ratio {
+	commitments, payments := totalsByYear(h)
+	var ratios []Ratio
+	for idx := int64(0); idx < window; idx++ {
+		var paid, committed float64
+		for y := origin - window; y+idx < origin; y++ {
+			if commitments[y] <= 0 {
+				continue
+			}
+			paid += payments[y][idx]
+			committed += commitments[y]
+		}
+		if committed > 0 {
+			ratios = append(ratios, Ratio{Index: idx, Ratio: paid / committed})
+		}
+	}
+	return ratios
+}
+
+// EmpiricalMethod spreads the commitments using the ratios computed from the
+// payments known at origin.
+func EmpiricalMethod(window int64) StockMethod {
+	return func(h *History, origin, horizon int64) map[int64][]float64 {
+		return RatioMethod(EmpiricalRatios(h, origin, window))(h, origin, horizon)
+	}
+}
+
+// CalibratedMethod spreads the commitments using the ratios calibrated as of
+// origin on the commitments of the window years before origin, each budget
+// action and commitment year being a commitment of the calibration. It
+// replays the payment ratios as adopted with the calibration.
+func CalibratedMethod(window int64, excludeOutliers bool) StockMethod {
+	return func(h *History, origin, horizon int64) map[int64][]float64 {
+		paid := h.paid()
+		var c []CommitmentHistory
+		for k, v := range h.cohorts() {
+			ch := CommitmentHistory{Year: k.Year, Value: v}
+			for idx, p := range paid[k] {
+				for int64(len(ch.Paid)) <= idx {
+					ch.Paid = append(ch.Paid, 0)
+				}
+				ch.Paid[idx] += p
+			}
+			c = append(c, ch)
+		}
+		cal, err := Calibrate(c, CalibrationParams{FirstYear: origin - window,
+			LastYear: origin, ExcludeOutliers: excludeOutliers})
+		if err != nil {
+			return nil
+		}
+		return RatioMethod(cal.Ratios)(h, origin, horizon)
+	}
+}
+
+// differentialRatios computes the share of the remaining to pay paid idx
+// years after the commitment year using the commitments of the window years
+// before origin.
+func differentialRatios(h *History, origin, window int64) []float64 {
+	commitments, payments := totalsByYear(h)
+	var ratios []float64
+	for idx := int64(0); idx < window; idx++ {
+		var paid, remaining float64
+		for y := origin - window; y+idx < origin; y++ {
+			r := commitments[y]
+			for j := int64(0); j < idx; j++ {
+				r -= payments[y][j]
+			}
+			if r <= 0 {
+				continue
+			}
+			paid += payments[y][idx]
+			remaining += r
+		}
+		if remaining <= 0 {
+			break
+		}
+		ratios = append(ratios, paid/remaining)
+	}
+	return ratios
+}
+
+// DifferentialMethod applies to the remaining to pay of each commitment year
+// the share paid each year computed from the payments known at origin.
+func DifferentialMethod(window int64) StockMethod {
+	return func(h *History, origin, horizon int64) map[int64][]float64 {
+		ratios := differentialRatios(h, origin, window)
+		paid := h.paid()
+		result := make(map[int64][]float64)
+		for k, v := range h.cohorts() {
+			remaining := v
+			for _, p := range paid[k] {
+				remaining -= p
+			}
+			if remaining <= 0 {
+				continue
+			}
+			for i := int64(0); i < horizon; i++ {
+				idx := origin + i - k.Year
+				if idx < 0 || idx >= int64(len(ratios)) {
+					continue
+				}
+				if result[k.ActionID] == nil {
+					result[k.ActionID] = make([]float64, horizon)
+				}
+				p := ratios[idx] * remaining
+				result[k.ActionID][i] += p
+				remaining -= p
+			}
+		}
+		return result
+	}
+}
+
+// Bounds of the parameters of a backtest
+const (
+	MaxBacktestHorizon = 10
+	MaxBacktestOrigins = 20
+)
+
+// ErrBacktestOrigins is returned when the origins of the backtest are not
+// within the years of the history
+var ErrBacktestOrigins = errors.New("années de départ hors de l'historique")
+
+// BacktestParams defines the origins of the replayed forecasts and their
+// horizon. The years from LastYear are ignored as their payments are not
+// complete.
+type BacktestParams struct {
+	FirstOrigin int64
+	LastOrigin  int64
+	Horizon     int64
+	LastYear    int64
+}
+
+// BacktestPoint compares the forecast and the actual payments of a year for
+// a budget action
+type BacktestPoint struct {
+	Origin   int64
+	Year     int64
+	ActionID int64
+	Forecast float64
+	Actual   float64
+}
+
+// Accuracy measures the forecast errors. MAPE is the mean of the absolute
+// errors relative to the actual payments, the points without actual payments
+// being ignored, and Bias is the total error relative to the total actual
+// payments, positive if the forecast is overestimated.
+type Accuracy struct {
+	Points int64
+	MAPE   float64
+	Bias   float64
+	Valid  bool
+}
+
+// BacktestResult gathers the points and the accuracy of a backtest
+type BacktestResult struct {
+	Points   []BacktestPoint
+	Accuracy Accuracy
+}
+
+// Validate checks the parameters of the backtest.
+func (p *BacktestParams) Validate() error {
+	if p.Horizon <= 0 || p.Horizon > MaxBacktestHorizon {
+		return errors.New("horizon incorrect")
+	}
+	if p.FirstOrigin > p.LastOrigin {
+		return errors.New("années de départ incorrectes")
+	}
+	if p.LastOrigin-p.FirstOrigin >= MaxBacktestOrigins {
+		return errors.New("trop d'années de départ")
+	}
+	return nil
+}
+
+// CheckOrigins checks that each origin has at least one year of history
+// before it and is prior to LastYear.
+func (p *BacktestParams) CheckOrigins(h *History) error {
+	first := p.FirstOrigin
+	for _, c := range h.Commitments {
+		if c.Year < first {
+			first = c.Year
+		}
+	}
+	if first == p.FirstOrigin || p.LastOrigin >= p.LastYear {
+		return ErrBacktestOrigins
+	}
+	return nil
+}
+
+// Backtest replays the method for each origin using only the history known
+// at origin and compares the forecasts to the payments made on the
+// commitments prior to the origin.
+func Backtest(h *History, m StockMethod, p BacktestParams) (*BacktestResult, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if err := p.CheckOrigins(h); err != nil {
+		return nil, err
+	}
+	var res BacktestResult
+	for origin := p.FirstOrigin; origin <= p.LastOrigin; origin++ {
+		forecasts := m(h.Before(origin), origin, p.Horizon)
+		actuals := make(map[int64][]float64)
+		for _, f := range h.Payments {
+			i := f.Year - origin
+			if f.CommitmentYear >= origin || i < 0 || i >= p.Horizon {
+				continue
+			}
+			if actuals[f.ActionID] == nil {
+				actuals[f.ActionID] = make([]float64, p.Horizon)
+			}
+			actuals[f.ActionID][i] += f.Value
+		}
+		actions := make(map[int64]bool)
+		for a := range forecasts {
+			actions[a] = true
+		}
+		for a := range actuals {
+			actions[a] = true
+		}
+		for a := range actions {
+			for i := int64(0); i < p.Horizon; i++ {
+				if origin+i >= p.LastYear {
+					break
+				}
+				pt := BacktestPoint{Origin: origin, Year: origin + i, ActionID: a}
+				if forecasts[a] != nil {
+					pt.Forecast = forecasts[a][i]
+				}
+				if actuals[a] != nil {
+					pt.Actual = actuals[a][i]
+				}
+				if pt.Forecast == 0 && pt.Actual == 0 {
+					continue
+				}
+				res.Points = append(res.Points, pt)
+			}
+		}
+	}
+	sort.Slice(res.Points, func(i, j int) bool {
+		a, b := res.Points[i], res.Points[j]
+		if a.Origin != b.Origin {
+			return a.Origin < b.Origin
+		}
+		if a.Year != b.Year {
+			return a.Year < b.Year
+		}
+		return a.ActionID < b.ActionID
+	})
+	res.Accuracy = Measure(res.Points)
+	return &res, nil
+}
+
+// Measure computes the accuracy of the points.
+func Measure(points []BacktestPoint) Accuracy {
+	var a Accuracy
+	var ape, errSum, actualSum float64
+	for _, p := range points {
+		errSum += p.Forecast - p.Actual
+		actualSum += p.Actual
+		if p.Actual == 0 {
+			continue
+		}
+		ape += math.Abs(p.Forecast-p.Actual) / math.Abs(p.Actual)
+		a.Points++
+	}
+	if a.Points == 0 || actualSum == 0 {
+		return a
+	}
+	a.MAPE, a.Bias, a.Valid = ape/float64(a.Points), errSum/actualSum, true
+	return a
+}
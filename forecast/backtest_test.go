@@ -0,0 +1,148 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+)
+
+// testHistory returns commitments of 100 per year from 2010 to 2017 for the
+// action 1, paid 40% the first year, 40% the second and 20% the third.
+func testHistory() *History {
+	var h History
+	for y := int64(2010); y <= 2017; y++ {
+		h.Commitments = append(h.Commitments, Flow{ActionID: 1, Year: y, Value: 100})
+		for i, r := range []float64{40, 40, 20} {
+			h.Payments = append(h.Payments, Payment{ActionID: 1, CommitmentYear: y,
+				Year: y + int64(i), Value: r})
+		}
+	}
+	return &h
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestBefore(t *testing.T) {
+	b := testHistory().Before(2012)
+	if len(b.Commitments) != 2 {
+		t.Errorf("2 engagements attendus, reçu %d", len(b.Commitments))
+	}
+	for _, p := range b.Payments {
+		if p.Year >= 2012 {
+			t.Errorf("paiement %+v postérieur à 2012", p)
+		}
+	}
+}
+
+func TestEmpiricalRatios(t *testing.T) {
+	ratios := EmpiricalRatios(testHistory().Before(2016), 2016, 5)
+	want := []float64{0.4, 0.4, 0.2, 0, 0}
+	if len(ratios) != len(want) {
+		t.Fatalf("%d ratios attendus, reçu %+v", len(want), ratios)
+	}
+	for i, r := range ratios {
+		if !almostEqual(r.Ratio, want[i]) {
+			t.Errorf("ratio %d : attendu %v, reçu %v", i, want[i], r.Ratio)
+		}
+	}
+}
+
+func TestBacktest(t *testing.T) {
+	h := testHistory()
+	p := BacktestParams{FirstOrigin: 2015, LastOrigin: 2016, Horizon: 2,
+		LastYear: 2018}
+	for name, m := range map[string]StockMethod{
+		"empirical":    EmpiricalMethod(5),
+		"differential": DifferentialMethod(5),
+		"calibrated":   CalibratedMethod(5, true),
+		"ratio": RatioMethod([]Ratio{{Index: 0, Ratio: 0.4}, {Index: 1, Ratio: 0.4},
+			{Index: 2, Ratio: 0.2}}),
+	} {
+		res, err := Backtest(h, m, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Forecasts of 2015-2016 and 2016-2017 on the stock : 60 then 20
+		if len(res.Points) != 4 {
+			t.Fatalf("%s : 4 points attendus, reçu %+v", name, res.Points)
+		}
+		for _, pt := range res.Points {
+			if !almostEqual(pt.Forecast, pt.Actual) {
+				t.Errorf("%s : prévision exacte attendue %+v", name, pt)
+			}
+		}
+		if !res.Accuracy.Valid || !almostEqual(res.Accuracy.MAPE, 0) ||
+			!almostEqual(res.Accuracy.Bias, 0) {
+			t.Errorf("%s : précision parfaite attendue %+v", name, res.Accuracy)
+		}
+	}
+	biased := RatioMethod([]Ratio{{Index: 1, Ratio: 0.6}})
+	res, err := Backtest(h, biased, BacktestParams{FirstOrigin: 2016,
+		LastOrigin: 2016, Horizon: 1, LastYear: 2018})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Forecast 60 for an actual of 60 (40% of 2015 + 20% of 2014)
+	if !almostEqual(res.Accuracy.Bias, 0) || res.Accuracy.Points != 1 {
+		t.Errorf("biais nul attendu %+v", res.Accuracy)
+	}
+	for name, bp := range map[string]BacktestParams{
+		"années de départ inversées": {FirstOrigin: 2016, LastOrigin: 2015,
+			Horizon: 1, LastYear: 2018},
+		"horizon trop grand": {FirstOrigin: 2015, LastOrigin: 2016,
+			Horizon: MaxBacktestHorizon + 1, LastYear: 2018},
+		"trop d'années de départ": {FirstOrigin: 2015,
+			LastOrigin: 2015 + MaxBacktestOrigins, Horizon: 1, LastYear: 2100},
+		"année de départ sans historique": {FirstOrigin: 2010, LastOrigin: 2011,
+			Horizon: 1, LastYear: 2018},
+		"année de départ future": {FirstOrigin: 2016, LastOrigin: 2018,
+			Horizon: 1, LastYear: 2018},
+	} {
+		if _, err = Backtest(h, biased, bp); err == nil {
+			t.Errorf("%s : erreur attendue", name)
+		}
+	}
+	if _, err = Backtest(h, biased, BacktestParams{FirstOrigin: 2000,
+		LastOrigin: 2001, Horizon: 1, LastYear: 2018}); err != ErrBacktestOrigins {
+		t.Errorf("années hors historique : ErrBacktestOrigins attendue, reçu %v", err)
+	}
+}
+
+func TestCalibratedMethod(t *testing.T) {
+	h := testHistory()
+	for a := int64(2); a <= 4; a++ {
+		for y := int64(2010); y <= 2017; y++ {
+			h.Commitments = append(h.Commitments, Flow{ActionID: a, Year: y, Value: 100})
+			for i, r := range []float64{40, 40, 20} {
+				h.Payments = append(h.Payments, Payment{ActionID: a, CommitmentYear: y,
+					Year: y + int64(i), Value: r})
+			}
+		}
+	}
+	// Action 5 paid entirely the year of its commitment in 2014
+	h.Commitments = append(h.Commitments, Flow{ActionID: 5, Year: 2014, Value: 100})
+	h.Payments = append(h.Payments, Payment{ActionID: 5, CommitmentYear: 2014,
+		Year: 2014, Value: 100})
+	// Forecast of 2016 for the action 1 : 40% of 2015 and 20% of 2014
+	if r := CalibratedMethod(5, true)(h.Before(2016), 2016, 1); r[1] == nil ||
+		!almostEqual(r[1][0], 60) {
+		t.Errorf("prévision de 60 attendue hors valeurs aberrantes, reçu %+v", r)
+	}
+	if r := CalibratedMethod(5, false)(h.Before(2016), 2016, 1); r[1] == nil ||
+		almostEqual(r[1][0], 60) {
+		t.Errorf("prévision biaisée par la valeur aberrante attendue, reçu %+v", r)
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	a := Measure([]BacktestPoint{{Forecast: 110, Actual: 100},
+		{Forecast: 45, Actual: 50}, {Forecast: 5, Actual: 0}})
+	if !a.Valid || a.Points != 2 || !almostEqual(a.MAPE, 0.1) ||
+		!almostEqual(a.Bias, 10.0/150) {
+		t.Errorf("précision inattendue %+v", a)
+	}
+	if a = Measure(nil); a.Valid {
+		t.Errorf("précision invalide attendue %+v", a)
+	}
+}
@@ -0,0 +1,125 @@
+package forecast
+
+import (
+	"errors"
+	"sort"
+)
+
+// CommitmentHistory is a commitment with the payments made Paid[idx] idx
+// years after its year
+type CommitmentHistory struct {
+	Year  int64
+	Value float64
+	Paid  []float64
+}
+
+// CalibrationParams defines the commitments used to compute a ratio curve.
+// The payments from LastYear are ignored as the year isn't complete.
+type CalibrationParams struct {
+	FirstYear       int64
+	LastYear        int64
+	ExcludeOutliers bool
+}
+
+// Calibration is the ratio curve computed from the commitments with the count
+// of commitments used and excluded as outliers
+type Calibration struct {
+	Ratios   []Ratio
+	Used     int64
+	Excluded int64
+}
+
+// Validate checks the parameters of the calibration.
+func (p *CalibrationParams) Validate() error {
+	if p.FirstYear > p.LastYear {
+		return errors.New("période incorrecte")
+	}
+	return nil
+}
+
+// share returns the share of the commitment paid up to the last year.
+func (c *CommitmentHistory) share(lastYear int64) float64 {
+	var paid float64
+	for idx, p := range c.Paid {
+		if c.Year+int64(idx) < lastYear {
+			paid += p
+		}
+	}
+	return paid / c.Value
+}
+
+// quantile returns the quantile of sorted values using linear interpolation.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// outliers flags the commitments whose share paid is outside the Tukey
+// fences, i.e. more than 1.5 interquartile range beyond the quartiles. The
+// share is compared among the commitments of the same year as the older ones
+// are more paid.
+func outliers(c []CommitmentHistory, lastYear int64) []bool {
+	years := make(map[int64][]float64)
+	for _, h := range c {
+		years[h.Year] = append(years[h.Year], h.share(lastYear))
+	}
+	fences := make(map[int64][2]float64)
+	for y, shares := range years {
+		sort.Float64s(shares)
+		q1, q3 := quantile(shares, 0.25), quantile(shares, 0.75)
+		fences[y] = [2]float64{q1 - 1.5*(q3-q1), q3 + 1.5*(q3-q1)}
+	}
+	out := make([]bool, len(c))
+	for i, h := range c {
+		s, f := h.share(lastYear), fences[h.Year]
+		out[i] = s < f[0] || s > f[1]
+	}
+	return out
+}
+
+// Calibrate computes the share of the commitments of the period paid idx
+// years after the commitment year. Each ratio only uses the commitments whose
+// year idx is complete. The commitments with a null or negative value are
+// ignored.
+func Calibrate(c []CommitmentHistory, p CalibrationParams) (*Calibration, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	var kept []CommitmentHistory
+	for _, h := range c {
+		if h.Value > 0 && h.Year >= p.FirstYear && h.Year <= p.LastYear {
+			kept = append(kept, h)
+		}
+	}
+	var res Calibration
+	var out []bool
+	if p.ExcludeOutliers {
+		out = outliers(kept, p.LastYear)
+	}
+	var paid, committed []float64
+	for i, h := range kept {
+		if out != nil && out[i] {
+			res.Excluded++
+			continue
+		}
+		res.Used++
+		for idx := int64(0); h.Year+idx < p.LastYear; idx++ {
+			if idx >= int64(len(committed)) {
+				paid, committed = append(paid, 0), append(committed, 0)
+			}
+			committed[idx] += h.Value
+			if idx < int64(len(h.Paid)) {
+				paid[idx] += h.Paid[idx]
+			}
+		}
+	}
+	for idx := range committed {
+		res.Ratios = append(res.Ratios, Ratio{Index: int64(idx),
+			Ratio: paid[idx] / committed[idx]})
+	}
+	return &res, nil
+}
@@ -0,0 +1,49 @@
+package forecast
+
+import "testing"
+
+func TestCalibrate(t *testing.T) {
+	c := []CommitmentHistory{
+		{Year: 2015, Value: 100, Paid: []float64{20, 50, 30}},
+		{Year: 2015, Value: 100, Paid: []float64{20, 50, 30}},
+		{Year: 2015, Value: 100, Paid: []float64{20, 50, 30}},
+		{Year: 2015, Value: 100, Paid: []float64{20, 50, 30}},
+		{Year: 2015, Value: 100, Paid: []float64{200}},
+		{Year: 2016, Value: 100, Paid: []float64{40, 60}},
+		{Year: 2016, Value: 0, Paid: []float64{10}},
+		{Year: 2010, Value: 100, Paid: []float64{100}},
+	}
+	p := CalibrationParams{FirstYear: 2015, LastYear: 2018}
+	cal, err := Calibrate(c, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.Used != 6 || cal.Excluded != 0 {
+		t.Errorf("6 engagements utilisés attendus, reçu %+v", cal)
+	}
+	want := []float64{320.0 / 600, 260.0 / 600, 120.0 / 500}
+	if len(cal.Ratios) != len(want) {
+		t.Fatalf("%d ratios attendus, reçu %+v", len(want), cal.Ratios)
+	}
+	for i, r := range cal.Ratios {
+		if !almostEqual(r.Ratio, want[i]) {
+			t.Errorf("ratio %d : attendu %v, reçu %v", i, want[i], r.Ratio)
+		}
+	}
+	p.ExcludeOutliers = true
+	if cal, err = Calibrate(c, p); err != nil {
+		t.Fatal(err)
+	}
+	if cal.Used != 5 || cal.Excluded != 1 {
+		t.Errorf("1 engagement exclu attendu, reçu %+v", cal)
+	}
+	want = []float64{120.0 / 500, 260.0 / 500, 120.0 / 400}
+	for i, r := range cal.Ratios {
+		if !almostEqual(r.Ratio, want[i]) {
+			t.Errorf("ratio %d : attendu %v, reçu %v", i, want[i], r.Ratio)
+		}
+	}
+	if _, err = Calibrate(c, CalibrationParams{FirstYear: 2018, LastYear: 2015}); err == nil {
+		t.Error("erreur attendue pour une période incorrecte")
+	}
+}
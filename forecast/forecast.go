@@ -0,0 +1,369 @@
+// Package forecast projects the payments and the commitments of the physical
+// operations over a horizon of years. The datas are loaded once from database
+// by the models and the computation is done in memory so that the horizon,
+// the aggregation level and the payment type are parameters of the projection.
+package forecast
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Level is the aggregation level of the projection
+type Level int
+
+// Aggregation levels of the projection
+const (
+	// LevelAction aggregates the amounts per budget action
+	LevelAction Level = iota
+	// LevelOp aggregates the amounts per physical operation, the unlinked
+	// commitments being aggregated per budget action
+	LevelOp
+)
+
+// ParseLevel decodes the aggregation level from its name.
+func ParseLevel(s string) (Level, error) {
+	switch s {
+	case "", "action":
+		return LevelAction, nil
+	case "op":
+		return LevelOp, nil
+	}
+	return LevelAction, fmt.Errorf("niveau d'agrégation %s inconnu", s)
+}
+
+// Op is a physical operation with its budget action, ActionID being 0 if the
+// operation isn't linked to a budget action
+type Op struct {
+	ID       int64
+	ActionID int64
+}
+
+// Flow is an amount in cents of a year attached to an operation or, if OpID
+// is 0, directly to a budget action
+type Flow struct {
+	OpID     int64
+	ActionID int64
+	Year     int64
+	Value    float64
+}
+
+// Ratio is the share of a commitment paid Index years after the commitment
+type Ratio struct {
+	Index int64
+	Ratio float64
+}
+
+// Data gathers all the datas used by the projections
+type Data struct {
+	Ops map[int64]Op
+	// Ratios are the payment ratios per payment type
+	Ratios map[int64][]Ratio
+	// Commitments are the financial commitments summed per year and operation
+	// or per year and budget action for the unlinked ones
+	Commitments     []Flow
+	Programmings    []Flow
+	PrevCommitments []Flow
+	// PrevPayments are the payments declared per operation and year
+	PrevPayments []Flow
+	// Offsets are the offsets in years of the operations of the scenario, nil
+	// if no scenario is used
+	Offsets map[int64]int64
+	// Levers are the modifications of the forecast commitments of the
+	// operations of the scenario
+	Levers map[int64]Lever
+}
+
+// Lever modifies the forecast commitments of an operation of a scenario.
+// Scale multiplies the amounts, Cancelled drops the operation and, if Capped
+// is set, the yearly commitments are limited to Cap, the excess being carried
+// over to the next year.
+type Lever struct {
+	Scale     float64
+	Cancelled bool
+	Capped    bool
+	Cap       float64
+}
+
+// Params defines a projection
+type Params struct {
+	FirstYear     int64
+	Horizon       int64
+	PaymentTypeID int64
+	Level         Level
+	// Declared replaces the statistical payments by the declared ones when an
+	// operation has a declared payment for the year
+	Declared bool
+}
+
+// Key identifies a line of the projection. OpID is 0 for the action level
+// and for the unlinked commitments.
+type Key struct {
+	OpID     int64
+	ActionID int64
+}
+
+// Line is the projection of a key over the horizon. Valid is false for the
+// years without any amount.
+type Line struct {
+	Key
+	Values []float64
+	Valid  []bool
+}
+
+// MaxHorizon is the maximum number of years of a projection
+const MaxHorizon = 20
+
+// Validate checks the parameters of the projection.
+func (p *Params) Validate() error {
+	if p.Horizon <= 0 || p.Horizon > MaxHorizon {
+		return errors.New("horizon incorrect")
+	}
+	return nil
+}
+
+// inHorizon returns the index of the year in the horizon or -1.
+func (p *Params) inHorizon(year int64) int {
+	if year < p.FirstYear || year >= p.FirstYear+p.Horizon {
+		return -1
+	}
+	return int(year - p.FirstYear)
+}
+
+// accumulator sums the amounts per key and year
+type accumulator struct {
+	horizon int64
+	lines   map[Key]*Line
+	order   []Key
+}
+
+func newAccumulator(horizon int64) *accumulator {
+	return &accumulator{horizon: horizon, lines: make(map[Key]*Line)}
+}
+
+// line returns the line of the key creating it if needed.
+func (a *accumulator) line(k Key) *Line {
+	l, ok := a.lines[k]
+	if !ok {
+		l = &Line{Key: k, Values: make([]float64, a.horizon),
+			Valid: make([]bool, a.horizon)}
+		a.lines[k] = l
+		a.order = append(a.order, k)
+	}
+	return l
+}
+
+func (a *accumulator) add(k Key, i int, v float64) {
+	l := a.line(k)
+	l.Values[i] += v
+	l.Valid[i] = true
+}
+
+func (a *accumulator) set(k Key, i int, v float64) {
+	l := a.line(k)
+	l.Values[i] = v
+	l.Valid[i] = true
+}
+
+// result returns the lines in the order of creation.
+func (a *accumulator) result() []Line {
+	lines := make([]Line, len(a.order))
+	for i, k := range a.order {
+		lines[i] = *a.lines[k]
+	}
+	return lines
+}
+
+// opKey returns the key of an operation at the operation level.
+func (d *Data) opKey(opID int64) Key {
+	return Key{OpID: opID, ActionID: d.Ops[opID].ActionID}
+}
+
+// Payments projects the payments over the horizon. The commitments older than
+// the year before the first year, the programmings of the year before the
+// first year and the forecast commitments are spread using the payment ratios.
+// The forecast commitments are shifted by the offsets and restricted to the
+// operations of the scenario if one is used, the levers of the scenario
+// applying to the forecast commitments and the declared payments, the
+// programmings of the year before the first year being already voted. The
+// amounts are in cents.
+func (d *Data) Payments(p Params) ([]Line, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	ratios := d.Ratios[p.PaymentTypeID]
+	ops := newAccumulator(p.Horizon)
+	unlinked := newAccumulator(p.Horizon)
+	spread := func(f Flow) {
+		for _, r := range ratios {
+			i := p.inHorizon(f.Year + r.Index)
+			if i < 0 {
+				continue
+			}
+			if f.OpID == 0 {
+				unlinked.add(Key{ActionID: f.ActionID}, i, f.Value*r.Ratio)
+			} else {
+				ops.add(d.opKey(f.OpID), i, f.Value*r.Ratio)
+			}
+		}
+	}
+	for _, f := range d.Commitments {
+		if f.Year < p.FirstYear-1 {
+			spread(f)
+		}
+	}
+	for _, f := range d.Programmings {
+		if f.Year == p.FirstYear-1 {
+			spread(f)
+		}
+	}
+	for _, f := range d.prevCommitments() {
+		spread(f)
+	}
+	if p.Declared {
+		for _, f := range d.PrevPayments {
+			if !d.applyLever(&f) {
+				continue
+			}
+			if i := p.inHorizon(f.Year); i >= 0 && f.Value != 0 {
+				ops.set(d.opKey(f.OpID), i, f.Value)
+			}
+		}
+	}
+	lines := append(ops.result(), unlinked.result()...)
+	if p.Level == LevelOp {
+		return lines, nil
+	}
+	actions := newAccumulator(p.Horizon)
+	for _, l := range lines {
+		k := Key{ActionID: l.ActionID}
+		for i := range l.Values {
+			if l.Valid[i] {
+				actions.add(k, i, l.Values[i])
+			}
+		}
+		actions.line(k)
+	}
+	return actions.result(), nil
+}
+
+// ScenarioCommitments projects the forecast commitments of the operations of
+// the scenario shifted by their offsets. Every operation of the scenario has
+// a line even without forecast commitment in the horizon.
+func (d *Data) ScenarioCommitments(firstYear, horizon int64) ([]Line, error) {
+	p := Params{FirstYear: firstYear, Horizon: horizon}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if d.Offsets == nil {
+		return nil, errors.New("scénario manquant")
+	}
+	ops := newAccumulator(horizon)
+	for _, f := range d.prevCommitments() {
+		if i := p.inHorizon(f.Year); i >= 0 {
+			ops.add(d.opKey(f.OpID), i, f.Value)
+		}
+	}
+	for opID := range d.Offsets {
+		if !d.Levers[opID].Cancelled {
+			ops.line(d.opKey(opID))
+		}
+	}
+	return ops.result(), nil
+}
+
+// LeveredCommitments returns the forecast commitments of the operations of
+// the scenario modified by their levers but not shifted by their offsets, the
+// cross tables of the scenarios showing the offsets apart.
+func (d *Data) LeveredCommitments() []Flow {
+	s := *d
+	s.Offsets = make(map[int64]int64, len(d.Offsets))
+	for opID := range d.Offsets {
+		s.Offsets[opID] = 0
+	}
+	return s.prevCommitments()
+}
+
+// applyLever scales the flow of an operation of the scenario and returns false
+// if the operation is cancelled.
+func (d *Data) applyLever(f *Flow) bool {
+	if d.Offsets == nil {
+		return true
+	}
+	l, ok := d.Levers[f.OpID]
+	if !ok {
+		return true
+	}
+	f.Value *= l.Scale
+	return !l.Cancelled
+}
+
+// prevCommitments returns all the forecast commitments if no scenario is used
+// or the forecast commitments of the operations of the scenario shifted by
+// their offsets and modified by their levers.
+func (d *Data) prevCommitments() []Flow {
+	if d.Offsets == nil {
+		return d.PrevCommitments
+	}
+	var flows []Flow
+	capped := make(map[int64][]Flow)
+	var cappedOps []int64
+	for _, f := range d.PrevCommitments {
+		offset, ok := d.Offsets[f.OpID]
+		if !ok {
+			continue
+		}
+		f.Year += offset
+		l, ok := d.Levers[f.OpID]
+		if !ok {
+			flows = append(flows, f)
+			continue
+		}
+		if l.Cancelled {
+			continue
+		}
+		f.Value *= l.Scale
+		if !l.Capped {
+			flows = append(flows, f)
+			continue
+		}
+		if capped[f.OpID] == nil {
+			cappedOps = append(cappedOps, f.OpID)
+		}
+		capped[f.OpID] = append(capped[f.OpID], f)
+	}
+	for _, opID := range cappedOps {
+		flows = append(flows, capFlows(capped[opID], d.Levers[opID].Cap)...)
+	}
+	return flows
+}
+
+// capFlows limits the yearly amounts of the flows of an operation to the cap,
+// the excess being carried over to the following years. A cap which isn't
+// positive drops the flows.
+func capFlows(flows []Flow, cap float64) []Flow {
+	if cap <= 0 {
+		return nil
+	}
+	sort.Slice(flows, func(i, j int) bool { return flows[i].Year < flows[j].Year })
+	years := make(map[int64]float64)
+	for _, f := range flows {
+		years[f.Year] += f.Value
+	}
+	last := flows[len(flows)-1].Year
+	var capped []Flow
+	var carry float64
+	for y := flows[0].Year; y <= last || carry > 0; y++ {
+		v := years[y] + carry
+		carry = 0
+		if v > cap {
+			v, carry = cap, v-cap
+		}
+		if v != 0 {
+			capped = append(capped, Flow{OpID: flows[0].OpID,
+				ActionID: flows[0].ActionID, Year: y, Value: v})
+		}
+	}
+	return capped
+}
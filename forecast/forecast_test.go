@@ -0,0 +1,207 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+)
+
+// testData returns two operations of the same budget action, one of them in
+// the scenario, and an unlinked commitment of another action.
+func testData() *Data {
+	return &Data{
+		Ops:    map[int64]Op{1: {ID: 1, ActionID: 10}, 2: {ID: 2, ActionID: 10}},
+		Ratios: map[int64][]Ratio{5: {{Index: 0, Ratio: 0.2}, {Index: 1, Ratio: 0.5}, {Index: 2, Ratio: 0.3}}},
+		Commitments: []Flow{
+			{OpID: 1, Year: 2016, Value: 1000},
+			{OpID: 1, Year: 2017, Value: 5000},
+			{ActionID: 20, Year: 2016, Value: 2000},
+		},
+		Programmings:    []Flow{{OpID: 2, Year: 2017, Value: 100}, {OpID: 2, Year: 2018, Value: 700}},
+		PrevCommitments: []Flow{{OpID: 1, Year: 2018, Value: 1000}, {OpID: 2, Year: 2019, Value: 500}},
+		PrevPayments:    []Flow{{OpID: 2, Year: 2019, Value: 42}},
+		Offsets:         map[int64]int64{1: 1},
+	}
+}
+
+func chkLine(t *testing.T, name string, l Line, want []float64, valid []bool) {
+	for i := range want {
+		if l.Valid[i] != valid[i] || math.Abs(l.Values[i]-want[i]) > 1e-9 {
+			t.Errorf("%s[%d] : attendu %v (%v), reçu %v (%v)", name, i, want[i],
+				valid[i], l.Values[i], l.Valid[i])
+		}
+	}
+}
+
+func TestPayments(t *testing.T) {
+	d := testData()
+	p := Params{FirstYear: 2018, Horizon: 3, PaymentTypeID: 5, Level: LevelOp}
+	lines, err := d.Payments(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[Key]Line)
+	for _, l := range lines {
+		got[l.Key] = l
+	}
+	if len(got) != 3 {
+		t.Fatalf("3 lignes attendues, reçu %d", len(got))
+	}
+	// Op 1 : commitment of 2016 paid in 2018 and forecast commitment of 2018
+	// shifted to 2019, the 2017 commitment being replaced by the programming
+	chkLine(t, "op 1", got[Key{OpID: 1, ActionID: 10}],
+		[]float64{300, 200, 500}, []bool{true, true, true})
+	// Op 2 : programming of 2017 only, out of scenario
+	chkLine(t, "op 2", got[Key{OpID: 2, ActionID: 10}],
+		[]float64{50, 30, 0}, []bool{true, true, false})
+	chkLine(t, "action 20", got[Key{ActionID: 20}],
+		[]float64{600, 0, 0}, []bool{true, false, false})
+
+	p.Declared = true
+	if lines, err = d.Payments(p); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lines {
+		if l.OpID == 2 {
+			chkLine(t, "op 2 déclaré", l, []float64{50, 42, 0},
+				[]bool{true, true, false})
+		}
+	}
+
+	p.Level, p.Declared, p.Horizon = LevelAction, false, 2
+	if lines, err = d.Payments(p); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0].ActionID != 10 || lines[1].ActionID != 20 {
+		t.Fatalf("actions 10 et 20 attendues, reçu %+v", lines)
+	}
+	chkLine(t, "action 10", lines[0], []float64{350, 230}, []bool{true, true})
+
+	d.Offsets = nil
+	p.Horizon = 3
+	if lines, err = d.Payments(p); err != nil {
+		t.Fatal(err)
+	}
+	// Without scenario all forecast commitments are used without offset
+	chkLine(t, "action 10 sans scénario", lines[0], []float64{550, 630, 550},
+		[]bool{true, true, true})
+
+	if _, err = d.Payments(Params{FirstYear: 2018}); err == nil {
+		t.Error("horizon nul : erreur attendue")
+	}
+	if _, err = d.Payments(Params{FirstYear: 2018, Horizon: MaxHorizon + 1}); err == nil {
+		t.Error("horizon trop grand : erreur attendue")
+	}
+}
+
+func TestScenarioCommitments(t *testing.T) {
+	d := testData()
+	d.Offsets[3] = 0
+	d.Ops[3] = Op{ID: 3}
+	lines, err := d.ScenarioCommitments(2018, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("2 lignes attendues, reçu %d", len(lines))
+	}
+	for _, l := range lines {
+		switch l.OpID {
+		case 1:
+			chkLine(t, "op 1", l, []float64{0, 1000, 0, 0, 0},
+				[]bool{false, true, false, false, false})
+		case 3:
+			chkLine(t, "op 3", l, make([]float64, 5), make([]bool, 5))
+		default:
+			t.Errorf("opération %d inattendue", l.OpID)
+		}
+	}
+	d.Offsets = nil
+	if _, err = d.ScenarioCommitments(2018, 5); err == nil {
+		t.Error("sans scénario : erreur attendue")
+	}
+}
+
+func TestLevers(t *testing.T) {
+	d := testData()
+	d.Offsets = map[int64]int64{1: 0, 2: 0, 3: 0}
+	d.Ops[3] = Op{ID: 3, ActionID: 10}
+	d.PrevCommitments = append(d.PrevCommitments, Flow{OpID: 3, Year: 2019,
+		Value: 300}, Flow{OpID: 3, Year: 2020, Value: 100})
+	d.Levers = map[int64]Lever{1: {Scale: 0.5}, 2: {Scale: 1, Cancelled: true},
+		3: {Scale: 1, Capped: true, Cap: 150}}
+	lines, err := d.ScenarioCommitments(2018, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("2 lignes attendues, reçu %+v", lines)
+	}
+	for _, l := range lines {
+		switch l.OpID {
+		case 1:
+			chkLine(t, "op 1", l, []float64{500, 0, 0, 0, 0},
+				[]bool{true, false, false, false, false})
+		case 3:
+			chkLine(t, "op 3", l, []float64{0, 150, 150, 100, 0},
+				[]bool{false, true, true, true, false})
+		default:
+			t.Errorf("opération %d inattendue", l.OpID)
+		}
+	}
+	p := Params{FirstYear: 2019, Horizon: 1, PaymentTypeID: 5, Level: LevelOp,
+		Declared: true}
+	if lines, err = d.Payments(p); err != nil {
+		t.Fatal(err)
+	}
+	// Op 2 : the voted programming of 2018 is still paid, the declared payment
+	// of the cancelled operation being ignored
+	var found bool
+	for _, l := range lines {
+		if l.OpID == 2 {
+			found = true
+			chkLine(t, "op 2 annulée", l, []float64{350}, []bool{true})
+		}
+	}
+	if !found {
+		t.Errorf("programmation votée de l'opération annulée manquante %+v", lines)
+	}
+	if flows := capFlows([]Flow{{OpID: 3, Year: 2019, Value: 10}}, 0); flows != nil {
+		t.Errorf("plafond nul : aucun engagement attendu, reçu %+v", flows)
+	}
+}
+
+func TestLeveredCommitments(t *testing.T) {
+	d := testData()
+	d.Offsets = map[int64]int64{1: 2, 3: 1}
+	d.PrevCommitments = append(d.PrevCommitments, Flow{OpID: 3, Year: 2019,
+		Value: 300})
+	d.Levers = map[int64]Lever{1: {Scale: 0.5}, 3: {Scale: 1, Capped: true, Cap: 200}}
+	got := make(map[[2]int64]float64)
+	for _, f := range d.LeveredCommitments() {
+		got[[2]int64{f.OpID, f.Year}] += f.Value
+	}
+	want := map[[2]int64]float64{{1, 2018}: 500, {3, 2019}: 200, {3, 2020}: 100}
+	if len(got) != len(want) {
+		t.Fatalf("attendu %v, reçu %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%v : attendu %v, reçu %v", k, v, got[k])
+		}
+	}
+	if d.Offsets[1] != 2 {
+		t.Errorf("décalages du scénario modifiés : %v", d.Offsets)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{"": LevelAction, "action": LevelAction,
+		"op": LevelOp} {
+		if l, err := ParseLevel(s); err != nil || l != want {
+			t.Errorf("ParseLevel(%q) : attendu %v, reçu %v %v", s, want, l, err)
+		}
+	}
+	if _, err := ParseLevel("program"); err == nil {
+		t.Error("ParseLevel(program) : erreur attendue")
+	}
+}
@@ -0,0 +1,239 @@
+package forecast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Band is a forecast amount with the bounds of its confidence interval
+type Band struct {
+	Low     float64
+	Central float64
+	High    float64
+}
+
+// BandLine is the forecast of a key over the horizon. Valid is false for the
+// years without any amount.
+type BandLine struct {
+	Key
+	Bands []Band
+	Valid []bool
+}
+
+// Forecaster forecasts the yearly payments with a confidence band
+type Forecaster interface {
+	Forecast(p Params) ([]BandLine, error)
+}
+
+// ZScore returns the quantile of the normal distribution for a two-sided
+// confidence level between 0 and 1.
+func ZScore(confidence float64) (float64, error) {
+	if confidence <= 0 || confidence >= 1 {
+		return 0, fmt.Errorf("niveau de confiance %v incorrect", confidence)
+	}
+	return math.Sqrt2 * math.Erfinv(confidence), nil
+}
+
+// exactBands converts projected lines to bands without uncertainty.
+func exactBands(lines []Line) []BandLine {
+	bands := make([]BandLine, len(lines))
+	for i, l := range lines {
+		b := BandLine{Key: l.Key, Bands: make([]Band, len(l.Values)), Valid: l.Valid}
+		for j, v := range l.Values {
+			b.Bands[j] = Band{Low: v, Central: v, High: v}
+		}
+		bands[i] = b
+	}
+	return bands
+}
+
+// DeclaredForecaster uses only the payments declared per operation. The
+// declared amounts have no statistical uncertainty so the bands are reduced
+// to the central value.
+type DeclaredForecaster struct {
+	Data *Data
+}
+
+// Forecast implements Forecaster.
+func (f *DeclaredForecaster) Forecast(p Params) ([]BandLine, error) {
+	d := Data{Ops: f.Data.Ops, PrevPayments: f.Data.PrevPayments}
+	p.Declared = true
+	lines, err := d.Payments(p)
+	if err != nil {
+		return nil, err
+	}
+	return exactBands(lines), nil
+}
+
+// cohortShares returns, per index, the shares of the commitments of each
+// year of the window paid idx years after the commitment year.
+func cohortShares(h *History, origin, window int64) map[int64][]float64 {
+	commitments, payments := totalsByYear(h)
+	shares := make(map[int64][]float64)
+	for idx := int64(0); idx < window; idx++ {
+		for y := origin - window; y+idx < origin; y++ {
+			if commitments[y] <= 0 {
+				continue
+			}
+			shares[idx] = append(shares[idx], payments[y][idx]/commitments[y])
+		}
+	}
+	return shares
+}
+
+// ratioDeviations computes for each ratio of the curve the root mean square
+// deviation of the shares of the cohorts around the ratio.
+func ratioDeviations(curve []Ratio, shares map[int64][]float64) []float64 {
+	dev := make([]float64, len(curve))
+	for i, r := range curve {
+		s := shares[r.Index]
+		if len(s) == 0 {
+			continue
+		}
+		var sum float64
+		for _, v := range s {
+			sum += (v - r.Ratio) * (v - r.Ratio)
+		}
+		dev[i] = math.Sqrt(sum / float64(len(s)))
+	}
+	return dev
+}
+
+// RatioForecaster spreads the commitments using a ratio curve, either the
+// curve of a payment type or, if FromHistory is set, the curve computed from
+// the payments on the commitments of the previous years (ratio by commitment
+// vintage). The band is given by the dispersion of the shares paid by the
+// commitments of each year around the curve.
+type RatioForecaster struct {
+	Data        *Data
+	History     *History
+	FromHistory bool
+	Window      int64
+	Z           float64
+}
+
+// Forecast implements Forecaster.
+func (f *RatioForecaster) Forecast(p Params) ([]BandLine, error) {
+	if f.Window <= 0 {
+		return nil, errors.New("fenêtre d'historique incorrecte")
+	}
+	known := f.History.Before(p.FirstYear)
+	curve := f.Data.Ratios[p.PaymentTypeID]
+	if f.FromHistory {
+		curve = EmpiricalRatios(known, p.FirstYear, f.Window)
+	}
+	if len(curve) == 0 {
+		return nil, errors.New("aucun ratio de paiement")
+	}
+	dev := ratioDeviations(curve, cohortShares(known, p.FirstYear, f.Window))
+	low, high := make([]Ratio, len(curve)), make([]Ratio, len(curve))
+	for i, r := range curve {
+		low[i] = Ratio{Index: r.Index, Ratio: math.Max(r.Ratio-f.Z*dev[i], 0)}
+		high[i] = Ratio{Index: r.Index, Ratio: r.Ratio + f.Z*dev[i]}
+	}
+	p.Declared = false
+	results := make([][]Line, 3)
+	for i, c := range [][]Ratio{low, curve, high} {
+		d := *f.Data
+		d.Ratios = map[int64][]Ratio{p.PaymentTypeID: c}
+		var err error
+		if results[i], err = d.Payments(p); err != nil {
+			return nil, err
+		}
+	}
+	bands := exactBands(results[1])
+	index := make(map[Key]int, len(bands))
+	for i, b := range bands {
+		index[b.Key] = i
+	}
+	for _, bound := range [][]Line{results[0], results[2]} {
+		for _, l := range bound {
+			i, ok := index[l.Key]
+			if !ok {
+				continue
+			}
+			for j, v := range l.Values {
+				b := &bands[i].Bands[j]
+				b.Low, b.High = math.Min(b.Low, v), math.Max(b.High, v)
+			}
+		}
+	}
+	return bands, nil
+}
+
+// MonthlySeries is a series of monthly amounts starting in January of
+// FirstYear
+type MonthlySeries struct {
+	FirstYear int64
+	Values    []float64
+}
+
+// SmoothingForecaster forecasts the total payments using an additive
+// Holt-Winters exponential smoothing of the monthly payments, the yearly
+// forecast being the sum of the monthly ones. The band is derived from the
+// standard deviation of the one step ahead errors.
+type SmoothingForecaster struct {
+	Series MonthlySeries
+	Alpha  float64
+	Beta   float64
+	Gamma  float64
+	Z      float64
+}
+
+// season is the count of months of a seasonal cycle
+const season = 12
+
+// Forecast implements Forecaster. The aggregation level is ignored and only
+// the total is forecast, with a key set to zero.
+func (f *SmoothingForecaster) Forecast(p Params) ([]BandLine, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	y := f.Series.Values
+	if len(y) < 2*season {
+		return nil, errors.New("historique mensuel insuffisant")
+	}
+	var m1, m2 float64
+	for i := 0; i < season; i++ {
+		m1 += y[i] / season
+		m2 += y[season+i] / season
+	}
+	level, trend := m1, (m2-m1)/season
+	seasonal := make([]float64, len(y)+season)
+	for i := 0; i < season; i++ {
+		seasonal[i] = y[i] - m1
+	}
+	var sse float64
+	var count int
+	for t := season; t < len(y); t++ {
+		fit := level + trend + seasonal[t-season]
+		sse += (y[t] - fit) * (y[t] - fit)
+		count++
+		prev := level
+		level = f.Alpha*(y[t]-seasonal[t-season]) + (1-f.Alpha)*(level+trend)
+		trend = f.Beta*(level-prev) + (1-f.Beta)*trend
+		seasonal[t] = f.Gamma*(y[t]-level) + (1-f.Gamma)*seasonal[t-season]
+	}
+	sigma := math.Sqrt(sse / float64(count))
+	n := int64(len(y))
+	line := BandLine{Bands: make([]Band, p.Horizon), Valid: make([]bool, p.Horizon)}
+	variances := make([]float64, p.Horizon)
+	for i := int64(0); i < p.Horizon; i++ {
+		for month := int64(0); month < season; month++ {
+			t := (p.FirstYear+i-f.Series.FirstYear)*season + month
+			h := t - n + 1
+			if h < 1 {
+				continue
+			}
+			v := level + float64(h)*trend + seasonal[n-season+(h-1)%season]
+			line.Bands[i].Central += v
+			line.Valid[i] = true
+			variances[i] += sigma * sigma * (1 + float64(h-1)*f.Alpha*f.Alpha)
+		}
+		margin := f.Z * math.Sqrt(variances[i])
+		line.Bands[i].Low = line.Bands[i].Central - margin
+		line.Bands[i].High = line.Bands[i].Central + margin
+	}
+	return []BandLine{line}, nil
+}
@@ -0,0 +1,117 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+)
+
+func TestZScore(t *testing.T) {
+	z, err := ZScore(0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(z-1.6449) > 1e-4 {
+		t.Errorf("z attendu 1.6449, reçu %v", z)
+	}
+	if _, err = ZScore(1); err == nil {
+		t.Error("erreur attendue pour un niveau de 1")
+	}
+}
+
+func TestDeclaredForecaster(t *testing.T) {
+	d := testData()
+	f := DeclaredForecaster{Data: d}
+	bands, err := f.Forecast(Params{FirstYear: 2019, Horizon: 3, PaymentTypeID: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bands) != 1 || bands[0].ActionID != 10 {
+		t.Fatalf("1 ligne de l'action 10 attendue, reçu %+v", bands)
+	}
+	want := []Band{{42, 42, 42}, {}, {}}
+	for i, b := range bands[0].Bands {
+		if b != want[i] || bands[0].Valid[i] != (i == 0) {
+			t.Errorf("année %d : attendu %+v, reçu %+v", i, want[i], b)
+		}
+	}
+}
+
+// testFlowData returns the commitments of testHistory attached to the action
+// 1 as datas for the projection.
+func testFlowData(h *History) *Data {
+	d := Data{Ops: map[int64]Op{}, Ratios: map[int64][]Ratio{1: {{0, 0.4},
+		{1, 0.4}, {2, 0.2}}}}
+	d.Commitments = append(d.Commitments, h.Commitments...)
+	return &d
+}
+
+func TestRatioForecaster(t *testing.T) {
+	h := testHistory()
+	for _, fromHistory := range []bool{false, true} {
+		f := RatioForecaster{Data: testFlowData(h), History: h, Window: 5,
+			FromHistory: fromHistory, Z: 1.64}
+		bands, err := f.Forecast(Params{FirstYear: 2019, Horizon: 2,
+			PaymentTypeID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bands) != 1 {
+			t.Fatalf("1 ligne attendue, reçu %+v", bands)
+		}
+		// Commitments before 2018 : 2017 pays 20 in 2019
+		b := bands[0].Bands[0]
+		if !almostEqual(b.Central, 20) || !almostEqual(b.Low, 20) ||
+			!almostEqual(b.High, 20) {
+			t.Errorf("historique %v : bande attendue de 20, reçu %+v", fromHistory, b)
+		}
+	}
+	h.Payments[2].Value, h.Payments[5].Value = 10, 30
+	f := RatioForecaster{Data: testFlowData(h), History: h, Window: 8, Z: 1.64}
+	bands, err := f.Forecast(Params{FirstYear: 2019, Horizon: 2, PaymentTypeID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bands[0].Bands[0]
+	if !(b.Low < b.Central && b.Central < b.High) {
+		t.Errorf("bande attendue non réduite, reçu %+v", b)
+	}
+	if _, err = (&RatioForecaster{Data: testFlowData(h), History: h}).Forecast(
+		Params{FirstYear: 2019, Horizon: 2, PaymentTypeID: 1}); err == nil {
+		t.Error("erreur attendue pour une fenêtre nulle")
+	}
+}
+
+func TestSmoothingForecaster(t *testing.T) {
+	var s MonthlySeries
+	s.FirstYear = 2015
+	for y := 0; y < 4; y++ {
+		for m := 0; m < 12; m++ {
+			s.Values = append(s.Values, float64(10+m))
+		}
+	}
+	f := SmoothingForecaster{Series: s, Alpha: 0.3, Beta: 0.1, Gamma: 0.2, Z: 1.64}
+	bands, err := f.Forecast(Params{FirstYear: 2019, Horizon: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bands) != 1 || bands[0].Key != (Key{}) {
+		t.Fatalf("1 ligne totale attendue, reçu %+v", bands)
+	}
+	for i, b := range bands[0].Bands {
+		if !bands[0].Valid[i] || !almostEqual(b.Central, 186) ||
+			!almostEqual(b.Low, 186) || !almostEqual(b.High, 186) {
+			t.Errorf("année %d : attendu 186, reçu %+v", i, b)
+		}
+	}
+	f.Series.Values[30] = 40
+	if bands, err = f.Forecast(Params{FirstYear: 2019, Horizon: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if b := bands[0].Bands[0]; !(b.Low < b.Central && b.Central < b.High) {
+		t.Errorf("bande attendue non réduite, reçu %+v", b)
+	}
+	f.Series.Values = f.Series.Values[:20]
+	if _, err = f.Forecast(Params{FirstYear: 2019, Horizon: 1}); err == nil {
+		t.Error("erreur attendue pour un historique insuffisant")
+	}
+}
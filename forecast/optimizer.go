@@ -0,0 +1,335 @@
+package forecast
+
+import (
+	"errors"
+	"sort"
+)
+
+// Ceiling limits the payments and the commitments of a group of budget
+// actions for a year, a limit being ignored if its flag is false
+type Ceiling struct {
+	Group          int64
+	Year           int64
+	Payments       float64
+	HasPayments    bool
+	Commitments    float64
+	HasCommitments bool
+}
+
+// OffsetRange bounds the shift in years of an operation, a negative Min
+// allowing the operation to be brought forward and a positive one forcing a
+// delay
+type OffsetRange struct {
+	Min int64
+	Max int64
+}
+
+// OptimizerParams defines the search of the offsets. Groups gives the group
+// of each budget action, Weights the priority of the operations, 1 if not
+// set, the cost of a shift being the weight times the count of years. The
+// operations of Locked keep their offset, those of Ranges are shifted within
+// their range and the others can be delayed up to MaxOffset years.
+type OptimizerParams struct {
+	Params
+	Groups    map[int64]int64
+	Ceilings  []Ceiling
+	Weights   map[int64]float64
+	Locked    map[int64]bool
+	Ranges    map[int64]OffsetRange
+	MaxOffset int64
+}
+
+// Relief is the reduction of the excess over a ceiling brought by a shift,
+// Payments being false for a commitment ceiling
+type Relief struct {
+	Group    int64
+	Year     int64
+	Payments bool
+	Value    float64
+}
+
+// Shift is the delay given to an operation with the reliefs that justified
+// each year of delay
+type Shift struct {
+	OpID    int64
+	Years   int64
+	Reliefs []Relief
+}
+
+// CeilingResult compares a ceiling with the amounts of the optimized offsets
+type CeilingResult struct {
+	Ceiling
+	PaymentsValue    float64
+	CommitmentsValue float64
+}
+
+// OptimizerResult gives the offsets found, the shifts with their
+// explanation, the remaining excess over the ceilings and the cost of the
+// shifts
+type OptimizerResult struct {
+	Offsets  map[int64]int64
+	Shifts   []Shift
+	Ceilings []CeilingResult
+	Excess   float64
+	Cost     float64
+}
+
+// Validate checks the parameters of the optimizer.
+func (p *OptimizerParams) Validate() error {
+	if err := p.Params.Validate(); err != nil {
+		return err
+	}
+	if p.MaxOffset < 0 {
+		return errors.New("décalage maximal incorrect")
+	}
+	for _, r := range p.Ranges {
+		if r.Min > r.Max {
+			return errors.New("plage de décalage incorrecte")
+		}
+	}
+	if len(p.Ceilings) == 0 {
+		return errors.New("aucun plafond")
+	}
+	for _, w := range p.Weights {
+		if w <= 0 {
+			return errors.New("poids incorrect")
+		}
+	}
+	return nil
+}
+
+// amounts are the payments and the commitments per ceiling
+type amounts struct {
+	payments    []float64
+	commitments []float64
+}
+
+func newAmounts(n int) amounts {
+	return amounts{payments: make([]float64, n), commitments: make([]float64, n)}
+}
+
+// optimizer holds the contributions of the operations to the amounts of the
+// ceilings for each possible shift of their range
+type optimizer struct {
+	p        *OptimizerParams
+	index    map[[2]int64]int
+	ranges   map[int64]OffsetRange
+	contribs map[int64][]amounts
+}
+
+// offsetRange returns the allowed shifts of an operation.
+func (o *optimizer) offsetRange(opID int64) OffsetRange {
+	if o.p.Locked[opID] {
+		return OffsetRange{}
+	}
+	if r, ok := o.p.Ranges[opID]; ok {
+		return r
+	}
+	return OffsetRange{Max: o.p.MaxOffset}
+}
+
+// contrib returns the contribution of an operation shifted by shift years.
+func (o *optimizer) contrib(opID, shift int64) amounts {
+	return o.contribs[opID][shift-o.ranges[opID].Min]
+}
+
+// weight returns the priority of an operation.
+func (o *optimizer) weight(opID int64) float64 {
+	if w, ok := o.p.Weights[opID]; ok {
+		return w
+	}
+	return 1
+}
+
+// collect adds the projected lines per budget action to the amounts of the
+// ceilings.
+func (o *optimizer) collect(lines []Line, a []float64) {
+	for _, l := range lines {
+		g, ok := o.p.Groups[l.ActionID]
+		if !ok {
+			continue
+		}
+		for y, v := range l.Values {
+			if i, ok := o.index[[2]int64{g, o.p.FirstYear + int64(y)}]; ok {
+				a[i] += v
+			}
+		}
+	}
+}
+
+// contribution computes the amounts of the forecast commitments of an
+// operation delayed by shift years.
+func (o *optimizer) contribution(d *Data, opID int64, flows []Flow, shift int64) (amounts, error) {
+	a := newAmounts(len(o.p.Ceilings))
+	s := Data{Ops: d.Ops, Ratios: d.Ratios, Levers: d.Levers, PrevCommitments: flows,
+		Offsets: map[int64]int64{opID: d.Offsets[opID] + shift}}
+	pp := o.p.Params
+	pp.Declared, pp.Level = false, LevelAction
+	lines, err := s.Payments(pp)
+	if err != nil {
+		return a, err
+	}
+	o.collect(lines, a.payments)
+	if lines, err = s.ScenarioCommitments(o.p.FirstYear, o.p.Horizon); err != nil {
+		return a, err
+	}
+	o.collect(lines, a.commitments)
+	return a, nil
+}
+
+// excess returns the sum of the amounts over the ceilings.
+func (o *optimizer) excess(a amounts) float64 {
+	var e float64
+	for i, c := range o.p.Ceilings {
+		if c.HasPayments && a.payments[i] > c.Payments {
+			e += a.payments[i] - c.Payments
+		}
+		if c.HasCommitments && a.commitments[i] > c.Commitments {
+			e += a.commitments[i] - c.Commitments
+		}
+	}
+	return e
+}
+
+// Optimize searches the shifts of the operations of the scenario, or of all
+// the operations with forecast commitments if no scenario is used, that
+// bring the payments and the commitments under the ceilings with the lowest
+// cost. Each operation starts at the shift of its range closest to zero and
+// the search is greedy: each step moves by one year within its range the
+// operation whose move brings the greatest reduction of the excess per unit
+// of cost and the search stops when no move reduces the excess. The payments
+// are statistical, the declared ones being ignored.
+func Optimize(d *Data, p OptimizerParams) (*OptimizerResult, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	o := optimizer{p: &p, index: make(map[[2]int64]int),
+		ranges: make(map[int64]OffsetRange), contribs: make(map[int64][]amounts)}
+	for i, c := range p.Ceilings {
+		o.index[[2]int64{c.Group, c.Year}] = i
+	}
+	s := *d
+	if s.Offsets == nil {
+		s.Offsets = make(map[int64]int64)
+		for _, f := range d.PrevCommitments {
+			s.Offsets[f.OpID] = 0
+		}
+	}
+	var ops []int64
+	for opID := range s.Offsets {
+		if !s.Levers[opID].Cancelled {
+			ops = append(ops, opID)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+	base := s
+	base.PrevCommitments = nil
+	pp := p.Params
+	pp.Declared, pp.Level = false, LevelAction
+	lines, err := base.Payments(pp)
+	if err != nil {
+		return nil, err
+	}
+	total := newAmounts(len(p.Ceilings))
+	o.collect(lines, total.payments)
+	flows := make(map[int64][]Flow)
+	for _, f := range d.PrevCommitments {
+		flows[f.OpID] = append(flows[f.OpID], f)
+	}
+	// shifts holds the current shift of every operation and the reliefs of
+	// its moves
+	shifts := make(map[int64]*Shift)
+	for _, opID := range ops {
+		r := o.offsetRange(opID)
+		o.ranges[opID] = r
+		for shift := r.Min; shift <= r.Max; shift++ {
+			a, err := o.contribution(&s, opID, flows[opID], shift)
+			if err != nil {
+				return nil, err
+			}
+			o.contribs[opID] = append(o.contribs[opID], a)
+		}
+		sh := &Shift{OpID: opID}
+		if r.Min > 0 {
+			sh.Years = r.Min
+		} else if r.Max < 0 {
+			sh.Years = r.Max
+		}
+		shifts[opID] = sh
+		start := o.contrib(opID, sh.Years)
+		for i := range total.payments {
+			total.payments[i] += start.payments[i]
+			total.commitments[i] += start.commitments[i]
+		}
+	}
+	current := o.excess(total)
+	for current > 0 {
+		var best, bestMove int64
+		var bestScore float64
+		var bestTotal amounts
+		for _, opID := range ops {
+			k, r := shifts[opID].Years, o.ranges[opID]
+			for _, move := range []int64{-1, 1} {
+				if k+move < r.Min || k+move > r.Max {
+					continue
+				}
+				from, to := o.contrib(opID, k), o.contrib(opID, k+move)
+				t := newAmounts(len(p.Ceilings))
+				for i := range t.payments {
+					t.payments[i] = total.payments[i] - from.payments[i] + to.payments[i]
+					t.commitments[i] = total.commitments[i] - from.commitments[i] +
+						to.commitments[i]
+				}
+				if score := (current - o.excess(t)) / o.weight(opID); score > bestScore {
+					best, bestMove, bestScore, bestTotal = opID, move, score, t
+				}
+			}
+		}
+		if bestScore <= 0 {
+			break
+		}
+		sh := shifts[best]
+		sh.Years += bestMove
+		for i, c := range p.Ceilings {
+			if c.HasPayments && total.payments[i] > c.Payments &&
+				bestTotal.payments[i] < total.payments[i] {
+				sh.Reliefs = append(sh.Reliefs, Relief{Group: c.Group, Year: c.Year,
+					Payments: true, Value: total.payments[i] -
+						maxFloat(bestTotal.payments[i], c.Payments)})
+			}
+			if c.HasCommitments && total.commitments[i] > c.Commitments &&
+				bestTotal.commitments[i] < total.commitments[i] {
+				sh.Reliefs = append(sh.Reliefs, Relief{Group: c.Group, Year: c.Year,
+					Value: total.commitments[i] - maxFloat(bestTotal.commitments[i],
+						c.Commitments)})
+			}
+		}
+		total, current = bestTotal, o.excess(bestTotal)
+	}
+	res := OptimizerResult{Offsets: make(map[int64]int64)}
+	for _, opID := range ops {
+		sh := shifts[opID]
+		res.Offsets[opID] = s.Offsets[opID] + sh.Years
+		if sh.Years != 0 {
+			res.Shifts = append(res.Shifts, *sh)
+			years := sh.Years
+			if years < 0 {
+				years = -years
+			}
+			res.Cost += o.weight(opID) * float64(years)
+		}
+	}
+	res.Excess = current
+	for i, c := range p.Ceilings {
+		res.Ceilings = append(res.Ceilings, CeilingResult{Ceiling: c,
+			PaymentsValue: total.payments[i], CommitmentsValue: total.commitments[i]})
+	}
+	return &res, nil
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,133 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+)
+
+// optimizerData returns two operations of the same budget action with
+// forecast commitments in 2019 fully paid the year of the commitment.
+func optimizerData() *Data {
+	return &Data{
+		Ops:             map[int64]Op{1: {ID: 1, ActionID: 10}, 2: {ID: 2, ActionID: 10}},
+		Ratios:          map[int64][]Ratio{5: {{Index: 0, Ratio: 1}}},
+		PrevCommitments: []Flow{{OpID: 1, Year: 2019, Value: 1000}, {OpID: 2, Year: 2019, Value: 500}},
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	d := optimizerData()
+	p := OptimizerParams{
+		Params:    Params{FirstYear: 2019, Horizon: 2, PaymentTypeID: 5},
+		Groups:    map[int64]int64{10: 908},
+		Ceilings:  []Ceiling{{Group: 908, Year: 2019, Payments: 1100, HasPayments: true}},
+		Weights:   map[int64]float64{1: 3},
+		MaxOffset: 2,
+	}
+	cases := []struct {
+		name    string
+		locked  map[int64]bool
+		offsets map[int64]int64
+		cost    float64
+	}{
+		{"priorité", nil, map[int64]int64{1: 0, 2: 1}, 1},
+		{"verrouillage", map[int64]bool{2: true}, map[int64]int64{1: 1, 2: 0}, 3},
+	}
+	for _, c := range cases {
+		p.Locked = c.locked
+		r, err := Optimize(d, p)
+		if err != nil {
+			t.Fatalf("%s : %v", c.name, err)
+		}
+		for opID, o := range c.offsets {
+			if r.Offsets[opID] != o {
+				t.Errorf("%s op %d : décalage %d attendu, reçu %d", c.name, opID, o,
+					r.Offsets[opID])
+			}
+		}
+		if r.Excess != 0 || math.Abs(r.Cost-c.cost) > 1e-9 || len(r.Shifts) != 1 {
+			t.Errorf("%s : excès 0 et coût %v attendus, reçu %v et %v", c.name, c.cost,
+				r.Excess, r.Cost)
+		}
+		if len(r.Shifts) == 1 && (len(r.Shifts[0].Reliefs) != 1 ||
+			math.Abs(r.Shifts[0].Reliefs[0].Value-400) > 1e-9) {
+			t.Errorf("%s : réduction de 400 attendue, reçu %+v", c.name, r.Shifts[0].Reliefs)
+		}
+	}
+
+	// Commitment ceiling that can't be respected without delay
+	p.Locked = nil
+	p.Ceilings = []Ceiling{{Group: 908, Year: 2019, Commitments: 100, HasCommitments: true}}
+	p.MaxOffset = 0
+	r, err := Optimize(d, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Excess != 1400 || len(r.Shifts) != 0 || r.Ceilings[0].CommitmentsValue != 1500 {
+		t.Errorf("excès de 1400 sans décalage attendu, reçu %+v", r)
+	}
+	p.MaxOffset = 1
+	if r, err = Optimize(d, p); err != nil {
+		t.Fatal(err)
+	}
+	if r.Excess != 0 || r.Offsets[1] != 1 || r.Offsets[2] != 1 || r.Cost != 4 {
+		t.Errorf("deux décalages attendus, reçu %+v", r)
+	}
+
+	p.Ceilings = nil
+	if _, err = Optimize(d, p); err == nil {
+		t.Error("erreur attendue sans plafond")
+	}
+}
+
+func TestOptimizeRanges(t *testing.T) {
+	d := optimizerData()
+	p := OptimizerParams{
+		Params:    Params{FirstYear: 2019, Horizon: 2, PaymentTypeID: 5},
+		Groups:    map[int64]int64{10: 908},
+		Weights:   map[int64]float64{1: 3},
+		MaxOffset: 2,
+	}
+	cases := []struct {
+		name    string
+		ceiling float64
+		ranges  map[int64]OffsetRange
+		offsets map[int64]int64
+		cost    float64
+		reliefs int
+	}{
+		{"plage nulle", 1100, map[int64]OffsetRange{2: {}},
+			map[int64]int64{1: 1, 2: 0}, 3, 1},
+		{"décalage imposé", 1100, map[int64]OffsetRange{1: {Min: 1, Max: 2}},
+			map[int64]int64{1: 1, 2: 0}, 3, 0},
+		{"avance", 600, map[int64]OffsetRange{1: {Min: -1}, 2: {}},
+			map[int64]int64{1: -1, 2: 0}, 3, 1},
+	}
+	for _, c := range cases {
+		p.Ceilings = []Ceiling{{Group: 908, Year: 2019, Payments: c.ceiling,
+			HasPayments: true}}
+		p.Ranges = c.ranges
+		r, err := Optimize(d, p)
+		if err != nil {
+			t.Fatalf("%s : %v", c.name, err)
+		}
+		for opID, o := range c.offsets {
+			if r.Offsets[opID] != o {
+				t.Errorf("%s op %d : décalage %d attendu, reçu %d", c.name, opID, o,
+					r.Offsets[opID])
+			}
+		}
+		if r.Excess != 0 || math.Abs(r.Cost-c.cost) > 1e-9 || len(r.Shifts) != 1 {
+			t.Errorf("%s : excès 0 et coût %v attendus, reçu %+v", c.name, c.cost, r)
+			continue
+		}
+		if len(r.Shifts[0].Reliefs) != c.reliefs {
+			t.Errorf("%s : %d réductions attendues, reçu %+v", c.name, c.reliefs,
+				r.Shifts[0].Reliefs)
+		}
+	}
+	p.Ranges = map[int64]OffsetRange{1: {Min: 1, Max: 0}}
+	if _, err := Optimize(d, p); err == nil {
+		t.Error("erreur attendue sur une plage inversée")
+	}
+}
@@ -0,0 +1,204 @@
+package forecast
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+// Simulation draws randomly the slippage of the forecast commitments of the
+// operations and the payment curve to get the distribution of the payments.
+// Slippages are the historical differences in years between the forecast and
+// the actual commitments and Curves the payment curves of the past commitment
+// years.
+type Simulation struct {
+	Data      *Data
+	Slippages []int64
+	Curves    [][]Ratio
+}
+
+// SimulationParams defines the projection, the count of iterations, the seed
+// of the random generator and the percentiles, between 0 and 1, to compute
+type SimulationParams struct {
+	Params
+	Iterations  int
+	Seed        int64
+	Percentiles []float64
+}
+
+// PercentileLine gives for a key the payments of each year of the horizon for
+// each percentile, Values[i][j] being the percentile i of the year j
+type PercentileLine struct {
+	Key
+	Values [][]float64
+}
+
+// Validate checks the parameters of the simulation.
+func (p *SimulationParams) Validate() error {
+	if err := p.Params.Validate(); err != nil {
+		return err
+	}
+	if p.Iterations <= 0 {
+		return errors.New("nombre d'itérations incorrect")
+	}
+	for _, q := range p.Percentiles {
+		if q < 0 || q > 1 {
+			return errors.New("centile incorrect")
+		}
+	}
+	return nil
+}
+
+// CohortCurves returns the payment curves of the commitment years of the
+// window before origin, the shares of the years not yet known being the
+// empirical ratios. The history must only contain the datas known at origin.
+func CohortCurves(h *History, origin, window int64) [][]Ratio {
+	commitments, payments := totalsByYear(h)
+	empirical := EmpiricalRatios(h, origin, window)
+	var curves [][]Ratio
+	for y := origin - window; y < origin; y++ {
+		if commitments[y] <= 0 {
+			continue
+		}
+		var c []Ratio
+		for _, r := range empirical {
+			if y+r.Index < origin {
+				r.Ratio = payments[y][r.Index] / commitments[y]
+			}
+			c = append(c, r)
+		}
+		curves = append(curves, c)
+	}
+	return curves
+}
+
+// HistoricalSlippage is the difference in years between the year of a past
+// forecast commitment and the year of the nearest actual commitment of the
+// operation. It returns false for a forecast commitment not yet done whose
+// slippage isn't known.
+func HistoricalSlippage(forecastYear int64, commitmentYears []int64) (int64, bool) {
+	if len(commitmentYears) == 0 {
+		return 0, false
+	}
+	best := commitmentYears[0] - forecastYear
+	for _, y := range commitmentYears[1:] {
+		if d := y - forecastYear; abs(d) < abs(best) {
+			best = d
+		}
+	}
+	return best, true
+}
+
+// ratiosSum returns the share of the commitments paid by the ratios.
+func ratiosSum(ratios []Ratio) float64 {
+	var sum float64
+	for _, r := range ratios {
+		sum += r.Ratio
+	}
+	return sum
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Run simulates the payments and returns per key and for the total the
+// percentiles of the payments of each year, the percentiles of the total not
+// being the sums of the percentiles of the keys. Each iteration draws a
+// slippage per operation added to the offset of the scenario if any and a
+// payment curve common to all the commitments as the payment delays depend on
+// the year. The ratios of the payment type are used if no curve has any
+// payment and an error is returned if there are no ratios either.
+func (s *Simulation) Run(p SimulationParams) ([]PercentileLine, *PercentileLine, error) {
+	if err := p.Validate(); err != nil {
+		return nil, nil, err
+	}
+	base := s.Data.Offsets
+	if base == nil {
+		base = make(map[int64]int64)
+		for _, f := range s.Data.PrevCommitments {
+			base[f.OpID] = 0
+		}
+	}
+	ops := make([]int64, 0, len(base))
+	for opID := range base {
+		ops = append(ops, opID)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+	var curves [][]Ratio
+	for _, c := range s.Curves {
+		if ratiosSum(c) > 0 {
+			curves = append(curves, c)
+		}
+	}
+	if len(curves) == 0 {
+		ratios := s.Data.Ratios[p.PaymentTypeID]
+		if ratiosSum(ratios) == 0 {
+			return nil, nil, errors.New("aucun historique de paiement ni ratio de paiement")
+		}
+		curves = [][]Ratio{ratios}
+	}
+	rnd := rand.New(rand.NewSource(p.Seed))
+	newSamples := func() [][]float64 {
+		v := make([][]float64, p.Horizon)
+		for j := range v {
+			v[j] = make([]float64, p.Iterations)
+		}
+		return v
+	}
+	samples := make(map[Key][][]float64)
+	total := newSamples()
+	var order []Key
+	pp := p.Params
+	pp.Declared = false
+	for it := 0; it < p.Iterations; it++ {
+		d := *s.Data
+		d.Offsets = make(map[int64]int64, len(ops))
+		for _, opID := range ops {
+			var slip int64
+			if len(s.Slippages) > 0 {
+				slip = s.Slippages[rnd.Intn(len(s.Slippages))]
+			}
+			d.Offsets[opID] = base[opID] + slip
+		}
+		d.Ratios = map[int64][]Ratio{p.PaymentTypeID: curves[rnd.Intn(len(curves))]}
+		lines, err := d.Payments(pp)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, l := range lines {
+			v, ok := samples[l.Key]
+			if !ok {
+				v = newSamples()
+				samples[l.Key] = v
+				order = append(order, l.Key)
+			}
+			for j, x := range l.Values {
+				v[j][it] = x
+				total[j][it] += x
+			}
+		}
+	}
+	percentiles := func(k Key, samples [][]float64) PercentileLine {
+		l := PercentileLine{Key: k, Values: make([][]float64, len(p.Percentiles))}
+		for j := range l.Values {
+			l.Values[j] = make([]float64, p.Horizon)
+		}
+		for y, v := range samples {
+			sort.Float64s(v)
+			for j, q := range p.Percentiles {
+				l.Values[j][y] = quantile(v, q)
+			}
+		}
+		return l
+	}
+	result := make([]PercentileLine, len(order))
+	for i, k := range order {
+		result[i] = percentiles(k, samples[k])
+	}
+	t := percentiles(Key{}, total)
+	return result, &t, nil
+}
@@ -0,0 +1,90 @@
+package forecast
+
+import "testing"
+
+func TestHistoricalSlippage(t *testing.T) {
+	for _, c := range []struct {
+		years []int64
+		want  int64
+		ok    bool
+	}{{nil, 0, false}, {[]int64{2014, 2017}, 1, true}, {[]int64{2015}, -1, true}} {
+		if s, ok := HistoricalSlippage(2016, c.years); s != c.want || ok != c.ok {
+			t.Errorf("%v : glissement attendu %d (%v), reçu %d (%v)", c.years, c.want,
+				c.ok, s, ok)
+		}
+	}
+}
+
+func TestCohortCurves(t *testing.T) {
+	curves := CohortCurves(testHistory().Before(2016), 2016, 3)
+	if len(curves) != 3 {
+		t.Fatalf("3 courbes attendues, reçu %+v", curves)
+	}
+	for _, c := range curves {
+		for i, want := range []float64{0.4, 0.4, 0.2} {
+			if !almostEqual(c[i].Ratio, want) {
+				t.Errorf("ratio %d : attendu %v, reçu %v", i, want, c[i].Ratio)
+			}
+		}
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	d := testData()
+	d.Offsets = nil
+	s := Simulation{Data: d}
+	p := SimulationParams{Params: Params{FirstYear: 2018, Horizon: 3,
+		PaymentTypeID: 5}, Iterations: 20, Percentiles: []float64{0.1, 0.5, 0.9}}
+	lines, total, err := s.Run(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	det, _ := d.Payments(p.Params)
+	if len(lines) != len(det) {
+		t.Fatalf("%d lignes attendues, reçu %d", len(det), len(lines))
+	}
+	for i, l := range lines {
+		for _, v := range l.Values {
+			chkLine(t, "sans aléa", Line{Values: v, Valid: det[i].Valid},
+				det[i].Values, det[i].Valid)
+		}
+	}
+	s.Slippages = []int64{0, 1, 2}
+	p.Iterations = 200
+	if lines, total, err = s.Run(p); err != nil {
+		t.Fatal(err)
+	}
+	for y := range total.Values[0] {
+		if total.Values[0][y] > total.Values[1][y] || total.Values[1][y] > total.Values[2][y] {
+			t.Errorf("année %d : centiles non ordonnés %v %v %v", y, total.Values[0][y],
+				total.Values[1][y], total.Values[2][y])
+		}
+	}
+	if total.Values[0][1] == total.Values[2][1] {
+		t.Errorf("dispersion attendue en 2019, reçu %v", total.Values[0][1])
+	}
+	again, _, _ := s.Run(p)
+	if again[0].Values[1][1] != lines[0].Values[1][1] {
+		t.Error("simulation non reproductible avec la même graine")
+	}
+	p.Iterations = 0
+	if _, _, err = s.Run(p); err == nil {
+		t.Error("erreur attendue pour un nombre d'itérations nul")
+	}
+	p.Iterations, p.PaymentTypeID = 20, 6
+	s.Curves = [][]Ratio{{{Index: 0, Ratio: 0}}}
+	if _, _, err = s.Run(p); err == nil {
+		t.Error("erreur attendue sans historique de paiement ni ratio")
+	}
+	s.Curves = [][]Ratio{{{Index: 0, Ratio: 1}}}
+	if lines, total, err = s.Run(p); err != nil {
+		t.Fatal(err)
+	}
+	var paid float64
+	for _, v := range total.Values[1] {
+		paid += v
+	}
+	if paid == 0 {
+		t.Error("paiements attendus avec une courbe historique")
+	}
+}
@@ -39,10 +39,44 @@ func main() {
 	app.Logger().Infof("Base de données connectée et initialisée")
 	defer db.Close()
 
+	actions.SetBatchLimits(cfg.App.MaxBatchBodySize, cfg.App.MaxBatchRows)
+	actions.SetAutoLinkThreshold(cfg.App.AutoLinkThreshold)
+	actions.SetLapseDemandDays(cfg.App.LapseDemandDays)
+	actions.SetInstanceID(cfg.App.InstanceID)
+	if cfg.App.SMTPHost != "" {
+		port := cfg.App.SMTPPort
+		if port == 0 {
+			port = 25
+		}
+		actions.SetMailer(&actions.SMTPMailer{Host: cfg.App.SMTPHost, Port: port,
+			UserName: cfg.App.SMTPUserName, Password: cfg.App.SMTPPassword,
+			From: cfg.App.MailFrom})
+		app.Logger().Infof("Envoi des courriels via %s configuré", cfg.App.SMTPHost)
+	}
 	actions.SetRoutes(app, db)
 	app.StaticWeb("/", "./dist")
 	app.Logger().Infof("Routes et serveur statique configurés")
 
+	if cfg.App.DropDir != "" {
+		scheduler, err := actions.NewDropScheduler(app, db, cfg.App.DropDir,
+			cfg.App.DropPatterns)
+		if err != nil {
+			log.Fatal("Répertoire d'import : " + err.Error())
+		}
+		interval := time.Duration(cfg.App.DropPollSeconds) * time.Second
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		scheduler.Start(interval)
+		app.Logger().Infof("Import automatique depuis %s configuré", cfg.App.DropDir)
+	}
+
+	if cfg.App.LapseAlertHours > 0 {
+		actions.StartLapseAlerts(db,
+			time.Duration(cfg.App.LapseAlertHours)*time.Hour)
+		app.Logger().Infof("Alertes de caducité configurées")
+	}
+
 	if cfg.App.TokenFileName != "" {
 		actions.TokenRecover(cfg.App.TokenFileName)
 		iris.RegisterOnInterrupt(func() {
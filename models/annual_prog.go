@@ -25,12 +25,16 @@ type AnnualProgrammation struct {
 	AnnualProgrammation []AnnualProgLine `json:"AnnualProgrammation"`
 }
 
-// GetAll fetches annual programmation of the given year from database.
-func (a *AnnualProgrammation) GetAll(year int, db *sql.DB) (err error) {
+// GetAll fetches annual programmation of the given year from database, the
+// programmings coming from the prevision snapshot if sID isn't 0.
+func (a *AnnualProgrammation) GetAll(year int, sID int64, db *sql.DB) (err error) {
+	if err = checkPrevisionSnapshot(sID, db); err != nil {
+		return err
+	}
 	qry := `WITH dates AS (
 		SELECT DISTINCT date FROM financial_commitment WHERE DATE_PART('YEAR', date)=$1
 		UNION
-		SELECT DISTINCT c.date FROM programmings p, commissions c
+		SELECT DISTINCT c.date FROM ` + snapshotSource("programmings", "p", sID) + `, commissions c
 			WHERE p.commission_id = c.id AND  p.year = $1
 		UNION
 		SELECT DISTINCT commission_date AS date FROM pending_commitments
@@ -59,7 +63,7 @@ func (a *AnnualProgrammation) GetAll(year int, db *sql.DB) (err error) {
 			LEFT JOIN
 				(SELECT p.physical_op_id,SUM(p.value) AS value, 
 					SUM(p.total_value) AS total_value,p.state_ratio,c.date 
-				FROM programmings p,commissions c
+				FROM ` + snapshotSource("programmings", "p", sID) + `,commissions c
 				WHERE p.commission_id = c.id GROUP BY 1,4,5) pr
 			ON pr.date=op.date AND pr.physical_op_id=op.id
 			LEFT JOIN 
@@ -0,0 +1,182 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// BatchStream decodes one by one the lines of a batch import payload, either
+// from a JSON object embedding the array of lines or from NDJSON, so that
+// lines can be sent to the database as they arrive.
+type BatchStream struct {
+	dec     *json.Decoder
+	key     string
+	ndjson  bool
+	started bool
+	ended   bool
+	MaxRows int
+	Count   int
+	Extras  map[string]json.RawMessage
+	hash    *LinesHash
+}
+
+// BatchDecodeError is the error of a batch stream whose payload is malformed
+// or exceeds the maximum number of rows, to tell it apart from the errors of
+// the database.
+type BatchDecodeError struct {
+	Err error
+}
+
+func (e *BatchDecodeError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the error wrapped by the decode error.
+func (e *BatchDecodeError) Unwrap() error { return e.Err }
+
+// decodeError returns a BatchDecodeError formatted like fmt.Errorf.
+func decodeError(format string, a ...interface{}) error {
+	return &BatchDecodeError{Err: fmt.Errorf(format, a...)}
+}
+
+// NewBatchStream returns a stream decoding r. key is the name of the array
+// of lines in the JSON object and is ignored for NDJSON. A MaxRows equal to
+// zero means no limit.
+func NewBatchStream(r io.Reader, key string, ndjson bool, maxRows int) *BatchStream {
+	return &BatchStream{dec: json.NewDecoder(r), key: key, ndjson: ndjson,
+		MaxRows: maxRows, Extras: make(map[string]json.RawMessage),
+		hash: NewLinesHash()}
+}
+
+// start skips the JSON payload until the beginning of the lines array.
+func (b *BatchStream) start() error {
+	if b.ndjson {
+		return nil
+	}
+	t, err := b.dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("objet JSON attendu")
+	}
+	for b.dec.More() {
+		if t, err = b.dec.Token(); err != nil {
+			return err
+		}
+		k, _ := t.(string)
+		if k == b.key {
+			if t, err = b.dec.Token(); err != nil {
+				return err
+			}
+			if d, ok := t.(json.Delim); !ok || d != '[' {
+				return fmt.Errorf("tableau %s attendu", b.key)
+			}
+			return nil
+		}
+		if err = b.decodeExtra(k); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("tableau %s absent", b.key)
+}
+
+// decodeExtra stores the value of a key of the JSON object that isn't the
+// lines array.
+func (b *BatchStream) decodeExtra(key string) error {
+	var extra json.RawMessage
+	if err := b.dec.Decode(&extra); err != nil {
+		return err
+	}
+	b.Extras[key] = extra
+	return nil
+}
+
+// end reads the keys of the JSON object following the lines array.
+func (b *BatchStream) end() error {
+	b.ended = true
+	if _, err := b.dec.Token(); err != nil {
+		return err
+	}
+	for b.dec.More() {
+		t, err := b.dec.Token()
+		if err != nil {
+			return err
+		}
+		k, _ := t.(string)
+		if err = b.decodeExtra(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extra decodes into v the value of a key of the JSON object other than the
+// lines array. It returns false if the key is absent. Keys following the
+// array are only available once all lines have been read.
+func (b *BatchStream) Extra(key string, v interface{}) (bool, error) {
+	extra, ok := b.Extras[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(extra, v)
+}
+
+// Next decodes the next line into v and returns false when there is no more
+// line. v should be reset by the caller since absent fields are not cleared.
+func (b *BatchStream) Next(v interface{}) (bool, error) {
+	if !b.started {
+		if err := b.start(); err != nil {
+			return false, decodeError("décodage : %w", err)
+		}
+		b.started = true
+	}
+	if b.ndjson {
+		err := b.dec.Decode(v)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, decodeError("décodage ligne %d : %w", b.Count+1, err)
+		}
+	} else {
+		if b.ended {
+			return false, nil
+		}
+		if !b.dec.More() {
+			if err := b.end(); err != nil {
+				return false, decodeError("décodage : %w", err)
+			}
+			return false, nil
+		}
+		if err := b.dec.Decode(v); err != nil {
+			return false, decodeError("décodage ligne %d : %w", b.Count+1, err)
+		}
+	}
+	b.Count++
+	if b.MaxRows > 0 && b.Count > b.MaxRows {
+		return false, decodeError("nombre de lignes supérieur au maximum de %d", b.MaxRows)
+	}
+	if err := b.hash.Add(v); err != nil {
+		return false, fmt.Errorf("hash ligne %d : %v", b.Count, err)
+	}
+	return true, nil
+}
+
+// ContentHash returns the hash of the normalized content of the lines read so
+// far.
+func (b *BatchStream) ContentHash() string {
+	return b.hash.Sum()
+}
+
+// endFunc returns a function to call within the import transaction once all
+// lines have been read: it decodes the source total and checks with the guard
+// if the content was already imported.
+func (b *BatchStream) endFunc(guard ImportGuard) func(*sql.Tx) (NullMoney, error) {
+	return func(tx *sql.Tx) (source NullMoney, err error) {
+		if _, err = b.Extra("SourceTotal", &source); err != nil {
+			return source, decodeError("décodage total source %w", err)
+		}
+		return source, checkImport(guard, b.ContentHash(), tx)
+	}
+}
@@ -0,0 +1,384 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Iledant/iris-propera/forecast"
+)
+
+// BudgetAuthorization is an authorization of programme (AP) voted for a
+// budget action and a year
+type BudgetAuthorization struct {
+	ID       int64      `json:"id"`
+	ActionID int64      `json:"action_id"`
+	Year     int64      `json:"year"`
+	Value    int64      `json:"value"`
+	Descript NullString `json:"descript"`
+}
+
+// BudgetAuthorizationLine is an authorization of programme with the amounts
+// affected to operations, consumed by the commitments of these operations,
+// not yet affected and affected but not yet consumed
+type BudgetAuthorizationLine struct {
+	BudgetAuthorization
+	Action      string `json:"action"`
+	ActionName  string `json:"action_name"`
+	Affected    int64  `json:"affected"`
+	Consumed    int64  `json:"consumed"`
+	NotAffected int64  `json:"not_affected"`
+	NotConsumed int64  `json:"not_consumed"`
+}
+
+// BudgetAuthorizations embeddes an array of BudgetAuthorizationLine for json
+// export
+type BudgetAuthorizations struct {
+	BudgetAuthorizations []BudgetAuthorizationLine `json:"BudgetAuthorization"`
+}
+
+// AuthorizationAffectation is the part of an authorization of programme given
+// to a physical operation
+type AuthorizationAffectation struct {
+	ID              int64      `json:"id"`
+	AuthorizationID int64      `json:"authorization_id"`
+	OpID            int64      `json:"physical_op_id"`
+	Value           int64      `json:"value"`
+	Descript        NullString `json:"descript"`
+}
+
+// AuthorizationAffectationLine is an affectation with the operation and the
+// amount consumed by the commitments of the operation
+type AuthorizationAffectationLine struct {
+	AuthorizationAffectation
+	OpNumber    string `json:"op_number"`
+	OpName      string `json:"op_name"`
+	Consumed    int64  `json:"consumed"`
+	NotConsumed int64  `json:"not_consumed"`
+}
+
+// AuthorizationAffectations embeddes an array of AuthorizationAffectationLine
+// for json export
+type AuthorizationAffectations struct {
+	AuthorizationAffectations []AuthorizationAffectationLine `json:"AuthorizationAffectation"`
+}
+
+// BudgetAuthorizationScheduleLine is the payment credits (CP) schedule of an
+// authorization of programme in euros
+type BudgetAuthorizationScheduleLine struct {
+	ID         int64         `json:"id"`
+	Action     string        `json:"action"`
+	ActionName string        `json:"action_name"`
+	Year       int64         `json:"year"`
+	Value      int64         `json:"value"`
+	Affected   int64         `json:"affected"`
+	Values     []NullFloat64 `json:"values"`
+}
+
+// BudgetAuthorizationSchedule embeddes the years and the payment credits
+// schedules of the authorizations of programme for json export
+type BudgetAuthorizationSchedule struct {
+	Years []int64                           `json:"Years"`
+	Lines []BudgetAuthorizationScheduleLine `json:"BudgetAuthorizationSchedule"`
+}
+
+// consumedAffectations is the query of the amounts of the commitments
+// consumed per affectation. A commitment of an operation consumes the
+// affectation of the latest authorization of its budget action voted the year
+// of the commitment or before.
+const consumedAffectations = `SELECT af.id AS affectation_id,SUM(fc.value)::bigint AS value
+	FROM financial_commitment fc
+	JOIN LATERAL (SELECT af.id FROM authorization_affectation af
+		JOIN budget_authorization a ON af.authorization_id=a.id
+		WHERE af.physical_op_id=fc.physical_op_id AND a.action_id=fc.action_id
+			AND a.year<=EXTRACT(year FROM fc.date)
+		ORDER BY a.year DESC LIMIT 1) af ON true
+	GROUP BY 1`
+
+// Validate checks if fields are well formed
+func (b *BudgetAuthorization) Validate() error {
+	if b.ActionID == 0 {
+		return errors.New("ActionID incorrect")
+	}
+	if b.Year == 0 {
+		return errors.New("Year incorrect")
+	}
+	if b.Value < 0 {
+		return errors.New("Value incorrect")
+	}
+	return nil
+}
+
+// Create inserts a new authorization of programme into database.
+func (b *BudgetAuthorization) Create(db *sql.DB) error {
+	if err := db.QueryRow(`INSERT INTO budget_authorization (action_id,year,value,
+	descript) VALUES($1,$2,$3,$4) RETURNING id`, b.ActionID, b.Year, b.Value,
+		b.Descript).Scan(&b.ID); err != nil {
+		return fmt.Errorf("insert %v", err)
+	}
+	return nil
+}
+
+// Update modifies an authorization of programme, the value being at least the
+// sum of its affectations.
+func (b *BudgetAuthorization) Update(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE budget_authorization SET action_id=$1,year=$2,
+	value=$3,descript=$4 WHERE id=$5`, b.ActionID, b.Year, b.Value, b.Descript, b.ID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update %v", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("rows affected %v", err)
+	}
+	if count != 1 {
+		tx.Rollback()
+		return errors.New("Autorisation de programme introuvable")
+	}
+	if err = checkAffectations(b.ID, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes an authorization of programme and its affectations from
+// database.
+func (b *BudgetAuthorization) Delete(db *sql.DB) error {
+	res, err := db.Exec(`DELETE FROM budget_authorization WHERE id=$1`, b.ID)
+	if err != nil {
+		return fmt.Errorf("delete %v", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected %v", err)
+	}
+	if count != 1 {
+		return errors.New("Autorisation de programme introuvable")
+	}
+	return nil
+}
+
+// checkAffectations returns an error if the sum of the affectations exceeds
+// the value of the authorization of programme, the authorization being
+// locked until the end of the transaction.
+func checkAffectations(ID int64, tx *sql.Tx) error {
+	var value, affected int64
+	if err := tx.QueryRow(`SELECT value FROM budget_authorization WHERE id=$1
+	FOR UPDATE`, ID).Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("Autorisation de programme introuvable")
+		}
+		return fmt.Errorf("select authorization %v", err)
+	}
+	if err := tx.QueryRow(`SELECT COALESCE(SUM(value),0)::bigint
+	FROM authorization_affectation WHERE authorization_id=$1`, ID).
+		Scan(&affected); err != nil {
+		return fmt.Errorf("select affected %v", err)
+	}
+	if affected > value {
+		return fmt.Errorf("affectations (%d) supérieures à l'autorisation (%d)",
+			affected, value)
+	}
+	return nil
+}
+
+// GetAll fetches the authorizations of programme of a year or of all years if
+// year is 0 with their affected and consumed amounts.
+func (b *BudgetAuthorizations) GetAll(year int64, db *sql.DB) error {
+	rows, err := db.Query(`WITH consumed AS (`+consumedAffectations+`),
+	af AS (SELECT af.authorization_id,SUM(af.value)::bigint AS affected,
+		COALESCE(SUM(c.value),0)::bigint AS consumed
+		FROM authorization_affectation af
+		LEFT JOIN consumed c ON c.affectation_id=af.id GROUP BY 1)
+	SELECT a.id,a.action_id,a.year,a.value,a.descript,
+		bp.code_contract||bp.code_function||bp.code_number||ba.code,ba.name,
+		COALESCE(af.affected,0),COALESCE(af.consumed,0)
+	FROM budget_authorization a
+	JOIN budget_action ba ON a.action_id=ba.id
+	JOIN budget_program bp ON ba.program_id=bp.id
+	LEFT JOIN af ON af.authorization_id=a.id
+	WHERE $1=0 OR a.year=$1
+	ORDER BY a.year,6`, year)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	var r BudgetAuthorizationLine
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.ActionID, &r.Year, &r.Value, &r.Descript,
+			&r.Action, &r.ActionName, &r.Affected, &r.Consumed); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		r.NotAffected, r.NotConsumed = r.Value-r.Affected, r.Affected-r.Consumed
+		b.BudgetAuthorizations = append(b.BudgetAuthorizations, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	if len(b.BudgetAuthorizations) == 0 {
+		b.BudgetAuthorizations = []BudgetAuthorizationLine{}
+	}
+	return nil
+}
+
+// Validate checks if fields are well formed
+func (a *AuthorizationAffectation) Validate() error {
+	if a.OpID == 0 {
+		return errors.New("PhysicalOpID incorrect")
+	}
+	if a.Value <= 0 {
+		return errors.New("Value incorrect")
+	}
+	return nil
+}
+
+// Create inserts a new affectation into database, the sum of the
+// affectations being at most the value of the authorization of programme.
+func (a *AuthorizationAffectation) Create(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = tx.QueryRow(`INSERT INTO authorization_affectation (authorization_id,
+	physical_op_id,value,descript) VALUES($1,$2,$3,$4) RETURNING id`,
+		a.AuthorizationID, a.OpID, a.Value, a.Descript).Scan(&a.ID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert %v", err)
+	}
+	if err = checkAffectations(a.AuthorizationID, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update modifies the value and the description of an affectation, the sum of
+// the affectations being at most the value of the authorization of programme.
+func (a *AuthorizationAffectation) Update(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = tx.QueryRow(`UPDATE authorization_affectation SET value=$1,descript=$2
+	WHERE id=$3 RETURNING authorization_id,physical_op_id`, a.Value, a.Descript,
+		a.ID).Scan(&a.AuthorizationID, &a.OpID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return errors.New("Affectation introuvable")
+		}
+		return fmt.Errorf("update %v", err)
+	}
+	if err = checkAffectations(a.AuthorizationID, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes an affectation from database.
+func (a *AuthorizationAffectation) Delete(db *sql.DB) error {
+	res, err := db.Exec(`DELETE FROM authorization_affectation WHERE id=$1`, a.ID)
+	if err != nil {
+		return fmt.Errorf("delete %v", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected %v", err)
+	}
+	if count != 1 {
+		return errors.New("Affectation introuvable")
+	}
+	return nil
+}
+
+// GetAll fetches the affectations of an authorization of programme with the
+// amounts consumed by the commitments of the operations.
+func (a *AuthorizationAffectations) GetAll(ID int64, db *sql.DB) error {
+	rows, err := db.Query(`WITH consumed AS (`+consumedAffectations+`)
+	SELECT af.id,af.authorization_id,af.physical_op_id,af.value,af.descript,
+		op.number,op.name,COALESCE(c.value,0)
+	FROM authorization_affectation af
+	JOIN physical_op op ON af.physical_op_id=op.id
+	LEFT JOIN consumed c ON c.affectation_id=af.id
+	WHERE af.authorization_id=$1 ORDER BY op.number`, ID)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	var r AuthorizationAffectationLine
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.AuthorizationID, &r.OpID, &r.Value, &r.Descript,
+			&r.OpNumber, &r.OpName, &r.Consumed); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		r.NotConsumed = r.Value - r.Consumed
+		a.AuthorizationAffectations = append(a.AuthorizationAffectations, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	if len(a.AuthorizationAffectations) == 0 {
+		a.AuthorizationAffectations = []AuthorizationAffectationLine{}
+	}
+	return nil
+}
+
+// GetAll computes the payment credits schedule of the authorizations of
+// programme from the payments projected for the commitments of the affected
+// operations that consume each authorization, the commitments made before an
+// authorization being left out of its schedule.
+func (b *BudgetAuthorizationSchedule) GetAll(p forecast.Params, db *sql.DB) error {
+	var auths BudgetAuthorizations
+	if err := auths.GetAll(0, db); err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT af.authorization_id,a.action_id,a.year,
+	af.physical_op_id,af.value::double precision FROM authorization_affectation af
+	JOIN budget_authorization a ON af.authorization_id=a.id`)
+	if err != nil {
+		return fmt.Errorf("select affectations %v", err)
+	}
+	defer rows.Close()
+	var affectations []forecast.Affectation
+	var af forecast.Affectation
+	for rows.Next() {
+		if err = rows.Scan(&af.AuthorizationID, &af.ActionID, &af.Year, &af.OpID,
+			&af.Value); err != nil {
+			return fmt.Errorf("scan affectations %v", err)
+		}
+		affectations = append(affectations, af)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows affectations %v", err)
+	}
+	d, err := LoadForecastData(0, db)
+	if err != nil {
+		return err
+	}
+	schedule, err := forecast.Schedule(d, p, affectations)
+	if err != nil {
+		return err
+	}
+	b.Years = scenarioYears(p.FirstYear, p.Horizon)
+	b.Lines = make([]BudgetAuthorizationScheduleLine, len(auths.BudgetAuthorizations))
+	for i, a := range auths.BudgetAuthorizations {
+		l := BudgetAuthorizationScheduleLine{ID: a.ID, Action: a.Action,
+			ActionName: a.ActionName, Year: a.Year, Value: a.Value,
+			Affected: a.Affected, Values: make([]NullFloat64, p.Horizon)}
+		values, ok := schedule[a.ID]
+		for j := range l.Values {
+			if ok {
+				l.Values[j] = euros(values[j], true)
+			}
+		}
+		b.Lines[i] = l
+	}
+	return nil
+}
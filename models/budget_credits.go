@@ -44,15 +44,16 @@ type BudgetCreditLine struct {
 	ID                 int64     `json:"id"`
 	CommissionDate     ExcelDate `json:"commission_date"`
 	Chapter            int64     `json:"chapter"`
-	PrimaryCommitment  float64   `json:"primary_commitment"`
-	FrozenCommitment   float64   `json:"frozen_commitment"`
-	ReservedCommitment float64   `json:"reserved_commitment"`
+	PrimaryCommitment  Money     `json:"primary_commitment"`
+	FrozenCommitment   Money     `json:"frozen_commitment"`
+	ReservedCommitment Money     `json:"reserved_commitment"`
 }
 
 // BudgetCreditBatch embeddes an array of BudgetCreditLine
 // to decode budget credits batch.
 type BudgetCreditBatch struct {
-	Lines []BudgetCreditLine `json:"BudgetCredits"`
+	Lines       []BudgetCreditLine `json:"BudgetCredits"`
+	SourceTotal NullMoney          `json:"SourceTotal"`
 }
 
 // Validate checks if fields are correctly formed.
@@ -179,21 +180,35 @@ func (b *BudgetCredit) Delete(db *sql.DB) (err error) {
 	return nil
 }
 
-// Save update or insert a batch of budget credits lines into database.
-func (b *BudgetCreditBatch) Save(db *sql.DB) error {
+// Save update or insert a batch of budget credits lines into database. The
+// primary commitments total is checked against the source total. The guard, if any, checks within
+// the transaction that the batch wasn't already imported and records the
+// reconciliation before the commit.
+func (b *BudgetCreditBatch) Save(guard ImportGuard, db *sql.DB) (*Reconciliation, error) {
 	for _, r := range b.Lines {
 		if r.CommissionDate == 0 || r.Chapter == 0 {
-			return errors.New("Date de commission ou chapitre incorrect")
+			return nil, errors.New("Date de commission ou chapitre incorrect")
 		}
 
 	}
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if guard != nil {
+		hash, err := BatchHash(b.Lines)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err = checkImport(guard, hash, tx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 	if _, err = tx.Exec(`DROP TABLE IF EXISTS temp_budget_credits`); err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 	q := `CREATE TABLE temp_budget_credits 
 	(	commission_date date,
@@ -203,25 +218,28 @@ func (b *BudgetCreditBatch) Save(db *sql.DB) error {
 		reserved_commitment bigint)`
 	if _, err = tx.Exec(q); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("create temp table %v", err)
+		return nil, fmt.Errorf("create temp table %v", err)
 	}
 	stmt, err := tx.Prepare(pq.CopyIn("temp_budget_credits", "commission_date",
 		"chapter", "primary_commitment", "frozen_commitment", "reserved_commitment"))
 	if err != nil {
-		return fmt.Errorf("prepare stmt %v", err)
+		return nil, fmt.Errorf("prepare stmt %v", err)
 	}
 	defer stmt.Close()
+	var recon Reconciliation
 	for _, r := range b.Lines {
 		if _, err = stmt.Exec(r.CommissionDate.ToDate(), r.Chapter,
-			int64(r.PrimaryCommitment*100), int64(100*r.FrozenCommitment),
-			int64(100*r.ReservedCommitment)); err != nil {
+			int64(r.PrimaryCommitment), int64(r.FrozenCommitment),
+			int64(r.ReservedCommitment)); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("insertion de %+v  %v", r, err)
+			return nil, fmt.Errorf("insertion de %+v  %v", r, err)
 		}
+		recon.Add(r.PrimaryCommitment)
 	}
+	recon.Check(b.SourceTotal)
 	if _, err = stmt.Exec(); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("statement exec flush %v", err)
+		return nil, fmt.Errorf("statement exec flush %v", err)
 	}
 	if _, err = tx.Exec(`INSERT INTO budget_credits (commission_date,chapter_id,
 		primary_commitment,frozen_commitment,reserved_commitment)
@@ -233,12 +251,18 @@ func (b *BudgetCreditBatch) Save(db *sql.DB) error {
 	(SELECT b.commission_date,c.code
 		FROM budget_credits b, budget_chapter c WHERE b.chapter_id = c.id)`); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("insert query %v", err)
+		return nil, fmt.Errorf("insert query %v", err)
 	}
 	if _, err = tx.Exec(`DROP TABLE IF EXISTS temp_budget_credits`); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("drop temp table %v", err)
+		return nil, fmt.Errorf("drop temp table %v", err)
 	}
-	err = tx.Commit()
-	return err
+	if err = recordImport(guard, &recon, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &recon, nil
 }
@@ -0,0 +1,364 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Iledant/iris-propera/forecast"
+)
+
+// Levels of the budget execution dashboard, from the widest to the finest
+const (
+	ExecutionChapter = "chapter"
+	ExecutionSector  = "sector"
+	ExecutionProgram = "program"
+	ExecutionAction  = "action"
+	ExecutionOp      = "op"
+)
+
+// executionLevels gives the depth of each level of the dashboard
+var executionLevels = map[string]int{ExecutionChapter: 1, ExecutionSector: 2,
+	ExecutionProgram: 3, ExecutionAction: 4, ExecutionOp: 5}
+
+// BudgetExecutionParams defines the year, the level and the filters of the
+// budget execution dashboard, a null filter being ignored. The payment type
+// is used to project the payments of the year.
+type BudgetExecutionParams struct {
+	Year          int64
+	PaymentTypeID int64
+	Level         string
+	Chapter       NullInt64
+	Sector        NullString
+	Program       NullString
+	Action        NullString
+}
+
+// BudgetExecutionLine is the execution of the budget of a year for a chapter,
+// a sector, a program, a budget action or an operation according to the
+// level, the codes of the finer levels being null. The voted credits, the
+// remaining credits and the rates are null at the operation level and, for a
+// year without budget version, below the chapter level. The amounts are in
+// cents.
+type BudgetExecutionLine struct {
+	Chapter             NullInt64   `json:"chapter"`
+	Sector              NullString  `json:"sector"`
+	Program             NullString  `json:"program"`
+	Action              NullString  `json:"action"`
+	ActionName          NullString  `json:"action_name"`
+	OpID                NullInt64   `json:"op_id"`
+	OpNumber            NullString  `json:"op_number"`
+	OpName              NullString  `json:"op_name"`
+	VotedCommitment     NullInt64   `json:"voted_commitment"`
+	VotedPayment        NullInt64   `json:"voted_payment"`
+	Programmed          int64       `json:"programmed"`
+	Committed           int64       `json:"committed"`
+	Pending             int64       `json:"pending"`
+	Paid                int64       `json:"paid"`
+	RemainingCommitment NullInt64   `json:"remaining_commitment"`
+	RemainingPayment    NullInt64   `json:"remaining_payment"`
+	CommitmentRate      NullFloat64 `json:"commitment_rate"`
+	PaymentRate         NullFloat64 `json:"payment_rate"`
+	ProjectedCommitment int64       `json:"projected_commitment"`
+	ProjectedPayment    int64       `json:"projected_payment"`
+}
+
+// BudgetExecution embeddes the lines of the budget execution dashboard for
+// json export
+type BudgetExecution struct {
+	Lines []BudgetExecutionLine `json:"BudgetExecution"`
+}
+
+// executionKey identifies an operation, 0 for the amounts attached directly
+// to the budget action, and its budget action
+type executionKey struct {
+	opID, actionID int64
+}
+
+// executionAmounts are the amounts of an operation for the year
+type executionAmounts struct {
+	programmed, committed, pending, paid, forecast int64
+}
+
+// Validate checks the parameters of the dashboard.
+func (p *BudgetExecutionParams) Validate() error {
+	if p.Year == 0 {
+		return errors.New("Year incorrect")
+	}
+	if _, ok := executionLevels[p.Level]; !ok {
+		return errors.New("Level incorrect")
+	}
+	return nil
+}
+
+// keep checks if the codes of a budget action match the filters.
+func (p *BudgetExecutionParams) keep(c *budgetCodes) bool {
+	return (!p.Chapter.Valid || c.Chapter == p.Chapter) &&
+		(!p.Sector.Valid || c.Sector == p.Sector) &&
+		(!p.Program.Valid || c.Program == p.Program) &&
+		(!p.Action.Valid || c.Action == p.Action)
+}
+
+// label returns the line of the level of the operation of the budget action,
+// the codes finer than the level being null.
+func (p *BudgetExecutionParams) label(opID int64, c *budgetCodes,
+	names map[int64][2]string) BudgetExecutionLine {
+	depth := executionLevels[p.Level]
+	l := BudgetExecutionLine{Chapter: c.Chapter}
+	if depth >= 2 {
+		l.Sector = c.Sector
+	}
+	if depth >= 3 {
+		l.Program = c.Program
+	}
+	if depth >= 4 {
+		l.Action, l.ActionName = c.Action, c.ActionName
+	}
+	if depth == 5 && opID != 0 {
+		l.OpID = NullInt64{Valid: true, Int64: opID}
+		l.OpNumber = NullString{Valid: true, String: names[opID][0]}
+		l.OpName = NullString{Valid: true, String: names[opID][1]}
+	}
+	return l
+}
+
+// fetchExecutionAmounts fetches the programmings, the commitments, the
+// pending commitments and the payments of the year per operation and budget
+// action. The pending commitments must be linked to an operation and the
+// payments to a commitment to be attributed to a budget action.
+func fetchExecutionAmounts(year int64, db *sql.DB) (map[executionKey]*executionAmounts, error) {
+	rows, err := db.Query(`SELECT COALESCE(op_id,0),action_id,SUM(programmed)::bigint,
+		SUM(committed)::bigint,SUM(pending)::bigint,SUM(paid)::bigint
+	FROM (SELECT p.physical_op_id AS op_id,op.budget_action_id AS action_id,
+			p.value AS programmed,0 AS committed,0 AS pending,0 AS paid
+		FROM programmings p JOIN physical_op op ON p.physical_op_id=op.id
+		WHERE p.year=$1
+		UNION ALL
+		SELECT physical_op_id,action_id,0,value,0,0 FROM financial_commitment
+		WHERE EXTRACT(year FROM date)=$1
+		UNION ALL
+		SELECT pe.physical_op_id,op.budget_action_id,0,0,pe.proposed_value,0
+		FROM pending_commitments pe JOIN physical_op op ON pe.physical_op_id=op.id
+		WHERE EXTRACT(year FROM pe.commission_date)=$1
+		UNION ALL
+		SELECT f.physical_op_id,f.action_id,0,0,0,p.value-p.cancelled_value
+		FROM payment p JOIN financial_commitment f ON p.financial_commitment_id=f.id
+		WHERE EXTRACT(year FROM p.date)=$1) q
+	WHERE action_id NOTNULL GROUP BY 1,2`, year)
+	if err != nil {
+		return nil, fmt.Errorf("select amounts %v", err)
+	}
+	defer rows.Close()
+	amounts := make(map[executionKey]*executionAmounts)
+	var k executionKey
+	for rows.Next() {
+		var a executionAmounts
+		if err = rows.Scan(&k.opID, &k.actionID, &a.programmed, &a.committed,
+			&a.pending, &a.paid); err != nil {
+			return nil, fmt.Errorf("scan amounts %v", err)
+		}
+		amounts[k] = &a
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows amounts %v", err)
+	}
+	return amounts, nil
+}
+
+// fetchLegacyVotedCredits fetches the credits voted per chapter code for a
+// year without budget version: the primary commitment credits of the latest
+// commission of the year and the payment credits of the year.
+func fetchLegacyVotedCredits(year int64, db *sql.DB) (map[int64][2]int64, error) {
+	rows, err := db.Query(`SELECT c.code,COALESCE(b.commitment,0)::bigint,
+		COALESCE(p.payment,0)::bigint
+	FROM budget_chapter c
+	LEFT JOIN (SELECT chapter_id,SUM(primary_commitment) AS commitment
+		FROM budget_credits WHERE commission_date=(SELECT max(commission_date)
+			FROM budget_credits WHERE EXTRACT(year FROM commission_date)=$1)
+		GROUP BY 1) b ON b.chapter_id=c.id
+	LEFT JOIN (SELECT chapter_id,SUM(primitive+added+modified+movement) AS payment
+		FROM payment_credit WHERE year=$1 GROUP BY 1) p ON p.chapter_id=c.id
+	WHERE b.chapter_id NOTNULL OR p.chapter_id NOTNULL`, year)
+	if err != nil {
+		return nil, fmt.Errorf("select legacy credits %v", err)
+	}
+	defer rows.Close()
+	credits := make(map[int64][2]int64)
+	var code int64
+	var c [2]int64
+	for rows.Next() {
+		if err = rows.Scan(&code, &c[0], &c[1]); err != nil {
+			return nil, fmt.Errorf("scan legacy credits %v", err)
+		}
+		credits[code] = c
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows legacy credits %v", err)
+	}
+	return credits, nil
+}
+
+// addForecast adds the payments of the year projected per operation to the
+// amounts.
+func addForecast(p *BudgetExecutionParams, amounts map[executionKey]*executionAmounts,
+	db *sql.DB) error {
+	d, err := LoadForecastData(0, db)
+	if err != nil {
+		return err
+	}
+	lines, err := d.Payments(forecast.Params{FirstYear: p.Year, Horizon: 1,
+		PaymentTypeID: p.PaymentTypeID, Level: forecast.LevelOp, Declared: true})
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if l.ActionID == 0 || !l.Valid[0] {
+			continue
+		}
+		k := executionKey{opID: l.OpID, actionID: l.ActionID}
+		a, ok := amounts[k]
+		if !ok {
+			a = &executionAmounts{}
+			amounts[k] = a
+		}
+		a.forecast = int64(math.Round(l.Values[0]))
+	}
+	return nil
+}
+
+// GetAll computes the execution of the budget of the year at the level of the
+// parameters. The voted credits are those of the budget versions and the
+// transfers of the year or, if the year has no budget version, those of the
+// budget credits and the payment credits per chapter. The projected commitments of an operation are the
+// greatest of its programmings and of its commitments including the pending
+// ones and the projected payments the greatest of its payments and of the
+// statistical projection of the payments of the year.
+func (b *BudgetExecution) GetAll(p *BudgetExecutionParams, db *sql.DB) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	amounts, err := fetchExecutionAmounts(p.Year, db)
+	if err != nil {
+		return err
+	}
+	if err = addForecast(p, amounts, db); err != nil {
+		return err
+	}
+	var versioned bool
+	if err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM budget_version WHERE year=$1)`,
+		p.Year).Scan(&versioned); err != nil {
+		return fmt.Errorf("select versions %v", err)
+	}
+	var voted VotedCredits
+	var legacy map[int64][2]int64
+	if versioned {
+		if err = voted.GetAll(time.Date(int(p.Year), 12, 31, 0, 0, 0, 0, time.UTC),
+			true, db); err != nil {
+			return err
+		}
+	} else if legacy, err = fetchLegacyVotedCredits(p.Year, db); err != nil {
+		return err
+	}
+	withVoted := p.Level != ExecutionOp && (versioned ||
+		(p.Level == ExecutionChapter && !p.Sector.Valid && !p.Program.Valid &&
+			!p.Action.Valid))
+	codes, err := fetchBudgetCodes(db)
+	if err != nil {
+		return err
+	}
+	var names map[int64][2]string
+	if p.Level == ExecutionOp {
+		if names, err = fetchOpNames(db); err != nil {
+			return err
+		}
+	}
+	// lines are keyed by their codes, the amounts of the keys being zero
+	lines := make(map[BudgetExecutionLine]*BudgetExecutionLine)
+	line := func(opID, actionID int64) *BudgetExecutionLine {
+		c, ok := codes[actionID]
+		if !ok || !p.keep(&c) {
+			return nil
+		}
+		k := p.label(opID, &c, names)
+		l, ok := lines[k]
+		if !ok {
+			l = &k
+			lines[k] = l
+		}
+		return l
+	}
+	for k, a := range amounts {
+		l := line(k.opID, k.actionID)
+		if l == nil {
+			continue
+		}
+		l.Programmed += a.programmed
+		l.Committed += a.committed
+		l.Pending += a.pending
+		l.Paid += a.paid
+		projected := a.committed + a.pending
+		if a.programmed > projected {
+			projected = a.programmed
+		}
+		l.ProjectedCommitment += projected
+		if projected = a.paid; a.forecast > projected {
+			projected = a.forecast
+		}
+		l.ProjectedPayment += projected
+	}
+	if withVoted {
+		for _, v := range voted.VotedCredits {
+			l := line(0, v.ActionID.Int64)
+			if l == nil {
+				continue
+			}
+			l.VotedCommitment.Int64 += v.Commitment
+			l.VotedPayment.Int64 += v.Payment
+		}
+		for code, v := range legacy {
+			if p.Chapter.Valid && p.Chapter.Int64 != code {
+				continue
+			}
+			k := BudgetExecutionLine{Chapter: NullInt64{Valid: true, Int64: code}}
+			l, ok := lines[k]
+			if !ok {
+				l = &k
+				lines[k] = l
+			}
+			l.VotedCommitment.Int64 += v[0]
+			l.VotedPayment.Int64 += v[1]
+		}
+	}
+	b.Lines = make([]BudgetExecutionLine, 0, len(lines))
+	for _, l := range lines {
+		if withVoted {
+			l.VotedCommitment.Valid, l.VotedPayment.Valid = true, true
+			l.RemainingCommitment = NullInt64{Valid: true,
+				Int64: l.VotedCommitment.Int64 - l.Committed}
+			l.RemainingPayment = NullInt64{Valid: true,
+				Int64: l.VotedPayment.Int64 - l.Paid}
+			if l.VotedCommitment.Int64 != 0 {
+				l.CommitmentRate = NullFloat64{Valid: true,
+					Float64: float64(l.Committed) / float64(l.VotedCommitment.Int64)}
+			}
+			if l.VotedPayment.Int64 != 0 {
+				l.PaymentRate = NullFloat64{Valid: true,
+					Float64: float64(l.Paid) / float64(l.VotedPayment.Int64)}
+			}
+		}
+		b.Lines = append(b.Lines, *l)
+	}
+	sort.Slice(b.Lines, func(i, j int) bool {
+		a, c := &b.Lines[i], &b.Lines[j]
+		if d := cmpNullInt64(a.Chapter, c.Chapter); d != 0 {
+			return d < 0
+		}
+		return cmpNullStrings([2]NullString{a.Sector, c.Sector},
+			[2]NullString{a.Program, c.Program}, [2]NullString{a.Action, c.Action},
+			[2]NullString{a.OpNumber, c.OpNumber}) < 0
+	})
+	return nil
+}
@@ -0,0 +1,428 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of the budget versions
+const (
+	PrimaryBudget       = "BP"
+	SupplementaryBudget = "BS"
+	AmendingBudget      = "DM"
+)
+
+// BudgetVersionCredit is the commitment and payment credits of a budget
+// action added by a budget version, negative for a reduction
+type BudgetVersionCredit struct {
+	ActionID   int64 `json:"action_id"`
+	Commitment int64 `json:"commitment"`
+	Payment    int64 `json:"payment"`
+}
+
+// BudgetVersion is the primary budget (BP), the supplementary budget (BS) or
+// an amending budget (DM) numbered in the year, the credits of the primary
+// budget being the initial ones and those of the other versions the amounts
+// added
+type BudgetVersion struct {
+	ID       int64                 `json:"id"`
+	Year     int64                 `json:"year"`
+	Kind     string                `json:"kind"`
+	Number   int64                 `json:"number"`
+	Name     string                `json:"name"`
+	VoteDate time.Time             `json:"vote_date"`
+	Descript NullString            `json:"descript"`
+	Credits  []BudgetVersionCredit `json:"credits"`
+}
+
+// BudgetVersions embeddes an array of BudgetVersion for json export
+type BudgetVersions struct {
+	BudgetVersions []BudgetVersion `json:"BudgetVersion"`
+}
+
+// BudgetTransfer moves commitment and payment credits from a budget action to
+// another one, possibly of another chapter
+type BudgetTransfer struct {
+	ID           int64      `json:"id"`
+	Date         time.Time  `json:"transfer_date"`
+	FromActionID int64      `json:"from_action_id"`
+	ToActionID   int64      `json:"to_action_id"`
+	Commitment   int64      `json:"commitment"`
+	Payment      int64      `json:"payment"`
+	Descript     NullString `json:"descript"`
+}
+
+// BudgetTransfers embeddes an array of BudgetTransfer for json export
+type BudgetTransfers struct {
+	BudgetTransfers []BudgetTransfer `json:"BudgetTransfer"`
+}
+
+// VotedCredit is the commitment and payment credits voted at a date for a
+// chapter or, if the action is set, for a budget action
+type VotedCredit struct {
+	ChapterID  int64      `json:"chapter_id"`
+	Chapter    int64      `json:"chapter"`
+	ActionID   NullInt64  `json:"action_id"`
+	Action     NullString `json:"action"`
+	ActionName NullString `json:"action_name"`
+	Commitment int64      `json:"commitment"`
+	Payment    int64      `json:"payment"`
+}
+
+// VotedCredits embeddes an array of VotedCredit for json export
+type VotedCredits struct {
+	VotedCredits []VotedCredit `json:"VotedCredit"`
+}
+
+// VotedCreditStep is the commitment and payment credits of a chapter after a
+// budget version or the transfers of a date
+type VotedCreditStep struct {
+	Date       time.Time `json:"date"`
+	Label      string    `json:"label"`
+	ChapterID  int64     `json:"chapter_id"`
+	Chapter    int64     `json:"chapter"`
+	Commitment int64     `json:"commitment"`
+	Payment    int64     `json:"payment"`
+}
+
+// VotedCreditHistory embeddes an array of VotedCreditStep for json export
+type VotedCreditHistory struct {
+	VotedCreditHistory []VotedCreditStep `json:"VotedCreditHistory"`
+}
+
+// budgetMovements is the query of the credits added per date, label and
+// budget action by the versions and the transfers of a year
+const budgetMovements = `SELECT v.vote_date AS date,
+		CASE v.kind WHEN 'DM' THEN 'DM'||v.number ELSE v.kind END AS label,
+		c.action_id,c.commitment,c.payment
+	FROM budget_version v JOIN budget_version_credit c ON c.version_id=v.id
+	WHERE v.year=$1
+	UNION ALL
+	SELECT transfer_date,'Virement',to_action_id,commitment,payment
+	FROM budget_transfer WHERE EXTRACT(year FROM transfer_date)=$1
+	UNION ALL
+	SELECT transfer_date,'Virement',from_action_id,-commitment,-payment
+	FROM budget_transfer WHERE EXTRACT(year FROM transfer_date)=$1`
+
+// versionName returns the name of a version such as BP 2019 or DM2 2019.
+func versionName(kind string, number, year int64) string {
+	if kind == AmendingBudget {
+		return fmt.Sprintf("%s%d %d", kind, number, year)
+	}
+	return fmt.Sprintf("%s %d", kind, year)
+}
+
+// Validate checks if fields are well formed
+func (b *BudgetVersion) Validate() error {
+	if b.Year == 0 {
+		return errors.New("Year incorrect")
+	}
+	switch b.Kind {
+	case PrimaryBudget, SupplementaryBudget:
+		if b.Number != 0 {
+			return errors.New("Number incorrect")
+		}
+	case AmendingBudget:
+		if b.Number <= 0 {
+			return errors.New("Number incorrect")
+		}
+	default:
+		return errors.New("Kind incorrect")
+	}
+	if b.VoteDate.IsZero() {
+		return errors.New("VoteDate incorrect")
+	}
+	actions := make(map[int64]bool, len(b.Credits))
+	for _, c := range b.Credits {
+		if c.ActionID == 0 || actions[c.ActionID] {
+			return errors.New("Credits action incorrecte ou en double")
+		}
+		actions[c.ActionID] = true
+	}
+	return nil
+}
+
+// saveCredits replaces the credits of the version.
+func (b *BudgetVersion) saveCredits(tx *sql.Tx) error {
+	if _, err := tx.Exec(`DELETE FROM budget_version_credit WHERE version_id=$1`,
+		b.ID); err != nil {
+		return fmt.Errorf("delete credits %v", err)
+	}
+	for _, c := range b.Credits {
+		if _, err := tx.Exec(`INSERT INTO budget_version_credit (version_id,action_id,
+		commitment,payment) VALUES($1,$2,$3,$4)`, b.ID, c.ActionID, c.Commitment,
+			c.Payment); err != nil {
+			return fmt.Errorf("insert credit %v", err)
+		}
+	}
+	if len(b.Credits) == 0 {
+		b.Credits = []BudgetVersionCredit{}
+	}
+	return nil
+}
+
+// Create inserts a new budget version and its credits into database.
+func (b *BudgetVersion) Create(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = tx.QueryRow(`INSERT INTO budget_version (year,kind,number,vote_date,
+	descript) VALUES($1,$2,$3,$4,$5) RETURNING id`, b.Year, b.Kind, b.Number,
+		b.VoteDate, b.Descript).Scan(&b.ID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert %v", err)
+	}
+	if err = b.saveCredits(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	b.Name = versionName(b.Kind, b.Number, b.Year)
+	return tx.Commit()
+}
+
+// Update modifies a budget version and replaces its credits.
+func (b *BudgetVersion) Update(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE budget_version SET year=$1,kind=$2,number=$3,
+	vote_date=$4,descript=$5 WHERE id=$6`, b.Year, b.Kind, b.Number, b.VoteDate,
+		b.Descript, b.ID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update %v", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("rows affected %v", err)
+	}
+	if count != 1 {
+		tx.Rollback()
+		return errors.New("Version budgétaire introuvable")
+	}
+	if err = b.saveCredits(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	b.Name = versionName(b.Kind, b.Number, b.Year)
+	return tx.Commit()
+}
+
+// Delete removes a budget version and its credits from database.
+func (b *BudgetVersion) Delete(db *sql.DB) error {
+	res, err := db.Exec(`DELETE FROM budget_version WHERE id=$1`, b.ID)
+	if err != nil {
+		return fmt.Errorf("delete %v", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected %v", err)
+	}
+	if count != 1 {
+		return errors.New("Version budgétaire introuvable")
+	}
+	return nil
+}
+
+// GetAll fetches the budget versions of a year with their credits ordered by
+// vote date.
+func (b *BudgetVersions) GetAll(year int64, db *sql.DB) error {
+	rows, err := db.Query(`SELECT id,year,kind,number,vote_date,descript
+	FROM budget_version WHERE year=$1 ORDER BY vote_date,id`, year)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	index := make(map[int64]int)
+	var r BudgetVersion
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.Year, &r.Kind, &r.Number, &r.VoteDate,
+			&r.Descript); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		r.Name = versionName(r.Kind, r.Number, r.Year)
+		r.Credits = []BudgetVersionCredit{}
+		index[r.ID] = len(b.BudgetVersions)
+		b.BudgetVersions = append(b.BudgetVersions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	if len(b.BudgetVersions) == 0 {
+		b.BudgetVersions = []BudgetVersion{}
+		return nil
+	}
+	rows, err = db.Query(`SELECT c.version_id,c.action_id,c.commitment,c.payment
+	FROM budget_version_credit c JOIN budget_version v ON c.version_id=v.id
+	WHERE v.year=$1 ORDER BY c.version_id,c.action_id`, year)
+	if err != nil {
+		return fmt.Errorf("select credits %v", err)
+	}
+	defer rows.Close()
+	var ID int64
+	var c BudgetVersionCredit
+	for rows.Next() {
+		if err = rows.Scan(&ID, &c.ActionID, &c.Commitment, &c.Payment); err != nil {
+			return fmt.Errorf("scan credits %v", err)
+		}
+		v := &b.BudgetVersions[index[ID]]
+		v.Credits = append(v.Credits, c)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows credits %v", err)
+	}
+	return nil
+}
+
+// Validate checks if fields are well formed
+func (b *BudgetTransfer) Validate() error {
+	if b.Date.IsZero() {
+		return errors.New("Date incorrecte")
+	}
+	if b.FromActionID == 0 || b.ToActionID == 0 || b.FromActionID == b.ToActionID {
+		return errors.New("Actions incorrectes")
+	}
+	if b.Commitment < 0 || b.Payment < 0 || b.Commitment+b.Payment == 0 {
+		return errors.New("Montants incorrects")
+	}
+	return nil
+}
+
+// Create inserts a new transfer into database.
+func (b *BudgetTransfer) Create(db *sql.DB) error {
+	if err := db.QueryRow(`INSERT INTO budget_transfer (transfer_date,from_action_id,
+	to_action_id,commitment,payment,descript) VALUES($1,$2,$3,$4,$5,$6) RETURNING id`,
+		b.Date, b.FromActionID, b.ToActionID, b.Commitment, b.Payment,
+		b.Descript).Scan(&b.ID); err != nil {
+		return fmt.Errorf("insert %v", err)
+	}
+	return nil
+}
+
+// Delete removes a transfer from database.
+func (b *BudgetTransfer) Delete(db *sql.DB) error {
+	res, err := db.Exec(`DELETE FROM budget_transfer WHERE id=$1`, b.ID)
+	if err != nil {
+		return fmt.Errorf("delete %v", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected %v", err)
+	}
+	if count != 1 {
+		return errors.New("Virement introuvable")
+	}
+	return nil
+}
+
+// GetAll fetches the transfers of a year ordered by date.
+func (b *BudgetTransfers) GetAll(year int64, db *sql.DB) error {
+	rows, err := db.Query(`SELECT id,transfer_date,from_action_id,to_action_id,
+	commitment,payment,descript FROM budget_transfer
+	WHERE EXTRACT(year FROM transfer_date)=$1 ORDER BY transfer_date,id`, year)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	var r BudgetTransfer
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.Date, &r.FromActionID, &r.ToActionID,
+			&r.Commitment, &r.Payment, &r.Descript); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		b.BudgetTransfers = append(b.BudgetTransfers, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	if len(b.BudgetTransfers) == 0 {
+		b.BudgetTransfers = []BudgetTransfer{}
+	}
+	return nil
+}
+
+// GetAll computes the credits voted at the date by the versions and the
+// transfers of the year of the date, per chapter or per budget action if
+// byAction is set.
+func (v *VotedCredits) GetAll(date time.Time, byAction bool, db *sql.DB) error {
+	qry := `WITH m AS (` + budgetMovements + `)
+	SELECT bc.id,bc.code,NULL::int,NULL::text,NULL::text,
+		SUM(m.commitment)::bigint,SUM(m.payment)::bigint
+	FROM m JOIN budget_action ba ON m.action_id=ba.id
+	JOIN budget_program bp ON ba.program_id=bp.id
+	JOIN budget_chapter bc ON bp.chapter_id=bc.id
+	WHERE m.date<=$2 GROUP BY 1,2 ORDER BY 2`
+	if byAction {
+		qry = `WITH m AS (` + budgetMovements + `)
+	SELECT bc.id,bc.code,ba.id,bp.code_contract||bp.code_function||bp.code_number||ba.code,
+		ba.name,SUM(m.commitment)::bigint,SUM(m.payment)::bigint
+	FROM m JOIN budget_action ba ON m.action_id=ba.id
+	JOIN budget_program bp ON ba.program_id=bp.id
+	JOIN budget_chapter bc ON bp.chapter_id=bc.id
+	WHERE m.date<=$2 GROUP BY 1,2,3,4,5 ORDER BY 2,4`
+	}
+	rows, err := db.Query(qry, date.Year(), date)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	var r VotedCredit
+	for rows.Next() {
+		if err = rows.Scan(&r.ChapterID, &r.Chapter, &r.ActionID, &r.Action,
+			&r.ActionName, &r.Commitment, &r.Payment); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		v.VotedCredits = append(v.VotedCredits, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	if len(v.VotedCredits) == 0 {
+		v.VotedCredits = []VotedCredit{}
+	}
+	return nil
+}
+
+// GetAll fetches the evolution of the credits voted per chapter during the
+// year, with a step for each chapter modified by a version or by the
+// transfers of a date.
+func (v *VotedCreditHistory) GetAll(year int64, db *sql.DB) error {
+	rows, err := db.Query(`WITH m AS (`+budgetMovements+`)
+	SELECT m.date,m.label,bc.id,bc.code,SUM(m.commitment)::bigint,
+		SUM(m.payment)::bigint
+	FROM m JOIN budget_action ba ON m.action_id=ba.id
+	JOIN budget_program bp ON ba.program_id=bp.id
+	JOIN budget_chapter bc ON bp.chapter_id=bc.id
+	GROUP BY 1,2,3,4 ORDER BY 1,2,4`, year)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	type credits struct{ commitment, payment int64 }
+	totals := make(map[int64]credits)
+	var r VotedCreditStep
+	for rows.Next() {
+		if err = rows.Scan(&r.Date, &r.Label, &r.ChapterID, &r.Chapter, &r.Commitment,
+			&r.Payment); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		t := totals[r.ChapterID]
+		t.commitment += r.Commitment
+		t.payment += r.Payment
+		totals[r.ChapterID] = t
+		r.Commitment, r.Payment = t.commitment, t.payment
+		v.VotedCreditHistory = append(v.VotedCreditHistory, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	if len(v.VotedCreditHistory) == 0 {
+		v.VotedCreditHistory = []VotedCreditStep{}
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// CmtOpMatchSettings configures the scoring of the proposals of links between
+// newly imported commitments and physical operations. Each signal gives a
+// value between 0 and 1 and the score is the weighted mean of the signals.
+type CmtOpMatchSettings struct {
+	OpNameWeight      float64 // similarity of the IRIS op name and the op name
+	TokensWeight      float64 // words of the op name found in the commitment name
+	BeneficiaryWeight float64 // beneficiary already linked to the op
+	ActionWeight      float64 // same budget action for the commitment and the op
+	AmountWeight      float64 // commitment value within the remaining prevision
+	MinScore          float64 // proposals below that score are dropped
+	MaxProposals      int     // maximum number of proposals per commitment
+	AutoLinkThreshold float64 // best proposal above that score is linked
+	AutoLinkMargin    float64 // lead required over the second best proposal
+}
+
+// CmtOpMatch is the settings used by the financial commitments imports
+var CmtOpMatch = CmtOpMatchSettings{
+	OpNameWeight:      0.35,
+	TokensWeight:      0.2,
+	BeneficiaryWeight: 0.2,
+	ActionWeight:      0.15,
+	AmountWeight:      0.1,
+	MinScore:          0.3,
+	MaxProposals:      3,
+	AutoLinkThreshold: 0.85,
+	AutoLinkMargin:    0.1,
+}
+
+// matchCmt is a newly imported commitment without physical operation
+type matchCmt struct {
+	ID              int64
+	Name            string
+	IRISOpName      NullString
+	BeneficiaryCode int
+	ActionID        NullInt64
+	Value           int64
+	Year            int
+}
+
+// matchOp is a physical operation candidate to a link with the signals
+// fetched from database
+type matchOp struct {
+	ID            int64
+	Number        string
+	Name          string
+	ActionID      NullInt64
+	norm          string
+	tokens        map[string]bool
+	beneficiaries map[int]bool
+	remaining     map[int]int64
+}
+
+// cmtOpMatcher indexes the physical operations to score the candidates of
+// each commitment
+type cmtOpMatcher struct {
+	settings      CmtOpMatchSettings
+	byName        map[string][]*matchOp
+	byToken       map[string][]*matchOp
+	byAction      map[int64][]*matchOp
+	byBeneficiary map[int][]*matchOp
+	byID          map[int64]*matchOp
+	feedback      *cmtOpFeedback
+}
+
+// stopWords are ignored when comparing names
+var stopWords = map[string]bool{"LES": true, "DES": true, "DU": true, "DE": true,
+	"LA": true, "LE": true, "ET": true, "AUX": true, "AU": true, "EN": true,
+	"POUR": true, "SUR": true, "DANS": true, "AVEC": true, "PAR": true,
+	"UNE": true, "UN": true, "A": true, "L": true, "D": true}
+
+var accents = strings.NewReplacer("À", "A", "Â", "A", "Ä", "A", "Ç", "C",
+	"É", "E", "È", "E", "Ê", "E", "Ë", "E", "Î", "I", "Ï", "I", "Ô", "O",
+	"Ö", "O", "Ù", "U", "Û", "U", "Ü", "U", "Œ", "OE")
+
+// normalizeName upper cases the name, removes accents and replaces
+// punctuation by single spaces.
+func normalizeName(s string) string {
+	s = accents.Replace(strings.ToUpper(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// nameTokens returns the significant words of a normalized name.
+func nameTokens(norm string) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, w := range strings.Fields(norm) {
+		if len(w) > 2 && !stopWords[w] {
+			tokens[w] = true
+		}
+	}
+	return tokens
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev, cur := make([]int, len(rb)+1), make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// similarity returns a value between 0 and 1 using the edit distance of the
+// normalized names.
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	l := len([]rune(a))
+	if lb := len([]rune(b)); lb > l {
+		l = lb
+	}
+	if l == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(a, b))/float64(l)
+}
+
+// newCmtOpMatcher indexes the operations by name, words, budget action and
+// linked beneficiaries. The feedback of the users' past decisions is used to
+// suppress rejected pairs and to match aliases of IRIS op names.
+func newCmtOpMatcher(ops []*matchOp, settings CmtOpMatchSettings,
+	feedback *cmtOpFeedback) *cmtOpMatcher {
+	if feedback == nil {
+		feedback = &cmtOpFeedback{}
+	}
+	m := cmtOpMatcher{settings: settings, feedback: feedback,
+		byID:          make(map[int64]*matchOp),
+		byName:        make(map[string][]*matchOp),
+		byToken:       make(map[string][]*matchOp),
+		byAction:      make(map[int64][]*matchOp),
+		byBeneficiary: make(map[int][]*matchOp)}
+	for _, op := range ops {
+		op.norm = normalizeName(op.Name)
+		op.tokens = nameTokens(op.norm)
+		m.byID[op.ID] = op
+		m.byName[op.norm] = append(m.byName[op.norm], op)
+		for t := range op.tokens {
+			m.byToken[t] = append(m.byToken[t], op)
+		}
+		if op.ActionID.Valid {
+			m.byAction[op.ActionID.Int64] = append(m.byAction[op.ActionID.Int64], op)
+		}
+		for b := range op.beneficiaries {
+			m.byBeneficiary[b] = append(m.byBeneficiary[b], op)
+		}
+	}
+	return &m
+}
+
+// candidates returns the operations sharing at least one signal with the
+// commitment.
+func (m *cmtOpMatcher) candidates(c *matchCmt) []*matchOp {
+	found := make(map[int64]*matchOp)
+	add := func(ops []*matchOp) {
+		for _, op := range ops {
+			found[op.ID] = op
+		}
+	}
+	irisName := normalizeName(c.IRISOpName.String)
+	add(m.byName[irisName])
+	if opID, ok := m.feedback.aliases[irisName]; ok && irisName != "" {
+		if op, ok := m.byID[opID]; ok {
+			add([]*matchOp{op})
+		}
+	}
+	for t := range nameTokens(irisName + " " + normalizeName(c.Name)) {
+		add(m.byToken[t])
+	}
+	if c.ActionID.Valid {
+		add(m.byAction[c.ActionID.Int64])
+	}
+	add(m.byBeneficiary[c.BeneficiaryCode])
+	ops := make([]*matchOp, 0, len(found))
+	for _, op := range found {
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// score computes the weighted mean of the signals of the link between the
+// commitment and the operation and the explanation of the signals found. The
+// IRIS op name is left out of the mean when the import doesn't give it.
+func (m *cmtOpMatcher) score(c *matchCmt, op *matchOp) (float64, []string) {
+	s := m.settings
+	var total float64
+	explanation := []string{}
+	weights := s.OpNameWeight + s.TokensWeight + s.BeneficiaryWeight +
+		s.ActionWeight + s.AmountWeight
+	if !c.IRISOpName.Valid || c.IRISOpName.String == "" {
+		weights -= s.OpNameWeight
+	} else {
+		irisName := normalizeName(c.IRISOpName.String)
+		sim := similarity(irisName, op.norm)
+		if opID, ok := m.feedback.aliases[irisName]; ok && opID == op.ID {
+			sim = 1
+			explanation = append(explanation, "alias du nom d'opération IRIS déjà validé")
+		} else if sim == 1 {
+			explanation = append(explanation, "nom d'opération IRIS identique")
+		} else if sim >= 0.5 {
+			explanation = append(explanation,
+				fmt.Sprintf("nom d'opération IRIS proche (%.0f %%)", sim*100))
+		} else {
+			sim = 0
+		}
+		total += s.OpNameWeight * sim
+	}
+	if len(op.tokens) > 0 {
+		cmtTokens, common := nameTokens(normalizeName(c.Name)), 0
+		for t := range op.tokens {
+			if cmtTokens[t] {
+				common++
+			}
+		}
+		if common > 0 {
+			explanation = append(explanation,
+				fmt.Sprintf("%d mot(s) de l'opération dans l'intitulé", common))
+			total += s.TokensWeight * float64(common) / float64(len(op.tokens))
+		}
+	}
+	if op.beneficiaries[c.BeneficiaryCode] {
+		explanation = append(explanation, "bénéficiaire déjà lié à l'opération")
+		total += s.BeneficiaryWeight
+	}
+	if c.ActionID.Valid && op.ActionID.Valid && c.ActionID.Int64 == op.ActionID.Int64 {
+		explanation = append(explanation, "même action budgétaire")
+		total += s.ActionWeight
+	}
+	if remaining, ok := op.remaining[c.Year]; ok && remaining > 0 && c.Value > 0 {
+		ratio := 1.0
+		if c.Value > remaining {
+			ratio = float64(remaining) / float64(c.Value)
+		}
+		explanation = append(explanation,
+			fmt.Sprintf("montant couvert à %.0f %% par la prévision restante", ratio*100))
+		total += s.AmountWeight * ratio
+	}
+	if weights <= 0 {
+		return 0, explanation
+	}
+	return math.Round(total/weights*100) / 100, explanation
+}
+
+// propose returns the ranked proposals of the commitment above the minimum
+// score, leaving out the pairs already rejected. The best one is flagged to
+// be linked if it reaches the threshold with enough lead over the second one.
+func (m *cmtOpMatcher) propose(c *matchCmt) []CmtOpProposal {
+	var proposals []CmtOpProposal
+	for _, op := range m.candidates(c) {
+		if m.feedback.rejected[[2]int64{c.ID, op.ID}] {
+			continue
+		}
+		score, explanation := m.score(c, op)
+		if score < m.settings.MinScore {
+			continue
+		}
+		proposals = append(proposals, CmtOpProposal{CommitmentID: c.ID,
+			CommitmentName: c.Name, IRISOpName: c.IRISOpName.String, OpID: op.ID,
+			OpNumber: op.Number, OpName: op.Name, Score: score,
+			Explanation: explanation})
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		if proposals[i].Score != proposals[j].Score {
+			return proposals[i].Score > proposals[j].Score
+		}
+		return proposals[i].OpNumber < proposals[j].OpNumber
+	})
+	if len(proposals) > m.settings.MaxProposals && m.settings.MaxProposals > 0 {
+		proposals = proposals[:m.settings.MaxProposals]
+	}
+	// Scores are rounded to the hundredth so the lead is compared in hundredths
+	// to avoid the float error of the difference
+	if len(proposals) > 0 && proposals[0].Score >= m.settings.AutoLinkThreshold &&
+		(len(proposals) == 1 ||
+			math.Round((proposals[0].Score-proposals[1].Score)*100) >=
+				math.Round(m.settings.AutoLinkMargin*100)) {
+		proposals[0].AutoLinked = true
+	}
+	return proposals
+}
+
+// fetchMatchOps fetches the physical operations and their signals.
+func fetchMatchOps(tx *sql.Tx) ([]*matchOp, error) {
+	rows, err := tx.Query(`SELECT id,number,name,budget_action_id FROM physical_op`)
+	if err != nil {
+		return nil, fmt.Errorf("select ops %v", err)
+	}
+	var ops []*matchOp
+	byID := make(map[int64]*matchOp)
+	for rows.Next() {
+		op := matchOp{beneficiaries: make(map[int]bool), remaining: make(map[int]int64)}
+		if err = rows.Scan(&op.ID, &op.Number, &op.Name, &op.ActionID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan ops %v", err)
+		}
+		ops = append(ops, &op)
+		byID[op.ID] = &op
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows ops %v", err)
+	}
+	rows, err = tx.Query(`SELECT DISTINCT physical_op_id,beneficiary_code
+	FROM financial_commitment WHERE physical_op_id IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("select beneficiaries %v", err)
+	}
+	var (
+		opID int64
+		code int
+	)
+	for rows.Next() {
+		if err = rows.Scan(&opID, &code); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan beneficiaries %v", err)
+		}
+		if op, ok := byID[opID]; ok {
+			op.beneficiaries[code] = true
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows beneficiaries %v", err)
+	}
+	// The previsions are summed per year before subtracting the commitments so
+	// that they're subtracted once when an operation has several previsions
+	rows, err = tx.Query(`SELECT p.physical_op_id,p.year,p.value-COALESCE(f.value,0)
+	FROM (SELECT physical_op_id,year,SUM(value) AS value FROM prev_commitment
+		GROUP BY 1,2) p
+	LEFT JOIN (SELECT physical_op_id,EXTRACT(YEAR FROM date)::int AS year,
+		SUM(value) AS value FROM financial_commitment
+		WHERE physical_op_id IS NOT NULL GROUP BY 1,2) f
+		ON f.physical_op_id=p.physical_op_id AND f.year=p.year`)
+	if err != nil {
+		return nil, fmt.Errorf("select previsions %v", err)
+	}
+	var (
+		year      int
+		remaining int64
+	)
+	for rows.Next() {
+		if err = rows.Scan(&opID, &year, &remaining); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan previsions %v", err)
+		}
+		if op, ok := byID[opID]; ok {
+			op.remaining[year] = remaining
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows previsions %v", err)
+	}
+	return ops, nil
+}
+
+// proposeCmtOpLinks scores the candidate operations of the commitments of the
+// temporary table that are not linked and links automatically the ones whose
+// best proposal is above the threshold. The proposals are stored to keep
+// track of the users' decisions.
+func proposeCmtOpLinks(tx *sql.Tx, settings CmtOpMatchSettings) ([]CmtOpProposal, error) {
+	rows, err := tx.Query(`SELECT c.id,c.name,t.op_name,c.beneficiary_code,
+		c.action_id,c.value,EXTRACT(YEAR FROM c.date)::int FROM temp_commitment t
+		JOIN financial_commitment c ON t.iris_code=c.iris_code
+			AND t.coriolis_year=c.coriolis_year
+			AND t.coriolis_egt_code=c.coriolis_egt_code
+			AND t.coriolis_egt_num=c.coriolis_egt_num
+			AND t.coriolis_egt_line=c.coriolis_egt_line AND t.date=c.date
+		WHERE c.physical_op_id IS NULL ORDER BY 1`)
+	if err != nil {
+		return nil, fmt.Errorf("select commitments %v", err)
+	}
+	var cmts []matchCmt
+	for rows.Next() {
+		var c matchCmt
+		if err = rows.Scan(&c.ID, &c.Name, &c.IRISOpName, &c.BeneficiaryCode,
+			&c.ActionID, &c.Value, &c.Year); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan commitments %v", err)
+		}
+		cmts = append(cmts, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows commitments %v", err)
+	}
+	proposals := []CmtOpProposal{}
+	if len(cmts) == 0 {
+		return proposals, nil
+	}
+	ops, err := fetchMatchOps(tx)
+	if err != nil {
+		return nil, err
+	}
+	feedback, err := fetchCmtOpFeedback(tx)
+	if err != nil {
+		return nil, err
+	}
+	m := newCmtOpMatcher(ops, settings, feedback)
+	stmt, err := tx.Prepare(`UPDATE financial_commitment SET physical_op_id=$1
+	WHERE id=$2 AND physical_op_id IS NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("prepare stmt %v", err)
+	}
+	defer stmt.Close()
+	for i := range cmts {
+		p := m.propose(&cmts[i])
+		if len(p) > 0 && p[0].AutoLinked {
+			if _, err = stmt.Exec(p[0].OpID, p[0].CommitmentID); err != nil {
+				return nil, fmt.Errorf("auto link %v", err)
+			}
+		}
+		proposals = append(proposals, p...)
+	}
+	if err = saveCmtOpProposals(tx, proposals); err != nil {
+		return nil, err
+	}
+	return proposals, nil
+}
@@ -0,0 +1,207 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{in: "Élargissement de l'A86 — Œuvre", want: "ELARGISSEMENT DE L A86 OEUVRE"},
+		{in: "  pont   de Rouen ", want: "PONT DE ROUEN"},
+		{in: "Gare/RER-B (tranche 2)", want: "GARE RER B TRANCHE 2"},
+		{in: "ÇÀÂÄÈÊËÎÏÔÖÙÛÜ", want: "CAAAEEEIIOOUUU"},
+		{in: "", want: ""},
+		{in: "--;;", want: ""},
+	}
+	for _, c := range cases {
+		if got := normalizeName(c.in); got != c.want {
+			t.Errorf("normalizeName(%q) : attendu %q, reçu %q", c.in, c.want, got)
+		}
+	}
+}
+
+func TestNameTokens(t *testing.T) {
+	got := nameTokens(normalizeName("Création d'une gare sur la ligne 15 et du RER"))
+	want := []string{"CREATION", "GARE", "LIGNE", "RER"}
+	if len(got) != len(want) {
+		t.Errorf("nameTokens : attendu %v, reçu %v", want, got)
+	}
+	for _, w := range want {
+		if !got[w] {
+			t.Errorf("nameTokens : %q attendu dans %v", w, got)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want float64
+	}{
+		{a: "PONT DE ROUEN", b: "PONT DE ROUEN", want: 1},
+		{a: "", b: "", want: 1},
+		{a: "ABCD", b: "", want: 0},
+		{a: "ABCD", b: "ABCE", want: 0.75},
+		{a: "ABCD", b: "ABC", want: 0.75},
+		{a: "ABCD", b: "WXYZ", want: 0},
+		{a: "ÉTÉ", b: "ETE", want: 1 - 2.0/3},
+	}
+	for _, c := range cases {
+		got := similarity(c.a, c.b)
+		if math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("similarity(%q,%q) : attendu %v, reçu %v", c.a, c.b, c.want, got)
+		}
+		if rev := similarity(c.b, c.a); rev != got {
+			t.Errorf("similarity(%q,%q) non symétrique : %v et %v", c.a, c.b, got, rev)
+		}
+	}
+}
+
+// testMatchOp returns an operation with the remaining prevision on 2020.
+func testMatchOp(id int64, number, name string, actionID int64,
+	beneficiaries []int, remaining int64) *matchOp {
+	op := matchOp{ID: id, Number: number, Name: name,
+		ActionID:      NullInt64{Valid: actionID != 0, Int64: actionID},
+		beneficiaries: make(map[int]bool), remaining: make(map[int]int64)}
+	for _, b := range beneficiaries {
+		op.beneficiaries[b] = true
+	}
+	if remaining != 0 {
+		op.remaining[2020] = remaining
+	}
+	return &op
+}
+
+// testMatchCmt returns a commitment of 100 on 2020 for the beneficiary 10 and
+// the action 1.
+func testMatchCmt(irisOpName string) *matchCmt {
+	return &matchCmt{ID: 1, Name: "Travaux pont Rouen",
+		IRISOpName:      NullString{Valid: irisOpName != "", String: irisOpName},
+		BeneficiaryCode: 10, ActionID: NullInt64{Valid: true, Int64: 1},
+		Value: 100, Year: 2020}
+}
+
+func TestCmtOpMatchScore(t *testing.T) {
+	cases := []struct {
+		name    string
+		iris    string
+		op      *matchOp
+		aliases map[string]int64
+		want    float64
+		count   int
+	}{
+		{name: "tous les signaux", iris: "Pont de Rouen",
+			op: testMatchOp(1, "OP1", "Pont de Rouen", 1, []int{10}, 1000), want: 1,
+			count: 5},
+		{name: "tous les signaux sans nom IRIS",
+			op: testMatchOp(1, "OP1", "Pont de Rouen", 1, []int{10}, 1000), want: 1,
+			count: 4},
+		{name: "nom IRIS seul", iris: "Pont de Rouen",
+			op: testMatchOp(1, "OP1", "Pont de Rouen", 0, nil, 0), want: 0.55,
+			count: 2},
+		{name: "nom IRIS éloigné ignoré", iris: "Tramway T9",
+			op: testMatchOp(1, "OP1", "Pont de Rouen", 0, nil, 0), want: 0.2,
+			count: 1},
+		{name: "nom IRIS proche", iris: "Pont de Rouen 2",
+			op: testMatchOp(1, "OP1", "Pont de Rouen", 0, nil, 0), want: 0.5,
+			count: 2},
+		{name: "alias validé", iris: "Franchissement Seine",
+			aliases: map[string]int64{"FRANCHISSEMENT SEINE": 1},
+			op:      testMatchOp(1, "OP1", "Pont de Rouen", 0, nil, 0), want: 0.55,
+			count: 2},
+		{name: "alias d'une autre opération", iris: "Franchissement Seine",
+			aliases: map[string]int64{"FRANCHISSEMENT SEINE": 2},
+			op:      testMatchOp(1, "OP1", "Pont de Rouen", 0, nil, 0), want: 0.2,
+			count: 1},
+		{name: "moitié des mots", op: testMatchOp(1, "OP1", "Pont de Nantes", 0, nil, 0),
+			want: 0.15, count: 1},
+		{name: "action seule", op: testMatchOp(1, "OP1", "Tramway", 1, nil, 0),
+			want: 0.23, count: 1},
+		{name: "bénéficiaire seul", op: testMatchOp(1, "OP1", "Tramway", 0, []int{10}, 0),
+			want: 0.31, count: 1},
+		{name: "montant à moitié couvert",
+			op: testMatchOp(1, "OP1", "Tramway", 0, nil, 50), want: 0.08, count: 1},
+		{name: "prévision épuisée", op: testMatchOp(1, "OP1", "Tramway", 0, nil, -50),
+			want: 0, count: 0},
+	}
+	for _, c := range cases {
+		m := newCmtOpMatcher([]*matchOp{c.op}, CmtOpMatch,
+			&cmtOpFeedback{aliases: c.aliases})
+		got, explanation := m.score(testMatchCmt(c.iris), c.op)
+		if got != c.want {
+			t.Errorf("%s : score attendu %v, reçu %v %v", c.name, c.want, got, explanation)
+		}
+		if len(explanation) != c.count {
+			t.Errorf("%s : %d explications attendues, reçu %v", c.name, c.count, explanation)
+		}
+	}
+}
+
+func TestCmtOpMatchPropose(t *testing.T) {
+	// With the IRIS op name and the words, the beneficiary gives 0.75 and the
+	// remaining prevision adds up to 0.1
+	cases := []struct {
+		name     string
+		ops      []*matchOp
+		rejected [][2]int64
+		want     []string
+		scores   []float64
+		auto     bool
+	}{
+		{name: "seuil atteint seul",
+			ops:  []*matchOp{testMatchOp(1, "OP1", "Pont de Rouen", 0, []int{10}, 100)},
+			want: []string{"OP1"}, scores: []float64{0.85}, auto: true},
+		{name: "sous le seuil seul",
+			ops:  []*matchOp{testMatchOp(1, "OP1", "Pont de Rouen", 0, []int{10}, 90)},
+			want: []string{"OP1"}, scores: []float64{0.84}},
+		{name: "seuil et marge atteints",
+			ops: []*matchOp{testMatchOp(1, "OP1", "Pont de Rouen", 0, []int{10}, 100),
+				testMatchOp(2, "OP2", "Pont de Rouen", 0, []int{10}, 0)},
+			want: []string{"OP1", "OP2"}, scores: []float64{0.85, 0.75}, auto: true},
+		{name: "marge insuffisante",
+			ops: []*matchOp{testMatchOp(1, "OP1", "Pont de Rouen", 0, []int{10}, 100),
+				testMatchOp(2, "OP2", "Pont de Rouen", 0, []int{10}, 20)},
+			want: []string{"OP1", "OP2"}, scores: []float64{0.85, 0.77}},
+		{name: "paire rejetée écartée",
+			ops: []*matchOp{testMatchOp(1, "OP1", "Pont de Rouen", 0, []int{10}, 100),
+				testMatchOp(2, "OP2", "Pont de Rouen", 1, []int{10}, 100)},
+			rejected: [][2]int64{{1, 2}},
+			want:     []string{"OP1"}, scores: []float64{0.85}, auto: true},
+		{name: "score minimum",
+			ops: []*matchOp{testMatchOp(1, "OP1", "Pont de Rouen", 0, []int{10}, 100),
+				testMatchOp(2, "OP2", "Tramway", 1, nil, 0)},
+			want: []string{"OP1"}, scores: []float64{0.85}, auto: true},
+		{name: "égalité triée par numéro et tronquée",
+			ops: []*matchOp{testMatchOp(4, "OP4", "Pont de Rouen", 1, []int{10}, 100),
+				testMatchOp(2, "OP2", "Pont de Rouen", 1, []int{10}, 100),
+				testMatchOp(3, "OP3", "Pont de Rouen", 1, []int{10}, 100),
+				testMatchOp(1, "OP1", "Pont de Rouen", 1, []int{10}, 100)},
+			want: []string{"OP1", "OP2", "OP3"}, scores: []float64{1, 1, 1}},
+	}
+	for _, c := range cases {
+		feedback := cmtOpFeedback{rejected: make(map[[2]int64]bool)}
+		for _, r := range c.rejected {
+			feedback.rejected[r] = true
+		}
+		m := newCmtOpMatcher(c.ops, CmtOpMatch, &feedback)
+		// Candidates come from a map so the order is checked on several runs
+		for i := 0; i < 10; i++ {
+			got := m.propose(testMatchCmt("Pont de Rouen"))
+			if len(got) != len(c.want) {
+				t.Fatalf("%s : %d propositions attendues, reçu %+v", c.name, len(c.want), got)
+			}
+			for j, p := range got {
+				if p.OpNumber != c.want[j] || p.Score != c.scores[j] {
+					t.Errorf("%s : attendu %s à %v en position %d, reçu %s à %v", c.name,
+						c.want[j], c.scores[j], j, p.OpNumber, p.Score)
+				}
+				if p.AutoLinked != (j == 0 && c.auto) {
+					t.Errorf("%s : lien automatique de %s incorrect", c.name, p.OpNumber)
+				}
+			}
+		}
+	}
+}
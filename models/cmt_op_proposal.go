@@ -0,0 +1,270 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Decisions of the users on the link proposals
+const (
+	ProposalAccepted = "accepted"
+	ProposalRejected = "rejected"
+)
+
+// OpNameAlias model links an IRIS op name, normalized, to the physical
+// operation chosen by the users when validating proposals
+type OpNameAlias struct {
+	ID           int64     `json:"id"`
+	IRISOpName   string    `json:"iris_op_name"`
+	PhysicalOpID int64     `json:"physical_op_id"`
+	OpNumber     string    `json:"op_number"`
+	OpName       string    `json:"op_name"`
+	Count        int64     `json:"count"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// OpNameAliases embeddes an array of OpNameAlias for json export
+type OpNameAliases struct {
+	Lines []OpNameAlias `json:"OpNameAlias"`
+}
+
+// CmtOpProposalStat gives the outcome of the proposals made during a month.
+// Missed counts the links validated by users that were not proposed.
+type CmtOpProposalStat struct {
+	Month      string      `json:"month"`
+	Proposals  int64       `json:"proposals"`
+	AutoLinked int64       `json:"auto_linked"`
+	Accepted   int64       `json:"accepted"`
+	Rejected   int64       `json:"rejected"`
+	Pending    int64       `json:"pending"`
+	Missed     int64       `json:"missed"`
+	Precision  NullFloat64 `json:"precision"`
+	Recall     NullFloat64 `json:"recall"`
+}
+
+// CmtOpProposalStats embeddes an array of CmtOpProposalStat for json export
+type CmtOpProposalStats struct {
+	Lines []CmtOpProposalStat `json:"CmtOpProposalStat"`
+}
+
+// cmtOpFeedback gathers the past decisions used by the matcher
+type cmtOpFeedback struct {
+	rejected map[[2]int64]bool
+	aliases  map[string]int64
+}
+
+// fetchCmtOpFeedback fetches the rejected pairs and the aliases.
+func fetchCmtOpFeedback(tx *sql.Tx) (*cmtOpFeedback, error) {
+	f := cmtOpFeedback{rejected: make(map[[2]int64]bool),
+		aliases: make(map[string]int64)}
+	rows, err := tx.Query(`SELECT commitment_id,op_id FROM cmt_op_proposal
+	WHERE decision=$1`, ProposalRejected)
+	if err != nil {
+		return nil, fmt.Errorf("select rejected %v", err)
+	}
+	var cmtID, opID int64
+	for rows.Next() {
+		if err = rows.Scan(&cmtID, &opID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan rejected %v", err)
+		}
+		f.rejected[[2]int64{cmtID, opID}] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows rejected %v", err)
+	}
+	rows, err = tx.Query(`SELECT iris_op_name,physical_op_id FROM op_name_alias`)
+	if err != nil {
+		return nil, fmt.Errorf("select aliases %v", err)
+	}
+	var name string
+	for rows.Next() {
+		if err = rows.Scan(&name, &opID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan aliases %v", err)
+		}
+		f.aliases[name] = opID
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows aliases %v", err)
+	}
+	return &f, nil
+}
+
+// saveCmtOpProposals replaces the pending proposals of the commitments by the
+// new ones and sets their IDs. The auto-linked proposals are stored as
+// accepted so that they're measured like the users' decisions.
+func saveCmtOpProposals(tx *sql.Tx, proposals []CmtOpProposal) error {
+	del, err := tx.Prepare(`DELETE FROM cmt_op_proposal WHERE commitment_id=$1
+	AND decision IS NULL`)
+	if err != nil {
+		return fmt.Errorf("prepare delete %v", err)
+	}
+	defer del.Close()
+	ins, err := tx.Prepare(`INSERT INTO cmt_op_proposal (commitment_id,op_id,
+		iris_op_name,score,auto_linked,created_at,decision,decided_at)
+		VALUES($1,$2,$3,$4,$5,$6,CASE WHEN $5 THEN $7 END,
+			CASE WHEN $5 THEN $6::timestamp END) RETURNING id`)
+	if err != nil {
+		return fmt.Errorf("prepare insert %v", err)
+	}
+	defer ins.Close()
+	now, deleted := time.Now(), make(map[int64]bool)
+	for i, p := range proposals {
+		if !deleted[p.CommitmentID] {
+			if _, err = del.Exec(p.CommitmentID); err != nil {
+				return fmt.Errorf("delete %v", err)
+			}
+			deleted[p.CommitmentID] = true
+		}
+		if err = ins.QueryRow(p.CommitmentID, p.OpID,
+			NullString{Valid: p.IRISOpName != "", String: p.IRISOpName}, p.Score,
+			p.AutoLinked, now, ProposalAccepted).Scan(&proposals[i].ID); err != nil {
+			return fmt.Errorf("insert %v", err)
+		}
+	}
+	return nil
+}
+
+// recordCmtOpDecision stores the decision on the link between the commitment
+// and the operation, adding a proposal without score if the link wasn't
+// proposed. Accepting a link rejects the pending proposals of the commitment
+// and the automatic link to another operation. The alias of the IRIS op name of the commitment, or of its last
+// proposal for the commitments imported before it was stored, is updated
+// accordingly.
+func recordCmtOpDecision(tx *sql.Tx, l CmtOpLink, decision string) error {
+	now := time.Now()
+	if _, err := tx.Exec(`WITH upd AS (UPDATE cmt_op_proposal
+		SET decision=$3,decided_at=$4 WHERE commitment_id=$1 AND op_id=$2)
+		INSERT INTO cmt_op_proposal (commitment_id,op_id,decision,created_at,
+			decided_at) SELECT $1,$2,$3,$4,$4 WHERE NOT EXISTS
+			(SELECT 1 FROM cmt_op_proposal WHERE commitment_id=$1 AND op_id=$2)`,
+		l.CommitmentID, l.OpID, decision, now); err != nil {
+		return fmt.Errorf("decision %v", err)
+	}
+	if decision == ProposalAccepted {
+		if _, err := tx.Exec(`UPDATE cmt_op_proposal SET decision=$3,decided_at=$4
+			WHERE commitment_id=$1 AND op_id<>$2 AND (decision IS NULL
+				OR (auto_linked AND decision=$5))`,
+			l.CommitmentID, l.OpID, ProposalRejected, now, ProposalAccepted); err != nil {
+			return fmt.Errorf("other proposals %v", err)
+		}
+	}
+	var name NullString
+	err := tx.QueryRow(`SELECT COALESCE(f.iris_op_name,(SELECT p.iris_op_name
+		FROM cmt_op_proposal p WHERE p.commitment_id=f.id
+			AND p.iris_op_name IS NOT NULL ORDER BY p.created_at DESC LIMIT 1))
+		FROM financial_commitment f WHERE f.id=$1`,
+		l.CommitmentID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("select iris op name %v", err)
+	}
+	irisOpName := normalizeName(name.String)
+	if irisOpName == "" {
+		return nil
+	}
+	if decision == ProposalRejected {
+		_, err = tx.Exec(`DELETE FROM op_name_alias WHERE iris_op_name=$1
+			AND physical_op_id=$2`, irisOpName, l.OpID)
+	} else {
+		_, err = tx.Exec(`INSERT INTO op_name_alias (iris_op_name,physical_op_id,
+			count,updated_at) VALUES($1,$2,1,$3)
+		ON CONFLICT (iris_op_name) DO UPDATE SET
+			physical_op_id=EXCLUDED.physical_op_id,
+			count=CASE WHEN op_name_alias.physical_op_id=EXCLUDED.physical_op_id
+				THEN op_name_alias.count+1 ELSE 1 END,
+			updated_at=EXCLUDED.updated_at`, irisOpName, l.OpID, now)
+	}
+	if err != nil {
+		return fmt.Errorf("alias %v", err)
+	}
+	return nil
+}
+
+// GetAll fetches all aliases of IRIS op names from database.
+func (o *OpNameAliases) GetAll(db *sql.DB) error {
+	rows, err := db.Query(`SELECT a.id,a.iris_op_name,a.physical_op_id,op.number,
+	op.name,a.count,a.updated_at FROM op_name_alias a
+	JOIN physical_op op ON a.physical_op_id=op.id ORDER BY 2`)
+	if err != nil {
+		return err
+	}
+	var r OpNameAlias
+	defer rows.Close()
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.IRISOpName, &r.PhysicalOpID, &r.OpNumber,
+			&r.OpName, &r.Count, &r.UpdatedAt); err != nil {
+			return err
+		}
+		o.Lines = append(o.Lines, r)
+	}
+	err = rows.Err()
+	if len(o.Lines) == 0 {
+		o.Lines = []OpNameAlias{}
+	}
+	return err
+}
+
+// Delete removes an alias of IRIS op name from database.
+func (o *OpNameAlias) Delete(db *sql.DB) error {
+	res, err := db.Exec(`DELETE FROM op_name_alias WHERE id=$1`, o.ID)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return errors.New("Alias introuvable")
+	}
+	return nil
+}
+
+// GetAll fetches the monthly statistics of the proposals from database. The
+// precision is the share of accepted proposals among the decided ones and the
+// recall the share of validated links that were proposed, the automatic links
+// counting as accepted unless a user linked the commitment elsewhere.
+func (c *CmtOpProposalStats) GetAll(db *sql.DB) error {
+	rows, err := db.Query(`SELECT to_char(created_at,'YYYY-MM'),
+	COUNT(*) FILTER (WHERE score IS NOT NULL),
+	COUNT(*) FILTER (WHERE auto_linked),
+	COUNT(*) FILTER (WHERE score IS NOT NULL AND decision=$1),
+	COUNT(*) FILTER (WHERE score IS NOT NULL AND decision=$2),
+	COUNT(*) FILTER (WHERE score IS NOT NULL AND decision IS NULL),
+	COUNT(*) FILTER (WHERE score IS NULL AND decision=$1)
+	FROM cmt_op_proposal GROUP BY 1 ORDER BY 1`, ProposalAccepted, ProposalRejected)
+	if err != nil {
+		return err
+	}
+	var r CmtOpProposalStat
+	defer rows.Close()
+	for rows.Next() {
+		if err = rows.Scan(&r.Month, &r.Proposals, &r.AutoLinked, &r.Accepted,
+			&r.Rejected, &r.Pending, &r.Missed); err != nil {
+			return err
+		}
+		r.Precision, r.Recall = NullFloat64{}, NullFloat64{}
+		if decided := r.Accepted + r.Rejected; decided > 0 {
+			r.Precision = NullFloat64{Valid: true,
+				Float64: float64(r.Accepted) / float64(decided)}
+		}
+		if links := r.Accepted + r.Missed; links > 0 {
+			r.Recall = NullFloat64{Valid: true,
+				Float64: float64(r.Accepted) / float64(links)}
+		}
+		c.Lines = append(c.Lines, r)
+	}
+	err = rows.Err()
+	if len(c.Lines) == 0 {
+		c.Lines = []CmtOpProposalStat{}
+	}
+	return err
+}
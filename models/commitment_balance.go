@@ -0,0 +1,298 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CommitmentBalance gives the payments state of a commitment: the amount paid,
+// the remaining to pay and the days until its lapse date. A commitment is at
+// risk if it isn't closed and will lapse within the horizon with an unpaid
+// balance.
+type CommitmentBalance struct {
+	ID              int64       `json:"id"`
+	IrisCode        string      `json:"iris_code"`
+	Name            string      `json:"name"`
+	BeneficiaryCode int         `json:"beneficiary_code"`
+	Beneficiary     string      `json:"beneficiary"`
+	PhysicalOpID    NullInt64   `json:"physical_op_id"`
+	OpNumber        NullString  `json:"op_number"`
+	OpName          NullString  `json:"op_name"`
+	ActionID        NullInt64   `json:"action_id"`
+	ActionName      NullString  `json:"action_name"`
+	Date            time.Time   `json:"date"`
+	Value           int64       `json:"value"`
+	Paid            int64       `json:"paid"`
+	Remaining       int64       `json:"remaining"`
+	PaidRatio       NullFloat64 `json:"paid_ratio"`
+	LapseDate       NullTime    `json:"lapse_date"`
+	DaysToLapse     NullInt64   `json:"days_to_lapse"`
+	AtRisk          bool        `json:"at_risk"`
+	Closed          bool        `json:"closed"`
+	ClosedAt        NullTime    `json:"closed_at"`
+	ReleasedValue   NullInt64   `json:"released_value"`
+}
+
+// CommitmentBalances embeddes an array of CommitmentBalance for json export
+type CommitmentBalances struct {
+	Lines []CommitmentBalance `json:"CommitmentBalance"`
+}
+
+// CommitmentBalanceFilter restricts the at risk commitments. Horizon is the
+// number of days before the lapse date.
+type CommitmentBalanceFilter struct {
+	Horizon         int64
+	OpID            NullInt64
+	BeneficiaryCode NullInt64
+	ActionID        NullInt64
+}
+
+// CommitmentRisk gives the at risk commitments of an operation, a beneficiary
+// or a budget action
+type CommitmentRisk struct {
+	ID        NullInt64 `json:"id"`
+	Name      string    `json:"name"`
+	Count     int64     `json:"count"`
+	Remaining int64     `json:"remaining"`
+}
+
+// CommitmentRisks embeddes an array of CommitmentRisk for json export
+type CommitmentRisks struct {
+	Lines []CommitmentRisk `json:"CommitmentRisk"`
+}
+
+// CommitmentClosure model marks a commitment as closed (soldé) and keeps the
+// remaining amount released by the closure
+type CommitmentClosure struct {
+	ID            int64      `json:"id"`
+	CommitmentID  int64      `json:"commitment_id"`
+	IrisCode      string     `json:"iris_code"`
+	Name          string     `json:"name"`
+	ClosedAt      time.Time  `json:"closed_at"`
+	ReleasedValue int64      `json:"released_value"`
+	Comment       NullString `json:"comment"`
+	UserID        NullInt64  `json:"users_id"`
+}
+
+// CommitmentClosures embeddes an array of CommitmentClosure for json export
+type CommitmentClosures struct {
+	Lines []CommitmentClosure `json:"CommitmentClosure"`
+}
+
+// CommitmentRiskGroups are the allowed groupings of the at risk commitments
+var CommitmentRiskGroups = map[string]string{
+	"op":          `k.physical_op_id,COALESCE(k.op_number||' - '||k.op_name,'Sans opération')`,
+	"beneficiary": `k.beneficiary_code::bigint,k.beneficiary`,
+	"action":      `k.action_id,COALESCE(k.action_name,'Sans action')`,
+}
+
+// commitmentBalanceQry computes the balance of commitments using $1 as the
+// horizon in days of the lapse risk
+const commitmentBalanceQry = `WITH b AS (SELECT f.id,f.iris_code,f.name,
+	f.beneficiary_code,COALESCE(be.name,'') AS beneficiary,f.physical_op_id,
+	op.number AS op_number,op.name AS op_name,f.action_id,ba.name AS action_name,
+	f.date,f.value,COALESCE(p.paid,0) AS paid,
+	CASE WHEN c.id ISNULL THEN f.value-COALESCE(p.paid,0) ELSE 0 END AS remaining,
+	f.lapse_date,f.lapse_date-current_date AS days_to_lapse,c.id NOTNULL AS closed,
+	c.closed_at,c.released_value
+	FROM financial_commitment f
+	LEFT JOIN beneficiary be ON f.beneficiary_code=be.code
+	LEFT JOIN physical_op op ON f.physical_op_id=op.id
+	LEFT JOIN budget_action ba ON f.action_id=ba.id
+	LEFT JOIN (SELECT financial_commitment_id,SUM(value-cancelled_value) AS paid
+		FROM payment WHERE financial_commitment_id NOTNULL GROUP BY 1) p
+		ON p.financial_commitment_id=f.id
+	LEFT JOIN commitment_closure c ON c.commitment_id=f.id),
+	r AS (SELECT b.*,NOT b.closed AND b.remaining>0 AND b.lapse_date NOTNULL
+		AND b.days_to_lapse BETWEEN 0 AND $1 AS at_risk FROM b)
+	SELECT r.id,r.iris_code,r.name,r.beneficiary_code,r.beneficiary,
+	r.physical_op_id,r.op_number,r.op_name,r.action_id,r.action_name,r.date,
+	r.value,r.paid,r.remaining,r.lapse_date,r.days_to_lapse,r.at_risk,r.closed,
+	r.closed_at,r.released_value FROM r `
+
+// rightsCondition restricts the commitments to the operations the user has
+// rights on, uID being 0 for an admin.
+func rightsCondition(uID int64) string {
+	if uID == 0 {
+		return ""
+	}
+	return ` AND r.physical_op_id IN (SELECT physical_op_id FROM rights
+		WHERE users_id=` + strconv.FormatInt(uID, 10) + `)`
+}
+
+// scan scans a row of the commitment balance query
+func (c *CommitmentBalance) scan(rows *sql.Rows) error {
+	if err := rows.Scan(&c.ID, &c.IrisCode, &c.Name, &c.BeneficiaryCode,
+		&c.Beneficiary, &c.PhysicalOpID, &c.OpNumber, &c.OpName, &c.ActionID,
+		&c.ActionName, &c.Date, &c.Value, &c.Paid, &c.Remaining, &c.LapseDate,
+		&c.DaysToLapse, &c.AtRisk, &c.Closed, &c.ClosedAt,
+		&c.ReleasedValue); err != nil {
+		return err
+	}
+	c.PaidRatio = NullFloat64{}
+	if c.Value > 0 {
+		c.PaidRatio = NullFloat64{Valid: true, Float64: float64(c.Paid) / float64(c.Value)}
+	}
+	return nil
+}
+
+// Get fetches the balance of a commitment from database using its ID.
+func (c *CommitmentBalance) Get(horizon int64, uID int64, db *sql.DB) error {
+	rows, err := db.Query(commitmentBalanceQry+`WHERE r.id=$2`+rightsCondition(uID),
+		horizon, c.ID)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("rows err %v", err)
+		}
+		return errors.New("Engagement introuvable")
+	}
+	if err = c.scan(rows); err != nil {
+		return fmt.Errorf("scan %v", err)
+	}
+	return nil
+}
+
+// GetAtRisk fetches the at risk commitments matching the filter, the nearest
+// lapse dates first.
+func (c *CommitmentBalances) GetAtRisk(f *CommitmentBalanceFilter, uID int64,
+	db *sql.DB) error {
+	rows, err := db.Query(commitmentBalanceQry+`WHERE r.at_risk
+	AND ($2::bigint ISNULL OR r.physical_op_id=$2)
+	AND ($3::bigint ISNULL OR r.beneficiary_code=$3)
+	AND ($4::bigint ISNULL OR r.action_id=$4)`+rightsCondition(uID)+
+		` ORDER BY r.lapse_date,r.id`, f.Horizon, f.OpID, f.BeneficiaryCode,
+		f.ActionID)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	var r CommitmentBalance
+	defer rows.Close()
+	for rows.Next() {
+		if err = r.scan(rows); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		c.Lines = append(c.Lines, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows err %v", err)
+	}
+	if len(c.Lines) == 0 {
+		c.Lines = []CommitmentBalance{}
+	}
+	return nil
+}
+
+// GetByGroup fetches the count and the remaining to pay of the at risk
+// commitments grouped by operation, beneficiary or budget action.
+func (c *CommitmentRisks) GetByGroup(group string, horizon int64, uID int64,
+	db *sql.DB) error {
+	fields, ok := CommitmentRiskGroups[group]
+	if !ok {
+		return fmt.Errorf("regroupement %s inconnu", group)
+	}
+	k := `WITH k AS (` + commitmentBalanceQry + `WHERE r.at_risk` +
+		rightsCondition(uID) + `)`
+	rows, err := db.Query(k+` SELECT `+fields+`,count(1),SUM(k.remaining)::bigint
+	FROM k GROUP BY 1,2 ORDER BY 4 DESC,2`, horizon)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	var r CommitmentRisk
+	defer rows.Close()
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.Name, &r.Count, &r.Remaining); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		c.Lines = append(c.Lines, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows err %v", err)
+	}
+	if len(c.Lines) == 0 {
+		c.Lines = []CommitmentRisk{}
+	}
+	return nil
+}
+
+// Validate checks if fields are correctly formed.
+func (c *CommitmentClosure) Validate() error {
+	if c.CommitmentID == 0 {
+		return errors.New("Champ commitment_id incorrect")
+	}
+	if c.ClosedAt.IsZero() {
+		return errors.New("Champ closed_at incorrect")
+	}
+	return nil
+}
+
+// Create closes the commitment storing the remaining to pay as the released
+// amount.
+func (c *CommitmentClosure) Create(db *sql.DB) error {
+	err := db.QueryRow(`INSERT INTO commitment_closure (commitment_id,closed_at,
+		released_value,comment,users_id,created_at)
+	SELECT f.id,$2,GREATEST(f.value-COALESCE(SUM(p.value-p.cancelled_value),0),0),
+		$3,$4,$5 FROM financial_commitment f
+	LEFT JOIN payment p ON p.financial_commitment_id=f.id
+	WHERE f.id=$1 GROUP BY f.id
+	ON CONFLICT (commitment_id) DO NOTHING
+	RETURNING id,released_value`, c.CommitmentID, c.ClosedAt, c.Comment, c.UserID,
+		time.Now()).Scan(&c.ID, &c.ReleasedValue)
+	if err == sql.ErrNoRows {
+		return errors.New("Engagement introuvable ou déjà soldé")
+	}
+	if err != nil {
+		return err
+	}
+	return db.QueryRow(`SELECT iris_code,name FROM financial_commitment WHERE id=$1`,
+		c.CommitmentID).Scan(&c.IrisCode, &c.Name)
+}
+
+// Delete reopens the commitment removing its closure.
+func (c *CommitmentClosure) Delete(db *sql.DB) error {
+	res, err := db.Exec(`DELETE FROM commitment_closure WHERE commitment_id=$1`,
+		c.CommitmentID)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return errors.New("Engagement non soldé")
+	}
+	return nil
+}
+
+// GetAll fetches the closed commitments of the operations the user has rights
+// on from database, uID being 0 for an admin.
+func (c *CommitmentClosures) GetAll(uID int64, db *sql.DB) error {
+	rows, err := db.Query(`SELECT c.id,c.commitment_id,r.iris_code,r.name,
+	c.closed_at,c.released_value,c.comment,c.users_id FROM commitment_closure c
+	JOIN financial_commitment r ON c.commitment_id=r.id` + rightsCondition(uID) +
+		` ORDER BY c.closed_at,1`)
+	if err != nil {
+		return err
+	}
+	var r CommitmentClosure
+	defer rows.Close()
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.CommitmentID, &r.IrisCode, &r.Name, &r.ClosedAt,
+			&r.ReleasedValue, &r.Comment, &r.UserID); err != nil {
+			return err
+		}
+		c.Lines = append(c.Lines, r)
+	}
+	err = rows.Err()
+	if len(c.Lines) == 0 {
+		c.Lines = []CommitmentClosure{}
+	}
+	return err
+}
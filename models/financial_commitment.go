@@ -130,7 +130,7 @@ type FinancialCommitmentLine struct {
 	Beneficiary     string     `json:"beneficiary"`
 	BeneficiaryCode int        `json:"beneficiary_code"`
 	Date            ExcelDate  `json:"date"`
-	Value           float64    `json:"value"`
+	Value           Money      `json:"value"`
 	LapseDate       ExcelDate  `json:"lapse_date"`
 	APP             bool       `json:"app"`
 	OpName          NullString `json:"op_name"`
@@ -139,22 +139,31 @@ type FinancialCommitmentLine struct {
 // FinancialCommitmentsBatch embeddes the data sent by a financial commitments batch request.
 type FinancialCommitmentsBatch struct {
 	FinancialCommitments []FinancialCommitmentLine `json:"FinancialCommitment"`
+	SourceTotal          NullMoney                 `json:"SourceTotal"`
 }
 
 // CmtOpProposal is used to propose a link between a newly imported commitment
-// and a physical operation using the name field
+// and a physical operation. The score is the confidence of the proposal and
+// the explanation lists the signals found. AutoLinked is set if the link has
+// been made by the import.
 type CmtOpProposal struct {
-	CommitmentID   int64  `json:"commitment_id"`
-	CommitmentName string `json:"commitment_name"`
-	IRISOpName     string `json:"iris_op_name"`
-	OpID           int64  `json:"op_id"`
-	OpNumber       string `json:"op_number"`
-	OpName         string `json:"op_name"`
+	ID             int64    `json:"id"`
+	CommitmentID   int64    `json:"commitment_id"`
+	CommitmentName string   `json:"commitment_name"`
+	IRISOpName     string   `json:"iris_op_name"`
+	OpID           int64    `json:"op_id"`
+	OpNumber       string   `json:"op_number"`
+	OpName         string   `json:"op_name"`
+	Score          float64  `json:"score"`
+	Explanation    []string `json:"explanation"`
+	AutoLinked     bool     `json:"auto_linked"`
 }
 
-// CmtOpProposals embeddes an array of CmtOpProposal
+// CmtOpProposals embeddes an array of CmtOpProposal and the reconciliation of
+// the import
 type CmtOpProposals struct {
-	Lines []CmtOpProposal `json:"CmtOpProposal"`
+	Lines          []CmtOpProposal `json:"CmtOpProposal"`
+	Reconciliation Reconciliation  `json:"Reconciliation"`
 }
 
 // CmtOpLink is used to link financial commitments and physical operations
@@ -164,9 +173,11 @@ type CmtOpLink struct {
 	OpID         int64 `json:"op_id"`
 }
 
-// CmtOpLinks embeddes an array of CmtOpLink for json upload
+// CmtOpLinks embeddes an array of CmtOpLink for json upload. Lines are the
+// validated links and Rejected the refused proposals.
 type CmtOpLinks struct {
-	Lines []CmtOpLink `json:"CmtOpLink"`
+	Lines    []CmtOpLink `json:"CmtOpLink"`
+	Rejected []CmtOpLink `json:"RejectedCmtOpLink"`
 }
 
 // Unlink set to null financial commitments links to a physical operation in database.
@@ -376,6 +387,53 @@ func (p *PaginatedPlanLineLinkedItems) GetLinked(pattern FCSearchPattern, db *sq
 
 // Save a batch of financial commitments into database.
 func (f *FinancialCommitmentsBatch) Save(db *sql.DB) (*CmtOpProposals, error) {
+	return f.SaveWithProgress(nil, db)
+}
+
+// SaveWithProgress saves a batch of financial commitments into database and
+// calls progress at the beginning of each phase of the import.
+func (f *FinancialCommitmentsBatch) SaveWithProgress(progress ImportProgress,
+	db *sql.DB) (*CmtOpProposals, error) {
+	i := 0
+	return saveFinancialCommitments(func(r *FinancialCommitmentLine) (bool, error) {
+		if i >= len(f.FinancialCommitments) {
+			return false, nil
+		}
+		*r = f.FinancialCommitments[i]
+		i++
+		return true, nil
+	}, func(*sql.Tx) (NullMoney, error) { return f.SourceTotal, nil }, nil,
+		progress, db)
+}
+
+// SaveStream saves into database the financial commitments decoded one by one
+// from the stream. If the guard reports that the content was already imported,
+// the import is rolled back and ErrAlreadyImported returned, otherwise the
+// guard records the proposals within the import transaction.
+func (f *FinancialCommitmentsBatch) SaveStream(s *BatchStream, guard ImportGuard,
+	db *sql.DB) (*CmtOpProposals, error) {
+	return f.SaveStreamWithProgress(s, guard, nil, db)
+}
+
+// SaveStreamWithProgress saves the lines decoded from the stream like
+// SaveStream and calls progress at the beginning of each phase of the import.
+func (f *FinancialCommitmentsBatch) SaveStreamWithProgress(s *BatchStream, guard ImportGuard,
+	progress ImportProgress, db *sql.DB) (*CmtOpProposals, error) {
+	return saveFinancialCommitments(func(r *FinancialCommitmentLine) (bool, error) {
+		*r = FinancialCommitmentLine{}
+		return s.Next(r)
+	}, s.endFunc(guard), guard, progress, db)
+}
+
+// saveFinancialCommitments copies the lines fetched by next into the
+// temporary table and then updates the financial commitments in database.
+// end is called once all lines are read and returns the source total checked
+// against the lines total. The guard, if any, records the proposals before the
+// commit.
+func saveFinancialCommitments(next func(*FinancialCommitmentLine) (bool, error),
+	end func(*sql.Tx) (NullMoney, error), guard ImportGuard,
+	progress ImportProgress, db *sql.DB) (*CmtOpProposals, error) {
+	progress.report(StagingPhase)
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
@@ -392,22 +450,41 @@ func (f *FinancialCommitmentsBatch) Save(db *sql.DB) (*CmtOpProposals, error) {
 		return nil, fmt.Errorf("prepare stmt %v", err)
 	}
 	defer stmt.Close()
-	for _, r := range f.FinancialCommitments {
+	var (
+		r     FinancialCommitmentLine
+		recon Reconciliation
+	)
+	for {
+		ok, err := next(&r)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if !ok {
+			break
+		}
 		if _, err = stmt.Exec(r.Chapter, r.Action, r.IrisCode, r.CoriolisYear,
 			r.CoriolisEgtCode, r.CoriolisEgtNum, r.CoriolisEgtLine, r.Name, r.Beneficiary,
-			r.BeneficiaryCode, r.Date.ToDate(), int64(100*r.Value), r.LapseDate.ToDate(),
+			r.BeneficiaryCode, r.Date.ToDate(), int64(r.Value), r.LapseDate.ToDate(),
 			r.APP, r.OpName); err != nil {
 			tx.Rollback()
 			return nil, fmt.Errorf("insertion de %+v  %v", r, err)
 		}
+		recon.Add(r.Value)
 	}
+	sourceTotal, err := end(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	recon.Check(sourceTotal)
 	if _, err = stmt.Exec(); err != nil {
 		tx.Rollback()
 		return nil, fmt.Errorf("statement exec flush %v", err)
 	}
-	queries := []string{
+	queries := []phaseQuery{
 		// remove duplicated commitment due to IRIS query bug
-		`WITH cnt as (SELECT count(1) cnt,value,beneficiary_code,name
+		{DedupPhase, `WITH cnt as (SELECT count(1) cnt,value,beneficiary_code,name
 				FROM temp_commitment GROUP by 2,3,4),
 			dup as (SELECT value,beneficiary_code,name FROM cnt WHERE cnt.cnt > 1),
 			max_ids as (SELECT id FROM temp_commitment 
@@ -419,10 +496,10 @@ func (f *FinancialCommitmentsBatch) Save(db *sql.DB) (*CmtOpProposals, error) {
 			sing_ids as (SELECT id FROM temp_commitment WHERE (value,beneficiary_code,name) in
 				(SELECT value,beneficiary_code,name FROM cnt WHERE cnt.cnt = 1))
 			DELETE FROM temp_commitment WHERE id not in
-				(SELECT * FROM max_ids union all SELECT * FROM sing_ids)`,
-		`WITH new AS (
+				(SELECT * FROM max_ids union all SELECT * FROM sing_ids)`},
+		{UpdatePhase, `WITH new AS (
 				SELECT f.id,t.chapter,t.action,t.iris_code,t.name,t.beneficiary_code,t.date,
-					t.value,t.lapse_date,t.app
+					t.value,t.lapse_date,t.app,t.op_name
 				FROM temp_commitment t JOIN financial_commitment f ON t.iris_code=f.iris_code
 				 WHERE (f.value<>t.value OR f.chapter<>t.chapter OR f.action<>t.action OR
 								f.name<>t.name OR f.coriolis_year<>t.coriolis_year OR
@@ -430,40 +507,47 @@ func (f *FinancialCommitmentsBatch) Save(db *sql.DB) (*CmtOpProposals, error) {
 								f.coriolis_egt_num<>t.coriolis_egt_num OR
 								f.coriolis_egt_line<>t.coriolis_egt_line OR
 								f.beneficiary_code<>t.beneficiary_code OR
-								f.lapse_date IS DISTINCT FROM t.lapse_date OR f.app<>t.app)
+								f.lapse_date IS DISTINCT FROM t.lapse_date OR f.app<>t.app OR
+								(t.op_name NOTNULL AND f.iris_op_name IS DISTINCT FROM t.op_name))
 								 AND f.date = t.date)
 			UPDATE financial_commitment SET
 			chapter=new.chapter,action=new.action,name=new.name,value=new.value,
-			beneficiary_code=new.beneficiary_code,lapse_date=new.lapse_date,app=new.app
-			FROM new WHERE financial_commitment.id = new.id`,
-		`INSERT INTO financial_commitment (physical_op_id,chapter,action,iris_code,
+			beneficiary_code=new.beneficiary_code,lapse_date=new.lapse_date,app=new.app,
+			iris_op_name=COALESCE(new.op_name,financial_commitment.iris_op_name)
+			FROM new WHERE financial_commitment.id = new.id`},
+		{InsertPhase, `INSERT INTO financial_commitment (physical_op_id,chapter,action,iris_code,
 				coriolis_year,coriolis_egt_code,coriolis_egt_num,coriolis_egt_line,name,
-				beneficiary_code,date,value,lapse_date,app)
+				beneficiary_code,date,value,lapse_date,app,iris_op_name)
 			SELECT NULL as physical_op_id,chapter,action,iris_code,coriolis_year,
 				coriolis_egt_code,coriolis_egt_num,coriolis_egt_line,name,
-				beneficiary_code,date,value,lapse_date,app
+				beneficiary_code,date,value,lapse_date,app,op_name
 				FROM temp_commitment t
-			WHERE (t.iris_code,t.date) NOT IN (SELECT iris_code,date FROM financial_commitment)`,
-		`WITH new AS (
+			WHERE (t.iris_code,t.date) NOT IN (SELECT iris_code,date FROM financial_commitment)`},
+		{InsertPhase, `WITH new AS (
 				SELECT t.beneficiary_code, t.beneficiary, t.date FROM temp_commitment t
 				WHERE t.beneficiary_code NOT IN (SELECT code FROM beneficiary) )
 			INSERT INTO beneficiary (code, name) SELECT beneficiary_code, beneficiary FROM new
-				WHERE (date, beneficiary_code) IN (SELECT Max(date), beneficiary_code FROM temp_commitment GROUP BY 2)`,
-		` WITH duplicated AS (SELECT id from financial_commitment WHERE iris_code IN
+				WHERE (date, beneficiary_code) IN (SELECT Max(date), beneficiary_code FROM temp_commitment GROUP BY 2)`},
+		{LinkingPhase, ` WITH duplicated AS (SELECT id from financial_commitment WHERE iris_code IN
 		(SELECT iris_code FROM financial_commitment WHERE iris_code in
 			(SELECT iris_code FROM
 				(SELECT SUM(1) as count, iris_code FROM financial_commitment GROUP BY 2) fcCount WHERE fcCount.count > 1)
 						AND coriolis_egt_line <> '1') AND coriolis_egt_line = '1')
-	UPDATE financial_commitment SET value = 0 FROM duplicated WHERE financial_commitment.id=duplicated.id`,
-		`WITH correspond AS (SELECT fc_extract.fc_id, ba_full.ba_id FROM
+	UPDATE financial_commitment SET value = 0 FROM duplicated WHERE financial_commitment.id=duplicated.id`},
+		{LinkingPhase, `WITH correspond AS (SELECT fc_extract.fc_id, ba_full.ba_id FROM
 		(SELECT fc.id AS fc_id, substring (fc.action FROM '^[0-9sS]+') AS fc_action FROM financial_commitment fc) fc_extract,
 	(SELECT ba.id AS ba_id, bp.code_contract || bp.code_function || bp.code_number || ba.code AS ba_code
 	FROM budget_action ba, budget_program bp WHERE ba.program_id = bp.id) ba_full
 	WHERE fc_extract.fc_action = ba_full.ba_code)
 	UPDATE financial_commitment SET action_id = correspond.ba_id
-	FROM correspond WHERE financial_commitment.id = correspond.fc_id`}
+	FROM correspond WHERE financial_commitment.id = correspond.fc_id`}}
+	phase := StagingPhase
 	for _, qry := range queries {
-		if _, err := tx.Exec(qry); err != nil {
+		if qry.Phase != phase {
+			phase = qry.Phase
+			progress.report(phase)
+		}
+		if _, err := tx.Exec(qry.Query); err != nil {
 			tx.Rollback()
 			return nil, err
 		}
@@ -475,49 +559,20 @@ func (f *FinancialCommitmentsBatch) Save(db *sql.DB) (*CmtOpProposals, error) {
 		tx.Rollback()
 		return nil, err
 	}
-	rows, err := tx.Query(
-		`SELECT c.id,c.name,t.op_name,op.id,op.number,op.name FROM temp_commitment t
-		JOIN financial_commitment c ON t.iris_code=c.iris_code
-			AND t.coriolis_year=c.coriolis_year
-			AND t.coriolis_egt_code=c.coriolis_egt_code
-			AND t.coriolis_egt_num=c.coriolis_egt_num
-			AND t.coriolis_egt_line=c.coriolis_egt_line AND t.date=c.date
-		JOIN physical_op op ON t.op_name=op.name
-		WHERE c.physical_op_id IS NULL
-		UNION ALL
-		SELECT c.id,c.name,t.op_name,op.id,op.number,op.name FROM temp_commitment t
-		JOIN financial_commitment c ON t.iris_code=c.iris_code
-			AND t.coriolis_year=c.coriolis_year
-			AND t.coriolis_egt_code=c.coriolis_egt_code
-			AND t.coriolis_egt_num=c.coriolis_egt_num
-			AND t.coriolis_egt_line=c.coriolis_egt_line AND t.date=c.date
-		JOIN physical_op op ON t.op_name<>op.name AND levenshtein(t.op_name,op.name)<2
-		WHERE c.physical_op_id IS NULL`)
+	lines, err := proposeCmtOpLinks(tx, CmtOpMatch)
 	if err != nil {
 		tx.Rollback()
-		return nil, fmt.Errorf("select %v", err)
-	}
-	var (
-		line CmtOpProposal
-		resp CmtOpProposals
-	)
-	for rows.Next() {
-		if err = rows.Scan(&line.CommitmentID, &line.CommitmentName, &line.IRISOpName,
-			&line.OpID, &line.OpNumber, &line.OpName); err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("rows scan %v", err)
-		}
-		resp.Lines = append(resp.Lines, line)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows err %v", err)
-	}
-	if len(resp.Lines) == 0 {
-		resp.Lines = []CmtOpProposal{}
+		return nil, err
 	}
-	if _, err = tx.Query(`DELETE FROM temp_commitment`); err != nil {
+	resp := CmtOpProposals{Lines: lines, Reconciliation: recon}
+	if _, err = tx.Exec(`DELETE FROM temp_commitment`); err != nil {
+		tx.Rollback()
 		return nil, fmt.Errorf("delete %v", err)
 	}
+	if err = recordImport(guard, &resp, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 	err = tx.Commit()
 	return &resp, err
 }
@@ -558,6 +613,8 @@ func (p *UnlinkedFinancialCommitments) GetAll(db *sql.DB) error {
 }
 
 // Save update the financial commitments to link to the physical operations
+// and stores the decisions on the proposals. A rejected link made by the
+// import is removed.
 func (c *CmtOpLinks) Save(db *sql.DB) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -575,7 +632,21 @@ func (c *CmtOpLinks) Save(db *sql.DB) error {
 			tx.Rollback()
 			return fmt.Errorf("statement exec %v", err)
 		}
+		if err = recordCmtOpDecision(tx, l, ProposalAccepted); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
-	tx.Commit()
-	return nil
+	for _, l := range c.Rejected {
+		if _, err = tx.Exec(`UPDATE financial_commitment SET physical_op_id=NULL
+		WHERE id=$1 AND physical_op_id=$2`, l.CommitmentID, l.OpID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unlink %v", err)
+		}
+		if err = recordCmtOpDecision(tx, l, ProposalRejected); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,440 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/Iledant/iris-propera/forecast"
+)
+
+// ForecastLabels are the operation and the budget codes of a line of a
+// projection, the operation being null at the budget action level. The
+// hypothetical operations of a scenario have a null OpID and their own ID in
+// NewOpID.
+type ForecastLabels struct {
+	OpID        NullInt64  `json:"op_id"`
+	NewOpID     NullInt64  `json:"new_op_id"`
+	OpNumber    NullString `json:"op_number"`
+	OpName      NullString `json:"op_name"`
+	ActionID    NullInt64  `json:"action_id"`
+	Chapter     NullInt64  `json:"chapter"`
+	Sector      NullString `json:"sector"`
+	Subfunction NullString `json:"subfunction"`
+	Program     NullString `json:"program"`
+	Action      NullString `json:"action"`
+	ActionName  NullString `json:"action_name"`
+}
+
+// PaymentForecastLine is a line of the projection of payments over a
+// generic horizon, the values being in euros
+type PaymentForecastLine struct {
+	ForecastLabels
+	Values []NullFloat64 `json:"values"`
+}
+
+// PaymentForecast embeddes the years and the lines of a projection of
+// payments for json export
+type PaymentForecast struct {
+	Years []int64               `json:"Years"`
+	Lines []PaymentForecastLine `json:"PaymentForecast"`
+}
+
+// budgetCodes are the codes of a budget action used by the projections
+type budgetCodes struct {
+	Chapter     NullInt64
+	Sector      NullString
+	Function    NullString
+	Subfunction NullString
+	Program     NullString
+	Action      NullString
+	ActionName  NullString
+}
+
+// fullSubfunction returns the function followed by the subfunction if any.
+func (b *budgetCodes) fullSubfunction() NullString {
+	return NullString{Valid: b.Function.Valid,
+		String: b.Function.String + b.Subfunction.String}
+}
+
+// strictSubfunction returns the function followed by the subfunction, null
+// if the subfunction is null.
+func (b *budgetCodes) strictSubfunction() NullString {
+	return NullString{Valid: b.Function.Valid && b.Subfunction.Valid,
+		String: b.Function.String + b.Subfunction.String}
+}
+
+// scanForecastFlows fetches flows using a query returning the operation ID,
+// the budget action ID, the year and the value.
+func scanForecastFlows(db *sql.DB, qry string, args ...interface{}) ([]forecast.Flow, error) {
+	rows, err := db.Query(qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var flows []forecast.Flow
+	var f forecast.Flow
+	for rows.Next() {
+		if err = rows.Scan(&f.OpID, &f.ActionID, &f.Year, &f.Value); err != nil {
+			return nil, err
+		}
+		flows = append(flows, f)
+	}
+	return flows, rows.Err()
+}
+
+// LoadForecastData fetches from database the datas used by the projections.
+// The offsets of the scenario are fetched if sID isn't 0 and the snapshot of
+// an adopted scenario replaces all the datas.
+func LoadForecastData(sID int64, db *sql.DB) (*forecast.Data, error) {
+	if sID != 0 {
+		if d, err := loadScenarioSnapshot(sID, db); err != nil || d != nil {
+			return d, err
+		}
+	}
+	d := forecast.Data{Ops: make(map[int64]forecast.Op),
+		Ratios: make(map[int64][]forecast.Ratio)}
+	rows, err := db.Query(`SELECT id,COALESCE(budget_action_id,0) FROM physical_op`)
+	if err != nil {
+		return nil, fmt.Errorf("select ops %v", err)
+	}
+	var op forecast.Op
+	for rows.Next() {
+		if err = rows.Scan(&op.ID, &op.ActionID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan ops %v", err)
+		}
+		d.Ops[op.ID] = op
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows ops %v", err)
+	}
+	rows, err = db.Query(`SELECT payment_types_id,index,ratio FROM payment_ratios
+	WHERE payment_types_id NOTNULL ORDER BY 1,2`)
+	if err != nil {
+		return nil, fmt.Errorf("select ratios %v", err)
+	}
+	var ptID int64
+	var r forecast.Ratio
+	for rows.Next() {
+		if err = rows.Scan(&ptID, &r.Index, &r.Ratio); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan ratios %v", err)
+		}
+		d.Ratios[ptID] = append(d.Ratios[ptID], r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows ratios %v", err)
+	}
+	if d.Commitments, err = scanForecastFlows(db, `SELECT COALESCE(physical_op_id,0),
+	CASE WHEN physical_op_id ISNULL THEN COALESCE(action_id,0) ELSE 0 END,
+	EXTRACT(year FROM date)::bigint,SUM(value)::double precision
+	FROM financial_commitment GROUP BY 1,2,3`); err != nil {
+		return nil, fmt.Errorf("select commitments %v", err)
+	}
+	if d.Programmings, err = scanForecastFlows(db, `SELECT physical_op_id,0,year,
+	SUM(value)::double precision FROM programmings GROUP BY 1,3`); err != nil {
+		return nil, fmt.Errorf("select programmings %v", err)
+	}
+	if d.PrevCommitments, err = scanForecastFlows(db, `SELECT physical_op_id,0,year,
+	value::double precision FROM prev_commitment`); err != nil {
+		return nil, fmt.Errorf("select prev commitments %v", err)
+	}
+	if d.PrevPayments, err = scanForecastFlows(db, `SELECT physical_op_id,0,year,
+	value::double precision FROM prev_payment WHERE value NOTNULL AND value<>0`); err != nil {
+		return nil, fmt.Errorf("select prev payments %v", err)
+	}
+	if sID == 0 {
+		return &d, nil
+	}
+	sd, err := loadScenarioDatas(sID, db)
+	if err != nil {
+		return nil, err
+	}
+	return sd.apply(&d), nil
+}
+
+// scenarioDatas gathers the offsets and the levers of the operations of a
+// scenario and its hypothetical operations whose IDs are the opposite of the
+// IDs of scenario_new_op
+type scenarioDatas struct {
+	Offsets     map[int64]int64
+	Levers      map[int64]forecast.Lever
+	Ops         []forecast.Op
+	Commitments []forecast.Flow
+}
+
+// loadScenarioDatas fetches the offsets, the levers and the hypothetical
+// operations of a scenario.
+func loadScenarioDatas(sID int64, db *sql.DB) (*scenarioDatas, error) {
+	rows, err := db.Query(`SELECT s.physical_op_id,s.offset,s.scale,s.cancelled,s.cap
+	FROM scenario_offset s WHERE s.scenario_id=$1`, sID)
+	if err != nil {
+		return nil, fmt.Errorf("select offsets %v", err)
+	}
+	defer rows.Close()
+	sd := scenarioDatas{Offsets: make(map[int64]int64),
+		Levers: make(map[int64]forecast.Lever)}
+	var opID, offset int64
+	var l forecast.Lever
+	var cap NullInt64
+	for rows.Next() {
+		if err = rows.Scan(&opID, &offset, &l.Scale, &l.Cancelled, &cap); err != nil {
+			return nil, fmt.Errorf("scan offsets %v", err)
+		}
+		sd.Offsets[opID] = offset
+		l.Capped, l.Cap = cap.Valid, float64(cap.Int64)
+		if l.Scale != 1 || l.Cancelled || l.Capped {
+			sd.Levers[opID] = l
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows offsets %v", err)
+	}
+	rows, err = db.Query(`SELECT -n.id,COALESCE(n.budget_action_id,0),c.year,
+	c.value::double precision FROM scenario_new_op n
+	LEFT JOIN scenario_new_op_commitment c ON c.new_op_id=n.id
+	WHERE n.scenario_id=$1`, sID)
+	if err != nil {
+		return nil, fmt.Errorf("select new ops %v", err)
+	}
+	defer rows.Close()
+	var op forecast.Op
+	var year NullInt64
+	var value NullFloat64
+	for rows.Next() {
+		if err = rows.Scan(&op.ID, &op.ActionID, &year, &value); err != nil {
+			return nil, fmt.Errorf("scan new ops %v", err)
+		}
+		if _, ok := sd.Offsets[op.ID]; !ok {
+			sd.Offsets[op.ID] = 0
+			sd.Ops = append(sd.Ops, op)
+		}
+		if year.Valid {
+			sd.Commitments = append(sd.Commitments, forecast.Flow{OpID: op.ID,
+				Year: year.Int64, Value: value.Float64})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows new ops %v", err)
+	}
+	return &sd, nil
+}
+
+// apply returns a copy of the datas using the scenario.
+func (sd *scenarioDatas) apply(d *forecast.Data) *forecast.Data {
+	s := *d
+	s.Offsets, s.Levers = sd.Offsets, sd.Levers
+	if len(sd.Ops) == 0 {
+		return &s
+	}
+	s.Ops = make(map[int64]forecast.Op, len(d.Ops)+len(sd.Ops))
+	for ID, op := range d.Ops {
+		s.Ops[ID] = op
+	}
+	for _, op := range sd.Ops {
+		s.Ops[op.ID] = op
+	}
+	s.PrevCommitments = append(append([]forecast.Flow{}, d.PrevCommitments...),
+		sd.Commitments...)
+	return &s
+}
+
+// fetchBudgetCodes fetches the codes of all budget actions.
+func fetchBudgetCodes(db *sql.DB) (map[int64]budgetCodes, error) {
+	rows, err := db.Query(`SELECT ba.id,bc.code,bs.code,bp.code_function,
+	bp.code_subfunction,bp.code_contract||bp.code_function||bp.code_number,
+	bp.code_contract||bp.code_function||bp.code_number||ba.code,ba.name
+	FROM budget_action ba
+	JOIN budget_program bp ON ba.program_id=bp.id
+	JOIN budget_chapter bc ON bp.chapter_id=bc.id
+	JOIN budget_sector bs ON ba.sector_id=bs.id`)
+	if err != nil {
+		return nil, fmt.Errorf("select budget codes %v", err)
+	}
+	defer rows.Close()
+	codes := make(map[int64]budgetCodes)
+	var ID int64
+	var b budgetCodes
+	for rows.Next() {
+		if err = rows.Scan(&ID, &b.Chapter, &b.Sector, &b.Function, &b.Subfunction,
+			&b.Program, &b.Action, &b.ActionName); err != nil {
+			return nil, fmt.Errorf("scan budget codes %v", err)
+		}
+		codes[ID] = b
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows budget codes %v", err)
+	}
+	return codes, nil
+}
+
+// fetchOpNames fetches the numbers and the names of the operations and of the
+// hypothetical operations of the scenarios whose IDs are the opposite of the
+// IDs of scenario_new_op.
+func fetchOpNames(db *sql.DB) (map[int64][2]string, error) {
+	rows, err := db.Query(`SELECT id,number,name FROM physical_op
+	UNION ALL SELECT -id,'HYP-'||id,name FROM scenario_new_op`)
+	if err != nil {
+		return nil, fmt.Errorf("select op names %v", err)
+	}
+	defer rows.Close()
+	names := make(map[int64][2]string)
+	var ID int64
+	var number, name string
+	for rows.Next() {
+		if err = rows.Scan(&ID, &number, &name); err != nil {
+			return nil, fmt.Errorf("scan op names %v", err)
+		}
+		names[ID] = [2]string{number, name}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows op names %v", err)
+	}
+	return names, nil
+}
+
+// euros converts a projected amount in cents to euros rounded to the cent.
+func euros(v float64, valid bool) NullFloat64 {
+	return NullFloat64{Valid: valid, Float64: math.Round(v) / 100}
+}
+
+// fullEuros converts a projected amount in cents to euros without rounding as
+// the payment previsions per budget action always did.
+func fullEuros(v float64, valid bool) NullFloat64 {
+	return NullFloat64{Valid: valid, Float64: v / 100}
+}
+
+// cmpNullInt64 compares two nullable integers, nulls being last.
+func cmpNullInt64(a, b NullInt64) int {
+	switch {
+	case a.Valid != b.Valid:
+		if a.Valid {
+			return -1
+		}
+		return 1
+	case a.Int64 < b.Int64:
+		return -1
+	case a.Int64 > b.Int64:
+		return 1
+	}
+	return 0
+}
+
+// cmpNullStrings compares pairwise nullable strings, nulls being last, and
+// returns the first difference.
+func cmpNullStrings(pairs ...[2]NullString) int {
+	for _, p := range pairs {
+		a, b := p[0], p[1]
+		switch {
+		case a.Valid != b.Valid:
+			if a.Valid {
+				return -1
+			}
+			return 1
+		case a.String < b.String:
+			return -1
+		case a.String > b.String:
+			return 1
+		}
+	}
+	return 0
+}
+
+// forecastPayments computes the projection of the payments per budget action
+// and returns the lines sorted by budget codes, the amounts being rounded to
+// the cent if rounded is set.
+func forecastPayments(p forecast.Params, sID int64, rounded bool,
+	db *sql.DB) ([]PaymentForecastLine, error) {
+	d, err := LoadForecastData(sID, db)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := d.Payments(p)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := fetchBudgetCodes(db)
+	if err != nil {
+		return nil, err
+	}
+	var names map[int64][2]string
+	if p.Level == forecast.LevelOp {
+		if names, err = fetchOpNames(db); err != nil {
+			return nil, err
+		}
+	}
+	convert := fullEuros
+	if rounded {
+		convert = euros
+	}
+	result := make([]PaymentForecastLine, len(lines))
+	for i, l := range lines {
+		r := PaymentForecastLine{ForecastLabels: forecastLabels(l.Key, codes, names),
+			Values: make([]NullFloat64, len(l.Values))}
+		for j, v := range l.Values {
+			r.Values[j] = convert(v, l.Valid[j])
+		}
+		result[i] = r
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return lessForecastLabels(&result[i].ForecastLabels,
+			&result[j].ForecastLabels)
+	})
+	return result, nil
+}
+
+// forecastLabels returns the codes of the budget action and the number and
+// name of the operation of the key.
+func forecastLabels(k forecast.Key, codes map[int64]budgetCodes,
+	names map[int64][2]string) ForecastLabels {
+	var r ForecastLabels
+	if k.OpID != 0 {
+		n := names[k.OpID]
+		if k.OpID > 0 {
+			r.OpID = NullInt64{Valid: true, Int64: k.OpID}
+		} else {
+			r.NewOpID = NullInt64{Valid: true, Int64: -k.OpID}
+		}
+		r.OpNumber = NullString{Valid: true, String: n[0]}
+		r.OpName = NullString{Valid: true, String: n[1]}
+	}
+	if b, ok := codes[k.ActionID]; ok {
+		r.ActionID = NullInt64{Valid: true, Int64: k.ActionID}
+		r.Chapter, r.Sector, r.Subfunction = b.Chapter, b.Sector, b.fullSubfunction()
+		r.Program, r.Action, r.ActionName = b.Program, b.Action, b.ActionName
+	}
+	return r
+}
+
+// lessForecastLabels orders the lines by budget codes and operation number.
+func lessForecastLabels(a, b *ForecastLabels) bool {
+	if c := cmpNullInt64(a.Chapter, b.Chapter); c != 0 {
+		return c < 0
+	}
+	return cmpNullStrings([2]NullString{a.Sector, b.Sector},
+		[2]NullString{a.Subfunction, b.Subfunction},
+		[2]NullString{a.Program, b.Program}, [2]NullString{a.Action, b.Action},
+		[2]NullString{a.OpNumber, b.OpNumber}) < 0
+}
+
+// GetAll computes the projection of the payments over the horizon using the
+// scenario if sID isn't 0.
+func (f *PaymentForecast) GetAll(p forecast.Params, sID int64, db *sql.DB) error {
+	lines, err := forecastPayments(p, sID, true, db)
+	if err != nil {
+		return err
+	}
+	f.Years = make([]int64, p.Horizon)
+	for i := range f.Years {
+		f.Years[i] = p.FirstYear + int64(i)
+	}
+	f.Lines = lines
+	if len(f.Lines) == 0 {
+		f.Lines = []PaymentForecastLine{}
+	}
+	return nil
+}
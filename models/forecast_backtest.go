@@ -0,0 +1,330 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Iledant/iris-propera/forecast"
+)
+
+// Names of the forecast methods compared by the backtest
+const (
+	RatioForecastMethod        = "ratio"
+	EmpiricalForecastMethod    = "empirical"
+	DifferentialForecastMethod = "differential"
+)
+
+// ForecastBacktestParams defines the forecasts to replay. Window is the count
+// of years of history used by the methods.
+type ForecastBacktestParams struct {
+	FirstOrigin int64
+	LastOrigin  int64
+	Horizon     int64
+	Window      int64
+	// LastYear is the current year whose payments are not complete
+	LastYear int64
+}
+
+// backtestParams returns the parameters of the forecast package.
+func (p *ForecastBacktestParams) backtestParams() forecast.BacktestParams {
+	return forecast.BacktestParams{FirstOrigin: p.FirstOrigin,
+		LastOrigin: p.LastOrigin, Horizon: p.Horizon, LastYear: p.LastYear}
+}
+
+// Validate checks the parameters of the backtest, the origins being checked
+// against the history when running it.
+func (p *ForecastBacktestParams) Validate() error {
+	if p.Window <= 0 {
+		return errors.New("Window incorrect")
+	}
+	bp := p.backtestParams()
+	return bp.Validate()
+}
+
+// ForecastAccuracy gives the accuracy of a method for the operations of a
+// payment type or for all commitments if PaymentTypeID is null. The
+// commitments without payment type are those of the operations without
+// payment type or linked only to a budget action.
+type ForecastAccuracy struct {
+	Method        string      `json:"method"`
+	PaymentTypeID NullInt64   `json:"payment_type_id"`
+	PaymentType   NullString  `json:"payment_type"`
+	Points        int64       `json:"points"`
+	MAPE          NullFloat64 `json:"mape"`
+	Bias          NullFloat64 `json:"bias"`
+}
+
+// BacktestYear compares the total forecast and actual payments of a year for
+// a forecast made at the beginning of the origin year, the values being in
+// euros
+type BacktestYear struct {
+	Method   string  `json:"method"`
+	Origin   int64   `json:"origin"`
+	Year     int64   `json:"year"`
+	Forecast float64 `json:"forecast"`
+	Actual   float64 `json:"actual"`
+}
+
+// BacktestAction gives the accuracy of a method for a budget action
+type BacktestAction struct {
+	Method     string      `json:"method"`
+	ActionID   NullInt64   `json:"action_id"`
+	Action     NullString  `json:"action"`
+	ActionName NullString  `json:"action_name"`
+	Points     int64       `json:"points"`
+	MAPE       NullFloat64 `json:"mape"`
+	Bias       NullFloat64 `json:"bias"`
+}
+
+// ForecastBacktest embeddes the results of the backtest for json export
+type ForecastBacktest struct {
+	Accuracy []ForecastAccuracy `json:"ForecastAccuracy"`
+	Years    []BacktestYear     `json:"BacktestYear"`
+	Actions  []BacktestAction   `json:"BacktestAction"`
+}
+
+// backtestMethod is a forecast method to replay
+type backtestMethod struct {
+	Name   string
+	Method forecast.StockMethod
+}
+
+// loadPaymentTypeHistories fetches the commitments and the payments per
+// budget action and year for each payment type of the operations, the
+// commitments without payment type being in the key 0. The commitments linked
+// to an operation are attached to the budget action of the operation.
+func loadPaymentTypeHistories(db *sql.DB) (map[int64]*forecast.History, error) {
+	histories := make(map[int64]*forecast.History)
+	history := func(ptID int64) *forecast.History {
+		h, ok := histories[ptID]
+		if !ok {
+			h = &forecast.History{}
+			histories[ptID] = h
+		}
+		return h
+	}
+	rows, err := db.Query(`SELECT COALESCE(op.payment_types_id,0),
+	CASE WHEN f.physical_op_id NOTNULL THEN COALESCE(op.budget_action_id,0)
+		ELSE COALESCE(f.action_id,0) END,
+	EXTRACT(year FROM f.date)::bigint,SUM(f.value)::double precision
+	FROM financial_commitment f
+	LEFT JOIN physical_op op ON f.physical_op_id=op.id GROUP BY 1,2,3`)
+	if err != nil {
+		return nil, fmt.Errorf("select commitments %v", err)
+	}
+	defer rows.Close()
+	var ptID int64
+	var c forecast.Flow
+	for rows.Next() {
+		if err = rows.Scan(&ptID, &c.ActionID, &c.Year, &c.Value); err != nil {
+			return nil, fmt.Errorf("scan commitments %v", err)
+		}
+		h := history(ptID)
+		h.Commitments = append(h.Commitments, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows commitments %v", err)
+	}
+	rows, err = db.Query(`SELECT COALESCE(op.payment_types_id,0),
+	CASE WHEN f.physical_op_id NOTNULL THEN COALESCE(op.budget_action_id,0)
+		ELSE COALESCE(f.action_id,0) END,
+	EXTRACT(year FROM f.date)::bigint,EXTRACT(year FROM p.date)::bigint,
+	SUM(p.value)::double precision
+	FROM payment p
+	JOIN financial_commitment f ON p.financial_commitment_id=f.id
+	LEFT JOIN physical_op op ON f.physical_op_id=op.id
+	WHERE EXTRACT(year FROM p.date)>=EXTRACT(year FROM f.date)
+	GROUP BY 1,2,3,4`)
+	if err != nil {
+		return nil, fmt.Errorf("select payments %v", err)
+	}
+	defer rows.Close()
+	var p forecast.Payment
+	for rows.Next() {
+		if err = rows.Scan(&ptID, &p.ActionID, &p.CommitmentYear, &p.Year,
+			&p.Value); err != nil {
+			return nil, fmt.Errorf("scan payments %v", err)
+		}
+		h := history(ptID)
+		h.Payments = append(h.Payments, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows payments %v", err)
+	}
+	return histories, nil
+}
+
+// LoadForecastHistory fetches the commitments and the payments per budget
+// action and year. The commitments linked to an operation are attached to
+// the budget action of the operation.
+func LoadForecastHistory(db *sql.DB) (*forecast.History, error) {
+	histories, err := loadPaymentTypeHistories(db)
+	if err != nil {
+		return nil, err
+	}
+	var h forecast.History
+	for _, pt := range histories {
+		h.Commitments = append(h.Commitments, pt.Commitments...)
+		h.Payments = append(h.Payments, pt.Payments...)
+	}
+	return &h, nil
+}
+
+// backtestMethods returns the methods computing their ratios from the history
+// known at each origin. The ratio method replays the payment ratios as they
+// would have been calibrated at each origin, excluding the outliers, the
+// empirical method being the same ratios computed on all commitments.
+func backtestMethods(p *ForecastBacktestParams) []backtestMethod {
+	return []backtestMethod{
+		{Name: RatioForecastMethod, Method: forecast.CalibratedMethod(p.Window, true)},
+		{Name: EmpiricalForecastMethod, Method: forecast.EmpiricalMethod(p.Window)},
+		{Name: DifferentialForecastMethod, Method: forecast.DifferentialMethod(p.Window)}}
+}
+
+// fetchPaymentTypeNames fetches the names of the payment types.
+func fetchPaymentTypeNames(db *sql.DB) (map[int64]string, error) {
+	rows, err := db.Query(`SELECT id,name FROM payment_types`)
+	if err != nil {
+		return nil, fmt.Errorf("select payment types %v", err)
+	}
+	defer rows.Close()
+	names := make(map[int64]string)
+	var ID int64
+	var name string
+	for rows.Next() {
+		if err = rows.Scan(&ID, &name); err != nil {
+			return nil, fmt.Errorf("scan payment types %v", err)
+		}
+		names[ID] = name
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows payment types %v", err)
+	}
+	return names, nil
+}
+
+// Run replays the forecasts of all methods for each payment type and measures
+// their accuracy per payment type, in total, per year and per budget action,
+// the forecasts of a method being the sum of the forecasts of the payment
+// types.
+func (f *ForecastBacktest) Run(p *ForecastBacktestParams, db *sql.DB) error {
+	histories, err := loadPaymentTypeHistories(db)
+	if err != nil {
+		return err
+	}
+	codes, err := fetchBudgetCodes(db)
+	if err != nil {
+		return err
+	}
+	names, err := fetchPaymentTypeNames(db)
+	if err != nil {
+		return err
+	}
+	var all forecast.History
+	ptIDs := make([]int64, 0, len(histories))
+	for ptID, h := range histories {
+		all.Commitments = append(all.Commitments, h.Commitments...)
+		all.Payments = append(all.Payments, h.Payments...)
+		ptIDs = append(ptIDs, ptID)
+	}
+	sort.Slice(ptIDs, func(i, j int) bool { return ptIDs[i] < ptIDs[j] })
+	bp := p.backtestParams()
+	if err = bp.Validate(); err != nil {
+		return err
+	}
+	if err = bp.CheckOrigins(&all); err != nil {
+		return err
+	}
+	f.Accuracy, f.Years, f.Actions = []ForecastAccuracy{}, []BacktestYear{},
+		[]BacktestAction{}
+	for _, m := range backtestMethods(p) {
+		type pointKey struct{ Origin, Year, ActionID int64 }
+		total := make(map[pointKey]*forecast.BacktestPoint)
+		var ptAccuracy []ForecastAccuracy
+		for _, ptID := range ptIDs {
+			res, err := forecast.Backtest(histories[ptID], m.Method, bp)
+			if err == forecast.ErrBacktestOrigins {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			mape, bias := accuracyValues(res.Accuracy)
+			a := ForecastAccuracy{Method: m.Name, Points: res.Accuracy.Points,
+				MAPE: mape, Bias: bias}
+			if ptID != 0 {
+				a.PaymentTypeID = NullInt64{Valid: true, Int64: ptID}
+				a.PaymentType = NullString{Valid: true, String: names[ptID]}
+			}
+			ptAccuracy = append(ptAccuracy, a)
+			for _, pt := range res.Points {
+				k := pointKey{pt.Origin, pt.Year, pt.ActionID}
+				t, ok := total[k]
+				if !ok {
+					t = &forecast.BacktestPoint{Origin: pt.Origin, Year: pt.Year,
+						ActionID: pt.ActionID}
+					total[k] = t
+				}
+				t.Forecast += pt.Forecast
+				t.Actual += pt.Actual
+			}
+		}
+		points := make([]forecast.BacktestPoint, 0, len(total))
+		for _, pt := range total {
+			points = append(points, *pt)
+		}
+		a := forecast.Measure(points)
+		mape, bias := accuracyValues(a)
+		f.Accuracy = append(f.Accuracy, ForecastAccuracy{Method: m.Name,
+			Points: a.Points, MAPE: mape, Bias: bias})
+		f.Accuracy = append(f.Accuracy, ptAccuracy...)
+		years := make(map[[2]int64]*BacktestYear)
+		actions := make(map[int64][]forecast.BacktestPoint)
+		for _, pt := range points {
+			k := [2]int64{pt.Origin, pt.Year}
+			y, ok := years[k]
+			if !ok {
+				y = &BacktestYear{Method: m.Name, Origin: pt.Origin, Year: pt.Year}
+				years[k] = y
+			}
+			y.Forecast += pt.Forecast
+			y.Actual += pt.Actual
+			actions[pt.ActionID] = append(actions[pt.ActionID], pt)
+		}
+		first := len(f.Years)
+		for _, y := range years {
+			y.Forecast, y.Actual = euros(y.Forecast, true).Float64,
+				euros(y.Actual, true).Float64
+			f.Years = append(f.Years, *y)
+		}
+		sort.Slice(f.Years[first:], func(i, j int) bool {
+			a, b := f.Years[first+i], f.Years[first+j]
+			return a.Origin < b.Origin || a.Origin == b.Origin && a.Year < b.Year
+		})
+		first = len(f.Actions)
+		for ID, points := range actions {
+			a := forecast.Measure(points)
+			mape, bias := accuracyValues(a)
+			r := BacktestAction{Method: m.Name, Points: a.Points, MAPE: mape,
+				Bias: bias}
+			if b, ok := codes[ID]; ok {
+				r.ActionID = NullInt64{Valid: true, Int64: ID}
+				r.Action, r.ActionName = b.Action, b.ActionName
+			}
+			f.Actions = append(f.Actions, r)
+		}
+		sort.Slice(f.Actions[first:], func(i, j int) bool {
+			return cmpNullStrings([2]NullString{f.Actions[first+i].Action,
+				f.Actions[first+j].Action}) < 0
+		})
+	}
+	return nil
+}
+
+// accuracyValues converts the accuracy measures to nullable values.
+func accuracyValues(a forecast.Accuracy) (NullFloat64, NullFloat64) {
+	return NullFloat64{Valid: a.Valid, Float64: a.MAPE},
+		NullFloat64{Valid: a.Valid, Float64: a.Bias}
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Iledant/iris-propera/forecast"
+)
+
+// Names of the forecast methods selectable for the payment previsions with
+// a confidence band, the ratio method using the curve of the payment type
+const (
+	DeclaredForecastMethod  = "declared"
+	VintageForecastMethod   = "vintage"
+	SmoothingForecastMethod = "smoothing"
+)
+
+// Smoothing factors of the level, the trend and the seasonality of the
+// monthly payments
+const (
+	smoothingAlpha = 0.3
+	smoothingBeta  = 0.1
+	smoothingGamma = 0.2
+)
+
+// ForecasterParams selects the forecast method, the confidence level of the
+// band and the count of years of history used by the ratio methods
+type ForecasterParams struct {
+	Method     string
+	Confidence float64
+	Window     int64
+}
+
+// PaymentBandForecastLine is a line of the payment previsions, Values being
+// the central values and Low and High the bounds of the confidence band
+type PaymentBandForecastLine struct {
+	PaymentForecastLine
+	Low  []NullFloat64 `json:"low"`
+	High []NullFloat64 `json:"high"`
+}
+
+// PaymentBandForecast embeddes the payment previsions of a method with their
+// confidence band for json export
+type PaymentBandForecast struct {
+	Method     string                    `json:"Method"`
+	Confidence float64                   `json:"Confidence"`
+	Years      []int64                   `json:"Years"`
+	Lines      []PaymentBandForecastLine `json:"PaymentBandForecast"`
+}
+
+// LoadMonthlyPayments fetches the total payments per month from January of
+// the first year with payments to the month before the limit.
+func LoadMonthlyPayments(limit time.Time, db *sql.DB) (*forecast.MonthlySeries, error) {
+	rows, err := db.Query(`SELECT EXTRACT(year FROM date)::bigint,
+	EXTRACT(month FROM date)::bigint,SUM(value)::double precision
+	FROM payment WHERE date<$1 GROUP BY 1,2 ORDER BY 1,2`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("select monthly payments %v", err)
+	}
+	defer rows.Close()
+	var s forecast.MonthlySeries
+	var year, month int64
+	var value float64
+	for rows.Next() {
+		if err = rows.Scan(&year, &month, &value); err != nil {
+			return nil, fmt.Errorf("scan monthly payments %v", err)
+		}
+		if s.Values == nil {
+			s.FirstYear = year
+		}
+		i := (year-s.FirstYear)*12 + month - 1
+		for int64(len(s.Values)) <= i {
+			s.Values = append(s.Values, 0)
+		}
+		s.Values[i] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows monthly payments %v", err)
+	}
+	if s.Values == nil {
+		return &s, nil
+	}
+	last := (int64(limit.Year())-s.FirstYear)*12 + int64(limit.Month()) - 1
+	for int64(len(s.Values)) < last {
+		s.Values = append(s.Values, 0)
+	}
+	return &s, nil
+}
+
+// newForecaster creates the forecaster of the method loading its datas.
+func newForecaster(fp *ForecasterParams, p forecast.Params, db *sql.DB) (forecast.Forecaster, error) {
+	z, err := forecast.ZScore(fp.Confidence)
+	if err != nil {
+		return nil, err
+	}
+	switch fp.Method {
+	case DeclaredForecastMethod:
+		d, err := LoadForecastData(0, db)
+		if err != nil {
+			return nil, err
+		}
+		return &forecast.DeclaredForecaster{Data: d}, nil
+	case RatioForecastMethod, VintageForecastMethod:
+		d, err := LoadForecastData(0, db)
+		if err != nil {
+			return nil, err
+		}
+		h, err := LoadForecastHistory(db)
+		if err != nil {
+			return nil, err
+		}
+		return &forecast.RatioForecaster{Data: d, History: h, Window: fp.Window,
+			FromHistory: fp.Method == VintageForecastMethod, Z: z}, nil
+	case SmoothingForecastMethod:
+		now := time.Now()
+		limit := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if first := time.Date(int(p.FirstYear), 1, 1, 0, 0, 0, 0, time.UTC); first.Before(limit) {
+			limit = first
+		}
+		s, err := LoadMonthlyPayments(limit, db)
+		if err != nil {
+			return nil, err
+		}
+		return &forecast.SmoothingForecaster{Series: *s, Alpha: smoothingAlpha,
+			Beta: smoothingBeta, Gamma: smoothingGamma, Z: z}, nil
+	}
+	return nil, fmt.Errorf("méthode %s inconnue", fp.Method)
+}
+
+// GetAll computes the payment previsions over the horizon with the selected
+// method and their confidence band.
+func (f *PaymentBandForecast) GetAll(fp *ForecasterParams, p forecast.Params, db *sql.DB) error {
+	fc, err := newForecaster(fp, p, db)
+	if err != nil {
+		return err
+	}
+	bands, err := fc.Forecast(p)
+	if err != nil {
+		return err
+	}
+	codes, err := fetchBudgetCodes(db)
+	if err != nil {
+		return err
+	}
+	var names map[int64][2]string
+	if p.Level == forecast.LevelOp {
+		if names, err = fetchOpNames(db); err != nil {
+			return err
+		}
+	}
+	f.Method, f.Confidence = fp.Method, fp.Confidence
+	f.Years = make([]int64, p.Horizon)
+	for i := range f.Years {
+		f.Years[i] = p.FirstYear + int64(i)
+	}
+	f.Lines = make([]PaymentBandForecastLine, len(bands))
+	for i, b := range bands {
+		r := PaymentBandForecastLine{PaymentForecastLine: PaymentForecastLine{
+			ForecastLabels: forecastLabels(b.Key, codes, names),
+			Values:         make([]NullFloat64, len(b.Bands))},
+			Low: make([]NullFloat64, len(b.Bands)), High: make([]NullFloat64, len(b.Bands))}
+		for j, v := range b.Bands {
+			r.Low[j] = euros(v.Low, b.Valid[j])
+			r.Values[j] = euros(v.Central, b.Valid[j])
+			r.High[j] = euros(v.High, b.Valid[j])
+		}
+		f.Lines[i] = r
+	}
+	sort.Slice(f.Lines, func(i, j int) bool {
+		return lessForecastLabels(&f.Lines[i].ForecastLabels,
+			&f.Lines[j].ForecastLabels)
+	})
+	return nil
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// Status of an import job
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Phases of a batch import reported to the import jobs
+const (
+	StagingPhase  = "staging"
+	DedupPhase    = "dedup"
+	UpdatePhase   = "update"
+	InsertPhase   = "insert"
+	LinkingPhase  = "linking"
+	MatchingPhase = "matching"
+)
+
+// JobHeartbeat is the interval at which an instance refreshes the heartbeat of
+// the jobs it owns. A job whose heartbeat is older than JobStaleDelay is
+// considered lost by its instance.
+const (
+	JobHeartbeat  = 30 * time.Second
+	JobStaleDelay = 4 * JobHeartbeat
+)
+
+// JobOwner identifies the server instance creating the import jobs so that an
+// instance only fails its own jobs when it starts. It defaults to the host
+// name that is kept when an instance restarts.
+var JobOwner, _ = os.Hostname()
+
+// ImportProgress is called by batch imports at the beginning of each phase
+type ImportProgress func(phase string)
+
+// ImportJob model
+type ImportJob struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	Source    NullString      `json:"source"`
+	Status    string          `json:"status"`
+	Phase     NullString      `json:"phase"`
+	Result    json.RawMessage `json:"result"`
+	Error     NullString      `json:"error"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	// ContentHash and IdempotencyKey identify the content of the import to
+	// find the jobs of the same content that are still in progress
+	ContentHash    NullString `json:"-"`
+	IdempotencyKey NullString `json:"-"`
+}
+
+// ImportJobs embeddes an array of ImportJob for json export.
+type ImportJobs struct {
+	ImportJobs []ImportJob `json:"ImportJob"`
+}
+
+// phaseQuery links a query of a batch import to its phase
+type phaseQuery struct {
+	Phase string
+	Query string
+}
+
+// report calls the progress function if set
+func (p ImportProgress) report(phase string) {
+	if p != nil {
+		p(phase)
+	}
+}
+
+// Create inserts a new pending import job into database owned by the
+// instance.
+func (j *ImportJob) Create(db *sql.DB) error {
+	now := time.Now()
+	j.Status, j.CreatedAt, j.UpdatedAt = JobPending, now, now
+	return db.QueryRow(`INSERT INTO import_job (kind,source,status,created_at,
+		updated_at,content_hash,idempotency_key,owner,heartbeat_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$4) RETURNING id`, j.Kind, j.Source,
+		j.Status, j.CreatedAt, j.UpdatedAt, j.ContentHash, j.IdempotencyKey,
+		JobOwner).Scan(&j.ID)
+}
+
+// FindInProgress fetches the last pending or running job of the same kind
+// having the same content hash or the same idempotency key. It returns false
+// if there's no such job and ErrIdempotencyConflict if the idempotency key is
+// used by a job of another content.
+func (j *ImportJob) FindInProgress(db *sql.DB) (bool, error) {
+	var (
+		result   []byte
+		hash     NullString
+		keyMatch bool
+	)
+	err := db.QueryRow(`SELECT id,source,status,phase,result,error,created_at,
+		updated_at,content_hash,COALESCE(idempotency_key=$3,FALSE) FROM import_job
+	WHERE kind=$1 AND status IN ($4,$5) AND (content_hash=$2 OR
+		(idempotency_key IS NOT NULL AND idempotency_key=$3))
+	ORDER BY 10 DESC,id DESC LIMIT 1`, j.Kind, j.ContentHash, j.IdempotencyKey,
+		JobPending, JobRunning).Scan(&j.ID, &j.Source, &j.Status, &j.Phase, &result,
+		&j.Error, &j.CreatedAt, &j.UpdatedAt, &hash, &keyMatch)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if keyMatch && hash != j.ContentHash {
+		return false, ErrIdempotencyConflict
+	}
+	j.Result = result
+	return true, nil
+}
+
+// Get fetches an import job from database using its ID.
+func (j *ImportJob) Get(db *sql.DB) error {
+	var result []byte
+	err := db.QueryRow(`SELECT kind,source,status,phase,result,error,created_at,
+	updated_at FROM import_job WHERE id=$1`, j.ID).Scan(&j.Kind, &j.Source,
+		&j.Status, &j.Phase, &result, &j.Error, &j.CreatedAt, &j.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return errors.New("Import introuvable")
+	}
+	j.Result = result
+	return err
+}
+
+// Finished returns true if the job is done or failed.
+func (j *ImportJob) Finished() bool {
+	return j.Status == JobDone || j.Status == JobFailed
+}
+
+// SetPhase updates the status and the phase of the job into database.
+func (j *ImportJob) SetPhase(phase string, db *sql.DB) error {
+	j.Status, j.Phase, j.UpdatedAt = JobRunning,
+		NullString{Valid: phase != "", String: phase}, time.Now()
+	_, err := db.Exec(`UPDATE import_job SET status=$1,phase=$2,updated_at=$3
+	WHERE id=$4`, j.Status, j.Phase, j.UpdatedAt, j.ID)
+	return err
+}
+
+// Finish stores the result of the job or the error that stopped it.
+func (j *ImportJob) Finish(result interface{}, jobErr error, db *sql.DB) error {
+	j.UpdatedAt = time.Now()
+	if jobErr != nil {
+		j.Status, j.Result = JobFailed, nil
+		j.Error = NullString{Valid: true, String: jobErr.Error()}
+	} else {
+		js, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		j.Status, j.Result, j.Error = JobDone, js, NullString{Valid: false}
+	}
+	res := NullString{Valid: j.Result != nil, String: string(j.Result)}
+	_, err := db.Exec(`UPDATE import_job SET status=$1,result=$2,error=$3,
+	updated_at=$4 WHERE id=$5`, j.Status, res, j.Error, j.UpdatedAt, j.ID)
+	return err
+}
+
+// FailOrphanJobs marks as failed the jobs of the instance that are still
+// pending or running, which happens when the server stopped before they were
+// processed, and the jobs of the other instances having a stale heartbeat.
+func FailOrphanJobs(db *sql.DB) error {
+	_, err := db.Exec(`UPDATE import_job SET status=$1,error=$2,updated_at=$3
+	WHERE status IN ($4,$5) AND owner=$6`, JobFailed,
+		"import interrompu par l'arrêt du serveur", time.Now(), JobPending,
+		JobRunning, JobOwner)
+	if err != nil {
+		return err
+	}
+	return FailStaleJobs(db)
+}
+
+// FailStaleJobs marks as failed the pending or running jobs whose heartbeat
+// is older than JobStaleDelay, their instance being stopped or unreachable.
+// The jobs created before the heartbeat use their last update instead.
+func FailStaleJobs(db *sql.DB) error {
+	now := time.Now()
+	_, err := db.Exec(`UPDATE import_job SET status=$1,error=$2,updated_at=$3
+	WHERE status IN ($4,$5) AND COALESCE(heartbeat_at,updated_at)<$6`, JobFailed,
+		"import interrompu, serveur sans signe de vie", now, JobPending,
+		JobRunning, now.Add(-JobStaleDelay))
+	return err
+}
+
+// BeatJobs refreshes the heartbeat of the pending or running jobs of the
+// instance.
+func BeatJobs(db *sql.DB) error {
+	_, err := db.Exec(`UPDATE import_job SET heartbeat_at=$1
+	WHERE status IN ($2,$3) AND owner=$4`, time.Now(), JobPending, JobRunning,
+		JobOwner)
+	return err
+}
+
+// GetAll fetches the history of the last import jobs from database, the most
+// recent first.
+func (j *ImportJobs) GetAll(limit int64, db *sql.DB) error {
+	rows, err := db.Query(`SELECT id,kind,source,status,phase,result,error,
+	created_at,updated_at FROM import_job ORDER BY created_at DESC,id DESC
+	LIMIT $1`, limit)
+	if err != nil {
+		return err
+	}
+	var r ImportJob
+	defer rows.Close()
+	for rows.Next() {
+		var result []byte
+		if err = rows.Scan(&r.ID, &r.Kind, &r.Source, &r.Status, &r.Phase, &result,
+			&r.Error, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return err
+		}
+		r.Result = result
+		j.ImportJobs = append(j.ImportJobs, r)
+	}
+	err = rows.Err()
+	if len(j.ImportJobs) == 0 {
+		j.ImportJobs = []ImportJob{}
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"reflect"
+	"time"
+)
+
+// ErrAlreadyImported is returned by streamed batch imports when the same
+// content was already successfully imported.
+var ErrAlreadyImported = errors.New("contenu déjà importé")
+
+// ErrIdempotencyConflict is returned when an idempotency key is used again
+// with a different content.
+var ErrIdempotencyConflict = errors.New("clé d'idempotence déjà utilisée pour un contenu différent")
+
+// ImportGuard prevents a batch import from importing twice the same content.
+// Once the hash of the content is known, Check is called within the import
+// transaction and returns true if the import must be aborted because the
+// content was already imported. Record is called with the result of the
+// import before the transaction is committed so that the import and its
+// record are committed together.
+type ImportGuard interface {
+	Check(contentHash string, tx *sql.Tx) (bool, error)
+	Record(result interface{}, tx *sql.Tx) error
+}
+
+// queryRower is implemented by *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ImportRecord model stores the result of a successful batch import so that
+// importing again the same content or using the same idempotency key sends
+// back the stored result instead of importing twice.
+type ImportRecord struct {
+	ID             int64           `json:"id"`
+	Kind           string          `json:"kind"`
+	ContentHash    string          `json:"content_hash"`
+	IdempotencyKey NullString      `json:"idempotency_key"`
+	Result         json.RawMessage `json:"result"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// LinesHash computes the hash of the normalized content of a batch, i.e. the
+// JSON encoding of each decoded line, so that the same lines sent with a
+// different formatting or as NDJSON give the same hash.
+type LinesHash struct {
+	h hash.Hash
+}
+
+// NewLinesHash returns an empty lines hash.
+func NewLinesHash() *LinesHash {
+	return &LinesHash{h: sha256.New()}
+}
+
+// Add adds a decoded line to the hash.
+func (l *LinesHash) Add(line interface{}) error {
+	content, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	l.h.Write(content)
+	l.h.Write([]byte{'\n'})
+	return nil
+}
+
+// Sum returns the hexadecimal hash of the lines added so far.
+func (l *LinesHash) Sum() string {
+	return hex.EncodeToString(l.h.Sum(nil))
+}
+
+// BatchHash returns the hash of the normalized content of a slice of lines.
+func BatchHash(lines interface{}) (string, error) {
+	l, v := NewLinesHash(), reflect.ValueOf(lines)
+	if v.Kind() != reflect.Slice {
+		return "", errors.New("tableau de lignes attendu")
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := l.Add(v.Index(i).Interface()); err != nil {
+			return "", err
+		}
+	}
+	return l.Sum(), nil
+}
+
+// Find fetches the last import of the same kind created after since having
+// the same content hash or the same idempotency key. It returns false if
+// there's no such import and ErrIdempotencyConflict if the idempotency key
+// was used for another content. The conflict is only checked if the content
+// hash is set.
+func (r *ImportRecord) Find(since time.Time, db queryRower) (bool, error) {
+	var (
+		result   []byte
+		hash     string
+		keyMatch bool
+	)
+	err := db.QueryRow(`SELECT id,created_at,result,content_hash,
+		COALESCE(idempotency_key=$4,FALSE) FROM import_record
+	WHERE kind=$1 AND created_at>=$2 AND (content_hash=$3 OR 
+		(idempotency_key IS NOT NULL AND idempotency_key=$4))
+	ORDER BY 5 DESC,id DESC LIMIT 1`, r.Kind, since, r.ContentHash, r.IdempotencyKey).
+		Scan(&r.ID, &r.CreatedAt, &result, &hash, &keyMatch)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if keyMatch && r.ContentHash != "" && hash != r.ContentHash {
+		return false, ErrIdempotencyConflict
+	}
+	r.Result = result
+	return true, nil
+}
+
+// Lock takes, within the import transaction, an advisory lock on the content
+// hash and on the idempotency key of the record. A concurrent import of the
+// same content or with the same key waits until the transaction ends and then
+// finds the record of this import.
+func (r *ImportRecord) Lock(tx *sql.Tx) error {
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`,
+		"import_hash:"+r.Kind+":"+r.ContentHash); err != nil {
+		return err
+	}
+	if !r.IdempotencyKey.Valid {
+		return nil
+	}
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`,
+		"import_key:"+r.Kind+":"+r.IdempotencyKey.String)
+	return err
+}
+
+// Save stores the result of a successful import within the import
+// transaction.
+func (r *ImportRecord) Save(result interface{}, tx *sql.Tx) error {
+	content, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	r.Result, r.CreatedAt = content, time.Now()
+	return tx.QueryRow(`INSERT INTO import_record (kind,content_hash,
+		idempotency_key,result,created_at) VALUES($1,$2,$3,$4,$5) RETURNING id`,
+		r.Kind, r.ContentHash, r.IdempotencyKey, string(r.Result), r.CreatedAt).
+		Scan(&r.ID)
+}
+
+// checkImport checks with the guard, if any, that the content wasn't already
+// imported and returns ErrAlreadyImported otherwise.
+func checkImport(guard ImportGuard, contentHash string, tx *sql.Tx) error {
+	if guard == nil {
+		return nil
+	}
+	imported, err := guard.Check(contentHash, tx)
+	if err != nil {
+		return err
+	}
+	if imported {
+		return ErrAlreadyImported
+	}
+	return nil
+}
+
+// recordImport stores the result of the import with the guard, if any.
+func recordImport(guard ImportGuard, result interface{}, tx *sql.Tx) error {
+	if guard == nil {
+		return nil
+	}
+	return guard.Record(result, tx)
+}
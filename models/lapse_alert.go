@@ -0,0 +1,291 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LapseAlertSettings defines the horizons of the lapse alerts, in days, and
+// the delay during which a payment demand shows that a commitment is followed
+type LapseAlertSettings struct {
+	Horizons   []int64
+	DemandDays int64
+}
+
+// LapseAlertConfig is used by the alerts job
+var LapseAlertConfig = LapseAlertSettings{
+	Horizons:   []int64{30, 60, 90},
+	DemandDays: 60,
+}
+
+// LapseAlert model is a notification to a user of a commitment that will
+// lapse within the horizon with an unpaid balance
+type LapseAlert struct {
+	ID           int64      `json:"id"`
+	CommitmentID int64      `json:"commitment_id"`
+	IrisCode     string     `json:"iris_code"`
+	Name         string     `json:"name"`
+	Beneficiary  string     `json:"beneficiary"`
+	OpNumber     NullString `json:"op_number"`
+	OpName       NullString `json:"op_name"`
+	Horizon      int64      `json:"horizon"`
+	LapseDate    time.Time  `json:"lapse_date"`
+	DaysToLapse  int64      `json:"days_to_lapse"`
+	Remaining    int64      `json:"remaining"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ReadAt       NullTime   `json:"read_at"`
+}
+
+// LapseAlerts embeddes an array of LapseAlert for json export
+type LapseAlerts struct {
+	Lines []LapseAlert `json:"LapseAlert"`
+}
+
+// LapseAlertRun is the outcome of the computation of the lapse alerts
+type LapseAlertRun struct {
+	Alerts  int64 `json:"alerts"`
+	Created int64 `json:"created"`
+	Removed int64 `json:"removed"`
+	Digests int64 `json:"digests"`
+	// FailedDigests lists the emails of the digests that couldn't be sent
+	FailedDigests []string `json:"failed_digests"`
+}
+
+// LapseDigest gathers the alerts to send to a user in the monthly digest
+type LapseDigest struct {
+	UserID int64
+	Name   string
+	Email  string
+	Alerts []LapseAlert
+	// previous and claimedAt are the dates of digest of the user before and
+	// after the claim
+	previous  NullTime
+	claimedAt time.Time
+}
+
+// LapseDigestSubscription is the choice of a user to receive the digest
+type LapseDigestSubscription struct {
+	UserID  int64    `json:"-"`
+	Enabled bool     `json:"lapse_digest"`
+	SentAt  NullTime `json:"lapse_digest_at"`
+}
+
+// lapseAlertQry computes the pairs of active users and at risk commitments on
+// the operations they have rights on, when no payment demand was received
+// recently, using $2 as the delay of payment demands, $3 as the admin role and
+// $4 as the horizons
+const lapseAlertQry = `WITH k AS (` + commitmentBalanceQry + `WHERE r.at_risk
+	AND NOT EXISTS (SELECT 1 FROM payment_demands d WHERE d.iris_code=r.iris_code
+		AND NOT d.excluded AND d.demand_date>=current_date-$2::int)),
+	a AS (SELECT u.id AS users_id,k.id AS commitment_id,k.lapse_date,k.remaining,
+		(SELECT min(h) FROM unnest($4::int[]) h WHERE h>=k.days_to_lapse) AS horizon
+		FROM k JOIN users u ON u.active AND (u.role=$3 OR k.physical_op_id IN
+			(SELECT physical_op_id FROM rights WHERE users_id=u.id))) `
+
+// maxHorizon returns the greatest horizon of the settings.
+func (s *LapseAlertSettings) maxHorizon() int64 {
+	var m int64
+	for _, h := range s.Horizons {
+		if h > m {
+			m = h
+		}
+	}
+	return m
+}
+
+// Run refreshes the lapse alerts of all users: alerts are created for the
+// newly at risk commitments, removed when the commitment is no longer at risk
+// and marked unread again when a shorter horizon is reached.
+func (l *LapseAlertRun) Run(progress ImportProgress, db *sql.DB) error {
+	s := LapseAlertConfig
+	if len(s.Horizons) == 0 {
+		return errors.New("aucun horizon d'alerte")
+	}
+	progress.report(MatchingPhase)
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("tx begin %v", err)
+	}
+	args := []interface{}{s.maxHorizon(), s.DemandDays, AdminRole,
+		pq.Array(s.Horizons)}
+	res, err := tx.Exec(lapseAlertQry+`DELETE FROM lapse_alert l
+	WHERE NOT EXISTS (SELECT 1 FROM a WHERE a.users_id=l.users_id
+		AND a.commitment_id=l.commitment_id)`, args...)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete %v", err)
+	}
+	if l.Removed, err = res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete count %v", err)
+	}
+	progress.report(UpdatePhase)
+	rows, err := tx.Query(lapseAlertQry+`INSERT INTO lapse_alert (users_id,
+		commitment_id,horizon,lapse_date,remaining,created_at,updated_at)
+	SELECT users_id,commitment_id,horizon,lapse_date,remaining,$5,$5 FROM a
+	ON CONFLICT (users_id,commitment_id) DO UPDATE SET
+		read_at=CASE WHEN EXCLUDED.horizon<lapse_alert.horizon THEN NULL
+			ELSE lapse_alert.read_at END,
+		horizon=EXCLUDED.horizon,lapse_date=EXCLUDED.lapse_date,
+		remaining=EXCLUDED.remaining,updated_at=EXCLUDED.updated_at
+	RETURNING xmax=0`, append(args, time.Now())...)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("upsert %v", err)
+	}
+	var created bool
+	for rows.Next() {
+		if err = rows.Scan(&created); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return fmt.Errorf("scan %v", err)
+		}
+		l.Alerts++
+		if created {
+			l.Created++
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("rows err %v", err)
+	}
+	return tx.Commit()
+}
+
+// GetByUser fetches the alerts of a user, the nearest lapse dates first,
+// optionally only the unread ones.
+func (l *LapseAlerts) GetByUser(uID int64, unread bool, db *sql.DB) error {
+	rows, err := db.Query(`SELECT l.id,l.commitment_id,f.iris_code,f.name,
+	COALESCE(b.name,''),op.number,op.name,l.horizon,l.lapse_date,
+	l.lapse_date-current_date,l.remaining,l.created_at,l.read_at
+	FROM lapse_alert l
+	JOIN financial_commitment f ON l.commitment_id=f.id
+	LEFT JOIN beneficiary b ON f.beneficiary_code=b.code
+	LEFT JOIN physical_op op ON f.physical_op_id=op.id
+	WHERE l.users_id=$1 AND (NOT $2 OR l.read_at ISNULL)
+	ORDER BY l.lapse_date,l.id`, uID, unread)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	var r LapseAlert
+	defer rows.Close()
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.CommitmentID, &r.IrisCode, &r.Name,
+			&r.Beneficiary, &r.OpNumber, &r.OpName, &r.Horizon, &r.LapseDate,
+			&r.DaysToLapse, &r.Remaining, &r.CreatedAt, &r.ReadAt); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		l.Lines = append(l.Lines, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows err %v", err)
+	}
+	if len(l.Lines) == 0 {
+		l.Lines = []LapseAlert{}
+	}
+	return nil
+}
+
+// MarkRead marks as read the alerts of the user given by their IDs or all
+// the alerts of the user if no ID is given and returns the count of alerts
+// marked.
+func (l *LapseAlerts) MarkRead(uID int64, IDs []int64, db *sql.DB) (int64, error) {
+	res, err := db.Exec(`UPDATE lapse_alert SET read_at=$3 WHERE users_id=$1
+	AND read_at ISNULL AND (cardinality($2::bigint[])=0 OR id=ANY($2))`,
+		uID, pq.Array(IDs), time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("update %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// Get fetches the subscription of the user to the monthly digest.
+func (s *LapseDigestSubscription) Get(db *sql.DB) error {
+	err := db.QueryRow(`SELECT lapse_digest,lapse_digest_at FROM users
+	WHERE id=$1`, s.UserID).Scan(&s.Enabled, &s.SentAt)
+	if err == sql.ErrNoRows {
+		return errors.New("Utilisateur introuvable")
+	}
+	return err
+}
+
+// Save updates the subscription of the user to the monthly digest.
+func (s *LapseDigestSubscription) Save(db *sql.DB) error {
+	err := db.QueryRow(`UPDATE users SET lapse_digest=$2 WHERE id=$1
+	RETURNING lapse_digest_at`, s.UserID, s.Enabled).Scan(&s.SentAt)
+	if err == sql.ErrNoRows {
+		return errors.New("Utilisateur introuvable")
+	}
+	return err
+}
+
+// GetDueLapseDigests fetches the subscribed users who didn't receive the
+// digest of the current month with their alerts. Users without alerts are
+// skipped.
+func GetDueLapseDigests(db *sql.DB) ([]LapseDigest, error) {
+	rows, err := db.Query(`SELECT id,name,email FROM users WHERE active
+	AND lapse_digest AND (lapse_digest_at ISNULL
+		OR date_trunc('month',lapse_digest_at)<date_trunc('month',current_date))
+	ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("select users %v", err)
+	}
+	var digests []LapseDigest
+	var d LapseDigest
+	for rows.Next() {
+		if err = rows.Scan(&d.UserID, &d.Name, &d.Email); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan users %v", err)
+		}
+		digests = append(digests, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows users %v", err)
+	}
+	due := digests[:0]
+	for _, d := range digests {
+		var alerts LapseAlerts
+		if err = alerts.GetByUser(d.UserID, false, db); err != nil {
+			return nil, err
+		}
+		if len(alerts.Lines) == 0 {
+			continue
+		}
+		d.Alerts = alerts.Lines
+		due = append(due, d)
+	}
+	return due, nil
+}
+
+// Claim atomically stores the date of the digest of the user unless the
+// digest of the current month was already sent, so that concurrent runs don't
+// send it twice. It returns false if the digest was already claimed.
+func (d *LapseDigest) Claim(db *sql.DB) (bool, error) {
+	d.claimedAt = time.Now().Truncate(time.Microsecond)
+	err := db.QueryRow(`UPDATE users u SET lapse_digest_at=$2
+	FROM (SELECT id,lapse_digest_at FROM users WHERE id=$1 FOR UPDATE) p
+	WHERE u.id=p.id AND (p.lapse_digest_at ISNULL
+		OR date_trunc('month',p.lapse_digest_at)<date_trunc('month',current_date))
+	RETURNING p.lapse_digest_at`, d.UserID, d.claimedAt).Scan(&d.previous)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Release restores the date of the previous digest of the user when the
+// claimed digest couldn't be sent so that the next run sends it.
+func (d *LapseDigest) Release(db *sql.DB) error {
+	_, err := db.Exec(`UPDATE users SET lapse_digest_at=$2
+	WHERE id=$1 AND lapse_digest_at=$3`, d.UserID, d.previous, d.claimedAt)
+	return err
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Money is an amount in cents decoded from an amount in euros sent by batch
+// imports. The amount can be sent as a JSON number or as a decimal string,
+// using a dot or a comma as decimal separator. It's parsed exactly and rounded
+// half-up to the cent, avoiding the float64 truncation that turns 0.29 into
+// 28 cents.
+type Money int64
+
+// NullMoney is used for batch imports to decode a nullable amount in euros.
+type NullMoney struct {
+	Valid bool
+	Money Money
+}
+
+// Reconciliation compares the total of the amounts of an import to the total
+// given by the source extraction, all values in cents.
+type Reconciliation struct {
+	LinesCount  int64     `json:"lines_count"`
+	ImportTotal int64     `json:"import_total"`
+	SourceTotal NullInt64 `json:"source_total"`
+	Difference  NullInt64 `json:"difference"`
+}
+
+var (
+	hundred = big.NewInt(100)
+	one     = big.NewInt(1)
+)
+
+// parseCents converts a decimal amount in euros to cents rounding half-up,
+// i.e. half away from zero.
+func parseCents(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ",") && !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	if s == "" || strings.Contains(s, "/") {
+		return 0, fmt.Errorf("montant %q invalide", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("montant %q invalide", s)
+	}
+	num := new(big.Int).Mul(r.Num(), hundred)
+	den := r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	m.Abs(m).Lsh(m, 1)
+	if m.Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			q.Sub(q, one)
+		} else {
+			q.Add(q, one)
+		}
+	}
+	if !q.IsInt64() {
+		return 0, fmt.Errorf("montant %q trop grand", s)
+	}
+	return Money(q.Int64()), nil
+}
+
+// UnmarshalJSON implements the unmarshal interface
+func (m *Money) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	v, err := parseCents(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// UnmarshalJSON implements the unmarshal interface
+func (nm *NullMoney) UnmarshalJSON(b []byte) error {
+	if len(b) == 4 && b[0] == 110 && b[1] == 117 && b[2] == 108 && b[3] == 108 {
+		nm.Valid, nm.Money = false, 0
+		return nil
+	}
+	err := nm.Money.UnmarshalJSON(b)
+	nm.Valid = (err == nil)
+	return err
+}
+
+// Add adds the amount of an imported line to the import total.
+func (r *Reconciliation) Add(m Money) {
+	r.LinesCount++
+	r.ImportTotal += int64(m)
+}
+
+// Check sets the source total and the difference with the import total.
+func (r *Reconciliation) Check(source NullMoney) {
+	if !source.Valid {
+		r.SourceTotal, r.Difference = NullInt64{}, NullInt64{}
+		return
+	}
+	r.SourceTotal = NullInt64{Valid: true, Int64: int64(source.Money)}
+	r.Difference = NullInt64{Valid: true, Int64: r.ImportTotal - int64(source.Money)}
+}
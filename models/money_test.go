@@ -0,0 +1,106 @@
+package models
+
+import "testing"
+
+func TestParseCents(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Money
+		err  bool
+	}{
+		{in: "1234.56", want: 123456},
+		{in: "1234,56", want: 123456},
+		{in: " 12 ", want: 1200},
+		{in: "0.29", want: 29},
+		{in: "0,29", want: 29},
+		{in: "1.005", want: 101},
+		{in: "1.004", want: 100},
+		{in: "2.675", want: 268},
+		{in: "-1.005", want: -101},
+		{in: "-0.29", want: -29},
+		{in: "-1234,5", want: -123450},
+		{in: "1e3", want: 100000},
+		{in: "1.5E-2", want: 2},
+		{in: "-2.5e-3", want: 0},
+		{in: "92233720368547758.07", want: 9223372036854775807},
+		{in: "92233720368547758.08", err: true},
+		{in: "-92233720368547758.09", err: true},
+		{in: "", err: true},
+		{in: "   ", err: true},
+		{in: "1/2", err: true},
+		{in: "1,234.56", err: true},
+		{in: "abc", err: true},
+	}
+	for _, c := range cases {
+		got, err := parseCents(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseCents(%q) : erreur attendue, reçu %d", c.in, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("parseCents(%q) : attendu %d, reçu %d (%v)", c.in, c.want, got, err)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Money
+		err  bool
+	}{
+		{in: `123.45`, want: 12345},
+		{in: `"123,45"`, want: 12345},
+		{in: `"123.456"`, want: 12346},
+		{in: `-0.5`, want: -50},
+		{in: `1e2`, want: 10000},
+		{in: `null`, want: 7},
+		{in: `""`, err: true},
+		{in: `true`, err: true},
+		{in: `"1e400"`, err: true},
+	}
+	for _, c := range cases {
+		m := Money(7)
+		err := m.UnmarshalJSON([]byte(c.in))
+		if c.err {
+			if err == nil {
+				t.Errorf("Money %s : erreur attendue, reçu %d", c.in, m)
+			}
+			continue
+		}
+		if err != nil || m != c.want {
+			t.Errorf("Money %s : attendu %d, reçu %d (%v)", c.in, c.want, m, err)
+		}
+	}
+}
+
+func TestNullMoneyUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		in   string
+		want NullMoney
+		err  bool
+	}{
+		{in: `null`, want: NullMoney{}},
+		{in: `0`, want: NullMoney{Valid: true}},
+		{in: `"12,3"`, want: NullMoney{Valid: true, Money: 1230}},
+		{in: `-7.125`, want: NullMoney{Valid: true, Money: -713}},
+		{in: `""`, err: true},
+		{in: `"x"`, err: true},
+		{in: `1e30`, err: true},
+	}
+	for _, c := range cases {
+		nm := NullMoney{Valid: true, Money: 7}
+		err := nm.UnmarshalJSON([]byte(c.in))
+		if c.err {
+			if err == nil || nm.Valid {
+				t.Errorf("NullMoney %s : erreur attendue, reçu %+v", c.in, nm)
+			}
+			continue
+		}
+		if err != nil || nm != c.want {
+			t.Errorf("NullMoney %s : attendu %+v, reçu %+v (%v)", c.in, c.want, nm, err)
+		}
+	}
+}
@@ -2,62 +2,86 @@ package models
 
 import (
 	"database/sql"
-	"encoding/json"
-	"strconv"
-	"strings"
+	"fmt"
 )
 
-// MultiannualProg embeddes an array of bytes for json export.
+// MultiannualProgValue is the commitment prevision of an operation for a year.
+type MultiannualProgValue struct {
+	Value      int64       `json:"value"`
+	TotalValue NullInt64   `json:"total_value"`
+	StateRatio NullFloat64 `json:"state_ratio"`
+}
+
+// MultiannualProgLine is the commitment previsions of an operation, Values
+// being null for the years without prevision.
+type MultiannualProgLine struct {
+	Number       string                  `json:"number"`
+	Name         string                  `json:"name"`
+	StepName     NullString              `json:"step_name"`
+	CategoryName NullString              `json:"category_name"`
+	Values       []*MultiannualProgValue `json:"values"`
+}
+
+// MultiannualProg embeddes the years and the lines of the multiannual
+// programmation for json export.
 type MultiannualProg struct {
-	MultiannualProg json.RawMessage `json:"MultiannualProgrammation"`
+	Years           []int64               `json:"Years"`
+	MultiannualProg []MultiannualProgLine `json:"MultiannualProgrammation"`
 }
 
-// GetAll fetches multi annual programmation from database.
-func (m *MultiannualProg) GetAll(firstYear int64, db *sql.DB) (err error) {
-	var lastYear int64
-	if err = db.QueryRow("SELECT max(year) FROM prev_commitment").Scan(&lastYear); err != nil {
+// GetAll fetches multi annual programmation from database or from the
+// prevision snapshot if sID isn't 0. If years is 0, the programmation goes
+// up to the last year of the previsions with at least five years.
+func (m *MultiannualProg) GetAll(firstYear, years int64, sID int64, db *sql.DB) (err error) {
+	if err = checkPrevisionSnapshot(sID, db); err != nil {
 		return err
 	}
-	if lastYear < firstYear+4 {
-		lastYear = firstYear + 4
-	}
-	if _, err := db.Exec("CREATE EXTENSION IF NOT EXISTS tablefunc"); err != nil {
-		return err
+	source := snapshotSource("prev_commitment", "pc", sID)
+	if years == 0 {
+		var lastYear int64
+		if err = db.QueryRow("SELECT COALESCE(max(year),0) FROM " + source).
+			Scan(&lastYear); err != nil {
+			return err
+		}
+		if years = lastYear - firstYear + 1; years < 5 {
+			years = 5
+		}
 	}
-	var columnNames, typesNames, jsonNames []string
-	for i := firstYear; i <= lastYear; i++ {
-		year := strconv.FormatInt(i-firstYear, 10)
-		columnNames = append(columnNames, `"`+strconv.FormatInt(i, 10)+`" AS y`+year)
-		typesNames = append(typesNames, `"`+strconv.FormatInt(i, 10)+`" VARCHAR`)
-		jsonNames = append(jsonNames, `'y`+year+`', q.y`+year)
+	m.Years = make([]int64, years)
+	for i := range m.Years {
+		m.Years[i] = firstYear + int64(i)
 	}
-	qry := `SELECT json_build_object('number',q.number,'name',q.name,'step_name', q.step_name, 'category_name',
-	 q.category_name,` + strings.Join(jsonNames, ",") + `) FROM
-	(SELECT op.number, op.name, s.name as step_name, cat.name as category_name, ` + strings.Join(columnNames, ",") + ` FROM 
-	crosstab('SELECT physical_op_id, year, row_to_json((SELECT d FROM (SELECT value, total_value, state_ratio) d)) 
-						FROM prev_commitment ORDER BY 1,2', 
-					'SELECT m FROM generate_series(` + strconv.FormatInt(firstYear, 10) + `,` + strconv.FormatInt(lastYear, 10) + `) AS m') AS
-					(op_id INTEGER, ` + strings.Join(typesNames, ",") + `)
-	JOIN physical_op op ON op.id = op_id
+	rows, err := db.Query(`SELECT op.id,op.number,op.name,s.name,cat.name,pc.year,
+		pc.value,pc.total_value,pc.state_ratio
+	FROM physical_op op
+	JOIN ` + source + ` ON pc.physical_op_id=op.id
 	LEFT OUTER JOIN step s ON op.step_id = s.id
-	LEFT OUTER JOIN category cat ON op.category_id = cat.id) q`
-	rows, err := db.Query(qry)
+	LEFT OUTER JOIN category cat ON op.category_id = cat.id
+	ORDER BY op.number,op.id,pc.year`)
 	if err != nil {
-		return err
+		return fmt.Errorf("select %v", err)
 	}
-	var r string
-	var rr []string
 	defer rows.Close()
+	var opID, lastOpID, year int64
+	var l MultiannualProgLine
+	m.MultiannualProg = []MultiannualProgLine{}
 	for rows.Next() {
-		if err = rows.Scan(&r); err != nil {
-			return err
+		var v MultiannualProgValue
+		if err = rows.Scan(&opID, &l.Number, &l.Name, &l.StepName, &l.CategoryName,
+			&year, &v.Value, &v.TotalValue, &v.StateRatio); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		if len(m.MultiannualProg) == 0 || opID != lastOpID {
+			l.Values = make([]*MultiannualProgValue, years)
+			m.MultiannualProg = append(m.MultiannualProg, l)
+			lastOpID = opID
+		}
+		if i := year - firstYear; i >= 0 && i < years {
+			m.MultiannualProg[len(m.MultiannualProg)-1].Values[i] = &v
 		}
-		rr = append(rr, r)
 	}
-	err = rows.Err()
-	if err != nil {
-		return err
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
 	}
-	m.MultiannualProg = json.RawMessage("[" + strings.Join(rr, ",") + "]")
 	return nil
 }
@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -9,31 +10,20 @@ import (
 	"github.com/lib/pq"
 )
 
-// OpDptRatio model
-type OpDptRatio struct {
-	ID           int64   `json:"id"`
+// OpTerritoryRatio model is the share of a physical operation located on a
+// territory.
+type OpTerritoryRatio struct {
 	PhysicalOpID int64   `json:"physical_op_id"`
-	R75          float64 `json:"r75"`
-	R77          float64 `json:"r77"`
-	R78          float64 `json:"r78"`
-	R91          float64 `json:"r91"`
-	R92          float64 `json:"r92"`
-	R93          float64 `json:"r93"`
-	R94          float64 `json:"r94"`
-	R95          float64 `json:"r95"`
+	TerritoryID  int64   `json:"territory_id"`
+	Ratio        float64 `json:"ratio"`
 }
 
-// OpDptRatioLine embeddes a line of sent datas for a batch of datas.
+// OpDptRatioLine embeddes a line of sent datas for a batch of datas, the
+// territory being identified by its code.
 type OpDptRatioLine struct {
 	PhysicalOpID int64   `json:"physical_op_id"`
-	R75          float64 `json:"r75"`
-	R77          float64 `json:"r77"`
-	R78          float64 `json:"r78"`
-	R91          float64 `json:"r91"`
-	R92          float64 `json:"r92"`
-	R93          float64 `json:"r93"`
-	R94          float64 `json:"r94"`
-	R95          float64 `json:"r95"`
+	Code         string  `json:"code"`
+	Ratio        float64 `json:"ratio"`
 }
 
 // OpDptRatioBatch embeddes a batch of opDptRatioLine to upload into database.
@@ -41,25 +31,24 @@ type OpDptRatioBatch struct {
 	OpDptRatioLines []OpDptRatioLine `json:"OpDptRatios"`
 }
 
-// FCPerDpt is used to decode one row of financial commitment per department query.
+// ratioTolerance absorbs the rounding of the sum of float ratios.
+const ratioTolerance = 1e-9
+
+// FCPerDpt is used to decode one row of financial commitment per territory
+// query, the values being ordered as the territories.
 type FCPerDpt struct {
-	Total NullInt64 `json:"total"`
-	FC75  NullInt64 `json:"fc75"`
-	FC77  NullInt64 `json:"fc77"`
-	FC78  NullInt64 `json:"fc78"`
-	FC91  NullInt64 `json:"fc91"`
-	FC92  NullInt64 `json:"fc92"`
-	FC93  NullInt64 `json:"fc93"`
-	FC94  NullInt64 `json:"fc94"`
-	FC95  NullInt64 `json:"fc95"`
+	Total  NullInt64   `json:"total"`
+	Values []NullInt64 `json:"values"`
 }
 
-// FCPerDepartments embeddes an array ofFcPerDpt for json export.
+// FCPerDepartments embeddes an array of FCPerDpt and the territories for json
+// export.
 type FCPerDepartments struct {
 	FCPerDepartments []FCPerDpt `json:"FinancialCommitmentPerDpt"`
+	Territories
 }
 
-// DetailedFCPerDpt is used to decode one row of detailed financial commitment per department query
+// DetailedFCPerDpt is used to decode one row of detailed financial commitment per territory query
 type DetailedFCPerDpt struct {
 	FCPerDpt
 	ID     NullInt64  `json:"id"`
@@ -67,75 +56,74 @@ type DetailedFCPerDpt struct {
 	Name   NullString `json:"name"`
 }
 
-// DetailedFCPerDepartments embeddes an array ofFcPerDpt for json export.
+// DetailedFCPerDepartments embeddes an array of DetailedFCPerDpt and the
+// territories for json export.
 type DetailedFCPerDepartments struct {
 	DetailedFCPerDepartments []DetailedFCPerDpt `json:"DetailedFinancialCommitmentPerDpt"`
+	Territories
 }
 
-// OpWithDptRatio embeddes a row of query that fetches physical operations and ratios par department.
+// OpWithDptRatio embeddes a row of query that fetches physical operations and
+// ratios per territory, ordered as the territories.
 type OpWithDptRatio struct {
-	ID     int64       `json:"id"`
-	Number string      `json:"number"`
-	Name   string      `json:"name"`
-	R75    NullFloat64 `json:"r75"`
-	R77    NullFloat64 `json:"r77"`
-	R78    NullFloat64 `json:"r78"`
-	R91    NullFloat64 `json:"r91"`
-	R92    NullFloat64 `json:"r92"`
-	R93    NullFloat64 `json:"r93"`
-	R94    NullFloat64 `json:"r94"`
-	R95    NullFloat64 `json:"r95"`
+	ID     int64         `json:"id"`
+	Number string        `json:"number"`
+	Name   string        `json:"name"`
+	Ratios []NullFloat64 `json:"ratios"`
 }
 
-// OpWithDptRatios embeddes an array of OpWithDptRatio for json export.
+// OpWithDptRatios embeddes an array of OpWithDptRatio and the territories for
+// json export.
 type OpWithDptRatios struct {
 	OpWithDptRatios []OpWithDptRatio `json:"OpsWithDptRatios"`
+	Territories
 }
 
-// DetailedPrgPerDpt  is used to decode one row of detailed programmings per department query
+// DetailedPrgPerDpt  is used to decode one row of detailed programmings per territory query
 type DetailedPrgPerDpt struct {
-	Date   time.Time `json:"date"`
-	ID     int       `json:"id"`
-	Number string    `json:"number"`
-	Name   string    `json:"name"`
-	Total  NullInt64 `json:"total"`
-	PR75   NullInt64 `json:"pr75"`
-	PR77   NullInt64 `json:"pr77"`
-	PR78   NullInt64 `json:"pr78"`
-	PR91   NullInt64 `json:"pr91"`
-	PR92   NullInt64 `json:"pr92"`
-	PR93   NullInt64 `json:"pr93"`
-	PR94   NullInt64 `json:"pr94"`
-	PR95   NullInt64 `json:"pr95"`
+	Date   time.Time   `json:"date"`
+	ID     int         `json:"id"`
+	Number string      `json:"number"`
+	Name   string      `json:"name"`
+	Total  NullInt64   `json:"total"`
+	Values []NullInt64 `json:"values"`
 }
 
-// DetailedPrgPerDepartments embeddes an array of DetailedPrgPerDpt for json export.
+// DetailedPrgPerDepartments embeddes an array of DetailedPrgPerDpt and the
+// territories for json export.
 type DetailedPrgPerDepartments struct {
 	DetailedPrgPerDepartments []DetailedPrgPerDpt `json:"DetailedProgrammingsPerDpt"`
+	Territories
 }
 
 // GetAll fetches OpWithDptRatio from database according to user role with convention
-// that uID is null for ADMINS or OBSERVERS and other for USERS
-func (o *OpWithDptRatios) GetAll(uID int64, db *sql.DB) (err error) {
+// that uID is null for ADMINS or OBSERVERS and other for USERS. The ratios
+// are those of the territories of the level.
+func (o *OpWithDptRatios) GetAll(level string, uID int64, db *sql.DB) (err error) {
+	ratios, err := fetchOpTerritoryRatios(level, &o.Territories, db)
+	if err != nil {
+		return err
+	}
 	var whereClause string
 	if uID != 0 {
-		whereClause = "WHERE op.id IN (SELECT physical_op_id FROM rights WHERE users_id = " +
+		whereClause = " WHERE op.id IN (SELECT physical_op_id FROM rights WHERE users_id = " +
 			strconv.FormatInt(uID, 10) + " ) "
 	}
-	rows, err := db.Query(`SELECT op.id, op.number, op.name, r.r75, r.r77, r.r78, 
-	r.r91, r.r92, r.r93, r.r94, r.r95
-	FROM physical_op op
-	LEFT OUTER JOIN op_dpt_ratios r ON r.physical_op_id = op.id` + whereClause)
+	rows, err := db.Query(`SELECT op.id, op.number, op.name FROM physical_op op` +
+		whereClause)
 	if err != nil {
 		return err
 	}
 	var r OpWithDptRatio
 	defer rows.Close()
 	for rows.Next() {
-		if err = rows.Scan(&r.ID, &r.Number, &r.Name, &r.R75, &r.R77, &r.R78, &r.R91, &r.R92,
-			&r.R93, &r.R94, &r.R95); err != nil {
+		if err = rows.Scan(&r.ID, &r.Number, &r.Name); err != nil {
 			return err
 		}
+		r.Ratios = ratios[r.ID]
+		if r.Ratios == nil {
+			r.Ratios = make([]NullFloat64, len(o.Territories.Territories))
+		}
 		o.OpWithDptRatios = append(o.OpWithDptRatios, r)
 	}
 	err = rows.Err()
@@ -145,41 +133,74 @@ func (o *OpWithDptRatios) GetAll(uID int64, db *sql.DB) (err error) {
 	return err
 }
 
-// Save a batch of OpDptRatio into database.
-func (o *OpDptRatioBatch) Save(uID int64, db *sql.DB) (err error) {
+// Validate checks that the batch neither sends the same territory twice for an
+// operation nor gives an operation ratios whose sum exceeds 1.
+func (o *OpDptRatioBatch) Validate() error {
+	type opCode struct {
+		opID int64
+		code string
+	}
+	codes := make(map[opCode]bool, len(o.OpDptRatioLines))
+	sums := make(map[int64]float64)
+	for _, l := range o.OpDptRatioLines {
+		if l.Ratio < 0 {
+			return fmt.Errorf("ratio négatif pour l'opération %d et le territoire %s",
+				l.PhysicalOpID, l.Code)
+		}
+		k := opCode{opID: l.PhysicalOpID, code: l.Code}
+		if codes[k] {
+			return fmt.Errorf("territoire %s en double pour l'opération %d",
+				l.Code, l.PhysicalOpID)
+		}
+		codes[k] = true
+		sums[l.PhysicalOpID] += l.Ratio
+		if sums[l.PhysicalOpID] > 1+ratioTolerance {
+			return fmt.Errorf("somme des ratios de l'opération %d supérieure à 1",
+				l.PhysicalOpID)
+		}
+	}
+	return nil
+}
+
+// Save a batch of ratios on the territories of the level into database. The
+// ratios of the level of the operations that are not in the batch are
+// removed and the ratios of the operations of the batch are replaced so that
+// an operation has ratios on one level only.
+func (o *OpDptRatioBatch) Save(level string, uID int64, db *sql.DB) (err error) {
+	if err = CheckTerritoryLevel(level); err != nil {
+		return err
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	var andClause, andInsertClause string
+	var andClause, whereInsertClause string
 	if uID != 0 {
-		andClause = `AND op_dpt_ratios.physical_op_id IN 
+		andClause = `AND op_territory_ratio.physical_op_id IN 
 		(SELECT physical_op_id FROM rights WHERE users_id = ` +
 			strconv.FormatInt(uID, 10) + ")"
-		andInsertClause = `AND physical_op_id IN 
+		whereInsertClause = `WHERE t.physical_op_id IN 
 		(SELECT physical_op_id FROM rights WHERE users_id = ` +
 			strconv.FormatInt(uID, 10) + ")"
 	}
-	if _, err = tx.Exec("DROP TABLE IF EXISTS temp_op_dpt_ratios"); err != nil {
+	if _, err = tx.Exec("DROP TABLE IF EXISTS temp_op_territory_ratio"); err != nil {
 		tx.Rollback()
 		return err
 	}
-	if _, err = tx.Exec(`CREATE TABLE temp_op_dpt_ratios ( 
-			physical_op_id integer, r75 double precision, r77 double precision, 
-			r78 double precision, r91 double precision, r92 double precision, 
-			r93 double precision, r94 double precision, r95 double precision );`); err != nil {
+	if _, err = tx.Exec(`CREATE TABLE temp_op_territory_ratio ( 
+			physical_op_id integer, code varchar(20), ratio double precision );`); err != nil {
 		tx.Rollback()
 		return err
 	}
-	stmt, err := tx.Prepare(pq.CopyIn("temp_op_dpt_ratios", "physical_op_id",
-		"r75", "r77", "r78", "r91", "r92", "r93", "r94", "r95"))
+	stmt, err := tx.Prepare(pq.CopyIn("temp_op_territory_ratio", "physical_op_id",
+		"code", "ratio"))
 	if err != nil {
+		tx.Rollback()
 		return fmt.Errorf("prepare stmt %v", err)
 	}
 	defer stmt.Close()
 	for _, r := range o.OpDptRatioLines {
-		if _, err = stmt.Exec(r.PhysicalOpID, r.R75, r.R77, r.R78, r.R91, r.R92,
-			r.R93, r.R94, r.R95); err != nil {
+		if _, err = stmt.Exec(r.PhysicalOpID, r.Code, r.Ratio); err != nil {
 			tx.Rollback()
 			return fmt.Errorf("insertion de %+v  %v", r, err)
 		}
@@ -188,26 +209,33 @@ func (o *OpDptRatioBatch) Save(uID int64, db *sql.DB) (err error) {
 		tx.Rollback()
 		return fmt.Errorf("statement exec flush %v", err)
 	}
-	if _, err = tx.Exec(`DELETE FROM op_dpt_ratios WHERE physical_op_id NOT IN
-		(SELECT physical_op_id FROM temp_op_dpt_ratios)` + andClause); err != nil {
+	var code string
+	err = tx.QueryRow(`SELECT code FROM temp_op_territory_ratio t
+		WHERE NOT EXISTS (SELECT 1 FROM territory WHERE level=$1 AND code=t.code)
+		LIMIT 1`, level).Scan(&code)
+	if err == nil {
+		tx.Rollback()
+		return errors.New("Territoire " + code + " introuvable")
+	}
+	if err != sql.ErrNoRows {
 		tx.Rollback()
 		return err
 	}
-	if _, err := tx.Exec(`UPDATE op_dpt_ratios SET r75 = t.r75, r77 = t.r77, r78 = t.r78,
-		r91 = t.r91, r92 = t.r92, r93 = t.r93, r94 = t.r94, r95 = t.r95
-		FROM temp_op_dpt_ratios t WHERE op_dpt_ratios.physical_op_id = t.physical_op_id` +
-		andClause); err != nil {
+	if _, err = tx.Exec(`DELETE FROM op_territory_ratio WHERE 
+		(physical_op_id IN (SELECT physical_op_id FROM temp_op_territory_ratio)
+		OR territory_id IN (SELECT id FROM territory WHERE level=$1))`+andClause,
+		level); err != nil {
 		tx.Rollback()
 		return err
 	}
-	if _, err := tx.Exec(`INSERT INTO op_dpt_ratios (physical_op_id,r75,r77,r78,r91,r92,r93,r94,r95) 
-		SELECT * FROM temp_op_dpt_ratios t
-			WHERE t.physical_op_id NOT IN (SELECT physical_op_id FROM op_dpt_ratios)` +
-		andInsertClause); err != nil {
+	if _, err = tx.Exec(`INSERT INTO op_territory_ratio (physical_op_id,territory_id,ratio)
+		SELECT t.physical_op_id,te.id,t.ratio FROM temp_op_territory_ratio t
+		JOIN territory te ON te.level=$1 AND te.code=t.code `+whereInsertClause,
+		level); err != nil {
 		tx.Rollback()
 		return err
 	}
-	if _, err = tx.Exec("DROP TABLE IF EXISTS temp_op_dpt_ratios"); err != nil {
+	if _, err = tx.Exec("DROP TABLE IF EXISTS temp_op_territory_ratio"); err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -215,65 +243,82 @@ func (o *OpDptRatioBatch) Save(uID int64, db *sql.DB) (err error) {
 	return err
 }
 
-// GetAll fetches financial commitments per departments from database.
-func (f *FCPerDepartments) GetAll(firstYear int, lastYear int, db *sql.DB) (err error) {
-	sy0 := strconv.Itoa(firstYear)
-	sy1 := strconv.Itoa(lastYear)
-	query := `SELECT SUM(fc.value)::bigint AS total, SUM(fc.value*r.r75)::bigint AS fc75,
-	SUM(fc.value*r.r77)::bigint AS fc77, SUM(fc.value*r.r78)::bigint AS fc78,
-	SUM(fc.value*r.r91)::bigint AS fc91, SUM(fc.value*r.r92)::bigint AS fc92,
-	SUM(fc.value*r.r93)::bigint AS fc93, SUM(fc.value*r.r94)::bigint AS fc94,
-	SUM(fc.value*r.r95)::bigint AS fc95
+// GetAll fetches financial commitments per territory of the level from database.
+func (f *FCPerDepartments) GetAll(level string, firstYear int, lastYear int, db *sql.DB) (err error) {
+	index, err := f.territoryIndex(level, db)
+	if err != nil {
+		return err
+	}
+	r := FCPerDpt{Values: make([]NullInt64, len(index))}
+	if err = db.QueryRow(`SELECT SUM(value)::bigint FROM financial_commitment
+		WHERE extract(year FROM date)>=$1 AND extract(year FROM date)<=$2`,
+		firstYear, lastYear).Scan(&r.Total); err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT r.territory_id, SUM(fc.value*r.ratio)::bigint
 	FROM financial_commitment fc
-	LEFT OUTER JOIN physical_op op ON fc.physical_op_id = op.id
-	LEFT OUTER JOIN op_dpt_ratios r ON r.physical_op_id = op.id
-	WHERE extract(year FROM fc.date)>=` + sy0 + ` AND extract(year FROM fc.date)<=` + sy1
-	rows, err := db.Query(query)
+	JOIN `+territoryRatios+` r ON r.physical_op_id = fc.physical_op_id
+	WHERE extract(year FROM fc.date)>=$2 AND extract(year FROM fc.date)<=$3
+	GROUP BY 1`, level, firstYear, lastYear)
 	if err != nil {
 		return err
 	}
-	var r FCPerDpt
+	var (
+		tID   int64
+		value NullInt64
+	)
 	defer rows.Close()
 	for rows.Next() {
-		if err = rows.Scan(&r.Total, &r.FC75, &r.FC77, &r.FC78, &r.FC91, &r.FC92, &r.FC93,
-			&r.FC94, &r.FC95); err != nil {
+		if err = rows.Scan(&tID, &value); err != nil {
 			return err
 		}
-		f.FCPerDepartments = append(f.FCPerDepartments, r)
+		r.Values[index[tID]] = value
 	}
-	err = rows.Err()
-	if len(f.FCPerDepartments) == 0 {
-		f.FCPerDepartments = []FCPerDpt{}
+	if err = rows.Err(); err != nil {
+		return err
 	}
-	return err
+	f.FCPerDepartments = []FCPerDpt{r}
+	return nil
 }
 
-// GetAll fetches detailed financial commitments per departments from database.
-func (f *DetailedFCPerDepartments) GetAll(firstYear int, lastYear int, db *sql.DB) (err error) {
-	sy0 := strconv.Itoa(firstYear)
-	sy1 := strconv.Itoa(lastYear)
-	query := `SELECT op.id, op.number, op.name, SUM(fc.value)::bigint AS total,
-	SUM(fc.value*r.r75)::bigint AS fc75, SUM(fc.value*r.r77)::bigint AS fc77, 
-	SUM(fc.value*r.r78)::bigint AS fc78, SUM(fc.value*r.r91)::bigint AS fc91,
-	SUM(fc.value*r.r92)::bigint AS fc92, SUM(fc.value*r.r93)::bigint AS fc93,
-	SUM(fc.value*r.r94)::bigint AS fc94, SUM(fc.value*r.r95)::bigint AS fc95
-	FROM financial_commitment fc
-	LEFT OUTER JOIN physical_op op ON fc.physical_op_id = op.id
-	LEFT OUTER JOIN op_dpt_ratios r ON r.physical_op_id = op.id
-	WHERE extract(year FROM fc.date)>=` + sy0 + ` AND extract(year FROM fc.date)<=` +
-		sy1 + ` GROUP BY 1,2,3 ORDER BY 2,3`
-	rows, err := db.Query(query)
+// GetAll fetches detailed financial commitments per territory of the level
+// from database.
+func (f *DetailedFCPerDepartments) GetAll(level string, firstYear int, lastYear int, db *sql.DB) (err error) {
+	index, err := f.territoryIndex(level, db)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT q.id, q.number, q.name, q.total, r.territory_id,
+		(q.total*r.ratio)::bigint
+	FROM (SELECT op.id, op.number, op.name, SUM(fc.value)::bigint AS total
+		FROM financial_commitment fc
+		LEFT OUTER JOIN physical_op op ON fc.physical_op_id = op.id
+		WHERE extract(year FROM fc.date)>=$2 AND extract(year FROM fc.date)<=$3
+		GROUP BY 1,2,3) q
+	LEFT OUTER JOIN `+territoryRatios+` r ON r.physical_op_id = q.id
+	ORDER BY 2,3,1`, level, firstYear, lastYear)
 	if err != nil {
 		return err
 	}
-	var r DetailedFCPerDpt
+	var (
+		r     DetailedFCPerDpt
+		tID   NullInt64
+		value NullInt64
+	)
 	defer rows.Close()
 	for rows.Next() {
-		if err = rows.Scan(&r.ID, &r.Number, &r.Name, &r.Total, &r.FC75, &r.FC77,
-			&r.FC78, &r.FC91, &r.FC92, &r.FC93, &r.FC94, &r.FC95); err != nil {
+		if err = rows.Scan(&r.ID, &r.Number, &r.Name, &r.Total, &tID, &value); err != nil {
 			return err
 		}
-		f.DetailedFCPerDepartments = append(f.DetailedFCPerDepartments, r)
+		n := len(f.DetailedFCPerDepartments)
+		if n == 0 || f.DetailedFCPerDepartments[n-1].ID != r.ID {
+			r.Values = make([]NullInt64, len(index))
+			f.DetailedFCPerDepartments = append(f.DetailedFCPerDepartments, r)
+			n++
+		}
+		if tID.Valid {
+			f.DetailedFCPerDepartments[n-1].Values[index[tID.Int64]] = value
+		}
 	}
 	err = rows.Err()
 	if len(f.DetailedFCPerDepartments) == 0 {
@@ -282,31 +327,45 @@ func (f *DetailedFCPerDepartments) GetAll(firstYear int, lastYear int, db *sql.D
 	return err
 }
 
-// GetAll fetches detailed programmings per departments from database.
-func (f *DetailedPrgPerDepartments) GetAll(year int, db *sql.DB) (err error) {
-	sy := strconv.Itoa(year)
-	query := `SELECT c.date, op.id, op.number, op.name, SUM(pr.value)::bigint AS total,
-	SUM(pr.value*r.r75)::bigint AS pr75, SUM(pr.value*r.r77)::bigint AS pr77, 
-	SUM(pr.value*r.r78)::bigint AS pr78, SUM(pr.value*r.r91)::bigint AS pr91,
-	SUM(pr.value*r.r92)::bigint AS pr92, SUM(pr.value*r.r93)::bigint AS pr93,
-	SUM(pr.value*r.r94)::bigint AS pr94, SUM(pr.value*r.r95)::bigint AS pr95
-	FROM programmings pr
-	LEFT JOIN commissions c ON pr.commission_id = c.id
-	LEFT OUTER JOIN physical_op op ON pr.physical_op_id = op.id
-	LEFT OUTER JOIN op_dpt_ratios r ON r.physical_op_id = op.id
-	WHERE pr.year = ` + sy + ` GROUP BY 1,2,3,4 ORDER BY 1,3,4`
-	rows, err := db.Query(query)
+// GetAll fetches detailed programmings per territory of the level from database.
+func (f *DetailedPrgPerDepartments) GetAll(level string, year int, db *sql.DB) (err error) {
+	index, err := f.territoryIndex(level, db)
 	if err != nil {
 		return err
 	}
-	var r DetailedPrgPerDpt
+	rows, err := db.Query(`SELECT q.date, q.id, q.number, q.name, q.total,
+		r.territory_id, (q.total*r.ratio)::bigint
+	FROM (SELECT c.date, op.id, op.number, op.name, SUM(pr.value)::bigint AS total
+		FROM programmings pr
+		LEFT JOIN commissions c ON pr.commission_id = c.id
+		LEFT OUTER JOIN physical_op op ON pr.physical_op_id = op.id
+		WHERE pr.year = $2 GROUP BY 1,2,3,4) q
+	LEFT OUTER JOIN `+territoryRatios+` r ON r.physical_op_id = q.id
+	ORDER BY 1,3,4,2`, level, year)
+	if err != nil {
+		return err
+	}
+	var (
+		r     DetailedPrgPerDpt
+		tID   NullInt64
+		value NullInt64
+	)
 	defer rows.Close()
 	for rows.Next() {
-		if err = rows.Scan(&r.Date, &r.ID, &r.Number, &r.Name, &r.Total, &r.PR75, &r.PR77,
-			&r.PR78, &r.PR91, &r.PR92, &r.PR93, &r.PR94, &r.PR95); err != nil {
+		if err = rows.Scan(&r.Date, &r.ID, &r.Number, &r.Name, &r.Total, &tID,
+			&value); err != nil {
 			return err
 		}
-		f.DetailedPrgPerDepartments = append(f.DetailedPrgPerDepartments, r)
+		n := len(f.DetailedPrgPerDepartments)
+		if n == 0 || f.DetailedPrgPerDepartments[n-1].ID != r.ID ||
+			!f.DetailedPrgPerDepartments[n-1].Date.Equal(r.Date) {
+			r.Values = make([]NullInt64, len(index))
+			f.DetailedPrgPerDepartments = append(f.DetailedPrgPerDepartments, r)
+			n++
+		}
+		if tID.Valid {
+			f.DetailedPrgPerDepartments[n-1].Values[index[tID.Int64]] = value
+		}
 	}
 	err = rows.Err()
 	if len(f.DetailedPrgPerDepartments) == 0 {
@@ -314,3 +373,238 @@ func (f *DetailedPrgPerDepartments) GetAll(year int, db *sql.DB) (err error) {
 	}
 	return err
 }
+
+// dptCodes are the codes of the departments having a field in the legacy
+// format of the ratios and of the values per department
+var dptCodes = [...]string{"75", "77", "78", "91", "92", "93", "94", "95"}
+
+// dptIndexes returns the index in the territories of each department of the
+// legacy format or -1 if the department isn't configured.
+func (t *Territories) dptIndexes() [len(dptCodes)]int {
+	var idx [len(dptCodes)]int
+	for i, code := range dptCodes {
+		idx[i] = -1
+		for j, r := range t.Territories {
+			if r.Level == TerritoryDepartment && r.Code == code {
+				idx[i] = j
+				break
+			}
+		}
+	}
+	return idx
+}
+
+// dptValues picks the values of the departments of the legacy format.
+func dptValues(values []NullInt64, idx [len(dptCodes)]int) (v [len(dptCodes)]NullInt64) {
+	for i, j := range idx {
+		if j >= 0 && j < len(values) {
+			v[i] = values[j]
+		}
+	}
+	return v
+}
+
+// DptRatios are the ratios of an operation on the departments in the legacy
+// format.
+type DptRatios struct {
+	R75 NullFloat64 `json:"r75"`
+	R77 NullFloat64 `json:"r77"`
+	R78 NullFloat64 `json:"r78"`
+	R91 NullFloat64 `json:"r91"`
+	R92 NullFloat64 `json:"r92"`
+	R93 NullFloat64 `json:"r93"`
+	R94 NullFloat64 `json:"r94"`
+	R95 NullFloat64 `json:"r95"`
+}
+
+// newDptRatios picks the ratios of the departments of the legacy format.
+func newDptRatios(ratios []NullFloat64, idx [len(dptCodes)]int) DptRatios {
+	var v [len(dptCodes)]NullFloat64
+	for i, j := range idx {
+		if j >= 0 && j < len(ratios) {
+			v[i] = ratios[j]
+		}
+	}
+	return DptRatios{R75: v[0], R77: v[1], R78: v[2], R91: v[3], R92: v[4],
+		R93: v[5], R94: v[6], R95: v[7]}
+}
+
+// LegacyOpDptRatioLine embeddes a line of a batch in the legacy format with a
+// field per department.
+type LegacyOpDptRatioLine struct {
+	PhysicalOpID int64   `json:"physical_op_id"`
+	R75          float64 `json:"r75"`
+	R77          float64 `json:"r77"`
+	R78          float64 `json:"r78"`
+	R91          float64 `json:"r91"`
+	R92          float64 `json:"r92"`
+	R93          float64 `json:"r93"`
+	R94          float64 `json:"r94"`
+	R95          float64 `json:"r95"`
+}
+
+// LegacyOpDptRatioBatch embeddes a batch of LegacyOpDptRatioLine.
+type LegacyOpDptRatioBatch struct {
+	OpDptRatioLines []LegacyOpDptRatioLine `json:"OpDptRatios"`
+}
+
+// Batch converts the legacy batch into a batch of ratios on the departments.
+func (o *LegacyOpDptRatioBatch) Batch() OpDptRatioBatch {
+	b := OpDptRatioBatch{OpDptRatioLines: make([]OpDptRatioLine, 0,
+		len(o.OpDptRatioLines)*len(dptCodes))}
+	for _, l := range o.OpDptRatioLines {
+		for i, r := range [...]float64{l.R75, l.R77, l.R78, l.R91, l.R92, l.R93,
+			l.R94, l.R95} {
+			b.OpDptRatioLines = append(b.OpDptRatioLines, OpDptRatioLine{
+				PhysicalOpID: l.PhysicalOpID, Code: dptCodes[i], Ratio: r})
+		}
+	}
+	return b
+}
+
+// LegacyOpWithDptRatio embeddes an operation with its ratios on the
+// departments in the legacy format.
+type LegacyOpWithDptRatio struct {
+	ID     int64  `json:"id"`
+	Number string `json:"number"`
+	Name   string `json:"name"`
+	DptRatios
+}
+
+// LegacyOpWithDptRatios embeddes an array of LegacyOpWithDptRatio for json
+// export.
+type LegacyOpWithDptRatios struct {
+	OpWithDptRatios []LegacyOpWithDptRatio `json:"OpsWithDptRatios"`
+}
+
+// GetAll fetches the operations with their ratios on the departments in the
+// legacy format according to user role.
+func (o *LegacyOpWithDptRatios) GetAll(uID int64, db *sql.DB) error {
+	var g OpWithDptRatios
+	if err := g.GetAll(TerritoryDepartment, uID, db); err != nil {
+		return err
+	}
+	idx := g.Territories.dptIndexes()
+	o.OpWithDptRatios = make([]LegacyOpWithDptRatio, len(g.OpWithDptRatios))
+	for i, r := range g.OpWithDptRatios {
+		o.OpWithDptRatios[i] = LegacyOpWithDptRatio{ID: r.ID, Number: r.Number,
+			Name: r.Name, DptRatios: newDptRatios(r.Ratios, idx)}
+	}
+	return nil
+}
+
+// LegacyFCPerDpt is a row of financial commitments per department in the
+// legacy format.
+type LegacyFCPerDpt struct {
+	Total NullInt64 `json:"total"`
+	FC75  NullInt64 `json:"fc75"`
+	FC77  NullInt64 `json:"fc77"`
+	FC78  NullInt64 `json:"fc78"`
+	FC91  NullInt64 `json:"fc91"`
+	FC92  NullInt64 `json:"fc92"`
+	FC93  NullInt64 `json:"fc93"`
+	FC94  NullInt64 `json:"fc94"`
+	FC95  NullInt64 `json:"fc95"`
+}
+
+// newLegacyFCPerDpt picks the values of the departments of the legacy format.
+func newLegacyFCPerDpt(r FCPerDpt, idx [len(dptCodes)]int) LegacyFCPerDpt {
+	v := dptValues(r.Values, idx)
+	return LegacyFCPerDpt{Total: r.Total, FC75: v[0], FC77: v[1], FC78: v[2],
+		FC91: v[3], FC92: v[4], FC93: v[5], FC94: v[6], FC95: v[7]}
+}
+
+// LegacyFCPerDepartments embeddes an array of LegacyFCPerDpt for json export.
+type LegacyFCPerDepartments struct {
+	FCPerDepartments []LegacyFCPerDpt `json:"FinancialCommitmentPerDpt"`
+}
+
+// GetAll fetches financial commitments per department in the legacy format.
+func (f *LegacyFCPerDepartments) GetAll(firstYear int, lastYear int, db *sql.DB) error {
+	var g FCPerDepartments
+	if err := g.GetAll(TerritoryDepartment, firstYear, lastYear, db); err != nil {
+		return err
+	}
+	idx := g.Territories.dptIndexes()
+	f.FCPerDepartments = make([]LegacyFCPerDpt, len(g.FCPerDepartments))
+	for i, r := range g.FCPerDepartments {
+		f.FCPerDepartments[i] = newLegacyFCPerDpt(r, idx)
+	}
+	return nil
+}
+
+// LegacyDetailedFCPerDpt is a row of detailed financial commitments per
+// department in the legacy format.
+type LegacyDetailedFCPerDpt struct {
+	LegacyFCPerDpt
+	ID     NullInt64  `json:"id"`
+	Number NullString `json:"number"`
+	Name   NullString `json:"name"`
+}
+
+// LegacyDetailedFCPerDepartments embeddes an array of LegacyDetailedFCPerDpt
+// for json export.
+type LegacyDetailedFCPerDepartments struct {
+	DetailedFCPerDepartments []LegacyDetailedFCPerDpt `json:"DetailedFinancialCommitmentPerDpt"`
+}
+
+// GetAll fetches detailed financial commitments per department in the legacy
+// format.
+func (f *LegacyDetailedFCPerDepartments) GetAll(firstYear int, lastYear int, db *sql.DB) error {
+	var g DetailedFCPerDepartments
+	if err := g.GetAll(TerritoryDepartment, firstYear, lastYear, db); err != nil {
+		return err
+	}
+	idx := g.Territories.dptIndexes()
+	f.DetailedFCPerDepartments = make([]LegacyDetailedFCPerDpt,
+		len(g.DetailedFCPerDepartments))
+	for i, r := range g.DetailedFCPerDepartments {
+		f.DetailedFCPerDepartments[i] = LegacyDetailedFCPerDpt{
+			LegacyFCPerDpt: newLegacyFCPerDpt(r.FCPerDpt, idx), ID: r.ID,
+			Number: r.Number, Name: r.Name}
+	}
+	return nil
+}
+
+// LegacyDetailedPrgPerDpt is a row of detailed programmings per department in
+// the legacy format.
+type LegacyDetailedPrgPerDpt struct {
+	Date   time.Time `json:"date"`
+	ID     int       `json:"id"`
+	Number string    `json:"number"`
+	Name   string    `json:"name"`
+	Total  NullInt64 `json:"total"`
+	PR75   NullInt64 `json:"pr75"`
+	PR77   NullInt64 `json:"pr77"`
+	PR78   NullInt64 `json:"pr78"`
+	PR91   NullInt64 `json:"pr91"`
+	PR92   NullInt64 `json:"pr92"`
+	PR93   NullInt64 `json:"pr93"`
+	PR94   NullInt64 `json:"pr94"`
+	PR95   NullInt64 `json:"pr95"`
+}
+
+// LegacyDetailedPrgPerDepartments embeddes an array of LegacyDetailedPrgPerDpt
+// for json export.
+type LegacyDetailedPrgPerDepartments struct {
+	DetailedPrgPerDepartments []LegacyDetailedPrgPerDpt `json:"DetailedProgrammingsPerDpt"`
+}
+
+// GetAll fetches detailed programmings per department in the legacy format.
+func (f *LegacyDetailedPrgPerDepartments) GetAll(year int, db *sql.DB) error {
+	var g DetailedPrgPerDepartments
+	if err := g.GetAll(TerritoryDepartment, year, db); err != nil {
+		return err
+	}
+	idx := g.Territories.dptIndexes()
+	f.DetailedPrgPerDepartments = make([]LegacyDetailedPrgPerDpt,
+		len(g.DetailedPrgPerDepartments))
+	for i, r := range g.DetailedPrgPerDepartments {
+		v := dptValues(r.Values, idx)
+		f.DetailedPrgPerDepartments[i] = LegacyDetailedPrgPerDpt{Date: r.Date,
+			ID: r.ID, Number: r.Number, Name: r.Name, Total: r.Total, PR75: v[0],
+			PR77: v[1], PR78: v[2], PR91: v[3], PR92: v[4], PR93: v[5], PR94: v[6],
+			PR95: v[7]}
+	}
+	return nil
+}
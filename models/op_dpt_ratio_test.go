@@ -0,0 +1,68 @@
+package models
+
+import "testing"
+
+func TestOpDptRatioBatchValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		lines []OpDptRatioLine
+		err   bool
+	}{
+		{name: "vide"},
+		{name: "somme égale à 1", lines: []OpDptRatioLine{
+			{PhysicalOpID: 9, Code: "75", Ratio: 0.2},
+			{PhysicalOpID: 9, Code: "77", Ratio: 0.2},
+			{PhysicalOpID: 9, Code: "78", Ratio: 0.2},
+			{PhysicalOpID: 9, Code: "91", Ratio: 0.2},
+			{PhysicalOpID: 9, Code: "92", Ratio: 0.2}}},
+		{name: "même code sur deux opérations", lines: []OpDptRatioLine{
+			{PhysicalOpID: 9, Code: "75", Ratio: 0.8},
+			{PhysicalOpID: 10, Code: "75", Ratio: 0.8}}},
+		{name: "code en double", err: true, lines: []OpDptRatioLine{
+			{PhysicalOpID: 9, Code: "75", Ratio: 0.2},
+			{PhysicalOpID: 9, Code: "75", Ratio: 0.3}}},
+		{name: "somme supérieure à 1", err: true, lines: []OpDptRatioLine{
+			{PhysicalOpID: 9, Code: "75", Ratio: 0.6},
+			{PhysicalOpID: 9, Code: "77", Ratio: 0.5}}},
+		{name: "ratio négatif", err: true, lines: []OpDptRatioLine{
+			{PhysicalOpID: 9, Code: "75", Ratio: -0.5},
+			{PhysicalOpID: 9, Code: "77", Ratio: 1.2}}},
+	}
+	for _, c := range cases {
+		b := OpDptRatioBatch{OpDptRatioLines: c.lines}
+		err := b.Validate()
+		if c.err && err == nil {
+			t.Errorf("%s : erreur attendue", c.name)
+		}
+		if !c.err && err != nil {
+			t.Errorf("%s : erreur inattendue %v", c.name, err)
+		}
+	}
+}
+
+func TestLegacyOpDptRatioBatch(t *testing.T) {
+	legacy := LegacyOpDptRatioBatch{OpDptRatioLines: []LegacyOpDptRatioLine{
+		{PhysicalOpID: 9, R75: 0.6, R95: 0.5}}}
+	b := legacy.Batch()
+	if len(b.OpDptRatioLines) != len(dptCodes) {
+		t.Fatalf("%d lignes attendues, reçu %+v", len(dptCodes), b.OpDptRatioLines)
+	}
+	if l := b.OpDptRatioLines[7]; l.PhysicalOpID != 9 || l.Code != "95" || l.Ratio != 0.5 {
+		t.Errorf("ligne du 95 inattendue %+v", l)
+	}
+	if err := b.Validate(); err == nil {
+		t.Error("somme supérieure à 1 : erreur attendue")
+	}
+}
+
+func TestNewDptRatios(t *testing.T) {
+	ter := Territories{Territories: []Territory{
+		{ID: 1, Code: "77", Level: TerritoryDepartment},
+		{ID: 2, Code: "75", Level: TerritoryDepartment},
+		{ID: 3, Code: "99", Level: TerritoryDepartment}}}
+	r := newDptRatios([]NullFloat64{{Valid: true, Float64: 0.3},
+		{Valid: true, Float64: 0.7}, {Valid: true, Float64: 0.1}}, ter.dptIndexes())
+	if r.R75.Float64 != 0.7 || r.R77.Float64 != 0.3 || r.R78.Valid {
+		t.Errorf("ratios inattendus %+v", r)
+	}
+}
@@ -49,8 +49,8 @@ type PaymentLine struct {
 	CoriolisEgtLine string        `json:"coriolis_egt_line"`
 	Date            ExcelDate     `json:"date"`
 	Number          string        `json:"number"`
-	Value           float64       `json:"value"`
-	CancelledValue  float64       `json:"cancelled_value"`
+	Value           Money         `json:"value"`
+	CancelledValue  Money         `json:"cancelled_value"`
 	BeneficiaryCode int64         `json:"beneficiary_code"`
 	ReceiptDate     NullExcelDate `json:"receipt_date"`
 }
@@ -58,6 +58,7 @@ type PaymentLine struct {
 // PaymentBatch embeddes an array of PaymentLine for batch request.
 type PaymentBatch struct {
 	PaymentBatch []PaymentLine `json:"Payment"`
+	SourceTotal  NullMoney     `json:"SourceTotal"`
 }
 
 // PrevisionRealized is used to decode a line of the dedicated query.
@@ -135,14 +136,60 @@ FROM payment WHERE date >= $1 GROUP BY 1,2 ORDER BY 1,2`, d0)
 }
 
 // Save a batch of payments to the database.
-func (p *PaymentBatch) Save(db *sql.DB) (err error) {
+func (p *PaymentBatch) Save(db *sql.DB) (*Reconciliation, error) {
+	return p.SaveWithProgress(nil, db)
+}
+
+// SaveWithProgress saves a batch of payments to the database and calls
+// progress at the beginning of each phase of the import.
+func (p *PaymentBatch) SaveWithProgress(progress ImportProgress,
+	db *sql.DB) (*Reconciliation, error) {
+	i := 0
+	return savePayments(func(r *PaymentLine) (bool, error) {
+		if i >= len(p.PaymentBatch) {
+			return false, nil
+		}
+		*r = p.PaymentBatch[i]
+		i++
+		return true, nil
+	}, func(*sql.Tx) (NullMoney, error) { return p.SourceTotal, nil }, nil,
+		progress, db)
+}
+
+// SaveStream saves into database the payments decoded one by one from the
+// stream. If the guard reports that the content was already imported, the
+// import is rolled back and ErrAlreadyImported returned, otherwise the guard
+// records the reconciliation within the import transaction.
+func (p *PaymentBatch) SaveStream(s *BatchStream, guard ImportGuard,
+	db *sql.DB) (*Reconciliation, error) {
+	return p.SaveStreamWithProgress(s, guard, nil, db)
+}
+
+// SaveStreamWithProgress saves the lines decoded from the stream like
+// SaveStream and calls progress at the beginning of each phase of the import.
+func (p *PaymentBatch) SaveStreamWithProgress(s *BatchStream, guard ImportGuard,
+	progress ImportProgress, db *sql.DB) (*Reconciliation, error) {
+	return savePayments(func(r *PaymentLine) (bool, error) {
+		*r = PaymentLine{}
+		return s.Next(r)
+	}, s.endFunc(guard), guard, progress, db)
+}
+
+// savePayments copies the lines fetched by next into the temporary table and
+// then updates the payments in database. end is called once all lines are
+// read and returns the source total checked against the lines total. The
+// guard, if any, records the reconciliation before the commit.
+func savePayments(next func(*PaymentLine) (bool, error),
+	end func(*sql.Tx) (NullMoney, error), guard ImportGuard,
+	progress ImportProgress, db *sql.DB) (*Reconciliation, error) {
+	progress.report(StagingPhase)
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if _, err = tx.Exec("DELETE from temp_payment"); err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 
 	stmt, err := tx.Prepare(pq.CopyIn("temp_payment", "coriolis_year",
@@ -150,23 +197,42 @@ func (p *PaymentBatch) Save(db *sql.DB) (err error) {
 		"beneficiary_code", "date", "value", "cancelled_value", "number",
 		"receipt_date"))
 	if err != nil {
-		return fmt.Errorf("prepare stmt %v", err)
+		return nil, fmt.Errorf("prepare stmt %v", err)
 	}
 	defer stmt.Close()
-	for _, r := range p.PaymentBatch {
+	var (
+		r     PaymentLine
+		ok    bool
+		recon Reconciliation
+	)
+	for {
+		if ok, err = next(&r); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if !ok {
+			break
+		}
 		if _, err = stmt.Exec(r.CoriolisYear, r.CoriolisEgtCode, r.CoriolisEgtNum,
-			r.CoriolisEgtLine, r.BeneficiaryCode, r.Date.ToDate(), int64(100*r.Value),
-			int64(100*r.CancelledValue), r.Number, r.ReceiptDate.ToDate()); err != nil {
+			r.CoriolisEgtLine, r.BeneficiaryCode, r.Date.ToDate(), int64(r.Value),
+			int64(r.CancelledValue), r.Number, r.ReceiptDate.ToDate()); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("insertion de %+v  %v", r, err)
+			return nil, fmt.Errorf("insertion de %+v  %v", r, err)
 		}
+		recon.Add(r.Value)
+	}
+	sourceTotal, err := end(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
 	}
+	recon.Check(sourceTotal)
 	if _, err = stmt.Exec(); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("statement exec flush %v", err)
+		return nil, fmt.Errorf("statement exec flush %v", err)
 	}
 
-	queries := []string{`WITH new AS (
+	queries := []phaseQuery{{UpdatePhase, `WITH new AS (
 		SELECT p.id, t.number, t.date, t.value, t.cancelled_value,t.receipt_date
 			FROM temp_payment t
 			LEFT JOIN payment p ON t.number = p.number AND t.date = p.date
@@ -174,15 +240,15 @@ func (p *PaymentBatch) Save(db *sql.DB) (err error) {
 			OR (p.receipt_date ISNULL AND t.receipt_date NOTNULL))
 	UPDATE payment SET value = new.value, cancelled_value = new.cancelled_value, 
 		receipt_date=new.receipt_date
-	FROM new WHERE payment.id = new.id`,
-		`INSERT INTO PAYMENT (financial_commitment_id, coriolis_year, coriolis_egt_code,
+	FROM new WHERE payment.id = new.id`},
+		{InsertPhase, `INSERT INTO PAYMENT (financial_commitment_id, coriolis_year, coriolis_egt_code,
 		coriolis_egt_num, coriolis_egt_line, date, number, value, cancelled_value, 
 		beneficiary_code, receipt_date)
 		SELECT NULL, coriolis_year, coriolis_egt_code, coriolis_egt_num, 
 	 coriolis_egt_line, date, number, value, cancelled_value, beneficiary_code,
 	 receipt_date FROM temp_payment t
-		WHERE (t.number, t.date) NOT IN (SELECT number, date FROM payment)`,
-		`WITH ref AS (
+		WHERE (t.number, t.date) NOT IN (SELECT number, date FROM payment)`},
+		{LinkingPhase, `WITH ref AS (
 			SELECT DISTINCT ON (coriolis_year, coriolis_egt_code, coriolis_egt_num, coriolis_egt_line) 
 			id, coriolis_year, coriolis_egt_code, coriolis_egt_num, coriolis_egt_line 
 			FROM financial_commitment ORDER BY 2,3,4,5) 
@@ -191,12 +257,17 @@ func (p *PaymentBatch) Save(db *sql.DB) (err error) {
 			 FROM ref WHERE (payment.coriolis_year = ref.coriolis_year AND 
 			payment.coriolis_egt_code = ref.coriolis_egt_code AND 
 			payment.coriolis_egt_num = ref.coriolis_egt_num AND 
-			payment.coriolis_egt_line = ref.coriolis_egt_line)`,
-		"DELETE from temp_payment"}
+			payment.coriolis_egt_line = ref.coriolis_egt_line)`},
+		{LinkingPhase, "DELETE from temp_payment"}}
+	phase := StagingPhase
 	for _, q := range queries {
-		if _, err = tx.Exec(q); err != nil {
+		if q.Phase != phase {
+			phase = q.Phase
+			progress.report(phase)
+		}
+		if _, err = tx.Exec(q.Query); err != nil {
 			tx.Rollback()
-			return err
+			return nil, err
 		}
 	}
 	if _, err = tx.Exec(`INSERT INTO import_logs (category,last_date)
@@ -204,11 +275,17 @@ func (p *PaymentBatch) Save(db *sql.DB) (err error) {
 		ON CONFLICT (category) DO UPDATE SET last_date = EXCLUDED.last_date;`,
 		time.Now()); err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
+	}
+	if err = recordImport(guard, &recon, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
-	err = tx.Commit()
 	update(paymentUpdate)
-	return err
+	return &recon, nil
 }
 
 // GetAll calculates payement previsions and realized from database.
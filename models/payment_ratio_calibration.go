@@ -0,0 +1,128 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Iledant/iris-propera/forecast"
+)
+
+// PaymentRatiosCalibration compares the current ratios of a payment type to
+// the ratios computed from the payments on the commitments of the operations
+// of this payment type
+type PaymentRatiosCalibration struct {
+	Current    []YearRatio `json:"Current"`
+	Calibrated []YearRatio `json:"Calibrated"`
+	Used       int64       `json:"Used"`
+	Excluded   int64       `json:"Excluded"`
+}
+
+// loadCommitmentHistory fetches the commitments of the operations of the
+// payment type with the payments per count of years after the commitment.
+func loadCommitmentHistory(ptID int64, p *forecast.CalibrationParams,
+	db *sql.DB) ([]forecast.CommitmentHistory, error) {
+	rows, err := db.Query(`SELECT f.id,EXTRACT(year FROM f.date)::bigint,
+	f.value::double precision,
+	COALESCE(EXTRACT(year FROM p.date)-EXTRACT(year FROM f.date),0)::bigint,
+	COALESCE(SUM(p.value),0)::double precision
+	FROM financial_commitment f
+	JOIN physical_op op ON f.physical_op_id=op.id
+	LEFT JOIN payment p ON p.financial_commitment_id=f.id
+		AND EXTRACT(year FROM p.date)>=EXTRACT(year FROM f.date)
+	WHERE op.payment_types_id=$1 AND EXTRACT(year FROM f.date)>=$2
+		AND EXTRACT(year FROM f.date)<=$3
+	GROUP BY 1,2,3,4 ORDER BY 1,4`, ptID, p.FirstYear, p.LastYear)
+	if err != nil {
+		return nil, fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	var c []forecast.CommitmentHistory
+	var ID, lastID, year, idx int64
+	var value, paid float64
+	for rows.Next() {
+		if err = rows.Scan(&ID, &year, &value, &idx, &paid); err != nil {
+			return nil, fmt.Errorf("scan %v", err)
+		}
+		if len(c) == 0 || ID != lastID {
+			c = append(c, forecast.CommitmentHistory{Year: year, Value: value})
+			lastID = ID
+		}
+		h := &c[len(c)-1]
+		for int64(len(h.Paid)) <= idx {
+			h.Paid = append(h.Paid, 0)
+		}
+		h.Paid[idx] += paid
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows %v", err)
+	}
+	return c, nil
+}
+
+// Get computes the ratios of the payment type from the commitments of the
+// period and fetches its current ratios.
+func (c *PaymentRatiosCalibration) Get(ptID int64, p *forecast.CalibrationParams,
+	db *sql.DB) error {
+	var count int64
+	if err := db.QueryRow(`SELECT COUNT(1) FROM payment_types WHERE id=$1`,
+		ptID).Scan(&count); err != nil {
+		return fmt.Errorf("select count %v", err)
+	}
+	if count == 0 {
+		return errors.New("Chronique introuvable")
+	}
+	h, err := loadCommitmentHistory(ptID, p, db)
+	if err != nil {
+		return err
+	}
+	cal, err := forecast.Calibrate(h, *p)
+	if err != nil {
+		return err
+	}
+	c.Used, c.Excluded = cal.Used, cal.Excluded
+	c.Calibrated = make([]YearRatio, len(cal.Ratios))
+	for i, r := range cal.Ratios {
+		c.Calibrated[i] = YearRatio{Index: r.Index, Ratio: r.Ratio}
+	}
+	rows, err := db.Query(`SELECT index,ratio FROM payment_ratios
+	WHERE payment_types_id=$1 ORDER BY 1`, ptID)
+	if err != nil {
+		return fmt.Errorf("select ratios %v", err)
+	}
+	defer rows.Close()
+	var r YearRatio
+	c.Current = []YearRatio{}
+	for rows.Next() {
+		if err = rows.Scan(&r.Index, &r.Ratio); err != nil {
+			return fmt.Errorf("scan ratios %v", err)
+		}
+		c.Current = append(c.Current, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows ratios %v", err)
+	}
+	return nil
+}
+
+// Adopt computes the ratios of the payment type from the commitments of the
+// period and replaces its current ratios.
+func (c *PaymentRatiosCalibration) Adopt(ptID int64, p *forecast.CalibrationParams,
+	db *sql.DB) error {
+	if err := c.Get(ptID, p, db); err != nil {
+		return err
+	}
+	if len(c.Calibrated) == 0 {
+		return errors.New("aucun engagement sur la période")
+	}
+	batch := PaymentRatiosBatch{PaymentRatios: make([]PaymentRatioLine,
+		len(c.Calibrated))}
+	for i, r := range c.Calibrated {
+		batch.PaymentRatios[i] = PaymentRatioLine{Index: r.Index, Ratio: r.Ratio}
+	}
+	if err := batch.Save(ptID, db); err != nil {
+		return fmt.Errorf("save %v", err)
+	}
+	c.Current = c.Calibrated
+	return nil
+}
@@ -41,12 +41,13 @@ type PendingLine struct {
 	Name           string    `json:"name"`
 	Beneficiary    string    `json:"beneficiary"`
 	CommissionDate ExcelDate `json:"commission_date"`
-	ProposedValue  float64   `json:"proposed_value"`
+	ProposedValue  Money     `json:"proposed_value"`
 }
 
 // PendingsBatch embeddes an array of PendingLine for batch import.
 type PendingsBatch struct {
 	PendingsBatch []PendingLine `json:"PendingCommitment"`
+	SourceTotal   NullMoney     `json:"SourceTotal"`
 }
 
 // CompletePendingCommitment is used to decode explicit pending commitment
@@ -218,16 +219,30 @@ func (p *PendingCommitments) Unlink(i *PendingIDs, db *sql.DB) (err error) {
 	return err
 }
 
-// Save a batch of pendings commitment to the database.
-func (p *PendingsBatch) Save(db *sql.DB) (err error) {
+// Save a batch of pendings commitment to the database. The proposed values
+// total is checked against the source total. The guard, if any, checks within
+// the transaction that the batch wasn't already imported and records the
+// reconciliation before the commit.
+func (p *PendingsBatch) Save(guard ImportGuard, db *sql.DB) (*Reconciliation, error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if guard != nil {
+		hash, err := BatchHash(p.PendingsBatch)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err = checkImport(guard, hash, tx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 	_, err = tx.Exec(`DROP TABLE IF EXISTS temp_pending`)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 	_, err = tx.Exec(`CREATE TABLE temp_pending (
 		chapter VARCHAR(5), action VARCHAR(154), iris_code VARCHAR(32),
@@ -235,25 +250,28 @@ func (p *PendingsBatch) Save(db *sql.DB) (err error) {
 		proposed_value BIGINT)`)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 
 	stmt, err := tx.Prepare(pq.CopyIn("temp_pending", "chapter", "action", "iris_code",
 		"name", "beneficiary", "commission_date", "proposed_value"))
 	if err != nil {
-		return fmt.Errorf("prepare stmt %v", err)
+		return nil, fmt.Errorf("prepare stmt %v", err)
 	}
 	defer stmt.Close()
+	var recon Reconciliation
 	for _, r := range p.PendingsBatch {
 		if _, err = stmt.Exec(r.Chapter, r.Action, r.IrisCode, r.Name, r.Beneficiary,
-			r.CommissionDate.ToDate(), int64(100*r.ProposedValue)); err != nil {
+			r.CommissionDate.ToDate(), int64(r.ProposedValue)); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("insertion de %+v  %v", r, err)
+			return nil, fmt.Errorf("insertion de %+v  %v", r, err)
 		}
+		recon.Add(r.ProposedValue)
 	}
+	recon.Check(p.SourceTotal)
 	if _, err = stmt.Exec(); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("statement exec flush %v", err)
+		return nil, fmt.Errorf("statement exec flush %v", err)
 	}
 
 	queries := []string{
@@ -274,7 +292,7 @@ func (p *PendingsBatch) Save(db *sql.DB) (err error) {
 		_, err = tx.Exec(qry)
 		if err != nil {
 			tx.Rollback()
-			return
+			return nil, err
 		}
 	}
 	if _, err := tx.Exec(`INSERT INTO import_logs (category,last_date) 
@@ -282,10 +300,16 @@ func (p *PendingsBatch) Save(db *sql.DB) (err error) {
 		ON CONFLICT (category) DO UPDATE SET last_date = EXCLUDED.last_date;`,
 		time.Now()); err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
-	err = tx.Commit()
-	return err
+	if err = recordImport(guard, &recon, tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &recon, nil
 }
 
 // GetAll fetches explicit pending commitments linked to a physical operation from database.
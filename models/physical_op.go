@@ -251,7 +251,7 @@ func (op *PhysicalOp) Delete(db *sql.DB) (err error) {
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec("DELETE FROM op_dpt_ratios WHERE physical_op_id=$1", op.ID)
+	_, err = tx.Exec("DELETE FROM op_territory_ratio WHERE physical_op_id=$1", op.ID)
 	if err != nil {
 		tx.Rollback()
 		return err
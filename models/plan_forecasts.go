@@ -10,38 +10,42 @@ import (
 
 // PlanForecast model
 type PlanForecast struct {
-	Number    string      `json:"number"`
-	Name      string      `json:"name"`
-	TRI       NullInt64   `json:"tri"`
-	VAN       NullInt64   `json:"van"`
-	Value     NullInt64   `json:"value"`
-	ValueDate NullInt64   `json:"value_date"`
-	Step      NullString  `json:"step"`
-	Category  NullString  `json:"category"`
-	R75       NullFloat64 `json:"r75"`
-	R77       NullFloat64 `json:"r77"`
-	R78       NullFloat64 `json:"r78"`
-	R91       NullFloat64 `json:"r91"`
-	R92       NullFloat64 `json:"r92"`
-	R93       NullFloat64 `json:"r93"`
-	R94       NullFloat64 `json:"r94"`
-	R95       NullFloat64 `json:"r95"`
-	TotalPrev []int64     `json:"total_prev"`
-	Prev      []int64     `json:"prev"`
+	Number    string        `json:"number"`
+	Name      string        `json:"name"`
+	TRI       NullInt64     `json:"tri"`
+	VAN       NullInt64     `json:"van"`
+	Value     NullInt64     `json:"value"`
+	ValueDate NullInt64     `json:"value_date"`
+	Step      NullString    `json:"step"`
+	Category  NullString    `json:"category"`
+	Ratios    []NullFloat64 `json:"ratios"`
+	TotalPrev []int64       `json:"total_prev"`
+	Prev      []int64       `json:"prev"`
 }
 
-// PlanForecasts embeddes an array of PlanForecast for json export and dedicated
-// queries
+// PlanForecasts embeddes an array of PlanForecast and the territories of the
+// ratios for json export and dedicated queries
 type PlanForecasts struct {
 	Lines []PlanForecast `json:"PlanForecast"`
+	Territories
 }
 
-// GetAll fetches physical operations caracteristics, department ratios and
-// commitment previsions between two years
-func (p *PlanForecasts) GetAll(db *sql.DB, firstYear, lastYear int64) error {
+// GetAll fetches physical operations caracteristics, ratios on the
+// territories of the level and commitment previsions between two years, from
+// the prevision snapshot if sID isn't 0
+func (p *PlanForecasts) GetAll(db *sql.DB, firstYear, lastYear, sID int64,
+	level string) error {
 	if lastYear < firstYear {
 		return fmt.Errorf("lastYear inférieure à firstYear")
 	}
+	if err := checkPrevisionSnapshot(sID, db); err != nil {
+		return err
+	}
+	ratios, err := fetchOpTerritoryRatios(level, &p.Territories, db)
+	if err != nil {
+		return err
+	}
+	source := snapshotSource("prev_commitment", "pc", sID)
 	i := firstYear
 	var array1, array2, years []string
 	for i <= lastYear {
@@ -50,9 +54,8 @@ func (p *PlanForecasts) GetAll(db *sql.DB, firstYear, lastYear int64) error {
 		years = append(years, fmt.Sprintf("y%d BIGINT", i))
 		i++
 	}
-	query := fmt.Sprintf(`SELECT op.number,op.name,op.tri,op.van,op.value,op.valuedate,
-		step.name,category.name,odr.r75,odr.r77,odr.r78,odr.r91,odr.r92,odr.r93,
-		odr.r94,odr.r95,q.total_value,q.value
+	query := fmt.Sprintf(`SELECT op.id,op.number,op.name,op.tri,op.van,op.value,
+		op.valuedate,step.name,category.name,q.total_value,q.value
 	FROM physical_op op
 	LEFT JOIN step ON op.step_id=step.id
 	LEFT JOIN category ON op.category_id=category.id
@@ -60,28 +63,34 @@ func (p *PlanForecasts) GetAll(db *sql.DB, firstYear, lastYear int64) error {
 		(SELECT q1.op_id,ARRAY[%s] as value,ARRAY[%s] as total_value
 		FROM
 			(SELECT * FROM crosstab('SELECT physical_op_id,year,value
-				FROM prev_commitment WHERE year>=%d AND year<=%d ORDER BY 1,2')
+				FROM %s WHERE year>=%d AND year<=%d ORDER BY 1,2')
 			AS ct(op_id integer,%s))q1
 		JOIN
 			(SELECT * FROM crosstab('SELECT physical_op_id,year,total_value
-				FROM prev_commitment WHERE year>=%d AND year<=%d ORDER BY 1,2')
+				FROM %s WHERE year>=%d AND year<=%d ORDER BY 1,2')
 			AS ct(op_id integer,%s))q2
-		ON q1.op_id=q2.op_id) q ON q.op_id=op.id
-	LEFT JOIN op_dpt_ratios odr ON op.id=odr.physical_op_id`,
-		strings.Join(array1, ","), strings.Join(array2, ","), firstYear, lastYear,
-		strings.Join(years, ","), firstYear, lastYear, strings.Join(years, ","))
+		ON q1.op_id=q2.op_id) q ON q.op_id=op.id`,
+		strings.Join(array1, ","), strings.Join(array2, ","), source, firstYear,
+		lastYear, strings.Join(years, ","), source, firstYear, lastYear,
+		strings.Join(years, ","))
 	rows, err := db.Query(query)
 	if err != nil {
 		return fmt.Errorf("query %v", err)
 	}
-	var line PlanForecast
+	var (
+		line PlanForecast
+		opID int64
+	)
 	for rows.Next() {
-		if err := rows.Scan(&line.Number, &line.Name, &line.TRI, &line.VAN,
-			&line.Value, &line.ValueDate, &line.Step, &line.Category, &line.R75,
-			&line.R77, &line.R78, &line.R91, &line.R92, &line.R93, &line.R94,
-			&line.R95, pq.Array(&line.TotalPrev), pq.Array(&line.Prev)); err != nil {
+		if err := rows.Scan(&opID, &line.Number, &line.Name, &line.TRI, &line.VAN,
+			&line.Value, &line.ValueDate, &line.Step, &line.Category,
+			pq.Array(&line.TotalPrev), pq.Array(&line.Prev)); err != nil {
 			return fmt.Errorf("scan %v", err)
 		}
+		line.Ratios = ratios[opID]
+		if line.Ratios == nil {
+			line.Ratios = make([]NullFloat64, len(p.Territories.Territories))
+		}
 		p.Lines = append(p.Lines, line)
 	}
 	if err = rows.Err(); err != nil {
@@ -92,3 +101,42 @@ func (p *PlanForecasts) GetAll(db *sql.DB, firstYear, lastYear int64) error {
 	}
 	return nil
 }
+
+// LegacyPlanForecast is a line of the plan forecasts with the ratios on the
+// departments in the legacy format
+type LegacyPlanForecast struct {
+	Number    string     `json:"number"`
+	Name      string     `json:"name"`
+	TRI       NullInt64  `json:"tri"`
+	VAN       NullInt64  `json:"van"`
+	Value     NullInt64  `json:"value"`
+	ValueDate NullInt64  `json:"value_date"`
+	Step      NullString `json:"step"`
+	Category  NullString `json:"category"`
+	DptRatios
+	TotalPrev []int64 `json:"total_prev"`
+	Prev      []int64 `json:"prev"`
+}
+
+// LegacyPlanForecasts embeddes an array of LegacyPlanForecast for json export
+type LegacyPlanForecasts struct {
+	Lines []LegacyPlanForecast `json:"PlanForecast"`
+}
+
+// GetAll fetches the plan forecasts with the ratios on the departments in the
+// legacy format.
+func (p *LegacyPlanForecasts) GetAll(db *sql.DB, firstYear, lastYear, sID int64) error {
+	var g PlanForecasts
+	if err := g.GetAll(db, firstYear, lastYear, sID, TerritoryDepartment); err != nil {
+		return err
+	}
+	idx := g.Territories.dptIndexes()
+	p.Lines = make([]LegacyPlanForecast, len(g.Lines))
+	for i, l := range g.Lines {
+		p.Lines[i] = LegacyPlanForecast{Number: l.Number, Name: l.Name, TRI: l.TRI,
+			VAN: l.VAN, Value: l.Value, ValueDate: l.ValueDate, Step: l.Step,
+			Category: l.Category, DptRatios: newDptRatios(l.Ratios, idx),
+			TotalPrev: l.TotalPrev, Prev: l.Prev}
+	}
+	return nil
+}
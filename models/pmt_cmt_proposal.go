@@ -0,0 +1,437 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PmtCmtMatchSettings configures the scoring of the proposals of links between
+// unlinked payments and commitments. Each signal gives a value between 0 and 1
+// and the score is the weighted mean of the signals.
+type PmtCmtMatchSettings struct {
+	BeneficiaryWeight float64 // same beneficiary
+	EgtWeight         float64 // close coriolis year, code and number
+	AmountWeight      float64 // payment within the remaining of the commitment
+	DateWeight        float64 // payment between commitment date and lapse date
+	MinScore          float64 // proposals below that score are dropped
+	MaxProposals      int     // maximum number of proposals per payment
+}
+
+// PmtCmtMatch is the settings used by the payments reconciliation
+var PmtCmtMatch = PmtCmtMatchSettings{
+	BeneficiaryWeight: 0.35,
+	EgtWeight:         0.3,
+	AmountWeight:      0.2,
+	DateWeight:        0.15,
+	MinScore:          0.5,
+	MaxProposals:      3,
+}
+
+// PmtCmtProposal is a proposal of link between a payment and a commitment
+// waiting for a review
+type PmtCmtProposal struct {
+	ID              int64     `json:"id"`
+	PaymentID       int64     `json:"payment_id"`
+	PaymentNumber   string    `json:"payment_number"`
+	PaymentDate     time.Time `json:"payment_date"`
+	PaymentValue    int64     `json:"payment_value"`
+	CommitmentID    int64     `json:"commitment_id"`
+	IrisCode        string    `json:"iris_code"`
+	CommitmentName  string    `json:"commitment_name"`
+	CommitmentValue int64     `json:"commitment_value"`
+	Score           float64   `json:"score"`
+	Explanation     []string  `json:"explanation"`
+}
+
+// PmtCmtProposals embeddes an array of PmtCmtProposal for json export
+type PmtCmtProposals struct {
+	Lines []PmtCmtProposal `json:"PmtCmtProposal"`
+}
+
+// PmtCmtReconciliation is the result of a payments reconciliation
+type PmtCmtReconciliation struct {
+	PaymentsCount  int64     `json:"payments_count"`
+	MatchedCount   int64     `json:"matched_count"`
+	ProposalsCount int64     `json:"proposals_count"`
+	UnmatchedCount int64     `json:"unmatched_count"`
+	ComputedAt     time.Time `json:"computed_at"`
+}
+
+// PmtCmtDecision selects the pending proposals to accept or reject, either by
+// their IDs or by a minimum score
+type PmtCmtDecision struct {
+	IDs      []int64     `json:"ProposalID"`
+	MinScore NullFloat64 `json:"MinScore"`
+}
+
+// pmtCmtPayment is an unlinked payment to reconcile
+type pmtCmtPayment struct {
+	ID              int64
+	CoriolisYear    string
+	CoriolisEgtCode string
+	CoriolisEgtNum  string
+	CoriolisEgtLine string
+	Date            time.Time
+	Value           int64
+	BeneficiaryCode int64
+}
+
+// pmtCmtCommitment is a candidate commitment with the amount not yet paid
+type pmtCmtCommitment struct {
+	ID              int64
+	CoriolisYear    string
+	CoriolisEgtCode string
+	CoriolisEgtNum  string
+	CoriolisEgtLine string
+	BeneficiaryCode int64
+	Date            time.Time
+	LapseDate       NullTime
+	Remaining       int64
+}
+
+// pmtCmtMatcher indexes the commitments by coriolis year and code to score
+// the candidates of each payment, leaving out the rejected pairs
+type pmtCmtMatcher struct {
+	settings PmtCmtMatchSettings
+	byEgt    map[string][]*pmtCmtCommitment
+	rejected map[[2]int64]bool
+}
+
+// pmtCmtProposal is a scored candidate
+type pmtCmtProposal struct {
+	CommitmentID int64
+	Score        float64
+	Explanation  []string
+}
+
+func newPmtCmtMatcher(cmts []*pmtCmtCommitment, settings PmtCmtMatchSettings,
+	rejected map[[2]int64]bool) *pmtCmtMatcher {
+	m := pmtCmtMatcher{settings: settings, rejected: rejected,
+		byEgt: make(map[string][]*pmtCmtCommitment)}
+	for _, c := range cmts {
+		k := c.CoriolisYear + "|" + c.CoriolisEgtCode
+		m.byEgt[k] = append(m.byEgt[k], c)
+	}
+	return &m
+}
+
+// score computes the weighted mean of the signals of the link between the
+// payment and the commitment and the explanation of the signals found. The
+// score is 0 if the coriolis references of the payment and the commitment are
+// not close, the other signals being too common to link them alone.
+func (m *pmtCmtMatcher) score(p *pmtCmtPayment, c *pmtCmtCommitment) (float64, []string) {
+	s := m.settings
+	var total, egt float64
+	explanation := []string{}
+	if p.CoriolisYear == c.CoriolisYear && p.CoriolisEgtCode == c.CoriolisEgtCode {
+		switch d := levenshtein(p.CoriolisEgtNum, c.CoriolisEgtNum); d {
+		case 0:
+			egt = 1
+			explanation = append(explanation, "même numéro d'engagement")
+		case 1, 2:
+			egt = 1 - 0.3*float64(d)
+			explanation = append(explanation,
+				fmt.Sprintf("numéro d'engagement proche (%d écart(s))", d))
+		}
+		if egt > 0 && p.CoriolisEgtLine != c.CoriolisEgtLine {
+			egt *= 0.8
+		}
+	}
+	if egt == 0 {
+		return 0, explanation
+	}
+	total += s.EgtWeight * egt
+	if p.BeneficiaryCode == c.BeneficiaryCode {
+		explanation = append(explanation, "même bénéficiaire")
+		total += s.BeneficiaryWeight
+	}
+	if p.Value > 0 && c.Remaining > 0 {
+		ratio := 1.0
+		if p.Value > c.Remaining {
+			ratio = float64(c.Remaining) / float64(p.Value)
+		}
+		explanation = append(explanation,
+			fmt.Sprintf("paiement couvert à %.0f %% par le reste à payer", ratio*100))
+		total += s.AmountWeight * ratio
+	}
+	if !p.Date.Before(c.Date) &&
+		(!c.LapseDate.Valid || !p.Date.After(c.LapseDate.Time)) {
+		explanation = append(explanation, "paiement entre engagement et caducité")
+		total += s.DateWeight
+	}
+	weights := s.BeneficiaryWeight + s.EgtWeight + s.AmountWeight + s.DateWeight
+	if weights <= 0 {
+		return 0, explanation
+	}
+	return math.Round(total/weights*100) / 100, explanation
+}
+
+// propose returns the ranked proposals of the payment above the minimum
+// score, leaving out the pairs already rejected.
+func (m *pmtCmtMatcher) propose(p *pmtCmtPayment) []pmtCmtProposal {
+	var proposals []pmtCmtProposal
+	for _, c := range m.byEgt[p.CoriolisYear+"|"+p.CoriolisEgtCode] {
+		if m.rejected[[2]int64{p.ID, c.ID}] {
+			continue
+		}
+		score, explanation := m.score(p, c)
+		if score >= m.settings.MinScore {
+			proposals = append(proposals, pmtCmtProposal{CommitmentID: c.ID,
+				Score: score, Explanation: explanation})
+		}
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		if proposals[i].Score != proposals[j].Score {
+			return proposals[i].Score > proposals[j].Score
+		}
+		return proposals[i].CommitmentID < proposals[j].CommitmentID
+	})
+	if m.settings.MaxProposals > 0 && len(proposals) > m.settings.MaxProposals {
+		proposals = proposals[:m.settings.MaxProposals]
+	}
+	return proposals
+}
+
+// fetchPmtCmtData fetches the unlinked payments and the commitments with the
+// amount remaining to pay.
+func fetchPmtCmtData(tx *sql.Tx) ([]pmtCmtPayment, []*pmtCmtCommitment, error) {
+	rows, err := tx.Query(`SELECT id,coriolis_year,coriolis_egt_code,
+	coriolis_egt_num,coriolis_egt_line,date,value,beneficiary_code
+	FROM payment WHERE financial_commitment_id ISNULL ORDER BY 1`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("select payments %v", err)
+	}
+	var pmts []pmtCmtPayment
+	for rows.Next() {
+		var p pmtCmtPayment
+		if err = rows.Scan(&p.ID, &p.CoriolisYear, &p.CoriolisEgtCode,
+			&p.CoriolisEgtNum, &p.CoriolisEgtLine, &p.Date, &p.Value,
+			&p.BeneficiaryCode); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("scan payments %v", err)
+		}
+		pmts = append(pmts, p)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows payments %v", err)
+	}
+	rows, err = tx.Query(`SELECT f.id,f.coriolis_year,f.coriolis_egt_code,
+	f.coriolis_egt_num,f.coriolis_egt_line,f.beneficiary_code,f.date,
+	f.lapse_date,f.value-COALESCE(p.paid,0)
+	FROM financial_commitment f
+	LEFT JOIN (SELECT financial_commitment_id,SUM(value-cancelled_value) AS paid
+		FROM payment WHERE financial_commitment_id NOTNULL GROUP BY 1) p
+		ON p.financial_commitment_id=f.id`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("select commitments %v", err)
+	}
+	var cmts []*pmtCmtCommitment
+	for rows.Next() {
+		var c pmtCmtCommitment
+		if err = rows.Scan(&c.ID, &c.CoriolisYear, &c.CoriolisEgtCode,
+			&c.CoriolisEgtNum, &c.CoriolisEgtLine, &c.BeneficiaryCode, &c.Date,
+			&c.LapseDate, &c.Remaining); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("scan commitments %v", err)
+		}
+		cmts = append(cmts, &c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows commitments %v", err)
+	}
+	return pmts, cmts, nil
+}
+
+// Run computes the proposals of links for all unlinked payments replacing the
+// pending ones. Pairs already rejected are not proposed again.
+func (r *PmtCmtReconciliation) Run(progress ImportProgress, db *sql.DB) error {
+	progress.report(StagingPhase)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	pmts, cmts, err := fetchPmtCmtData(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	rejected := make(map[[2]int64]bool)
+	rows, err := tx.Query(`SELECT payment_id,commitment_id FROM pmt_cmt_proposal
+	WHERE decision=$1`, ProposalRejected)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("select rejected %v", err)
+	}
+	var pmtID, cmtID int64
+	for rows.Next() {
+		if err = rows.Scan(&pmtID, &cmtID); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return fmt.Errorf("scan rejected %v", err)
+		}
+		rejected[[2]int64{pmtID, cmtID}] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("rows rejected %v", err)
+	}
+	progress.report(MatchingPhase)
+	if _, err = tx.Exec(`DELETE FROM pmt_cmt_proposal WHERE decision ISNULL`); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete %v", err)
+	}
+	stmt, err := tx.Prepare(pq.CopyIn("pmt_cmt_proposal", "payment_id",
+		"commitment_id", "score", "explanation", "created_at"))
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("prepare stmt %v", err)
+	}
+	defer stmt.Close()
+	m, now := newPmtCmtMatcher(cmts, PmtCmtMatch, rejected), time.Now()
+	*r = PmtCmtReconciliation{PaymentsCount: int64(len(pmts)), ComputedAt: now}
+	for i := range pmts {
+		matched := false
+		for _, p := range m.propose(&pmts[i]) {
+			if _, err = stmt.Exec(pmts[i].ID, p.CommitmentID, p.Score,
+				pq.Array(p.Explanation), now); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("insert %v", err)
+			}
+			r.ProposalsCount++
+			matched = true
+		}
+		if matched {
+			r.MatchedCount++
+		}
+	}
+	r.UnmatchedCount = r.PaymentsCount - r.MatchedCount
+	if _, err = stmt.Exec(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("statement exec flush %v", err)
+	}
+	return tx.Commit()
+}
+
+// GetPending fetches the proposals waiting for a review, the best ones first
+// for each payment.
+func (p *PmtCmtProposals) GetPending(db *sql.DB) error {
+	rows, err := db.Query(`SELECT pr.id,p.id,p.number,p.date,p.value,f.id,
+	f.iris_code,f.name,f.value,pr.score,pr.explanation
+	FROM pmt_cmt_proposal pr
+	JOIN payment p ON pr.payment_id=p.id
+	JOIN financial_commitment f ON pr.commitment_id=f.id
+	WHERE pr.decision ISNULL AND p.financial_commitment_id ISNULL
+	ORDER BY 2,10 DESC,6`)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	var r PmtCmtProposal
+	defer rows.Close()
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.PaymentID, &r.PaymentNumber, &r.PaymentDate,
+			&r.PaymentValue, &r.CommitmentID, &r.IrisCode, &r.CommitmentName,
+			&r.CommitmentValue, &r.Score, pq.Array(&r.Explanation)); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		p.Lines = append(p.Lines, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows err %v", err)
+	}
+	if len(p.Lines) == 0 {
+		p.Lines = []PmtCmtProposal{}
+	}
+	return nil
+}
+
+// selection returns the condition on the pending proposals and its argument
+func (d *PmtCmtDecision) selection() (string, interface{}, error) {
+	if d.MinScore.Valid {
+		return "pr.score>=$1", d.MinScore.Float64, nil
+	}
+	if len(d.IDs) == 0 {
+		return "", nil, fmt.Errorf("ProposalID ou MinScore requis")
+	}
+	return "pr.id=ANY($1)", pq.Array(d.IDs), nil
+}
+
+// Accept links the payments to the commitments of the selected pending
+// proposals, keeping the best one when several are selected for a payment.
+// The other pending proposals of the linked payments are rejected. It returns
+// the number of payments linked.
+func (d *PmtCmtDecision) Accept(db *sql.DB) (int64, error) {
+	cond, arg, err := d.selection()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query(`SELECT DISTINCT ON (pr.payment_id) pr.id,pr.payment_id,
+		pr.commitment_id FROM pmt_cmt_proposal pr
+		JOIN payment p ON pr.payment_id=p.id
+		WHERE pr.decision ISNULL AND p.financial_commitment_id ISNULL AND `+cond+`
+		ORDER BY pr.payment_id,pr.score DESC,pr.id`, arg)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("select %v", err)
+	}
+	var best [][3]int64
+	for rows.Next() {
+		var b [3]int64
+		if err = rows.Scan(&b[0], &b[1], &b[2]); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, fmt.Errorf("scan %v", err)
+		}
+		best = append(best, b)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("rows err %v", err)
+	}
+	now := time.Now()
+	for _, b := range best {
+		if _, err = tx.Exec(`UPDATE payment SET financial_commitment_id=$1
+		WHERE id=$2`, b[2], b[1]); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("link %v", err)
+		}
+		if _, err = tx.Exec(`UPDATE pmt_cmt_proposal SET decision=CASE WHEN id=$1
+			THEN 'accepted' ELSE 'rejected' END,decided_at=$3
+			WHERE payment_id=$2 AND decision ISNULL`, b[0], b[1], now); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("decision %v", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	update(paymentUpdate)
+	return int64(len(best)), nil
+}
+
+// Reject marks the selected pending proposals as rejected so that they are
+// not proposed again. It returns the number of proposals rejected.
+func (d *PmtCmtDecision) Reject(db *sql.DB) (int64, error) {
+	cond, arg, err := d.selection()
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.Exec(`UPDATE pmt_cmt_proposal pr SET decision='rejected',
+	decided_at=$2 WHERE pr.decision ISNULL AND `+cond, arg, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("reject %v", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// testPmtCmtPayment returns a payment of 100 on the commitment 2018 IRIS
+// 553827 line 1 of the beneficiary 10.
+func testPmtCmtPayment() *pmtCmtPayment {
+	return &pmtCmtPayment{ID: 1, CoriolisYear: "2018", CoriolisEgtCode: "IRIS",
+		CoriolisEgtNum: "553827", CoriolisEgtLine: "1",
+		Date: time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC), Value: 100,
+		BeneficiaryCode: 10}
+}
+
+// testPmtCmtCommitment returns a commitment matching every signal of the
+// payment, modified by the function.
+func testPmtCmtCommitment(id int64, modify func(c *pmtCmtCommitment)) *pmtCmtCommitment {
+	c := pmtCmtCommitment{ID: id, CoriolisYear: "2018", CoriolisEgtCode: "IRIS",
+		CoriolisEgtNum: "553827", CoriolisEgtLine: "1", BeneficiaryCode: 10,
+		Date: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+		LapseDate: NullTime{Valid: true,
+			Time: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		Remaining: 1000}
+	if modify != nil {
+		modify(&c)
+	}
+	return &c
+}
+
+func TestPmtCmtMatchScore(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(c *pmtCmtCommitment)
+		want   float64
+		count  int
+	}{
+		{name: "tous les signaux", want: 1, count: 4},
+		{name: "numéro à un écart",
+			modify: func(c *pmtCmtCommitment) { c.CoriolisEgtNum = "553828" },
+			want:   0.91, count: 4},
+		{name: "numéro à deux écarts",
+			modify: func(c *pmtCmtCommitment) { c.CoriolisEgtNum = "553800" },
+			want:   0.82, count: 4},
+		{name: "autre ligne",
+			modify: func(c *pmtCmtCommitment) { c.CoriolisEgtLine = "2" },
+			want:   0.94, count: 4},
+		{name: "reste à payer partiel",
+			modify: func(c *pmtCmtCommitment) { c.Remaining = 50 },
+			want:   0.9, count: 4},
+		{name: "paiement avant l'engagement",
+			modify: func(c *pmtCmtCommitment) { c.Date = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) },
+			want:   0.85, count: 3},
+		{name: "paiement après la caducité",
+			modify: func(c *pmtCmtCommitment) { c.LapseDate.Time = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC) },
+			want:   0.85, count: 3},
+		{name: "sans caducité",
+			modify: func(c *pmtCmtCommitment) { c.LapseDate = NullTime{} },
+			want:   1, count: 4},
+		{name: "numéro seul",
+			modify: func(c *pmtCmtCommitment) {
+				c.BeneficiaryCode, c.Remaining = 11, 0
+				c.Date = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			},
+			want: 0.3, count: 1},
+		{name: "numéro éloigné",
+			modify: func(c *pmtCmtCommitment) { c.CoriolisEgtNum = "999999" },
+			want:   0, count: 0},
+		{name: "autre année",
+			modify: func(c *pmtCmtCommitment) { c.CoriolisYear = "2017" },
+			want:   0, count: 0},
+		{name: "autre code",
+			modify: func(c *pmtCmtCommitment) { c.CoriolisEgtCode = "P1215" },
+			want:   0, count: 0},
+	}
+	m := newPmtCmtMatcher(nil, PmtCmtMatch, nil)
+	for _, c := range cases {
+		got, explanation := m.score(testPmtCmtPayment(), testPmtCmtCommitment(1, c.modify))
+		if got != c.want {
+			t.Errorf("%s : score attendu %v, reçu %v %v", c.name, c.want, got, explanation)
+		}
+		if len(explanation) != c.count {
+			t.Errorf("%s : %d explications attendues, reçu %v", c.name, c.count, explanation)
+		}
+	}
+}
+
+func TestPmtCmtMatchPropose(t *testing.T) {
+	cases := []struct {
+		name     string
+		cmts     []*pmtCmtCommitment
+		rejected map[[2]int64]bool
+		want     []int64
+	}{
+		{name: "bénéficiaire, montant et date sans lien d'engagement",
+			cmts: []*pmtCmtCommitment{testPmtCmtCommitment(1, func(c *pmtCmtCommitment) {
+				c.CoriolisEgtNum = "999999"
+			})}},
+		{name: "score minimum",
+			cmts: []*pmtCmtCommitment{testPmtCmtCommitment(1, func(c *pmtCmtCommitment) {
+				c.BeneficiaryCode, c.Remaining = 11, 0
+			}), testPmtCmtCommitment(2, nil)},
+			want: []int64{2}},
+		{name: "classement, égalité et troncature",
+			cmts: []*pmtCmtCommitment{
+				testPmtCmtCommitment(4, nil),
+				testPmtCmtCommitment(3, func(c *pmtCmtCommitment) { c.CoriolisEgtLine = "2" }),
+				testPmtCmtCommitment(2, nil),
+				testPmtCmtCommitment(1, func(c *pmtCmtCommitment) { c.CoriolisEgtNum = "553828" })},
+			want: []int64{2, 4, 3}},
+		{name: "paire rejetée écartée avant la troncature",
+			cmts: []*pmtCmtCommitment{
+				testPmtCmtCommitment(4, nil),
+				testPmtCmtCommitment(3, func(c *pmtCmtCommitment) { c.CoriolisEgtLine = "2" }),
+				testPmtCmtCommitment(2, nil),
+				testPmtCmtCommitment(1, func(c *pmtCmtCommitment) { c.CoriolisEgtNum = "553828" })},
+			rejected: map[[2]int64]bool{{1, 2}: true},
+			want:     []int64{4, 3, 1}},
+	}
+	for _, c := range cases {
+		m := newPmtCmtMatcher(c.cmts, PmtCmtMatch, c.rejected)
+		got := m.propose(testPmtCmtPayment())
+		if len(got) != len(c.want) {
+			t.Errorf("%s : %d propositions attendues, reçu %+v", c.name, len(c.want), got)
+			continue
+		}
+		for i, p := range got {
+			if p.CommitmentID != c.want[i] {
+				t.Errorf("%s : engagement %d attendu en position %d, reçu %d", c.name,
+					c.want[i], i, p.CommitmentID)
+			}
+		}
+	}
+}
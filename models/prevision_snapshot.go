@@ -0,0 +1,219 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// snapshotTable is a table captured by the prevision snapshots with the kind
+// of its lines in prevision_snapshot_line and the columns copied
+type snapshotTable struct {
+	Kind    int64
+	Name    string
+	Columns string
+}
+
+// snapshotTables are the tables overwritten by the imports and captured by
+// the prevision snapshots
+var snapshotTables = []snapshotTable{
+	{1, "prev_commitment", "physical_op_id,year,value,descript,total_value,state_ratio"},
+	{2, "prev_payment", "physical_op_id,year,value,descript"},
+	{3, "programmings", "physical_op_id,commission_id,year,value,total_value,state_ratio"},
+	{4, "pre_programmings", "physical_op_id,commission_id,year,value,total_value,state_ratio,descript"},
+}
+
+// PrevisionSnapshot is a named copy at a date of the commitment and payment
+// previsions, the programmings and the pre programmings
+type PrevisionSnapshot struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Descript  NullString `json:"descript"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PrevisionSnapshots embeddes an array of PrevisionSnapshot for json export
+type PrevisionSnapshots struct {
+	PrevisionSnapshots []PrevisionSnapshot `json:"PrevisionSnapshot"`
+}
+
+// PrevisionSnapshotDiff is the difference of the amounts of an operation for
+// a year and a table between two snapshots, the values being null if the
+// snapshot has no amount
+type PrevisionSnapshotDiff struct {
+	OpID       int64     `json:"physical_op_id"`
+	OpNumber   string    `json:"op_number"`
+	OpName     string    `json:"op_name"`
+	Kind       string    `json:"kind"`
+	Year       NullInt64 `json:"year"`
+	Value      NullInt64 `json:"value"`
+	OtherValue NullInt64 `json:"other_value"`
+	Delta      int64     `json:"delta"`
+}
+
+// PrevisionSnapshotDiffs embeddes an array of PrevisionSnapshotDiff for json
+// export
+type PrevisionSnapshotDiffs struct {
+	PrevisionSnapshotDiffs []PrevisionSnapshotDiff `json:"PrevisionSnapshotDiff"`
+}
+
+// snapshotSource returns the FROM clause of the table as captured by the
+// snapshot or the table itself if sID is 0. The clause can be embedded in the
+// source query of a crosstab as it contains no quote.
+func snapshotSource(table, alias string, sID int64) string {
+	if sID == 0 {
+		return table + " " + alias
+	}
+	for _, t := range snapshotTables {
+		if t.Name == table {
+			return fmt.Sprintf(`(SELECT %s FROM prevision_snapshot_line
+				WHERE snapshot_id=%d AND kind=%d) %s`, t.Columns, sID, t.Kind, alias)
+		}
+	}
+	return table + " " + alias
+}
+
+// checkPrevisionSnapshot returns an error if sID isn't 0 and the snapshot
+// doesn't exist.
+func checkPrevisionSnapshot(sID int64, db *sql.DB) error {
+	if sID == 0 {
+		return nil
+	}
+	var count int64
+	if err := db.QueryRow(`SELECT COUNT(1) FROM prevision_snapshot WHERE id=$1`,
+		sID).Scan(&count); err != nil {
+		return fmt.Errorf("select snapshot %v", err)
+	}
+	if count == 0 {
+		return errors.New("Snapshot introuvable")
+	}
+	return nil
+}
+
+// Validate checks if fields are well formed
+func (p *PrevisionSnapshot) Validate() error {
+	if p.Name == "" || len(p.Name) > 255 {
+		return errors.New("Name incorrect")
+	}
+	return nil
+}
+
+// Create captures the current content of the tables in a new snapshot.
+func (p *PrevisionSnapshot) Create(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = tx.QueryRow(`INSERT INTO prevision_snapshot (name,descript,created_at)
+	VALUES($1,$2,now()) RETURNING id,created_at`, p.Name, p.Descript).Scan(&p.ID,
+		&p.CreatedAt); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert %v", err)
+	}
+	for _, t := range snapshotTables {
+		if _, err = tx.Exec(`INSERT INTO prevision_snapshot_line (snapshot_id,kind,`+
+			t.Columns+`) SELECT $1,$2,`+t.Columns+` FROM `+t.Name, p.ID,
+			t.Kind); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("insert %s %v", t.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes the snapshot and its lines from database.
+func (p *PrevisionSnapshot) Delete(db *sql.DB) error {
+	res, err := db.Exec(`DELETE FROM prevision_snapshot WHERE id=$1`, p.ID)
+	if err != nil {
+		return fmt.Errorf("delete %v", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected %v", err)
+	}
+	if count != 1 {
+		return errors.New("Snapshot introuvable")
+	}
+	return nil
+}
+
+// GetAll fetches all the snapshots from database.
+func (p *PrevisionSnapshots) GetAll(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id,name,descript,created_at FROM prevision_snapshot
+	ORDER BY created_at DESC`)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	var r PrevisionSnapshot
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.Name, &r.Descript, &r.CreatedAt); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		p.PrevisionSnapshots = append(p.PrevisionSnapshots, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	if len(p.PrevisionSnapshots) == 0 {
+		p.PrevisionSnapshots = []PrevisionSnapshot{}
+	}
+	return nil
+}
+
+// snapshotSums returns the query of the amounts per table, operation and
+// year of the snapshot or of the current tables if sID is 0.
+func snapshotSums(sID int64) string {
+	parts := make([]string, len(snapshotTables))
+	for i, t := range snapshotTables {
+		parts[i] = fmt.Sprintf(`SELECT %d AS kind,t.physical_op_id,COALESCE(t.year,0) AS year,
+		SUM(t.value)::bigint AS value FROM %s GROUP BY 1,2,3`, t.Kind,
+			snapshotSource(t.Name, "t", sID))
+	}
+	return strings.Join(parts, " UNION ALL ")
+}
+
+// GetAll fetches the amounts per table, operation and year that differ
+// between the snapshots sID and otherID, 0 standing for the current tables.
+func (p *PrevisionSnapshotDiffs) GetAll(sID, otherID int64, db *sql.DB) error {
+	for _, ID := range []int64{sID, otherID} {
+		if err := checkPrevisionSnapshot(ID, db); err != nil {
+			return err
+		}
+	}
+	rows, err := db.Query(`WITH a AS (` + snapshotSums(sID) + `),
+	b AS (` + snapshotSums(otherID) + `)
+	SELECT op.id,op.number,op.name,COALESCE(a.kind,b.kind),
+		NULLIF(COALESCE(a.year,b.year),0),a.value,b.value
+	FROM a FULL OUTER JOIN b ON a.kind=b.kind AND a.physical_op_id=b.physical_op_id
+		AND a.year=b.year
+	JOIN physical_op op ON op.id=COALESCE(a.physical_op_id,b.physical_op_id)
+	WHERE a.value IS DISTINCT FROM b.value ORDER BY 2,4,5`)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	names := make(map[int64]string, len(snapshotTables))
+	for _, t := range snapshotTables {
+		names[t.Kind] = t.Name
+	}
+	var r PrevisionSnapshotDiff
+	var kind int64
+	for rows.Next() {
+		if err = rows.Scan(&r.OpID, &r.OpNumber, &r.OpName, &kind, &r.Year, &r.Value,
+			&r.OtherValue); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		r.Kind, r.Delta = names[kind], r.OtherValue.Int64-r.Value.Int64
+		p.PrevisionSnapshotDiffs = append(p.PrevisionSnapshotDiffs, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	if len(p.PrevisionSnapshotDiffs) == 0 {
+		p.PrevisionSnapshotDiffs = []PrevisionSnapshotDiff{}
+	}
+	return nil
+}
@@ -4,15 +4,18 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"sort"
 	"strconv"
-	"strings"
 )
 
 // Scenario model
 type Scenario struct {
-	ID       int64      `json:"id"`
-	Name     string     `json:"name"`
-	Descript NullString `json:"descript"`
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Descript  NullString `json:"descript"`
+	OwnerID   NullInt64  `json:"owner_id"`
+	Status    string     `json:"status"`
+	AdoptedAt NullTime   `json:"adopted_at"`
 }
 
 // Scenarios embeddes an array of Scenario for json export.
@@ -25,41 +28,41 @@ type Scenarios struct {
 type ScenarioDatas struct {
 	OperationCrossTable json.RawMessage `json:"OperationCrossTable"`
 	ScenarioCrossTable  json.RawMessage `json:"ScenarioCrossTable"`
+	ScenarioNewOps
 }
 
 // MABScenarioLine is used to decode one line of the multi annual budget
 // scenario query that calculates commitments per budget entities.
 type MABScenarioLine struct {
-	Number      string     `json:"number"`
-	Name        string     `json:"name"`
-	Chapter     NullInt64  `json:"chapter"`
-	Sector      NullString `json:"sector"`
-	Subfunction NullString `json:"subfunction"`
-	Program     NullString `json:"program"`
-	Action      NullString `json:"action"`
-	Y0          NullInt64  `json:"y0"`
-	Y1          NullInt64  `json:"y1"`
-	Y2          NullInt64  `json:"y2"`
-	Y3          NullInt64  `json:"y3"`
-	Y4          NullInt64  `json:"y4"`
+	Number      string      `json:"number"`
+	Name        string      `json:"name"`
+	Chapter     NullInt64   `json:"chapter"`
+	Sector      NullString  `json:"sector"`
+	Subfunction NullString  `json:"subfunction"`
+	Program     NullString  `json:"program"`
+	Action      NullString  `json:"action"`
+	Values      []NullInt64 `json:"values"`
 }
 
-// MultiAnnualBudgetScenario embeddes an array of MABScenarioLine to fetch
-// the dedicated query.
+// MultiAnnualBudgetScenario embeddes the years and an array of MABScenarioLine
+// to fetch the dedicated query.
 type MultiAnnualBudgetScenario struct {
+	Years                     []int64           `json:"Years"`
 	MultiAnnualBudgetScenario []MABScenarioLine `json:"MultiannualBudgetScenario"`
 }
 
 // GetAll fetches all scenarios from database.
 func (s *Scenarios) GetAll(db *sql.DB) (err error) {
-	rows, err := db.Query(`SELECT id, name, descript FROM scenario`)
+	rows, err := db.Query(`SELECT id, name, descript, owner_id, status, adopted_at
+	FROM scenario`)
 	if err != nil {
 		return err
 	}
 	var r Scenario
 	defer rows.Close()
 	for rows.Next() {
-		if err = rows.Scan(&r.ID, &r.Name, &r.Descript); err != nil {
+		if err = rows.Scan(&r.ID, &r.Name, &r.Descript, &r.OwnerID, &r.Status,
+			&r.AdoptedAt); err != nil {
 			return err
 		}
 		s.Scenarios = append(s.Scenarios, r)
@@ -78,30 +81,30 @@ func (s *Scenario) Invalid() bool {
 
 // Create insert a new scenario into database.
 func (s *Scenario) Create(db *sql.DB) (err error) {
-	err = db.QueryRow("INSERT INTO scenario (name,descript) VALUES($1,$2) RETURNING id",
-		s.Name, s.Descript).Scan(&s.ID)
+	err = db.QueryRow(`INSERT INTO scenario (name,descript,owner_id) VALUES($1,$2,$3)
+	RETURNING id,status`, s.Name, s.Descript, s.OwnerID).Scan(&s.ID, &s.Status)
 	return err
 }
 
-// Update modifies a scenario into database.
-func (s *Scenario) Update(db *sql.DB) (err error) {
-	res, err := db.Exec(`UPDATE scenario SET name=$1, descript=$2 WHERE id = $3`,
-		s.Name, s.Descript, s.ID)
-	if err != nil {
+// Update modifies a scenario into database if the user can edit it.
+func (s *Scenario) Update(uID int64, db *sql.DB) (err error) {
+	if err = checkScenarioEditable(s.ID, uID, db); err != nil {
 		return err
 	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if count != 1 {
+	err = db.QueryRow(`UPDATE scenario SET name=$1, descript=$2 WHERE id = $3
+	RETURNING owner_id,status,adopted_at`, s.Name, s.Descript, s.ID).Scan(&s.OwnerID,
+		&s.Status, &s.AdoptedAt)
+	if err == sql.ErrNoRows {
 		return errors.New("Scenario introuvable")
 	}
 	return err
 }
 
-// Delete remote scenario from database.
-func (s *Scenario) Delete(db *sql.DB) (err error) {
+// Delete remote scenario from database if the user can edit it.
+func (s *Scenario) Delete(uID int64, db *sql.DB) (err error) {
+	if err = checkScenarioEditable(s.ID, uID, db); err != nil {
+		return err
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -125,125 +128,159 @@ func (s *Scenario) Delete(db *sql.DB) (err error) {
 		tx.Rollback()
 		return errors.New("Scenario introuvable")
 	}
-	return nil
+	return tx.Commit()
+}
+
+// crossTableYears returns the values of an operation between firstYear and
+// lastYear keyed by the index of the year, null if the operation has no
+// amount for the year.
+func crossTableYears(line map[string]interface{}, values map[int64]float64,
+	firstYear, lastYear int64) map[string]interface{} {
+	for y := firstYear; y <= lastYear; y++ {
+		k := "y" + strconv.FormatInt(y-firstYear, 10)
+		if v, ok := values[y]; ok {
+			line[k] = v
+		} else {
+			line[k] = nil
+		}
+	}
+	return line
 }
 
-// Populate calculates datas linked to a scenario.
+// Populate calculates datas linked to a scenario. The operation cross table
+// gives the forecast commitments of all the operations and the scenario cross
+// table those of the operations of the scenario modified by their levers, the
+// offsets being sent apart. Both use the snapshot of an adopted scenario.
 func (d *ScenarioDatas) Populate(sID int64, firstYear int64, db *sql.DB) (err error) {
-	var lastYear NullInt64
-	if err = db.QueryRow(`SELECT max(p.year+s.offset)::bigint AS year 
-	 FROM prev_commitment p, scenario_offset s 
-	 WHERE s.physical_op_id = p.physical_op_id AND s.scenario_id= $1`, sID).
-		Scan(&lastYear); err != nil {
+	fd, err := LoadForecastData(sID, db)
+	if err != nil {
 		return err
 	}
-	if !lastYear.Valid {
-		lastYear.Int64 = firstYear + 4
-	}
-	var columnNames, typesNames, jsonNames []string
-
-	for i := firstYear; i <= lastYear.Int64; i++ {
-		sy := strconv.FormatInt(i-firstYear, 10)
-		columnNames = append(columnNames, `"`+strconv.FormatInt(i, 10)+`" AS y`+sy)
-		typesNames = append(typesNames, `"`+strconv.FormatInt(i, 10)+`" NUMERIC`)
-		jsonNames = append(jsonNames, `'y`+sy+`', q.y`+sy)
-	}
-	sfy := strconv.FormatInt(firstYear, 10)
-	sly := strconv.FormatInt(lastYear.Int64, 10)
-	operationCrossQuery := `SELECT json_build_object('id',q.id,'number',q.number, 
-		'name', q.name,` + strings.Join(jsonNames, ",") + ` ) FROM
-	(SELECT op.id, op.number, op.name, ` + strings.Join(columnNames, ",") + ` 
-		FROM physical_op op, 
-			(SELECT * FROM 
-				crosstab ('SELECT p.id, c.year, c.value FROM physical_op p
-									 LEFT OUTER JOIN prev_commitment c ON c.physical_op_id=p.id ORDER BY 1',
-									'SELECT m FROM generate_series(` + sfy + `, ` + sly + `) AS m')
-				AS ( id INTEGER, ` + strings.Join(typesNames, ",") + `)
-			) AS ppi WHERE op.id=ppi.id) q;`
-	scenarioCrossQuery := `SELECT json_build_object('id',q.id,'number',q.number, 
-		'name', q.name, 'offset', q.offset, ` + strings.Join(jsonNames, ",") + ` ) FROM
-	(SELECT op.id, op.number, op.name, sc.offset, ` + strings.Join(columnNames, ",") + `
-		FROM physical_op op, scenario_offset sc, 
-			(SELECT * FROM 
-				crosstab ('SELECT p.id, c.year, c.value FROM physical_op p 
-									 LEFT OUTER JOIN prev_commitment c ON c.physical_op_id=p.id ORDER BY 1',
-		 							'SELECT m FROM generate_series(` + sfy + `, ` + sly + `) m')
-				AS (id INTEGER, ` + strings.Join(typesNames, ",") + `)
-			) AS ppi WHERE op.id=ppi.id AND sc.physical_op_id=op.id AND sc.scenario_id = $1) q;`
-	lines, line := []string{}, ""
-	rows, err := db.Query(operationCrossQuery)
+	names, err := fetchOpNames(db)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		if err := rows.Scan(&line); err != nil {
-			return err
+	levered := fd.LeveredCommitments()
+	lastYear := firstYear + 4
+	for i, f := range levered {
+		if y := f.Year + fd.Offsets[f.OpID]; i == 0 || y > lastYear {
+			lastYear = y
 		}
-		lines = append(lines, line)
 	}
-	if err = rows.Err(); err != nil {
+	prevs := make(map[int64]map[int64]float64)
+	for _, f := range fd.PrevCommitments {
+		if f.OpID > 0 {
+			if prevs[f.OpID] == nil {
+				prevs[f.OpID] = make(map[int64]float64)
+			}
+			prevs[f.OpID][f.Year] += f.Value
+		}
+	}
+	var opIDs []int64
+	for ID := range names {
+		if ID > 0 {
+			opIDs = append(opIDs, ID)
+		}
+	}
+	sort.Slice(opIDs, func(i, j int) bool { return opIDs[i] < opIDs[j] })
+	lines := make([]map[string]interface{}, len(opIDs))
+	for i, ID := range opIDs {
+		lines[i] = crossTableYears(map[string]interface{}{"id": ID,
+			"number": names[ID][0], "name": names[ID][1]}, prevs[ID], firstYear,
+			lastYear)
+	}
+	if d.OperationCrossTable, err = json.Marshal(lines); err != nil {
 		return err
 	}
-	d.OperationCrossTable = json.RawMessage("[" + strings.Join(lines, ",") + "]")
-	rows, err = db.Query(scenarioCrossQuery, sID)
+	scenarioPrevs := make(map[int64]map[int64]float64)
+	for _, f := range levered {
+		if scenarioPrevs[f.OpID] == nil {
+			scenarioPrevs[f.OpID] = make(map[int64]float64)
+		}
+		scenarioPrevs[f.OpID][f.Year] += f.Value
+	}
+	rows, err := db.Query(`SELECT s.physical_op_id,s.offset,s.scale,s.cancelled,
+	s.cap,s.explanation FROM scenario_offset s WHERE s.scenario_id=$1 ORDER BY 1`,
+		sID)
 	if err != nil {
 		return err
 	}
-	lines = nil
 	defer rows.Close()
+	lines = []map[string]interface{}{}
+	var (
+		opID, offset int64
+		scale        float64
+		cancelled    bool
+		cap          NullInt64
+		explanation  NullString
+	)
 	for rows.Next() {
-		if err := rows.Scan(&line); err != nil {
+		if err = rows.Scan(&opID, &offset, &scale, &cancelled, &cap,
+			&explanation); err != nil {
 			return err
 		}
-		lines = append(lines, line)
+		lines = append(lines, crossTableYears(map[string]interface{}{"id": opID,
+			"new_op_id": nil, "number": names[opID][0], "name": names[opID][1],
+			"offset": offset, "scale": scale, "cancelled": cancelled, "cap": cap,
+			"explanation": explanation}, scenarioPrevs[opID], firstYear, lastYear))
 	}
 	if err = rows.Err(); err != nil {
 		return err
 	}
-	d.ScenarioCrossTable = json.RawMessage("[" + strings.Join(lines, ",") + "]")
-	return nil
+	if err = d.ScenarioNewOps.GetAll(sID, db); err != nil {
+		return err
+	}
+	for _, o := range d.ScenarioNewOps.ScenarioNewOps {
+		lines = append(lines, crossTableYears(map[string]interface{}{"id": nil,
+			"new_op_id": o.ID, "number": names[-o.ID][0], "name": o.Name, "offset": 0,
+			"scale": 1, "cancelled": false, "cap": nil, "explanation": nil},
+			scenarioPrevs[-o.ID], firstYear, lastYear))
+	}
+	d.ScenarioCrossTable, err = json.Marshal(lines)
+	return err
 }
 
-// GetAll populate MultiAnnualBudgetScenario from database
-func (m *MultiAnnualBudgetScenario) GetAll(year int64, scenarioID int64, db *sql.DB) (err error) {
-	sy := strconv.FormatInt(year, 10)
-	ssID := strconv.FormatInt(scenarioID, 10)
-	rows, err := db.Query(`SELECT op.number, op.name, bt.chapter, bt.sector, bt.subfunction, 
-		bt.program, bt.action, sc.y0, sc.y1, sc.y2, sc.y3, sc.y4
-	FROM physical_op AS op
-	JOIN (SELECT * FROM
-	crosstab ('SELECT op.id, p.year+s.offset AS year, p.value FROM physical_op op 
-					JOIN scenario_offset s 
-					ON s.physical_op_id = op.id AND s.scenario_id = ` + ssID + ` 
-					LEFT OUTER JOIN prev_commitment p ON p.physical_op_id = op.id ORDER BY 1,2',
-					'SELECT m FROM generate_series(` + sy + `, ` + sy + ` + 4) AS m')
-	AS (id INTEGER, y0 BIGINT, y1 BIGINT, y2 BIGINT, y3 BIGINT, y4 BIGINT)) sc 
-	ON sc.id = op.id
-	LEFT OUTER JOIN 
-	(SELECT ba.id, bc.code AS chapter, bs.code AS sector,
-		bp.code_function || bp.code_subfunction AS subfunction,
-		bp.code_contract || bp.code_function || bp.code_number AS program, 
-		bp.code_contract || bp.code_function || bp.code_number || ba.code AS action 
-	FROM budget_chapter AS bc, budget_program AS bp, budget_action AS ba, budget_sector AS bs 
-	WHERE bp.id = ba.program_id  AND bc.id=bp.chapter_id AND bs.id = ba.sector_id) AS bt
-	ON bt.id = op.budget_action_id 
-	ORDER BY chapter, sector, subfunction, program, action, number`)
+// GetAll populate MultiAnnualBudgetScenario from database over the years
+// starting with year.
+func (m *MultiAnnualBudgetScenario) GetAll(year, years int64, scenarioID int64, db *sql.DB) (err error) {
+	d, err := LoadForecastData(scenarioID, db)
 	if err != nil {
 		return err
 	}
-	var r MABScenarioLine
-	defer rows.Close()
-	for rows.Next() {
-		if err = rows.Scan(&r.Number, &r.Name, &r.Chapter, &r.Sector, &r.Subfunction,
-			&r.Program, &r.Action, &r.Y0, &r.Y1, &r.Y2, &r.Y3, &r.Y4); err != nil {
-			return err
-		}
-		m.MultiAnnualBudgetScenario = append(m.MultiAnnualBudgetScenario, r)
+	lines, err := d.ScenarioCommitments(year, years)
+	if err != nil {
+		return err
 	}
-	err = rows.Err()
-	if len(m.MultiAnnualBudgetScenario) == 0 {
-		m.MultiAnnualBudgetScenario = []MABScenarioLine{}
+	codes, err := fetchBudgetCodes(db)
+	if err != nil {
+		return err
 	}
-	return err
+	names, err := fetchOpNames(db)
+	if err != nil {
+		return err
+	}
+	m.Years = scenarioYears(year, years)
+	m.MultiAnnualBudgetScenario = make([]MABScenarioLine, len(lines))
+	for i, l := range lines {
+		n, b := names[l.OpID], codes[l.ActionID]
+		r := MABScenarioLine{Number: n[0], Name: n[1], Chapter: b.Chapter,
+			Sector: b.Sector, Subfunction: b.strictSubfunction(), Program: b.Program,
+			Action: b.Action, Values: make([]NullInt64, years)}
+		for j := range r.Values {
+			r.Values[j] = NullInt64{Valid: l.Valid[j], Int64: int64(l.Values[j])}
+		}
+		m.MultiAnnualBudgetScenario[i] = r
+	}
+	sort.Slice(m.MultiAnnualBudgetScenario, func(i, j int) bool {
+		a, b := &m.MultiAnnualBudgetScenario[i], &m.MultiAnnualBudgetScenario[j]
+		if c := cmpNullInt64(a.Chapter, b.Chapter); c != 0 {
+			return c < 0
+		}
+		return cmpNullStrings([2]NullString{a.Sector, b.Sector},
+			[2]NullString{a.Subfunction, b.Subfunction},
+			[2]NullString{a.Program, b.Program}, [2]NullString{a.Action, b.Action},
+			[2]NullString{{Valid: true, String: a.Number},
+				{Valid: true, String: b.Number}}) < 0
+	})
+	return nil
 }
@@ -0,0 +1,276 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Iledant/iris-propera/forecast"
+	"github.com/lib/pq"
+)
+
+// ScenarioComparisonLine compares the amounts of an operation or a budget
+// action among scenarios. Values[i] are the amounts of the scenario i and
+// Deltas[i] the differences with the first scenario, in euros.
+type ScenarioComparisonLine struct {
+	ForecastLabels
+	Values [][]NullFloat64 `json:"values"`
+	Deltas [][]NullFloat64 `json:"deltas"`
+}
+
+// ScenarioComparison embeddes the comparison of the forecast commitments per
+// operation and of the payments per budget action of scenarios for json export
+type ScenarioComparison struct {
+	Scenarios   []Scenario               `json:"Scenario"`
+	Years       []int64                  `json:"Years"`
+	Commitments []ScenarioComparisonLine `json:"ScenarioCommitmentComparison"`
+	Payments    []ScenarioComparisonLine `json:"ScenarioPaymentComparison"`
+}
+
+// ScenarioOffsetDiff is an operation whose offset or levers differ between two
+// scenarios, the fields being null if the operation isn't in the scenario
+type ScenarioOffsetDiff struct {
+	OpID           int64       `json:"physical_op_id"`
+	OpNumber       string      `json:"op_number"`
+	OpName         string      `json:"op_name"`
+	Offset         NullInt64   `json:"offset"`
+	Scale          NullFloat64 `json:"scale"`
+	Cancelled      NullBool    `json:"cancelled"`
+	Cap            NullInt64   `json:"cap"`
+	OtherOffset    NullInt64   `json:"other_offset"`
+	OtherScale     NullFloat64 `json:"other_scale"`
+	OtherCancelled NullBool    `json:"other_cancelled"`
+	OtherCap       NullInt64   `json:"other_cap"`
+}
+
+// ScenarioOffsetDiffs embeddes an array of ScenarioOffsetDiff for json export
+type ScenarioOffsetDiffs struct {
+	ScenarioOffsetDiffs []ScenarioOffsetDiff `json:"ScenarioOffsetDiff"`
+}
+
+// Clone creates a new scenario with the name and the description of s and
+// the offsets, the levers and the hypothetical operations of the scenario
+// srcID.
+func (s *Scenario) Clone(srcID int64, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = s.cloneTx(srcID, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// cloneTx creates the copy of the scenario srcID within the transaction
+// which must be rolled back by the caller in case of error.
+func (s *Scenario) cloneTx(srcID int64, tx *sql.Tx) error {
+	var count int64
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM scenario WHERE id=$1`, srcID).
+		Scan(&count); err != nil {
+		return fmt.Errorf("select count %v", err)
+	}
+	if count == 0 {
+		return errors.New("Scenario introuvable")
+	}
+	if err := tx.QueryRow(`INSERT INTO scenario (name,descript,owner_id)
+	VALUES($1,$2,$3) RETURNING id,status`, s.Name, s.Descript, s.OwnerID).Scan(&s.ID,
+		&s.Status); err != nil {
+		return fmt.Errorf("insert %v", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO scenario_offset (scenario_id,physical_op_id,
+		"offset",scale,cancelled,cap,explanation) SELECT $1,physical_op_id,"offset",
+		scale,cancelled,cap,explanation FROM scenario_offset WHERE scenario_id=$2`,
+		s.ID, srcID); err != nil {
+		return fmt.Errorf("insert offsets %v", err)
+	}
+	rows, err := tx.Query(`SELECT id FROM scenario_new_op WHERE scenario_id=$1`, srcID)
+	if err != nil {
+		return fmt.Errorf("select new ops %v", err)
+	}
+	var IDs []int64
+	var ID int64
+	for rows.Next() {
+		if err = rows.Scan(&ID); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan new ops %v", err)
+		}
+		IDs = append(IDs, ID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows new ops %v", err)
+	}
+	for _, srcOpID := range IDs {
+		if err = tx.QueryRow(`INSERT INTO scenario_new_op (scenario_id,name,
+			budget_action_id) SELECT $1,name,budget_action_id FROM scenario_new_op
+			WHERE id=$2 RETURNING id`, s.ID, srcOpID).Scan(&ID); err != nil {
+			return fmt.Errorf("insert new op %v", err)
+		}
+		if _, err = tx.Exec(`INSERT INTO scenario_new_op_commitment (new_op_id,year,
+			value) SELECT $1,year,value FROM scenario_new_op_commitment
+			WHERE new_op_id=$2`, ID, srcOpID); err != nil {
+			return fmt.Errorf("insert new op commitments %v", err)
+		}
+	}
+	return nil
+}
+
+// fetchScenarios fetches the scenarios in the order of the IDs.
+func fetchScenarios(IDs []int64, db *sql.DB) ([]Scenario, error) {
+	rows, err := db.Query(`SELECT id,name,descript,owner_id,status,adopted_at
+	FROM scenario WHERE id=ANY($1)`, pq.Array(IDs))
+	if err != nil {
+		return nil, fmt.Errorf("select scenarios %v", err)
+	}
+	defer rows.Close()
+	found := make(map[int64]Scenario)
+	var s Scenario
+	for rows.Next() {
+		if err = rows.Scan(&s.ID, &s.Name, &s.Descript, &s.OwnerID, &s.Status,
+			&s.AdoptedAt); err != nil {
+			return nil, fmt.Errorf("scan scenarios %v", err)
+		}
+		found[s.ID] = s
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows scenarios %v", err)
+	}
+	scenarios := make([]Scenario, len(IDs))
+	for i, ID := range IDs {
+		var ok bool
+		if scenarios[i], ok = found[ID]; !ok {
+			return nil, fmt.Errorf("Scenario %d introuvable", ID)
+		}
+	}
+	return scenarios, nil
+}
+
+// compareLines builds the comparison lines from the projections of each
+// scenario, the keys missing in a scenario having null values.
+func compareLines(projections [][]forecast.Line, horizon int64,
+	codes map[int64]budgetCodes, names map[int64][2]string) []ScenarioComparisonLine {
+	index := make(map[forecast.Key]int)
+	var lines []ScenarioComparisonLine
+	for s, projection := range projections {
+		for _, l := range projection {
+			i, ok := index[l.Key]
+			if !ok {
+				i = len(lines)
+				index[l.Key] = i
+				r := ScenarioComparisonLine{ForecastLabels: forecastLabels(l.Key, codes, names),
+					Values: make([][]NullFloat64, len(projections)),
+					Deltas: make([][]NullFloat64, len(projections))}
+				for j := range r.Values {
+					r.Values[j] = make([]NullFloat64, horizon)
+				}
+				lines = append(lines, r)
+			}
+			for y, v := range l.Values {
+				lines[i].Values[s][y] = euros(v, l.Valid[y])
+			}
+		}
+	}
+	for i := range lines {
+		ref := lines[i].Values[0]
+		for s, values := range lines[i].Values {
+			lines[i].Deltas[s] = make([]NullFloat64, horizon)
+			for y, v := range values {
+				if v.Valid || ref[y].Valid {
+					lines[i].Deltas[s][y] = NullFloat64{Valid: true,
+						Float64: euros((v.Float64-ref[y].Float64)*100, true).Float64}
+				}
+			}
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lessForecastLabels(&lines[i].ForecastLabels, &lines[j].ForecastLabels)
+	})
+	if len(lines) == 0 {
+		lines = []ScenarioComparisonLine{}
+	}
+	return lines
+}
+
+// Compare projects the forecast commitments per operation and the payments
+// per budget action of the scenarios and computes the differences with the
+// first one.
+func (c *ScenarioComparison) Compare(IDs []int64, p forecast.Params, db *sql.DB) error {
+	if len(IDs) < 2 {
+		return errors.New("au moins deux scénarios nécessaires")
+	}
+	var err error
+	if c.Scenarios, err = fetchScenarios(IDs, db); err != nil {
+		return err
+	}
+	codes, err := fetchBudgetCodes(db)
+	if err != nil {
+		return err
+	}
+	names, err := fetchOpNames(db)
+	if err != nil {
+		return err
+	}
+	p.Level = forecast.LevelAction
+	commitments := make([][]forecast.Line, len(IDs))
+	payments := make([][]forecast.Line, len(IDs))
+	for i, ID := range IDs {
+		s, err := LoadForecastData(ID, db)
+		if err != nil {
+			return err
+		}
+		if commitments[i], err = s.ScenarioCommitments(p.FirstYear, p.Horizon); err != nil {
+			return err
+		}
+		if payments[i], err = s.Payments(p); err != nil {
+			return err
+		}
+	}
+	c.Years = make([]int64, p.Horizon)
+	for i := range c.Years {
+		c.Years[i] = p.FirstYear + int64(i)
+	}
+	c.Commitments = compareLines(commitments, p.Horizon, codes, names)
+	c.Payments = compareLines(payments, p.Horizon, codes, names)
+	return nil
+}
+
+// GetAll fetches the operations whose offset or levers differ between the
+// scenarios sID and otherID, including the operations in only one of them.
+func (d *ScenarioOffsetDiffs) GetAll(sID, otherID int64, db *sql.DB) error {
+	if _, err := fetchScenarios([]int64{sID, otherID}, db); err != nil {
+		return err
+	}
+	rows, err := db.Query(`WITH a AS (SELECT physical_op_id,"offset",scale,cancelled,cap
+		FROM scenario_offset WHERE scenario_id=$1),
+	b AS (SELECT physical_op_id,"offset",scale,cancelled,cap FROM scenario_offset
+		WHERE scenario_id=$2)
+	SELECT op.id,op.number,op.name,a.offset,a.scale,a.cancelled,a.cap,
+		b.offset,b.scale,b.cancelled,b.cap
+	FROM a FULL OUTER JOIN b ON a.physical_op_id=b.physical_op_id
+	JOIN physical_op op ON op.id=COALESCE(a.physical_op_id,b.physical_op_id)
+	WHERE (a.offset,a.scale,a.cancelled,a.cap) IS DISTINCT FROM
+		(b.offset,b.scale,b.cancelled,b.cap) ORDER BY op.number`, sID, otherID)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	var r ScenarioOffsetDiff
+	for rows.Next() {
+		if err = rows.Scan(&r.OpID, &r.OpNumber, &r.OpName, &r.Offset, &r.Scale,
+			&r.Cancelled, &r.Cap, &r.OtherOffset, &r.OtherScale, &r.OtherCancelled,
+			&r.OtherCap); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		d.ScenarioOffsetDiffs = append(d.ScenarioOffsetDiffs, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	if len(d.ScenarioOffsetDiffs) == 0 {
+		d.ScenarioOffsetDiffs = []ScenarioOffsetDiff{}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ScenarioNewOpCommitment is a forecast commitment of an hypothetical
+// operation
+type ScenarioNewOpCommitment struct {
+	Year  int64 `json:"year"`
+	Value int64 `json:"value"`
+}
+
+// ScenarioNewOp is an hypothetical operation of a scenario that doesn't
+// exist in physical_op
+type ScenarioNewOp struct {
+	ID             int64                     `json:"id"`
+	Name           string                    `json:"name"`
+	BudgetActionID NullInt64                 `json:"budget_action_id"`
+	Commitments    []ScenarioNewOpCommitment `json:"commitments"`
+}
+
+// ScenarioNewOps embeddes an array of ScenarioNewOp for json export
+type ScenarioNewOps struct {
+	ScenarioNewOps []ScenarioNewOp `json:"ScenarioNewOp"`
+}
+
+// Validate checks if fields are well formed
+func (s *ScenarioNewOps) Validate() error {
+	for _, o := range s.ScenarioNewOps {
+		if o.Name == "" || len(o.Name) > 255 {
+			return errors.New("Name incorrect")
+		}
+	}
+	return nil
+}
+
+// GetAll fetches the hypothetical operations of a scenario with their
+// forecast commitments.
+func (s *ScenarioNewOps) GetAll(sID int64, db *sql.DB) error {
+	rows, err := db.Query(`SELECT n.id,n.name,n.budget_action_id,c.year,c.value
+	FROM scenario_new_op n
+	LEFT JOIN scenario_new_op_commitment c ON c.new_op_id=n.id
+	WHERE n.scenario_id=$1 ORDER BY n.id,c.year`, sID)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	var o ScenarioNewOp
+	var year, value NullInt64
+	s.ScenarioNewOps = []ScenarioNewOp{}
+	for rows.Next() {
+		if err = rows.Scan(&o.ID, &o.Name, &o.BudgetActionID, &year,
+			&value); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		n := len(s.ScenarioNewOps)
+		if n == 0 || s.ScenarioNewOps[n-1].ID != o.ID {
+			o.Commitments = []ScenarioNewOpCommitment{}
+			s.ScenarioNewOps = append(s.ScenarioNewOps, o)
+			n++
+		}
+		if year.Valid {
+			s.ScenarioNewOps[n-1].Commitments = append(
+				s.ScenarioNewOps[n-1].Commitments,
+				ScenarioNewOpCommitment{Year: year.Int64, Value: value.Int64})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows %v", err)
+	}
+	return nil
+}
+
+// Save replaces the hypothetical operations of a scenario if the user can
+// edit it.
+func (s *ScenarioNewOps) Save(sID, uID int64, db *sql.DB) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if err := checkScenarioEditable(sID, uID, db); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM scenario_new_op WHERE scenario_id=$1`,
+		sID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("delete %v", err)
+	}
+	for i := range s.ScenarioNewOps {
+		o := &s.ScenarioNewOps[i]
+		if err = tx.QueryRow(`INSERT INTO scenario_new_op (scenario_id,name,
+			budget_action_id) VALUES($1,$2,$3) RETURNING id`, sID, o.Name,
+			o.BudgetActionID).Scan(&o.ID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("insert %v", err)
+		}
+		for _, c := range o.Commitments {
+			if _, err = tx.Exec(`INSERT INTO scenario_new_op_commitment (new_op_id,
+				year,value) VALUES($1,$2,$3)`, o.ID, c.Year, c.Value); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("insert commitment %v", err)
+			}
+		}
+		if o.Commitments == nil {
+			o.Commitments = []ScenarioNewOpCommitment{}
+		}
+	}
+	if s.ScenarioNewOps == nil {
+		s.ScenarioNewOps = []ScenarioNewOp{}
+	}
+	return tx.Commit()
+}
@@ -2,17 +2,24 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/lib/pq"
 )
 
-// ScenarioOffset model
+// ScenarioOffset model. Besides the offset, the forecast amounts of the
+// operation are multiplied by Scale, 1 if null, dropped if Cancelled and
+// the yearly commitments limited to Cap if not null.
 type ScenarioOffset struct {
-	ID           int64 `json:"id"`
-	ScenarioID   int64 `json:"scenario_id"`
-	PhysicalOpID int64 `json:"physical_op_id"`
-	Offset       int64 `json:"offset"`
+	ID           int64       `json:"id"`
+	ScenarioID   int64       `json:"scenario_id"`
+	PhysicalOpID int64       `json:"physical_op_id"`
+	Offset       int64       `json:"offset"`
+	Scale        NullFloat64 `json:"scale"`
+	Cancelled    bool        `json:"cancelled"`
+	Cap          NullInt64   `json:"cap"`
+	Explanation  NullString  `json:"explanation"`
 }
 
 // ScenarioOffsets embeddes an array of ScenarioOffset
@@ -20,8 +27,27 @@ type ScenarioOffsets struct {
 	ScenarioOffsets []ScenarioOffset `json:"offsetList"`
 }
 
-// Save replaces offsets of a scenario.
-func (s *ScenarioOffsets) Save(sID int64, db *sql.DB) (err error) {
+// Validate checks the levers of the offsets.
+func (s *ScenarioOffsets) Validate() error {
+	for _, o := range s.ScenarioOffsets {
+		if o.Scale.Valid && o.Scale.Float64 < 0 {
+			return errors.New("scale incorrect")
+		}
+		if o.Cap.Valid && o.Cap.Int64 <= 0 {
+			return errors.New("cap incorrect")
+		}
+	}
+	return nil
+}
+
+// Save replaces offsets of a scenario if the user can edit it.
+func (s *ScenarioOffsets) Save(sID, uID int64, db *sql.DB) (err error) {
+	if err = s.Validate(); err != nil {
+		return err
+	}
+	if err = checkScenarioEditable(sID, uID, db); err != nil {
+		return err
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -32,13 +58,18 @@ func (s *ScenarioOffsets) Save(sID int64, db *sql.DB) (err error) {
 	}
 
 	stmt, err := tx.Prepare(pq.CopyIn("scenario_offset", "offset",
-		"physical_op_id", "scenario_id"))
+		"physical_op_id", "scenario_id", "scale", "cancelled", "cap", "explanation"))
 	if err != nil {
 		return fmt.Errorf("prepare stmt %v", err)
 	}
 	defer stmt.Close()
 	for _, r := range s.ScenarioOffsets {
-		if _, err = stmt.Exec(r.Offset, r.PhysicalOpID, sID); err != nil {
+		scale := 1.0
+		if r.Scale.Valid {
+			scale = r.Scale.Float64
+		}
+		if _, err = stmt.Exec(r.Offset, r.PhysicalOpID, sID, scale, r.Cancelled,
+			r.Cap, r.Explanation); err != nil {
 			tx.Rollback()
 			return fmt.Errorf("insertion de %+v  %v", r, err)
 		}
@@ -0,0 +1,337 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Iledant/iris-propera/forecast"
+)
+
+// Kinds of the priorities of the operations of the optimizer
+const (
+	StepPriority     = "step"
+	CategoryPriority = "category"
+	PlanLinePriority = "plan_line"
+)
+
+// ScenarioCeiling limits the payments and the commitments of a budget chapter
+// for a year, in euros, a null limit being ignored
+type ScenarioCeiling struct {
+	Chapter     int64       `json:"chapter"`
+	Year        int64       `json:"year"`
+	Payments    NullFloat64 `json:"payments"`
+	Commitments NullFloat64 `json:"commitments"`
+}
+
+// ScenarioPriority gives the weight of the operations of a step, a category
+// or a plan line, the cost of a delay being proportional to the weight
+type ScenarioPriority struct {
+	Kind   string  `json:"kind"`
+	ID     int64   `json:"id"`
+	Weight float64 `json:"weight"`
+}
+
+// ScenarioOffsetRange bounds the shift of an operation by the optimizer, a
+// negative min_offset allowing the operation to be brought forward
+type ScenarioOffsetRange struct {
+	OpID      int64 `json:"physical_op_id"`
+	MinOffset int64 `json:"min_offset"`
+	MaxOffset int64 `json:"max_offset"`
+}
+
+// ScenarioOptimizationParams defines the optimization and the scenario
+// created. The offsets, the levers and the hypothetical operations of the
+// base scenario are kept if it is set, otherwise all the operations with
+// forecast commitments are used. The operations of OffsetRanges are shifted
+// within their range instead of up to MaxOffset years.
+type ScenarioOptimizationParams struct {
+	Name           string                `json:"name"`
+	Descript       NullString            `json:"descript"`
+	BaseScenarioID NullInt64             `json:"base_scenario_id"`
+	FirstYear      int64                 `json:"first_year"`
+	Horizon        int64                 `json:"horizon"`
+	PaymentTypeID  int64                 `json:"payment_type_id"`
+	MaxOffset      int64                 `json:"max_offset"`
+	Ceilings       []ScenarioCeiling     `json:"ceilings"`
+	Priorities     []ScenarioPriority    `json:"priorities"`
+	LockedOps      []int64               `json:"locked_ops"`
+	OffsetRanges   []ScenarioOffsetRange `json:"offset_ranges"`
+}
+
+// ScenarioShift is an operation delayed by the optimizer with the
+// explanation of the shift
+type ScenarioShift struct {
+	OpID        int64  `json:"physical_op_id"`
+	OpNumber    string `json:"op_number"`
+	OpName      string `json:"op_name"`
+	Years       int64  `json:"years"`
+	Offset      int64  `json:"offset"`
+	Explanation string `json:"explanation"`
+}
+
+// ScenarioCeilingResult compares a ceiling with the amounts of the optimized
+// scenario in euros
+type ScenarioCeilingResult struct {
+	ScenarioCeiling
+	PaymentsValue    float64 `json:"payments_value"`
+	CommitmentsValue float64 `json:"commitments_value"`
+}
+
+// ScenarioOptimization embeddes the scenario created by the optimizer with the
+// shifts, the ceilings, the remaining excess over the ceilings in euros and
+// the cost of the shifts for json export
+type ScenarioOptimization struct {
+	Scenario Scenario                `json:"Scenario"`
+	Shifts   []ScenarioShift         `json:"ScenarioShift"`
+	Ceilings []ScenarioCeilingResult `json:"ScenarioCeiling"`
+	Excess   float64                 `json:"Excess"`
+	Cost     float64                 `json:"Cost"`
+}
+
+// Validate checks if fields are well formed
+func (p *ScenarioOptimizationParams) Validate() error {
+	if p.Name == "" || len(p.Name) > 255 {
+		return errors.New("Name incorrect")
+	}
+	if p.Horizon <= 0 {
+		return errors.New("Horizon incorrect")
+	}
+	if p.MaxOffset < 0 {
+		return errors.New("MaxOffset incorrect")
+	}
+	if len(p.Ceilings) == 0 {
+		return errors.New("Ceilings vide")
+	}
+	for _, c := range p.Ceilings {
+		if (c.Payments.Valid && c.Payments.Float64 < 0) ||
+			(c.Commitments.Valid && c.Commitments.Float64 < 0) {
+			return errors.New("Ceilings incorrect")
+		}
+	}
+	for _, r := range p.OffsetRanges {
+		if r.MinOffset > r.MaxOffset {
+			return errors.New("OffsetRanges incorrect")
+		}
+	}
+	for _, pr := range p.Priorities {
+		if pr.Kind != StepPriority && pr.Kind != CategoryPriority &&
+			pr.Kind != PlanLinePriority {
+			return errors.New("Priorities kind incorrect")
+		}
+		if pr.Weight <= 0 {
+			return errors.New("Priorities weight incorrect")
+		}
+	}
+	return nil
+}
+
+// loadOpWeights computes the weight of each operation, the greatest of the
+// weights of its step, its category and its plan line, operations without
+// priority being omitted.
+func loadOpWeights(priorities []ScenarioPriority, db *sql.DB) (map[int64]float64, error) {
+	weights := make(map[int64]float64)
+	if len(priorities) == 0 {
+		return weights, nil
+	}
+	byKind := make(map[string]map[int64]float64)
+	for _, pr := range priorities {
+		if byKind[pr.Kind] == nil {
+			byKind[pr.Kind] = make(map[int64]float64)
+		}
+		byKind[pr.Kind][pr.ID] = pr.Weight
+	}
+	rows, err := db.Query(`SELECT id,step_id,category_id,plan_line_id FROM physical_op`)
+	if err != nil {
+		return nil, fmt.Errorf("select weights %v", err)
+	}
+	defer rows.Close()
+	var opID int64
+	var step, category, planLine NullInt64
+	for rows.Next() {
+		if err = rows.Scan(&opID, &step, &category, &planLine); err != nil {
+			return nil, fmt.Errorf("scan weights %v", err)
+		}
+		for kind, ID := range map[string]NullInt64{StepPriority: step,
+			CategoryPriority: category, PlanLinePriority: planLine} {
+			if !ID.Valid {
+				continue
+			}
+			if w, ok := byKind[kind][ID.Int64]; ok && w > weights[opID] {
+				weights[opID] = w
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows weights %v", err)
+	}
+	return weights, nil
+}
+
+// explainShift describes the reductions of the excess over the ceilings that
+// justified the delay of an operation.
+func explainShift(s *forecast.Shift) string {
+	type reliefKey struct {
+		group, year int64
+		payments    bool
+	}
+	var keys []reliefKey
+	values := make(map[reliefKey]float64)
+	for _, r := range s.Reliefs {
+		k := reliefKey{r.Group, r.Year, r.Payments}
+		if _, ok := values[k]; !ok {
+			keys = append(keys, k)
+		}
+		values[k] += r.Value
+	}
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		kind := "engagements"
+		if k.payments {
+			kind = "paiements"
+		}
+		parts[i] = fmt.Sprintf("%s du chapitre %d en %d réduits de %.2f €", kind,
+			k.group, k.year, euros(values[k], true).Float64)
+	}
+	move, years := "Décalage", s.Years
+	if years < 0 {
+		move, years = "Avance", -years
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("%s de %d an(s) imposé par la plage de décalage", move,
+			years)
+	}
+	return fmt.Sprintf("%s de %d an(s) pour respecter les plafonds : %s", move,
+		years, strings.Join(parts, ", "))
+}
+
+// Optimize searches the offsets of the operations that bring the payments
+// and the commitments of the chapters under the ceilings with the lowest
+// weighted slippage and saves them as a new scenario with the explanation of
+// each shift. The hypothetical operations of the base scenario and the
+// locked operations keep their offset.
+func (o *ScenarioOptimization) Optimize(p *ScenarioOptimizationParams, uID int64, db *sql.DB) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	d, err := LoadForecastData(0, db)
+	if err != nil {
+		return err
+	}
+	if p.BaseScenarioID.Valid {
+		sd, err := loadScenarioDatas(p.BaseScenarioID.Int64, db)
+		if err != nil {
+			return err
+		}
+		d = sd.apply(d)
+	}
+	codes, err := fetchBudgetCodes(db)
+	if err != nil {
+		return err
+	}
+	weights, err := loadOpWeights(p.Priorities, db)
+	if err != nil {
+		return err
+	}
+	op := forecast.OptimizerParams{
+		Params: forecast.Params{FirstYear: p.FirstYear, Horizon: p.Horizon,
+			PaymentTypeID: p.PaymentTypeID},
+		Groups:    make(map[int64]int64),
+		Weights:   weights,
+		Locked:    make(map[int64]bool),
+		Ranges:    make(map[int64]forecast.OffsetRange),
+		MaxOffset: p.MaxOffset}
+	for ID, c := range codes {
+		if c.Chapter.Valid {
+			op.Groups[ID] = c.Chapter.Int64
+		}
+	}
+	for _, c := range p.Ceilings {
+		op.Ceilings = append(op.Ceilings, forecast.Ceiling{Group: c.Chapter,
+			Year: c.Year, Payments: c.Payments.Float64 * 100,
+			HasPayments: c.Payments.Valid, Commitments: c.Commitments.Float64 * 100,
+			HasCommitments: c.Commitments.Valid})
+	}
+	for _, r := range p.OffsetRanges {
+		op.Ranges[r.OpID] = forecast.OffsetRange{Min: r.MinOffset, Max: r.MaxOffset}
+	}
+	for _, ID := range p.LockedOps {
+		op.Locked[ID] = true
+	}
+	for ID := range d.Ops {
+		if ID < 0 {
+			op.Locked[ID] = true
+		}
+	}
+	r, err := forecast.Optimize(d, op)
+	if err != nil {
+		return err
+	}
+	names, err := fetchOpNames(db)
+	if err != nil {
+		return err
+	}
+	o.Scenario = Scenario{Name: p.Name, Descript: p.Descript,
+		OwnerID: NullInt64{Valid: true, Int64: uID}}
+	o.Shifts = make([]ScenarioShift, len(r.Shifts))
+	for i := range r.Shifts {
+		s := &r.Shifts[i]
+		o.Shifts[i] = ScenarioShift{OpID: s.OpID, OpNumber: names[s.OpID][0],
+			OpName: names[s.OpID][1], Years: s.Years, Offset: r.Offsets[s.OpID],
+			Explanation: explainShift(s)}
+	}
+	sort.Slice(o.Shifts, func(i, j int) bool {
+		return o.Shifts[i].OpNumber < o.Shifts[j].OpNumber
+	})
+	o.Ceilings = make([]ScenarioCeilingResult, len(r.Ceilings))
+	for i, c := range r.Ceilings {
+		o.Ceilings[i] = ScenarioCeilingResult{ScenarioCeiling: p.Ceilings[i],
+			PaymentsValue:    euros(c.PaymentsValue, true).Float64,
+			CommitmentsValue: euros(c.CommitmentsValue, true).Float64}
+	}
+	o.Excess = euros(r.Excess, true).Float64
+	o.Cost = r.Cost
+	return o.save(p.BaseScenarioID, r.Offsets, db)
+}
+
+// save creates the scenario as a copy of the base scenario or with all the
+// operations of the offsets and stores the offsets of the shifts with their
+// explanation.
+func (o *ScenarioOptimization) save(baseID NullInt64, offsets map[int64]int64, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if baseID.Valid {
+		if err = o.Scenario.cloneTx(baseID.Int64, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	} else {
+		if err = tx.QueryRow(`INSERT INTO scenario (name,descript,owner_id)
+		VALUES($1,$2,$3) RETURNING id,status`, o.Scenario.Name, o.Scenario.Descript,
+			o.Scenario.OwnerID).Scan(&o.Scenario.ID, &o.Scenario.Status); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("insert scenario %v", err)
+		}
+		for opID, offset := range offsets {
+			if _, err = tx.Exec(`INSERT INTO scenario_offset (scenario_id,
+				physical_op_id,"offset") VALUES($1,$2,$3)`, o.Scenario.ID, opID,
+				offset); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("insert offset %v", err)
+			}
+		}
+	}
+	for _, s := range o.Shifts {
+		if _, err = tx.Exec(`UPDATE scenario_offset SET "offset"=$1,explanation=$2
+			WHERE scenario_id=$3 AND physical_op_id=$4`, s.Offset, s.Explanation,
+			o.Scenario.ID, s.OpID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("update offset %v", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/Iledant/iris-propera/forecast"
+	"github.com/lib/pq"
+)
+
+// simulationPercentiles are the percentiles computed by the simulation
+var simulationPercentiles = []float64{0.1, 0.5, 0.9}
+
+// ScenarioSimulationParams defines the projection simulated, the count of
+// iterations, the seed of the random draws and the count of years of history
+// used for the payment curves
+type ScenarioSimulationParams struct {
+	forecast.Params
+	Iterations int
+	Seed       int64
+	Window     int64
+	// CurrentYear is the year whose forecast commitments aren't past
+	CurrentYear int64
+}
+
+// ScenarioSimulationLine gives the percentiles 10, 50 and 90 of the simulated
+// payments of a budget action, Values being the medians
+type ScenarioSimulationLine struct {
+	PaymentForecastLine
+	P10 []NullFloat64 `json:"p10"`
+	P90 []NullFloat64 `json:"p90"`
+}
+
+// ScenarioSimulation embeddes the results of a Monte Carlo simulation of the
+// payments of a scenario for json export
+type ScenarioSimulation struct {
+	Years      []int64                  `json:"Years"`
+	Iterations int                      `json:"Iterations"`
+	Lines      []ScenarioSimulationLine `json:"ScenarioSimulation"`
+	Total      ScenarioSimulationLine   `json:"ScenarioSimulationTotal"`
+}
+
+// LoadSlippages fetches the past forecast commitments with the years of the
+// commitments of their operation and computes their slippage. The forecast
+// commitments of operations never committed are left out as their slippage
+// isn't known yet.
+func LoadSlippages(currentYear int64, db *sql.DB) ([]int64, error) {
+	rows, err := db.Query(`SELECT pc.year,array_agg(DISTINCT
+		EXTRACT(year FROM f.date)::bigint)
+	FROM prev_commitment pc
+	JOIN financial_commitment f ON f.physical_op_id=pc.physical_op_id
+	WHERE pc.year<$1 GROUP BY pc.id,pc.year`, currentYear)
+	if err != nil {
+		return nil, fmt.Errorf("select slippages %v", err)
+	}
+	defer rows.Close()
+	var slippages []int64
+	var year int64
+	var years pq.Int64Array
+	for rows.Next() {
+		if err = rows.Scan(&year, &years); err != nil {
+			return nil, fmt.Errorf("scan slippages %v", err)
+		}
+		if slip, ok := forecast.HistoricalSlippage(year, years); ok {
+			slippages = append(slippages, slip)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows slippages %v", err)
+	}
+	return slippages, nil
+}
+
+// simulationLine converts a percentile line to a line in euros.
+func simulationLine(l *forecast.PercentileLine, labels ForecastLabels) ScenarioSimulationLine {
+	s := ScenarioSimulationLine{PaymentForecastLine: PaymentForecastLine{
+		ForecastLabels: labels}}
+	values := make([][]NullFloat64, len(l.Values))
+	for i, v := range l.Values {
+		values[i] = make([]NullFloat64, len(v))
+		for j, x := range v {
+			values[i][j] = euros(x, true)
+		}
+	}
+	s.P10, s.Values, s.P90 = values[0], values[1], values[2]
+	return s
+}
+
+// Run simulates the payments of the scenario, or of all the forecast
+// commitments if sID is 0, per budget action.
+func (s *ScenarioSimulation) Run(sID int64, p *ScenarioSimulationParams, db *sql.DB) error {
+	d, err := LoadForecastData(sID, db)
+	if err != nil {
+		return err
+	}
+	h, err := LoadForecastHistory(db)
+	if err != nil {
+		return err
+	}
+	slippages, err := LoadSlippages(p.CurrentYear, db)
+	if err != nil {
+		return err
+	}
+	codes, err := fetchBudgetCodes(db)
+	if err != nil {
+		return err
+	}
+	origin := p.CurrentYear
+	sim := forecast.Simulation{Data: d, Slippages: slippages,
+		Curves: forecast.CohortCurves(h.Before(origin), origin, p.Window)}
+	p.Level = forecast.LevelAction
+	lines, total, err := sim.Run(forecast.SimulationParams{Params: p.Params,
+		Iterations: p.Iterations, Seed: p.Seed, Percentiles: simulationPercentiles})
+	if err != nil {
+		return err
+	}
+	s.Iterations = p.Iterations
+	s.Years = make([]int64, p.Horizon)
+	for i := range s.Years {
+		s.Years[i] = p.FirstYear + int64(i)
+	}
+	s.Lines = make([]ScenarioSimulationLine, len(lines))
+	for i := range lines {
+		s.Lines[i] = simulationLine(&lines[i], forecastLabels(lines[i].Key, codes, nil))
+	}
+	sort.Slice(s.Lines, func(i, j int) bool {
+		return lessForecastLabels(&s.Lines[i].ForecastLabels,
+			&s.Lines[j].ForecastLabels)
+	})
+	s.Total = simulationLine(total, ForecastLabels{})
+	return nil
+}
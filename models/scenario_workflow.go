@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Iledant/iris-propera/forecast"
+)
+
+// Statuses of the publication workflow of a scenario. Only the drafts can be
+// modified and the adopted scenarios keep a snapshot of their inputs.
+const (
+	DraftScenario     = "draft"
+	SubmittedScenario = "submitted"
+	AdoptedScenario   = "adopted"
+	ArchivedScenario  = "archived"
+)
+
+// scenarioTransitions are the allowed changes of status, the value being
+// true if only the owner can make the change
+var scenarioTransitions = map[[2]string]bool{
+	{DraftScenario, SubmittedScenario}:   true,
+	{SubmittedScenario, DraftScenario}:   false,
+	{SubmittedScenario, AdoptedScenario}: false,
+	{AdoptedScenario, ArchivedScenario}:  false,
+}
+
+// Errors of the publication workflow of a scenario
+var (
+	ErrScenarioNotOwner   = errors.New("scénario d'un autre utilisateur")
+	ErrScenarioLocked     = errors.New("scénario verrouillé")
+	ErrScenarioTransition = errors.New("changement de statut impossible")
+)
+
+// ScenarioStatus is used to decode the status requested for a scenario
+type ScenarioStatus struct {
+	Status string `json:"status"`
+}
+
+// Validate checks if the status is known
+func (s *ScenarioStatus) Validate() error {
+	switch s.Status {
+	case DraftScenario, SubmittedScenario, AdoptedScenario, ArchivedScenario:
+		return nil
+	}
+	return errors.New("Status incorrect")
+}
+
+// fetchScenarioState fetches the owner and the status of a scenario.
+func fetchScenarioState(sID int64, db *sql.DB) (owner NullInt64, status string, err error) {
+	err = db.QueryRow(`SELECT owner_id,status FROM scenario WHERE id=$1`, sID).
+		Scan(&owner, &status)
+	if err == sql.ErrNoRows {
+		err = errors.New("Scenario introuvable")
+	}
+	return owner, status, err
+}
+
+// checkScenarioEditable returns ErrScenarioLocked if the scenario isn't a
+// draft or ErrScenarioNotOwner if it belongs to another user, the scenarios without owner being editable by
+// all administrators.
+func checkScenarioEditable(sID, uID int64, db *sql.DB) error {
+	owner, status, err := fetchScenarioState(sID, db)
+	if err != nil {
+		return err
+	}
+	if status != DraftScenario {
+		return fmt.Errorf("%w, statut %s", ErrScenarioLocked, status)
+	}
+	if owner.Valid && owner.Int64 != uID {
+		return ErrScenarioNotOwner
+	}
+	return nil
+}
+
+// loadScenarioSnapshot fetches the inputs frozen at the adoption of the
+// scenario or returns nil if the scenario has no snapshot.
+func loadScenarioSnapshot(sID int64, db *sql.DB) (*forecast.Data, error) {
+	var data []byte
+	err := db.QueryRow(`SELECT data FROM scenario_snapshot WHERE scenario_id=$1`,
+		sID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select snapshot %v", err)
+	}
+	var d forecast.Data
+	if err = json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot %v", err)
+	}
+	return &d, nil
+}
+
+// SetStatus changes the status of the scenario if the transition is allowed
+// for the user. The adoption freezes the previsions, the commitments and the
+// payment ratios used by the projections of the scenario.
+func (s *Scenario) SetStatus(status string, uID int64, db *sql.DB) error {
+	owner, current, err := fetchScenarioState(s.ID, db)
+	if err != nil {
+		return err
+	}
+	ownerOnly, ok := scenarioTransitions[[2]string{current, status}]
+	if !ok {
+		return fmt.Errorf("%w, passage du statut %s au statut %s", ErrScenarioTransition,
+			current, status)
+	}
+	if ownerOnly && owner.Valid && owner.Int64 != uID {
+		return ErrScenarioNotOwner
+	}
+	var snapshot []byte
+	if status == AdoptedScenario {
+		d, err := LoadForecastData(s.ID, db)
+		if err != nil {
+			return err
+		}
+		if snapshot, err = json.Marshal(d); err != nil {
+			return fmt.Errorf("marshal snapshot %v", err)
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = tx.QueryRow(`UPDATE scenario SET status=$1,adopted_at=CASE WHEN $1=$2
+		THEN now() ELSE adopted_at END WHERE id=$3 AND status=$4
+		RETURNING name,descript,owner_id,status,adopted_at`, status, AdoptedScenario,
+		s.ID, current).Scan(&s.Name, &s.Descript, &s.OwnerID, &s.Status,
+		&s.AdoptedAt); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w, statut du scénario modifié entre-temps",
+				ErrScenarioTransition)
+		}
+		return fmt.Errorf("update %v", err)
+	}
+	if snapshot != nil {
+		if _, err = tx.Exec(`INSERT INTO scenario_snapshot (scenario_id,created_at,data)
+			VALUES($1,now(),$2)`, s.ID, snapshot); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("insert snapshot %v", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Levels of the territories, from the widest to the finest
+const (
+	TerritoryDepartment = "department"
+	TerritoryEPT        = "ept"
+	TerritoryCommune    = "commune"
+)
+
+// territoryLevels gives the depth of each level of territory, a territory
+// being attached to a territory of a wider level
+var territoryLevels = map[string]int{TerritoryDepartment: 1, TerritoryEPT: 2,
+	TerritoryCommune: 3}
+
+// Territory model
+type Territory struct {
+	ID       int64     `json:"id"`
+	Code     string    `json:"code"`
+	Name     string    `json:"name"`
+	Level    string    `json:"level"`
+	ParentID NullInt64 `json:"parent_id"`
+}
+
+// Territories embeddes an array of Territory for json export.
+type Territories struct {
+	Territories []Territory `json:"Territory"`
+}
+
+// CheckTerritoryLevel checks if the level is one of the territory levels.
+func CheckTerritoryLevel(level string) error {
+	if _, ok := territoryLevels[level]; !ok {
+		return errors.New("Level incorrect")
+	}
+	return nil
+}
+
+// Validate checks if fields are correctly formed.
+func (t *Territory) Validate() error {
+	if t.Code == "" || len(t.Code) > 20 {
+		return errors.New("Code incorrect")
+	}
+	if t.Name == "" || len(t.Name) > 255 {
+		return errors.New("Name incorrect")
+	}
+	return CheckTerritoryLevel(t.Level)
+}
+
+// checkParent checks that the parent of the territory exists and is of a
+// wider level to avoid loops.
+func (t *Territory) checkParent(db *sql.DB) error {
+	if !t.ParentID.Valid {
+		return nil
+	}
+	var level string
+	err := db.QueryRow(`SELECT level FROM territory WHERE id=$1`,
+		t.ParentID.Int64).Scan(&level)
+	if err == sql.ErrNoRows {
+		return errors.New("Territoire parent introuvable")
+	}
+	if err != nil {
+		return fmt.Errorf("select parent %v", err)
+	}
+	if territoryLevels[level] >= territoryLevels[t.Level] {
+		return errors.New("Territoire parent de niveau incorrect")
+	}
+	return nil
+}
+
+// Create insert a new territory into database.
+func (t *Territory) Create(db *sql.DB) error {
+	if err := t.checkParent(db); err != nil {
+		return err
+	}
+	if err := db.QueryRow(`INSERT INTO territory (code,name,level,parent_id)
+		VALUES($1,$2,$3,$4) RETURNING id`, t.Code, t.Name, t.Level,
+		t.ParentID).Scan(&t.ID); err != nil {
+		return fmt.Errorf("insert %v", err)
+	}
+	return nil
+}
+
+// Update modifies a territory into database.
+func (t *Territory) Update(db *sql.DB) error {
+	if err := t.checkParent(db); err != nil {
+		return err
+	}
+	res, err := db.Exec(`UPDATE territory SET code=$1,name=$2,level=$3,parent_id=$4
+		WHERE id=$5`, t.Code, t.Name, t.Level, t.ParentID, t.ID)
+	if err != nil {
+		return fmt.Errorf("update %v", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected %v", err)
+	}
+	if count != 1 {
+		return errors.New("Territoire introuvable")
+	}
+	return nil
+}
+
+// Delete removes a territory from database with the ratios of the operations
+// on this territory.
+func (t *Territory) Delete(db *sql.DB) error {
+	res, err := db.Exec(`DELETE FROM territory WHERE id=$1`, t.ID)
+	if err != nil {
+		return fmt.Errorf("delete %v", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected %v", err)
+	}
+	if count != 1 {
+		return errors.New("Territoire introuvable")
+	}
+	return nil
+}
+
+// GetAll fetches the territories of a level from database or all territories
+// if level is empty.
+func (t *Territories) GetAll(level string, db *sql.DB) error {
+	rows, err := db.Query(`SELECT id,code,name,level,parent_id FROM territory
+		WHERE $1::text='' OR level=$1
+		ORDER BY CASE level WHEN 'department' THEN 1 WHEN 'ept' THEN 2 ELSE 3 END,code`,
+		level)
+	if err != nil {
+		return fmt.Errorf("select %v", err)
+	}
+	defer rows.Close()
+	var r Territory
+	for rows.Next() {
+		if err = rows.Scan(&r.ID, &r.Code, &r.Name, &r.Level, &r.ParentID); err != nil {
+			return fmt.Errorf("scan %v", err)
+		}
+		t.Territories = append(t.Territories, r)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows err %v", err)
+	}
+	if len(t.Territories) == 0 {
+		t.Territories = []Territory{}
+	}
+	return nil
+}
+
+// territoryIndex fetches the territories of a level and returns the index of
+// each territory ID in the array used for the values per territory.
+func (t *Territories) territoryIndex(level string, db *sql.DB) (map[int64]int, error) {
+	if err := CheckTerritoryLevel(level); err != nil {
+		return nil, err
+	}
+	if err := t.GetAll(level, db); err != nil {
+		return nil, err
+	}
+	index := make(map[int64]int, len(t.Territories))
+	for i, r := range t.Territories {
+		index[r.ID] = i
+	}
+	return index, nil
+}
+
+// territoryRatios is the query of the ratios of the operations on the
+// territories of the level sent as $1. The ratios on finer territories are
+// added to the ratios of the territories of the level they are attached to.
+const territoryRatios = `(WITH RECURSIVE up(id,ancestor_id) AS (
+		SELECT id,id FROM territory
+		UNION
+		SELECT up.id,t.parent_id FROM up JOIN territory t ON up.ancestor_id=t.id
+		WHERE t.parent_id NOTNULL)
+	SELECT r.physical_op_id,a.id AS territory_id,SUM(r.ratio) AS ratio
+	FROM op_territory_ratio r
+	JOIN up ON r.territory_id=up.id
+	JOIN territory a ON up.ancestor_id=a.id
+	WHERE a.level=$1 GROUP BY 1,2)`
+
+// fetchOpTerritoryRatios fetches the ratios of the operations on the
+// territories of the level, ordered as the territories.
+func fetchOpTerritoryRatios(level string, t *Territories,
+	db *sql.DB) (map[int64][]NullFloat64, error) {
+	index, err := t.territoryIndex(level, db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT physical_op_id,territory_id,ratio FROM `+
+		territoryRatios+` r`, level)
+	if err != nil {
+		return nil, fmt.Errorf("select ratios %v", err)
+	}
+	defer rows.Close()
+	ratios := make(map[int64][]NullFloat64)
+	var (
+		opID, tID int64
+		ratio     float64
+	)
+	for rows.Next() {
+		if err = rows.Scan(&opID, &tID, &ratio); err != nil {
+			return nil, fmt.Errorf("scan ratios %v", err)
+		}
+		r, ok := ratios[opID]
+		if !ok {
+			r = make([]NullFloat64, len(t.Territories))
+			ratios[opID] = r
+		}
+		r[index[tID]] = NullFloat64{Valid: true, Float64: ratio}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows ratios %v", err)
+	}
+	return ratios, nil
+}